@@ -3,6 +3,7 @@ package ast
 import (
 	"bytes"
 	"fmt"
+	"strings"
 
 	"interpreter/token"
 )
@@ -22,6 +23,12 @@ type Expression interface {
 	expressionNode()
 }
 
+// ExpressionNode implements the unexported part of Expression. Embedding it
+// lets packages outside of ast define their own expression nodes.
+type ExpressionNode struct{}
+
+func (ExpressionNode) expressionNode() {}
+
 type Program struct {
 	Statements []Statement
 }
@@ -33,6 +40,17 @@ func (p *Program) TokenLiteral() string {
 	return ""
 }
 
+// EndsWithExpression reports whether the last statement of the program is
+// an expression statement. The value of a program is that of its last
+// expression statement if so, and null otherwise.
+func (p *Program) EndsWithExpression() bool {
+	if len(p.Statements) == 0 {
+		return false
+	}
+	_, ok := p.Statements[len(p.Statements)-1].(*ExpressionStatement)
+	return ok
+}
+
 func (p *Program) String() string {
 	var out bytes.Buffer
 
@@ -44,9 +62,11 @@ func (p *Program) String() string {
 }
 
 type LetStatement struct {
-	Token token.Token // token.LET
-	Name  *Identifier
-	Value Expression
+	Token   token.Token // token.LET or token.CONST
+	Name    *Identifier
+	Pattern Pattern // set instead of Name when the let destructures its value
+	Value   Expression
+	Const   bool // declared with const, the names cannot be reassigned
 }
 
 func (ls *LetStatement) statementNode() {}
@@ -57,7 +77,11 @@ func (ls *LetStatement) String() string {
 	var out bytes.Buffer
 
 	out.WriteString(ls.TokenLiteral() + " ")
-	out.WriteString(ls.Name.String())
+	if ls.Name != nil {
+		out.WriteString(ls.Name.String())
+	} else if ls.Pattern != nil {
+		out.WriteString(ls.Pattern.String())
+	}
 	out.WriteString(" = ")
 	if ls.Value != nil {
 		out.WriteString(ls.Value.String())
@@ -73,6 +97,7 @@ type Identifier struct {
 }
 
 func (id *Identifier) expressionNode() {}
+func (id *Identifier) patternNode()    {}
 func (id *Identifier) TokenLiteral() string {
 	return id.Token.Literal
 }
@@ -130,6 +155,20 @@ func (il *IntegerLiteral) String() string {
 	return il.Token.Literal
 }
 
+// StringLiteral holds the decoded string, the token keeps it as written.
+type StringLiteral struct {
+	Token token.Token
+	Value string
+}
+
+func (sl *StringLiteral) expressionNode() {}
+func (sl *StringLiteral) TokenLiteral() string {
+	return sl.Token.Literal
+}
+func (sl *StringLiteral) String() string {
+	return sl.Token.Literal
+}
+
 type PrefixExpression struct {
 	Token    token.Token
 	Operator string
@@ -141,7 +180,7 @@ func (pe *PrefixExpression) TokenLiteral() string {
 	return pe.Token.Literal
 }
 func (pe *PrefixExpression) String() string {
-	return fmt.Sprintf("(%s%s)", pe.Operator, pe.Right.String())
+	return fmt.Sprintf("(%s%s)", pe.Operator, nodeString(pe.Right))
 }
 
 type InfixExpression struct {
@@ -157,7 +196,24 @@ func (ie *InfixExpression) TokenLiteral() string {
 }
 func (ie *InfixExpression) String() string {
 	return fmt.Sprintf("(%s %s %s)",
-		ie.Left.String(), ie.Operator, ie.Right.String())
+		nodeString(ie.Left), ie.Operator, nodeString(ie.Right))
+}
+
+// AssignExpression updates an existing binding and evaluates to the
+// assigned value.
+type AssignExpression struct {
+	Token    token.Token // token.ASSIGN or a compound assignment like token.PLUS_ASSIGN
+	Target   Expression  // identifier or index expression
+	Operator string      // operator of a compound assignment, "+" for +=, empty for =
+	Value    Expression
+}
+
+func (ae *AssignExpression) expressionNode() {}
+func (ae *AssignExpression) TokenLiteral() string {
+	return ae.Token.Literal
+}
+func (ae *AssignExpression) String() string {
+	return nodeString(ae.Target) + " " + ae.TokenLiteral() + " " + nodeString(ae.Value)
 }
 
 type Boolean struct {
@@ -172,3 +228,571 @@ func (b *Boolean) TokenLiteral() string {
 func (b *Boolean) String() string {
 	return b.Token.Literal
 }
+
+type BlockStatement struct {
+	Token      token.Token // token.LBRACE
+	Statements []Statement
+}
+
+func (bs *BlockStatement) statementNode() {}
+func (bs *BlockStatement) TokenLiteral() string {
+	return bs.Token.Literal
+}
+func (bs *BlockStatement) String() string {
+	var out bytes.Buffer
+
+	for _, s := range bs.Statements {
+		out.WriteString(s.String())
+	}
+
+	return out.String()
+}
+
+type IfExpression struct {
+	Token       token.Token // token.IF
+	Condition   Expression
+	Consequence *BlockStatement
+	Alternative *BlockStatement
+}
+
+func (ie *IfExpression) expressionNode() {}
+func (ie *IfExpression) TokenLiteral() string {
+	return ie.Token.Literal
+}
+func (ie *IfExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("if")
+	out.WriteString(nodeString(ie.Condition))
+	out.WriteString(" ")
+	if ie.Consequence != nil {
+		out.WriteString(ie.Consequence.String())
+	}
+	if ie.Alternative != nil {
+		out.WriteString("else ")
+		out.WriteString(ie.Alternative.String())
+	}
+
+	return out.String()
+}
+
+type WhileStatement struct {
+	Token     token.Token // token.WHILE
+	Condition Expression
+	Body      *BlockStatement
+}
+
+func (ws *WhileStatement) statementNode() {}
+func (ws *WhileStatement) TokenLiteral() string {
+	return ws.Token.Literal
+}
+func (ws *WhileStatement) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("while")
+	out.WriteString(nodeString(ws.Condition))
+	out.WriteString(" ")
+	if ws.Body != nil {
+		out.WriteString(ws.Body.String())
+	}
+
+	return out.String()
+}
+
+// ForStatement is a C-style loop. Init, Condition and Update are nil when
+// left out, a loop without condition runs until it breaks.
+type ForStatement struct {
+	Token     token.Token // token.FOR
+	Init      Statement
+	Condition Expression
+	Update    Statement
+	Body      *BlockStatement
+}
+
+func (fs *ForStatement) statementNode() {}
+func (fs *ForStatement) TokenLiteral() string {
+	return fs.Token.Literal
+}
+func (fs *ForStatement) String() string {
+	var out bytes.Buffer
+
+	clauses := []string{}
+	for _, n := range []Node{fs.Init, fs.Condition, fs.Update} {
+		clauses = append(clauses, strings.TrimSuffix(nodeString(n), ";"))
+	}
+
+	out.WriteString("for (")
+	out.WriteString(strings.Join(clauses, "; "))
+	out.WriteString(") ")
+	if fs.Body != nil {
+		out.WriteString(fs.Body.String())
+	}
+
+	return out.String()
+}
+
+// ForInStatement loops over the elements of an iterable value. With one
+// variable it takes the elements of arrays, strings and ranges and the keys
+// of hashes, with two the index or key and the element or value.
+type ForInStatement struct {
+	Token     token.Token // token.FOR
+	Variables []*Identifier
+	Iterable  Expression
+	Body      *BlockStatement
+}
+
+func (fs *ForInStatement) statementNode() {}
+func (fs *ForInStatement) TokenLiteral() string {
+	return fs.Token.Literal
+}
+func (fs *ForInStatement) String() string {
+	var out bytes.Buffer
+
+	variables := []string{}
+	for _, v := range fs.Variables {
+		variables = append(variables, v.String())
+	}
+
+	out.WriteString("for (")
+	out.WriteString(strings.Join(variables, ", "))
+	out.WriteString(" in ")
+	out.WriteString(nodeString(fs.Iterable))
+	out.WriteString(") ")
+	if fs.Body != nil {
+		out.WriteString(fs.Body.String())
+	}
+
+	return out.String()
+}
+
+type BreakStatement struct {
+	Token token.Token // token.BREAK
+}
+
+func (bs *BreakStatement) statementNode() {}
+func (bs *BreakStatement) TokenLiteral() string {
+	return bs.Token.Literal
+}
+func (bs *BreakStatement) String() string {
+	return bs.TokenLiteral() + ";"
+}
+
+type ContinueStatement struct {
+	Token token.Token // token.CONTINUE
+}
+
+func (cs *ContinueStatement) statementNode() {}
+func (cs *ContinueStatement) TokenLiteral() string {
+	return cs.Token.Literal
+}
+func (cs *ContinueStatement) String() string {
+	return cs.TokenLiteral() + ";"
+}
+
+type FunctionLiteral struct {
+	Token      token.Token // token.FUNCTION
+	Parameters []*Identifier
+	Defaults   []Expression // by parameter, nil for those without a default
+	Rest       *Identifier  // collects the extra arguments into an array
+	Body       *BlockStatement
+	Name       string // name of the let binding, if any
+}
+
+func (fl *FunctionLiteral) expressionNode() {}
+func (fl *FunctionLiteral) TokenLiteral() string {
+	return fl.Token.Literal
+}
+func (fl *FunctionLiteral) String() string {
+	var out bytes.Buffer
+
+	params := []string{}
+	for i, p := range fl.Parameters {
+		if d := fl.Default(i); d != nil {
+			params = append(params, p.String()+" = "+d.String())
+		} else {
+			params = append(params, p.String())
+		}
+	}
+	if fl.Rest != nil {
+		params = append(params, "..."+fl.Rest.String())
+	}
+
+	out.WriteString(fl.TokenLiteral())
+	if fl.Name != "" {
+		out.WriteString(fmt.Sprintf("<%s>", fl.Name))
+	}
+	out.WriteString("(")
+	out.WriteString(strings.Join(params, ", "))
+	out.WriteString(") ")
+	if fl.Body != nil {
+		out.WriteString(fl.Body.String())
+	}
+
+	return out.String()
+}
+
+// Default returns the default value of the i-th parameter, nil if it has
+// none.
+func (fl *FunctionLiteral) Default(i int) Expression {
+	if i < len(fl.Defaults) {
+		return fl.Defaults[i]
+	}
+	return nil
+}
+
+// Arity returns the least and the most number of arguments the function
+// takes, -1 for the most if it has a rest parameter. Parameters with a
+// default come after the others.
+func (fl *FunctionLiteral) Arity() (min, max int) {
+	min = len(fl.Parameters)
+	for i := range fl.Parameters {
+		if fl.Default(i) != nil {
+			min = i
+			break
+		}
+	}
+	if fl.Rest != nil {
+		return min, -1
+	}
+	return min, len(fl.Parameters)
+}
+
+// NestsFunctions reports whether the body or the defaults of the function
+// define functions, which may capture its locals.
+func (fl *FunctionLiteral) NestsFunctions() bool {
+	found := false
+	Inspect(fl, func(n Node) bool {
+		if _, ok := n.(*FunctionLiteral); ok && n != fl {
+			found = true
+		}
+		return !found
+	})
+	return found
+}
+
+type CallExpression struct {
+	Token     token.Token // token.LPAREN
+	Function  Expression  // identifier or function literal
+	Arguments []Expression
+}
+
+func (ce *CallExpression) expressionNode() {}
+func (ce *CallExpression) TokenLiteral() string {
+	return ce.Token.Literal
+}
+func (ce *CallExpression) String() string {
+	var out bytes.Buffer
+
+	args := []string{}
+	for _, a := range ce.Arguments {
+		args = append(args, nodeString(a))
+	}
+
+	out.WriteString(nodeString(ce.Function))
+	out.WriteString("(")
+	out.WriteString(strings.Join(args, ", "))
+	out.WriteString(")")
+
+	return out.String()
+}
+
+// SpreadExpression passes the elements of an array as separate arguments.
+// It is only allowed in the arguments of a call.
+type SpreadExpression struct {
+	Token token.Token // token.ELLIPSIS
+	Value Expression
+}
+
+func (se *SpreadExpression) expressionNode() {}
+func (se *SpreadExpression) TokenLiteral() string {
+	return se.Token.Literal
+}
+func (se *SpreadExpression) String() string {
+	return "..." + nodeString(se.Value)
+}
+
+type ArrayLiteral struct {
+	Token    token.Token // token.LBRACKET
+	Elements []Expression
+}
+
+func (al *ArrayLiteral) expressionNode() {}
+func (al *ArrayLiteral) TokenLiteral() string {
+	return al.Token.Literal
+}
+func (al *ArrayLiteral) String() string {
+	elements := []string{}
+	for _, e := range al.Elements {
+		elements = append(elements, nodeString(e))
+	}
+
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// HashLiteral keeps its pairs in source order, which is the order of the
+// hash it evaluates to.
+type HashLiteral struct {
+	Token token.Token // token.LBRACE
+	Pairs []*HashPair
+}
+
+type HashPair struct {
+	Key   Expression
+	Value Expression
+}
+
+func (hl *HashLiteral) expressionNode() {}
+func (hl *HashLiteral) TokenLiteral() string {
+	return hl.Token.Literal
+}
+func (hl *HashLiteral) String() string {
+	pairs := []string{}
+	for _, p := range hl.Pairs {
+		pairs = append(pairs, nodeString(p.Key)+": "+nodeString(p.Value))
+	}
+
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+type IndexExpression struct {
+	Token token.Token // token.LBRACKET
+	Left  Expression
+	Index Expression
+}
+
+func (ie *IndexExpression) expressionNode() {}
+func (ie *IndexExpression) TokenLiteral() string {
+	return ie.Token.Literal
+}
+func (ie *IndexExpression) String() string {
+	return "(" + nodeString(ie.Left) + "[" + nodeString(ie.Index) + "])"
+}
+
+// RangeExpression is a range of integers from Start up to End, which is
+// left out unless Inclusive. Step is nil for the default of 1.
+type RangeExpression struct {
+	Token     token.Token // token.RANGE or token.RANGE_INCLUSIVE
+	Start     Expression
+	End       Expression
+	Step      Expression
+	Inclusive bool
+}
+
+func (re *RangeExpression) expressionNode() {}
+func (re *RangeExpression) TokenLiteral() string {
+	return re.Token.Literal
+}
+func (re *RangeExpression) String() string {
+	var out bytes.Buffer
+
+	out.WriteString("(")
+	out.WriteString(nodeString(re.Start))
+	out.WriteString(re.TokenLiteral())
+	out.WriteString(nodeString(re.End))
+	if re.Step != nil {
+		out.WriteString(" step ")
+		out.WriteString(re.Step.String())
+	}
+	out.WriteString(")")
+
+	return out.String()
+}
+
+// MatchExpression evaluates to the body of the first arm whose pattern
+// matches Value and whose guard, if any, is truthy, or to null if there is
+// none.
+type MatchExpression struct {
+	Token token.Token // token.MATCH
+	Value Expression
+	Arms  []*MatchArm
+}
+
+// MatchArm binds the identifiers of Pattern for Guard and Body only, to new
+// variables shadowing those of the enclosing scope.
+type MatchArm struct {
+	Pattern Pattern
+	Guard   Expression
+	Body    Expression
+}
+
+func (me *MatchExpression) expressionNode() {}
+func (me *MatchExpression) TokenLiteral() string {
+	return me.Token.Literal
+}
+func (me *MatchExpression) String() string {
+	arms := []string{}
+	for _, a := range me.Arms {
+		arms = append(arms, a.String())
+	}
+
+	return "match (" + nodeString(me.Value) + ") { " + strings.Join(arms, ", ") + " }"
+}
+
+func (ma *MatchArm) String() string {
+	var out bytes.Buffer
+
+	out.WriteString(nodeString(ma.Pattern))
+	if ma.Guard != nil {
+		out.WriteString(" if ")
+		out.WriteString(ma.Guard.String())
+	}
+	out.WriteString(" => ")
+	out.WriteString(nodeString(ma.Body))
+
+	return out.String()
+}
+
+// Pattern is the target of a destructuring let, which binds the parts of
+// the value matching its shape. Identifiers bind the whole value.
+type Pattern interface {
+	Node
+	patternNode()
+}
+
+// ArrayPattern binds the elements of an array by position. Rest, if not nil,
+// binds an array of the elements after them, otherwise the array must not
+// have more elements than the pattern.
+type ArrayPattern struct {
+	Token    token.Token // token.LBRACKET
+	Elements []Pattern
+	Rest     *Identifier
+}
+
+func (ap *ArrayPattern) patternNode() {}
+func (ap *ArrayPattern) TokenLiteral() string {
+	return ap.Token.Literal
+}
+func (ap *ArrayPattern) String() string {
+	elements := []string{}
+	for _, e := range ap.Elements {
+		elements = append(elements, nodeString(e))
+	}
+	if ap.Rest != nil {
+		elements = append(elements, "..."+ap.Rest.String())
+	}
+
+	return "[" + strings.Join(elements, ", ") + "]"
+}
+
+// HashPattern binds the values of string keys of a hash, other keys are
+// ignored.
+type HashPattern struct {
+	Token token.Token // token.LBRACE
+	Pairs []*HashPatternPair
+}
+
+// HashPatternPair binds the value of the key named like Key to Value, which
+// is an identifier of the same name for the shorthand `{name}`.
+type HashPatternPair struct {
+	Key   *Identifier
+	Value Pattern
+}
+
+func (hp *HashPattern) patternNode() {}
+func (hp *HashPattern) TokenLiteral() string {
+	return hp.Token.Literal
+}
+func (hp *HashPattern) String() string {
+	pairs := []string{}
+	for _, p := range hp.Pairs {
+		pairs = append(pairs, p.String())
+	}
+
+	return "{" + strings.Join(pairs, ", ") + "}"
+}
+
+func (p *HashPatternPair) String() string {
+	target := p.Value
+	if d, ok := target.(*DefaultPattern); ok {
+		target = d.Target
+	}
+	if p.Key == nil {
+		return ": " + nodeString(p.Value)
+	}
+	if id, ok := target.(*Identifier); ok && id.Value == p.Key.Value {
+		return nodeString(p.Value)
+	}
+	return p.Key.String() + ": " + nodeString(p.Value)
+}
+
+// DefaultPattern binds Default to Target when the element or key it matches
+// is missing or null.
+type DefaultPattern struct {
+	Token   token.Token // token.ASSIGN
+	Target  Pattern
+	Default Expression
+}
+
+func (dp *DefaultPattern) patternNode() {}
+func (dp *DefaultPattern) TokenLiteral() string {
+	return dp.Token.Literal
+}
+func (dp *DefaultPattern) String() string {
+	return nodeString(dp.Target) + " = " + nodeString(dp.Default)
+}
+
+// LiteralPattern matches values equal to an integer, string or boolean
+// literal, possibly negated. It is only allowed in the arms of a match.
+type LiteralPattern struct {
+	Token token.Token // the first token of Value
+	Value Expression
+}
+
+func (lp *LiteralPattern) patternNode() {}
+func (lp *LiteralPattern) TokenLiteral() string {
+	return lp.Token.Literal
+}
+func (lp *LiteralPattern) String() string {
+	return nodeString(lp.Value)
+}
+
+// WildcardPattern matches any value without binding it. It is only allowed
+// in the arms of a match, elsewhere `_` is an identifier.
+type WildcardPattern struct {
+	Token token.Token // token.IDENT "_"
+}
+
+func (wp *WildcardPattern) patternNode() {}
+func (wp *WildcardPattern) TokenLiteral() string {
+	return wp.Token.Literal
+}
+func (wp *WildcardPattern) String() string {
+	return "_"
+}
+
+// PatternNames returns the names bound by the pattern in source order, a
+// repeated name once per occurrence.
+func PatternNames(p Pattern) []string {
+	var names []string
+	var collect func(Pattern)
+	collect = func(p Pattern) {
+		switch p := p.(type) {
+		case *Identifier:
+			names = append(names, p.Value)
+		case *ArrayPattern:
+			for _, e := range p.Elements {
+				collect(e)
+			}
+			if p.Rest != nil {
+				names = append(names, p.Rest.Value)
+			}
+		case *HashPattern:
+			for _, pair := range p.Pairs {
+				collect(pair.Value)
+			}
+		case *DefaultPattern:
+			collect(p.Target)
+		}
+	}
+	collect(p)
+	return names
+}
+
+// Returns the string of a child node, which may be missing in a tree built
+// from invalid input.
+func nodeString(n Node) string {
+	if n == nil {
+		return ""
+	}
+	return n.String()
+}
@@ -2,6 +2,7 @@ package ast
 
 import (
 	"interpreter/token"
+	"strings"
 	"testing"
 )
 
@@ -28,3 +29,122 @@ func TestString(t *testing.T) {
 		t.Errorf("expected program.String() to be %s, got %s", expected, actual)
 	}
 }
+
+func TestStringIncompleteNodes(t *testing.T) {
+	tests := []struct {
+		node     Node
+		expected string
+	}{
+		{&PrefixExpression{Token: token.Token{Type: token.MINUS, Literal: "-"}, Operator: "-"}, "(-)"},
+		{&InfixExpression{Token: token.Token{Type: token.PLUS, Literal: "+"}, Operator: "+"}, "( + )"},
+		{&LetStatement{Token: token.Token{Type: token.LET, Literal: "let"}}, "let  = ;"},
+		{&ExpressionStatement{}, ""},
+		{&WhileStatement{Token: token.Token{Type: token.WHILE, Literal: "while"}}, "while "},
+		{&ForStatement{Token: token.Token{Type: token.FOR, Literal: "for"}}, "for (; ; ) "},
+		{&ArrayPattern{Token: token.Token{Type: token.LBRACKET, Literal: "["}, Elements: []Pattern{nil}}, "[]"},
+		{&HashPattern{Token: token.Token{Type: token.LBRACE, Literal: "{"}, Pairs: []*HashPatternPair{{}}}, "{: }"},
+		{&DefaultPattern{Token: token.Token{Type: token.ASSIGN, Literal: "="}}, " = "},
+		{&MatchExpression{Token: token.Token{Type: token.MATCH, Literal: "match"}, Arms: []*MatchArm{{}}}, "match () {  =>  }"},
+		{&LiteralPattern{}, ""},
+	}
+
+	for _, tt := range tests {
+		if actual := tt.node.String(); actual != tt.expected {
+			t.Errorf("expected %T.String() to be %q, got %q", tt.node, tt.expected, actual)
+		}
+	}
+}
+
+func TestEndsWithExpression(t *testing.T) {
+	expression := &ExpressionStatement{Expression: &Identifier{Value: "x"}}
+	let := &LetStatement{Name: &Identifier{Value: "x"}, Value: &Identifier{Value: "y"}}
+
+	tests := []struct {
+		statements []Statement
+		expected   bool
+	}{
+		{nil, false},
+		{[]Statement{expression}, true},
+		{[]Statement{expression, let}, false},
+		{[]Statement{let, expression}, true},
+	}
+
+	for i, tt := range tests {
+		program := &Program{Statements: tt.statements}
+		if actual := program.EndsWithExpression(); actual != tt.expected {
+			t.Errorf("tests[%d] - expected %t, got %t", i, tt.expected, actual)
+		}
+	}
+}
+
+func TestNestsFunctions(t *testing.T) {
+	inner := &FunctionLiteral{Body: &BlockStatement{}}
+	body := func(e Expression) *BlockStatement {
+		return &BlockStatement{Statements: []Statement{&ExpressionStatement{Expression: e}}}
+	}
+
+	tests := []struct {
+		fn       *FunctionLiteral
+		expected bool
+	}{
+		{&FunctionLiteral{Body: &BlockStatement{}}, false},
+		{&FunctionLiteral{Body: body(&Identifier{Value: "x"})}, false},
+		{&FunctionLiteral{Body: body(inner)}, true},
+		{&FunctionLiteral{Body: body(&CallExpression{Function: &Identifier{Value: "f"}, Arguments: []Expression{inner}})}, true},
+		{&FunctionLiteral{Parameters: []*Identifier{{Value: "f"}}, Defaults: []Expression{inner}, Body: &BlockStatement{}}, true},
+	}
+
+	for i, tt := range tests {
+		if actual := tt.fn.NestsFunctions(); actual != tt.expected {
+			t.Errorf("tests[%d] - expected %t, got %t", i, tt.expected, actual)
+		}
+	}
+}
+
+func TestPatternNames(t *testing.T) {
+	id := func(name string) *Identifier { return &Identifier{Value: name} }
+
+	tests := []struct {
+		pattern  Pattern
+		expected []string
+	}{
+		{id("x"), []string{"x"}},
+		{&WildcardPattern{}, nil},
+		{&LiteralPattern{Value: &IntegerLiteral{Value: 1}}, nil},
+		{&ArrayPattern{Elements: []Pattern{id("a"), &WildcardPattern{}, id("a")}, Rest: id("rest")}, []string{"a", "a", "rest"}},
+		{&HashPattern{Pairs: []*HashPatternPair{
+			{Key: id("a"), Value: &DefaultPattern{Target: id("b"), Default: id("c")}},
+			{Key: id("d"), Value: &ArrayPattern{Elements: []Pattern{id("e")}}},
+		}}, []string{"b", "e"}},
+	}
+
+	for i, tt := range tests {
+		actual := PatternNames(tt.pattern)
+		if strings.Join(actual, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("tests[%d] - expected %q, got %q", i, tt.expected, actual)
+		}
+	}
+}
+
+func TestArity(t *testing.T) {
+	id := func(name string) *Identifier { return &Identifier{Value: name} }
+	one := &IntegerLiteral{Value: 1}
+
+	tests := []struct {
+		function *FunctionLiteral
+		min, max int
+	}{
+		{&FunctionLiteral{Parameters: []*Identifier{id("x"), id("y")}}, 2, 2},
+		{&FunctionLiteral{Parameters: []*Identifier{id("x"), id("y")}, Defaults: []Expression{nil, one}}, 1, 2},
+		{&FunctionLiteral{Parameters: []*Identifier{id("x"), id("y")}, Defaults: []Expression{one, one}}, 0, 2},
+		{&FunctionLiteral{Rest: id("rest")}, 0, -1},
+		{&FunctionLiteral{Parameters: []*Identifier{id("x"), id("y")}, Defaults: []Expression{nil, one}, Rest: id("rest")}, 1, -1},
+	}
+
+	for i, tt := range tests {
+		min, max := tt.function.Arity()
+		if min != tt.min || max != tt.max {
+			t.Errorf("tests[%d] - expected arity %d to %d, got %d to %d", i, tt.min, tt.max, min, max)
+		}
+	}
+}
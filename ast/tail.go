@@ -0,0 +1,50 @@
+package ast
+
+// TailCalls returns the calls in tail position of the function: those whose
+// value is returned, by a return statement or as the value of the last
+// expression statement of the body, directly or through the branches of
+// an if or the arms of a match. The calls of nested functions are left out.
+func TailCalls(fn *FunctionLiteral) map[*CallExpression]bool {
+	calls := make(map[*CallExpression]bool)
+	if fn.Body == nil {
+		return calls
+	}
+
+	Inspect(fn.Body, func(n Node) bool {
+		switch n := n.(type) {
+		case *FunctionLiteral:
+			return false
+		case *ReturnStatement:
+			markTail(n.ReturnValue, calls)
+		}
+		return true
+	})
+	markBlockTail(fn.Body, calls)
+
+	return calls
+}
+
+func markTail(e Expression, calls map[*CallExpression]bool) {
+	switch e := e.(type) {
+	case *CallExpression:
+		calls[e] = true
+	case *IfExpression:
+		markBlockTail(e.Consequence, calls)
+		markBlockTail(e.Alternative, calls)
+	case *MatchExpression:
+		for _, a := range e.Arms {
+			markTail(a.Body, calls)
+		}
+	}
+}
+
+// The value of a block is the value of its last statement if that is an
+// expression statement.
+func markBlockTail(b *BlockStatement, calls map[*CallExpression]bool) {
+	if b == nil || len(b.Statements) == 0 {
+		return
+	}
+	if es, ok := b.Statements[len(b.Statements)-1].(*ExpressionStatement); ok {
+		markTail(es.Expression, calls)
+	}
+}
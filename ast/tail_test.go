@@ -0,0 +1,55 @@
+package ast_test
+
+import (
+	"sort"
+	"strings"
+	"testing"
+
+	"interpreter/ast"
+	"interpreter/lexer"
+	"interpreter/parser"
+)
+
+func TestTailCalls(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"fn(n) { f(n) }", []string{"f(n)"}},
+		{"fn(n) { f(n); }", []string{"f(n)"}},
+		{"fn(n) { f(n); 1 }", nil},
+		{"fn(n) { 1 + f(n) }", nil},
+		{"fn(n) { g(f(n)) }", []string{"g(f(n))"}},
+		{"fn(n) { let x = f(n); x }", nil},
+		{"fn(n) { return f(n); }", []string{"f(n)"}},
+		{"fn(n) { if (n) { return a(n); } b(n) }", []string{"a(n)", "b(n)"}},
+		{"fn(n) { if (n) { a(n) } else { b(n) } }", []string{"a(n)", "b(n)"}},
+		{"fn(n) { if (c(n)) { if (n) { a(n) } } else { 1; b(n); } }", []string{"a(n)", "b(n)"}},
+		{"fn(n) { if (n) { a(n) } 2 }", nil},
+		{"fn(n) { fn() { a(n) } }", nil},
+		{"fn(n) { fn() { a(n) }() }", []string{"fn() a(n)()"}},
+		{"fn() { }", nil},
+		{"fn(n) { while (n) { if (n) { return a(n); } b(n) } }", []string{"a(n)"}},
+		{"fn(n) { for (;;) { a(n) } }", nil},
+		{"fn(n) { match (n) { 0 => a(n), x if b(x) => c(x) } }", []string{"a(n)", "c(x)"}},
+	}
+
+	for _, tt := range tests {
+		p := parser.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			t.Fatalf("parser errors for %q: %v", tt.input, p.Errors())
+		}
+		fn := program.Statements[0].(*ast.ExpressionStatement).Expression.(*ast.FunctionLiteral)
+
+		var actual []string
+		for call := range ast.TailCalls(fn) {
+			actual = append(actual, call.String())
+		}
+		sort.Strings(actual)
+
+		if strings.Join(actual, " ") != strings.Join(tt.expected, " ") {
+			t.Errorf("wrong tail calls for %q. expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package ast
+
+import "reflect"
+
+// Inspect traverses the tree rooted at node in depth-first order. It calls
+// f for each node and then visits its children if f returns true. Children
+// missing from a tree built from invalid input are skipped.
+func Inspect(node Node, f func(Node) bool) {
+	if isNil(node) || !f(node) {
+		return
+	}
+
+	switch n := node.(type) {
+	case *Program:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+	case *LetStatement:
+		Inspect(n.Name, f)
+		Inspect(n.Pattern, f)
+		Inspect(n.Value, f)
+	case *ReturnStatement:
+		Inspect(n.ReturnValue, f)
+	case *ExpressionStatement:
+		Inspect(n.Expression, f)
+	case *BlockStatement:
+		for _, s := range n.Statements {
+			Inspect(s, f)
+		}
+	case *PrefixExpression:
+		Inspect(n.Right, f)
+	case *InfixExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Right, f)
+	case *AssignExpression:
+		Inspect(n.Target, f)
+		Inspect(n.Value, f)
+	case *IfExpression:
+		Inspect(n.Condition, f)
+		Inspect(n.Consequence, f)
+		Inspect(n.Alternative, f)
+	case *WhileStatement:
+		Inspect(n.Condition, f)
+		Inspect(n.Body, f)
+	case *ForStatement:
+		Inspect(n.Init, f)
+		Inspect(n.Condition, f)
+		Inspect(n.Update, f)
+		Inspect(n.Body, f)
+	case *ForInStatement:
+		for _, v := range n.Variables {
+			Inspect(v, f)
+		}
+		Inspect(n.Iterable, f)
+		Inspect(n.Body, f)
+	case *ArrayLiteral:
+		for _, e := range n.Elements {
+			Inspect(e, f)
+		}
+	case *HashLiteral:
+		for _, p := range n.Pairs {
+			Inspect(p.Key, f)
+			Inspect(p.Value, f)
+		}
+	case *IndexExpression:
+		Inspect(n.Left, f)
+		Inspect(n.Index, f)
+	case *RangeExpression:
+		Inspect(n.Start, f)
+		Inspect(n.End, f)
+		Inspect(n.Step, f)
+	case *ArrayPattern:
+		for _, e := range n.Elements {
+			Inspect(e, f)
+		}
+		Inspect(n.Rest, f)
+	case *HashPattern:
+		for _, p := range n.Pairs {
+			Inspect(p.Key, f)
+			Inspect(p.Value, f)
+		}
+	case *DefaultPattern:
+		Inspect(n.Target, f)
+		Inspect(n.Default, f)
+	case *LiteralPattern:
+		Inspect(n.Value, f)
+	case *MatchExpression:
+		Inspect(n.Value, f)
+		for _, a := range n.Arms {
+			Inspect(a.Pattern, f)
+			Inspect(a.Guard, f)
+			Inspect(a.Body, f)
+		}
+	case *FunctionLiteral:
+		for i, p := range n.Parameters {
+			Inspect(p, f)
+			Inspect(n.Default(i), f)
+		}
+		Inspect(n.Rest, f)
+		Inspect(n.Body, f)
+	case *SpreadExpression:
+		Inspect(n.Value, f)
+	case *CallExpression:
+		Inspect(n.Function, f)
+		for _, a := range n.Arguments {
+			Inspect(a, f)
+		}
+	}
+}
+
+func isNil(node Node) bool {
+	if node == nil {
+		return true
+	}
+	v := reflect.ValueOf(node)
+	return v.Kind() == reflect.Ptr && v.IsNil()
+}
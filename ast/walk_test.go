@@ -0,0 +1,69 @@
+package ast
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"interpreter/token"
+)
+
+func TestInspect(t *testing.T) {
+	ident := func(name string) *Identifier {
+		return &Identifier{Token: token.Token{Type: token.IDENT, Literal: name}, Value: name}
+	}
+
+	// let f = fn(x, y = 1, ...r) { if (x) { g(...x) } }; -f
+	program := &Program{
+		Statements: []Statement{
+			&LetStatement{
+				Name: ident("f"),
+				Value: &FunctionLiteral{
+					Parameters: []*Identifier{ident("x"), ident("y")},
+					Defaults:   []Expression{nil, &IntegerLiteral{Value: 1}},
+					Rest:       ident("r"),
+					Body: &BlockStatement{Statements: []Statement{
+						&ExpressionStatement{Expression: &IfExpression{
+							Condition: ident("x"),
+							Consequence: &BlockStatement{Statements: []Statement{
+								&ExpressionStatement{Expression: &CallExpression{
+									Function:  ident("g"),
+									Arguments: []Expression{&SpreadExpression{Value: ident("x")}},
+								}},
+							}},
+						}},
+					}},
+				},
+			},
+			&ExpressionStatement{Expression: &PrefixExpression{Operator: "-", Right: ident("f")}},
+			&LetStatement{},
+		},
+	}
+
+	var visited []string
+	Inspect(program, func(n Node) bool {
+		visited = append(visited, strings.TrimPrefix(fmt.Sprintf("%T", n), "*ast."))
+		return true
+	})
+
+	expected := "Program LetStatement Identifier FunctionLiteral Identifier Identifier " +
+		"IntegerLiteral Identifier BlockStatement ExpressionStatement IfExpression Identifier " +
+		"BlockStatement ExpressionStatement CallExpression Identifier SpreadExpression Identifier " +
+		"ExpressionStatement PrefixExpression Identifier LetStatement"
+	if actual := strings.Join(visited, " "); actual != expected {
+		t.Errorf("wrong order of nodes.\nexpected=%s\ngot=%s", expected, actual)
+	}
+
+	// returning false skips the children
+	functions := 0
+	Inspect(program, func(n Node) bool {
+		_, ok := n.(*FunctionLiteral)
+		if ok {
+			functions++
+		}
+		return !ok
+	})
+	if functions != 1 {
+		t.Errorf("expected 1 function, got=%d", functions)
+	}
+}
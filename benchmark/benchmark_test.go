@@ -0,0 +1,119 @@
+package benchmark
+
+import (
+	"testing"
+
+	"interpreter/ast"
+	"interpreter/compiler"
+	"interpreter/lexer"
+	"interpreter/object"
+	"interpreter/parser"
+	"interpreter/regvm"
+	"interpreter/vm"
+)
+
+var programs = []struct {
+	name     string
+	input    string
+	expected int64
+}{
+	{
+		name: "fib",
+		input: `
+		let fib = fn(n) {
+			if (n < 2) { return n; }
+			fib(n - 1) + fib(n - 2)
+		};
+		fib(20)`,
+		expected: 6765,
+	},
+	{
+		name: "loop",
+		input: `
+		let total = 0;
+		for (let times = 0; times < 20; times += 1) {
+			let n = 500;
+			while (n > 0) {
+				total += n % 7;
+				n -= 1;
+			}
+		}
+		total`,
+		expected: 20 * 1497,
+	},
+	{
+		name: "arithmetic",
+		input: `
+		let step = fn(a, b, c) {
+			(a * 3 + b * 5 - c * 7) % 1000 + (a - b) * (b - c) % 97 - (c + a) / 3 + 2 ** 3 * a % 11
+		};
+		let chain = fn(n, acc) {
+			if (n == 0) { return acc; }
+			chain(n - 1, step(acc, n, n * 2) % 10007)
+		};
+		chain(300, 1)`,
+		expected: 83,
+	},
+}
+
+func BenchmarkStackVM(b *testing.B) {
+	for _, p := range programs {
+		b.Run(p.name, func(b *testing.B) {
+			comp := compiler.New()
+			if err := comp.Compile(parse(b, p.input)); err != nil {
+				b.Fatalf("compiler error: %s", err)
+			}
+			bytecode := comp.Bytecode()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				machine := vm.New(bytecode)
+				if err := machine.Run(); err != nil {
+					b.Fatalf("vm error: %s", err)
+				}
+				check(b, p.expected, machine.LastPoppedStackElem())
+			}
+		})
+	}
+}
+
+func BenchmarkRegisterVM(b *testing.B) {
+	for _, p := range programs {
+		b.Run(p.name, func(b *testing.B) {
+			program, err := regvm.Compile(parse(b, p.input))
+			if err != nil {
+				b.Fatalf("compiler error: %s", err)
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				machine := regvm.New(program)
+				if err := machine.Run(); err != nil {
+					b.Fatalf("vm error: %s", err)
+				}
+				check(b, p.expected, machine.Result())
+			}
+		})
+	}
+}
+
+func parse(b *testing.B, input string) *ast.Program {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		b.Fatalf("parser errors: %v", p.Errors())
+	}
+	return program
+}
+
+// Checks the result so that both backends are known to compute the same
+// thing.
+func check(b *testing.B, expected int64, actual object.Object) {
+	integer, ok := actual.(*object.Integer)
+	if !ok {
+		b.Fatalf("result is not Integer. got=%T (%+v)", actual, actual)
+	}
+	if integer.Value != expected {
+		b.Fatalf("wrong result. expected=%d, got=%d", expected, integer.Value)
+	}
+}
@@ -0,0 +1,5 @@
+// Package benchmark compares the execution backends of Monkey. It only
+// contains benchmarks, run them with
+//
+//	go test ./benchmark -run '^$' -bench .
+package benchmark
@@ -0,0 +1,454 @@
+package bytecode
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"strings"
+
+	"interpreter/code"
+	"interpreter/compiler"
+	"interpreter/lexer"
+	"interpreter/object"
+	"interpreter/optimizer"
+	"interpreter/parser"
+)
+
+// A compiled program on disk is laid out as
+//
+//	magic    "MKBC"
+//	version  uint16
+//	length   uint32, of the payload
+//	checksum uint32, CRC-32 (IEEE) of the payload
+//	payload  constants, instructions, symbols, builtins, source map
+//
+// Fixed size integers are big-endian like instruction operands, the payload
+// uses unsigned and signed varints.
+const magic = "MKBC"
+
+// Version must be bumped whenever the instruction set or the payload
+// changes, so that stale caches are recompiled instead of misread.
+const Version = 15
+
+// CacheSuffix is appended to a source path to get the path of its cache.
+const CacheSuffix = "c"
+
+var ErrFormat = errors.New("not a compiled Monkey program")
+
+// Tags of the constants in the payload.
+const (
+	tagInteger byte = iota + 1
+	tagFunction
+	tagString
+)
+
+func Encode(w io.Writer, bc *compiler.Bytecode) error {
+	var payload bytes.Buffer
+	e := &encoder{w: &payload}
+
+	e.uvarint(uint64(len(bc.Constants)))
+	for _, constant := range bc.Constants {
+		if err := e.constant(constant); err != nil {
+			return err
+		}
+	}
+
+	e.bytes(bc.Instructions)
+
+	e.uvarint(uint64(len(bc.Symbols)))
+	for _, symbol := range bc.Symbols {
+		e.bytes([]byte(symbol.Name))
+		e.bytes([]byte(symbol.Scope))
+		e.uvarint(uint64(symbol.Index))
+		e.bool(symbol.Const)
+	}
+	e.strings(bc.Builtins)
+
+	e.sourceMap(bc.SourceMap)
+
+	header := make([]byte, len(magic)+2+4+4)
+	copy(header, magic)
+	binary.BigEndian.PutUint16(header[4:], Version)
+	binary.BigEndian.PutUint32(header[6:], uint32(payload.Len()))
+	binary.BigEndian.PutUint32(header[10:], crc32.ChecksumIEEE(payload.Bytes()))
+
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err := w.Write(payload.Bytes())
+	return err
+}
+
+func Decode(r io.Reader) (*compiler.Bytecode, error) {
+	header := make([]byte, len(magic)+2+4+4)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrFormat
+	}
+	if string(header[:4]) != magic {
+		return nil, ErrFormat
+	}
+	if version := binary.BigEndian.Uint16(header[4:]); version != Version {
+		return nil, fmt.Errorf("unsupported bytecode version %d, expected %d", version, Version)
+	}
+
+	payload := make([]byte, binary.BigEndian.Uint32(header[6:]))
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("truncated bytecode: %w", err)
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[10:]) {
+		return nil, fmt.Errorf("bytecode checksum mismatch")
+	}
+
+	d := &decoder{r: bytes.NewReader(payload)}
+	bc := &compiler.Bytecode{}
+
+	bc.Constants = make([]object.Object, d.length())
+	for i := range bc.Constants {
+		bc.Constants[i] = d.constant()
+	}
+
+	bc.Instructions = code.Instructions(d.bytes())
+
+	bc.Symbols = make([]compiler.Symbol, d.length())
+	for i := range bc.Symbols {
+		bc.Symbols[i] = compiler.Symbol{
+			Name:  string(d.bytes()),
+			Scope: compiler.SymbolScope(d.bytes()),
+			Index: int(d.uvarint()),
+			Const: d.bool(),
+		}
+	}
+	bc.Builtins = d.strings()
+
+	bc.SourceMap = d.sourceMap()
+
+	if d.err != nil {
+		return nil, fmt.Errorf("malformed bytecode: %w", d.err)
+	}
+	if d.r.Len() != 0 {
+		return nil, fmt.Errorf("malformed bytecode: %d trailing bytes", d.r.Len())
+	}
+
+	return bc, nil
+}
+
+// Compile parses, optimizes and compiles a program, reporting all parser
+// errors as a single error. builtins are the names of the builtins the
+// program will be run with, by index.
+func Compile(input string, builtins ...string) (*compiler.Bytecode, error) {
+	bc, _, err := compile(input, builtins)
+	return bc, err
+}
+
+// Like Compile, also returning the parser warnings.
+func compile(input string, builtins []string) (*compiler.Bytecode, []string, error) {
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, nil, fmt.Errorf("parser errors:\n\t%s", strings.Join(p.Errors(), "\n\t"))
+	}
+
+	symbols := compiler.NewSymbolTable()
+	for i, name := range builtins {
+		symbols.DefineBuiltin(i, name)
+	}
+	c := compiler.NewWithState(symbols, []object.Object{})
+	if err := c.Compile(optimizer.New().Optimize(program)); err != nil {
+		return nil, nil, err
+	}
+
+	return c.Bytecode(), p.Warnings(), nil
+}
+
+// Writes the parser warnings of the source file at path to w, unless it is
+// nil.
+func writeWarnings(w io.Writer, path string, warnings []string) {
+	if w == nil {
+		return
+	}
+	for _, msg := range warnings {
+		fmt.Fprintf(w, "%s: warning: %s\n", path, msg)
+	}
+}
+
+// Build compiles the source file at path like Compile and writes the result
+// next to it, returning the path of the written cache. The parser warnings
+// are written to warnings unless it is nil.
+func Build(path string, warnings io.Writer, builtins ...string) (string, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	bc, msgs, err := compile(string(src), builtins)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	writeWarnings(warnings, path, msgs)
+
+	cachePath := path + CacheSuffix
+	return cachePath, writeFile(cachePath, bc)
+}
+
+// Load returns the compiled program for the source file at path. The cache
+// written by Build is used if it is newer than the source, valid and built
+// with the same builtins, otherwise the source is compiled like Compile and
+// the cache refreshed on a best-effort basis. The parser warnings are
+// written to warnings unless it is nil, only when the source is compiled.
+func Load(path string, warnings io.Writer, builtins ...string) (*compiler.Bytecode, error) {
+	srcInfo, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cachePath := path + CacheSuffix
+	if cacheInfo, err := os.Stat(cachePath); err == nil && cacheInfo.ModTime().After(srcInfo.ModTime()) {
+		if bc, err := readFile(cachePath); err == nil && sameNames(bc.Builtins, builtins) {
+			return bc, nil
+		}
+	}
+
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	bc, msgs, err := compile(string(src), builtins)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	writeWarnings(warnings, path, msgs)
+
+	// an unwritable cache only costs the next run a compilation
+	_ = writeFile(cachePath, bc)
+
+	return bc, nil
+}
+
+// Reports whether the programs compiled with the builtins named a and b
+// refer to the same builtins by index.
+func sameNames(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func readFile(path string) (*compiler.Bytecode, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return Decode(bufio.NewReader(f))
+}
+
+// Writes to a temporary file first so that readers never see a partially
+// written cache.
+func writeFile(path string, bc *compiler.Bytecode) error {
+	var buf bytes.Buffer
+	if err := Encode(&buf, bc); err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+type encoder struct {
+	w   *bytes.Buffer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (e *encoder) uvarint(v uint64) {
+	n := binary.PutUvarint(e.buf[:], v)
+	e.w.Write(e.buf[:n])
+}
+
+func (e *encoder) varint(v int64) {
+	n := binary.PutVarint(e.buf[:], v)
+	e.w.Write(e.buf[:n])
+}
+
+func (e *encoder) bool(b bool) {
+	if b {
+		e.w.WriteByte(1)
+	} else {
+		e.w.WriteByte(0)
+	}
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.uvarint(uint64(len(b)))
+	e.w.Write(b)
+}
+
+func (e *encoder) strings(s []string) {
+	e.uvarint(uint64(len(s)))
+	for _, str := range s {
+		e.bytes([]byte(str))
+	}
+}
+
+func (e *encoder) constant(obj object.Object) error {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		e.w.WriteByte(tagInteger)
+		e.varint(obj.Value)
+	case *object.CompiledFunction:
+		e.w.WriteByte(tagFunction)
+		e.bytes([]byte(obj.Name))
+		e.uvarint(uint64(obj.NumParameters))
+		e.uvarint(uint64(obj.NumDefaults))
+		e.bool(obj.Variadic)
+		e.uvarint(uint64(obj.NumLocals))
+		e.bool(obj.Captured)
+		e.strings(obj.ConstLocals)
+		e.bytes(obj.Instructions)
+		e.sourceMap(obj.SourceMap)
+	case *object.String:
+		e.w.WriteByte(tagString)
+		e.bytes([]byte(obj.Value))
+	default:
+		return fmt.Errorf("cannot encode constant of type %s", obj.Type())
+	}
+	return nil
+}
+
+func (e *encoder) sourceMap(sm code.SourceMap) {
+	e.uvarint(uint64(len(sm)))
+	for _, location := range sm {
+		e.uvarint(uint64(location.Offset))
+		e.uvarint(uint64(location.Line))
+		e.uvarint(uint64(location.Column))
+	}
+}
+
+// decoder keeps the first error and returns zero values afterwards, so that
+// callers only check once at the end.
+type decoder struct {
+	r   *bytes.Reader
+	err error
+}
+
+func (d *decoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadUvarint(d.r)
+	d.err = err
+	return v
+}
+
+func (d *decoder) varint() int64 {
+	if d.err != nil {
+		return 0
+	}
+	v, err := binary.ReadVarint(d.r)
+	d.err = err
+	return v
+}
+
+// Reads a count of items, bounded by the remaining payload so that a bogus
+// count cannot make us allocate huge slices.
+func (d *decoder) length() int {
+	n := d.uvarint()
+	if d.err == nil && n > uint64(d.r.Len()) {
+		d.err = fmt.Errorf("length %d exceeds payload", n)
+	}
+	if d.err != nil {
+		return 0
+	}
+	return int(n)
+}
+
+func (d *decoder) bool() bool {
+	if d.err != nil {
+		return false
+	}
+	b, err := d.r.ReadByte()
+	if err == nil && b > 1 {
+		err = fmt.Errorf("invalid boolean %d", b)
+	}
+	d.err = err
+	return b == 1
+}
+
+func (d *decoder) bytes() []byte {
+	n := d.length()
+	if d.err != nil {
+		return nil
+	}
+	b := make([]byte, n)
+	_, d.err = io.ReadFull(d.r, b)
+	return b
+}
+
+// Decodes a list of strings, nil if it is empty.
+func (d *decoder) strings() []string {
+	var s []string
+	for n := d.length(); n > 0; n-- {
+		s = append(s, string(d.bytes()))
+	}
+	return s
+}
+
+func (d *decoder) constant() object.Object {
+	if d.err != nil {
+		return nil
+	}
+
+	tag, err := d.r.ReadByte()
+	if err != nil {
+		d.err = err
+		return nil
+	}
+
+	switch tag {
+	case tagInteger:
+		return &object.Integer{Value: d.varint()}
+	case tagFunction:
+		return &object.CompiledFunction{
+			Name:          string(d.bytes()),
+			NumParameters: int(d.uvarint()),
+			NumDefaults:   int(d.uvarint()),
+			Variadic:      d.bool(),
+			NumLocals:     int(d.uvarint()),
+			Captured:      d.bool(),
+			ConstLocals:   d.strings(),
+			Instructions:  code.Instructions(d.bytes()),
+			SourceMap:     d.sourceMap(),
+		}
+	case tagString:
+		return &object.String{Value: string(d.bytes())}
+	default:
+		d.err = fmt.Errorf("unknown constant tag %d", tag)
+		return nil
+	}
+}
+
+func (d *decoder) sourceMap() code.SourceMap {
+	sm := make(code.SourceMap, d.length())
+	for i := range sm {
+		sm[i] = code.Location{
+			Offset: int(d.uvarint()),
+			Line:   int(d.uvarint()),
+			Column: int(d.uvarint()),
+		}
+	}
+	return sm
+}
@@ -0,0 +1,269 @@
+package bytecode
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"interpreter/compiler"
+	"interpreter/object"
+	"interpreter/vm"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	inputs := []string{
+		"",
+		"1 + 2",
+		"let a = -5 ** 2;\nlet b = a % 7 != 3;\n!b",
+		"let fib = fn(n) {\n  if (n < 2) { return n; }\n  fib(n - 1) + fib(n - 2)\n};\nfib(10) + fn() { }()",
+		"let adder = fn(a) { fn(b) { a + b } };\nadder(1)(2)",
+		"let greet = fn(name) { \"hello, \" + name + \"\\n\" };\ngreet(\"monkey\")",
+		"const limit = 3;\nlet count = limit;\ncount += 1",
+		"let f = fn() { let a = 1; const k = 2; fn() { a + k } };\nf()()",
+		"let [a, {b = 2}, ...c] = [1, {}];\n[a, b, c]",
+		"let f = fn(x, y = 10, ...rest) { [x, y, rest] };\nf(...[1, 2], 3)",
+	}
+
+	for _, input := range inputs {
+		bc, err := Compile(input)
+		if err != nil {
+			t.Fatalf("compile error: %s", err)
+		}
+
+		var buf bytes.Buffer
+		if err := Encode(&buf, bc); err != nil {
+			t.Fatalf("encode error: %s", err)
+		}
+
+		decoded, err := Decode(&buf)
+		if err != nil {
+			t.Fatalf("decode error: %s", err)
+		}
+
+		if !reflect.DeepEqual(normalize(bc), normalize(decoded)) {
+			t.Errorf("decoded bytecode differs.\nexpected=%+v\ngot=%+v", bc, decoded)
+		}
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	bc, err := Compile("let x = 42; x * 2")
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	var buf bytes.Buffer
+	if err := Encode(&buf, bc); err != nil {
+		t.Fatalf("encode error: %s", err)
+	}
+	valid := buf.Bytes()
+
+	corrupt := func(i int, b byte) []byte {
+		data := append([]byte{}, valid...)
+		data[i] = b
+		return data
+	}
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected string
+	}{
+		{"empty", []byte{}, ErrFormat.Error()},
+		{"magic", corrupt(0, 'X'), ErrFormat.Error()},
+		{"version", corrupt(5, Version+1), "unsupported bytecode version"},
+		{"checksum", corrupt(len(valid)-1, valid[len(valid)-1]^0xff), "checksum mismatch"},
+		{"truncated", valid[:len(valid)-3], "truncated bytecode"},
+	}
+
+	for _, tt := range tests {
+		_, err := Decode(bytes.NewReader(tt.data))
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.expected) {
+			t.Errorf("%s: expected error containing %q, got=%q", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "main.mk")
+	cache := src + CacheSuffix
+
+	write := func(content string, modTime time.Time) {
+		t.Helper()
+		if err := os.WriteFile(src, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(src, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	now := time.Now()
+	write("1 + 1", now.Add(-time.Hour))
+
+	path, err := Build(src, nil)
+	if err != nil {
+		t.Fatalf("build error: %s", err)
+	}
+	if path != cache {
+		t.Fatalf("expected cache at %s, got=%s", cache, path)
+	}
+
+	// an edit that keeps an older modification time is not noticed
+	write("2 + 2", now.Add(-time.Hour))
+	testLoad(t, src, 2)
+
+	// a newer source is recompiled and the cache refreshed
+	write("3 + 3", now.Add(time.Hour))
+	testLoad(t, src, 6)
+	write("4 + 4", now.Add(-time.Hour))
+	testLoad(t, src, 6)
+
+	// a broken cache is ignored
+	if err := os.WriteFile(cache, []byte("MKBC garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	testLoad(t, src, 8)
+}
+
+func TestLoadWithOtherBuiltins(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "main.mk")
+	if err := os.WriteFile(src, []byte("let puts = 1; double(21)"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(src, old, old); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Build(src, nil, "puts", "double"); err != nil {
+		t.Fatalf("build error: %s", err)
+	}
+
+	// the cache is used with the builtins it was built with, even shadowed
+	bc, err := Load(src, nil, "puts", "double")
+	if err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	if !reflect.DeepEqual(bc.Builtins, []string{"puts", "double"}) {
+		t.Errorf("expected the builtins of the cache, got=%q", bc.Builtins)
+	}
+
+	// and recompiled with others, whose indices differ
+	bc, err = Load(src, nil, "double")
+	if err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	if !reflect.DeepEqual(bc.Builtins, []string{"double"}) {
+		t.Errorf("expected the program to be recompiled, got builtins %q", bc.Builtins)
+	}
+
+	double := &object.Builtin{Name: "double", Fn: func(args ...object.Object) (object.Object, error) {
+		return &object.Integer{Value: 2 * args[0].(*object.Integer).Value}, nil
+	}}
+	machine := vm.NewWithBuiltins(bc, make([]object.Object, vm.GlobalsSize), []*object.Builtin{double})
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if result := machine.LastPoppedStackElem().Inspect(); result != "42" {
+		t.Errorf("expected result 42, got=%s", result)
+	}
+}
+
+func TestWarnings(t *testing.T) {
+	src := filepath.Join(t.TempDir(), "main.mk")
+	if err := os.WriteFile(src, []byte("match (1) { 2 => 3 }"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(src, old, old); err != nil {
+		t.Fatal(err)
+	}
+	expected := src + ": warning: 1:1: match has no wildcard arm, unmatched values give null\n"
+
+	var warnings bytes.Buffer
+	if _, err := Build(src, &warnings); err != nil {
+		t.Fatalf("build error: %s", err)
+	}
+	if warnings.String() != expected {
+		t.Errorf("expected warnings %q, got=%q", expected, warnings.String())
+	}
+
+	// the cache is up to date, so the source is not parsed again
+	warnings.Reset()
+	if _, err := Load(src, &warnings); err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+	if warnings.Len() != 0 {
+		t.Errorf("expected no warnings from the cache, got=%q", warnings.String())
+	}
+}
+
+func TestCompileWithBuiltins(t *testing.T) {
+	double := &object.Builtin{Name: "double", Fn: func(args ...object.Object) (object.Object, error) {
+		return &object.Integer{Value: 2 * args[0].(*object.Integer).Value}, nil
+	}}
+
+	if _, err := Compile("double(21)"); err == nil {
+		t.Fatalf("expected an error for an unknown builtin")
+	}
+
+	bc, err := Compile("double(21)", "puts", "double")
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	machine := vm.NewWithBuiltins(bc, make([]object.Object, vm.GlobalsSize), []*object.Builtin{nil, double})
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	if result := machine.LastPoppedStackElem().Inspect(); result != "42" {
+		t.Errorf("expected result 42, got=%s", result)
+	}
+}
+
+func testLoad(t *testing.T, path string, expected int64) {
+	t.Helper()
+
+	bc, err := Load(path, nil)
+	if err != nil {
+		t.Fatalf("load error: %s", err)
+	}
+
+	machine := vm.New(bc)
+	if err := machine.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+
+	result := machine.LastPoppedStackElem().Inspect()
+	if result != fmt.Sprint(expected) {
+		t.Errorf("expected result %d, got=%s", expected, result)
+	}
+}
+
+// Makes empty slices comparable to nil ones.
+func normalize(bc *compiler.Bytecode) compiler.Bytecode {
+	n := *bc
+	if len(n.Instructions) == 0 {
+		n.Instructions = nil
+	}
+	if len(n.Constants) == 0 {
+		n.Constants = nil
+	}
+	if len(n.Symbols) == 0 {
+		n.Symbols = nil
+	}
+	if len(n.SourceMap) == 0 {
+		n.SourceMap = nil
+	}
+	return n
+}
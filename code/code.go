@@ -0,0 +1,319 @@
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+type Instructions []byte
+
+// String disassembles the instructions, one per line prefixed with its
+// offset.
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+
+		i += 1 + read
+	}
+
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n",
+			len(operands), operandCount)
+	}
+
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	case 3:
+		return fmt.Sprintf("%s %d %d %d", def.Name, operands[0], operands[1], operands[2])
+	}
+
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpPow
+
+	OpTrue
+	OpFalse
+
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpLessThan
+
+	OpMinus
+	OpBang
+
+	OpGetGlobal
+	OpSetGlobal
+
+	OpJumpNotTruthy
+	OpJump
+	OpNull
+
+	OpCall
+	OpReturnValue
+	OpReturn
+	OpGetLocal
+	OpSetLocal
+
+	OpGetBuiltin
+
+	OpClosure
+	OpGetEnv
+	OpSetEnv
+
+	OpTailCall
+
+	OpArray
+	OpHash
+	OpIndex
+	OpRange
+
+	OpIterator
+	OpIterNext
+
+	OpSetIndex
+	OpDupPair
+
+	OpCheckArray
+	OpCheckHash
+	OpElement
+	OpRest
+	OpField
+	OpJumpNotNull
+
+	OpDup
+	OpMatchArray
+	OpMatchHash
+	OpHasKey
+
+	OpCallSpread
+
+	OpInitGlobal
+	OpInitEnv
+)
+
+type Definition struct {
+	Name          string
+	OperandWidths []int // number of bytes of each operand
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{2}},
+	OpPop:      {"OpPop", []int{}},
+
+	OpAdd: {"OpAdd", []int{}},
+	OpSub: {"OpSub", []int{}},
+	OpMul: {"OpMul", []int{}},
+	OpDiv: {"OpDiv", []int{}},
+	OpMod: {"OpMod", []int{}},
+	OpPow: {"OpPow", []int{}},
+
+	OpTrue:  {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+
+	OpEqual:       {"OpEqual", []int{}},
+	OpNotEqual:    {"OpNotEqual", []int{}},
+	OpGreaterThan: {"OpGreaterThan", []int{}},
+	OpLessThan:    {"OpLessThan", []int{}},
+
+	OpMinus: {"OpMinus", []int{}},
+	OpBang:  {"OpBang", []int{}},
+
+	OpGetGlobal: {"OpGetGlobal", []int{2}},
+	OpSetGlobal: {"OpSetGlobal", []int{2}},
+
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{2}},
+	OpJump:          {"OpJump", []int{2}},
+	OpNull:          {"OpNull", []int{}},
+
+	OpCall:        {"OpCall", []int{1}}, // number of arguments
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+	OpGetLocal:    {"OpGetLocal", []int{1}},
+	OpSetLocal:    {"OpSetLocal", []int{1}},
+
+	OpGetBuiltin: {"OpGetBuiltin", []int{2}},
+
+	OpClosure: {"OpClosure", []int{2}},   // constant index of the function
+	OpGetEnv:  {"OpGetEnv", []int{1, 1}}, // depth, index
+	OpSetEnv:  {"OpSetEnv", []int{1, 1}},
+
+	// call replacing the frame of the caller, which returns its result
+	OpTailCall: {"OpTailCall", []int{1}},
+
+	OpArray: {"OpArray", []int{2}}, // number of elements
+	OpHash:  {"OpHash", []int{2}},  // number of keys and values
+	OpIndex: {"OpIndex", []int{}},
+	OpRange: {"OpRange", []int{1}}, // 1 if inclusive, the step is on top of the end
+
+	// replaces the iterable on top of the stack with an iterator
+	OpIterator: {"OpIterator", []int{}},
+	// pushes the key and the element of the next iteration, or only one
+	// of them, or jumps once the iterator on top of the stack is exhausted
+	OpIterNext: {"OpIterNext", []int{2, 1}}, // jump target, number of values
+
+	// pops the value, the index and the indexed object, pushes the value
+	OpSetIndex: {"OpSetIndex", []int{}},
+	// duplicates the two values on top of the stack
+	OpDupPair: {"OpDupPair", []int{}},
+
+	// destructuring: the checks fail unless the value on top of the stack
+	// has the shape of the pattern, the other instructions push a part of
+	// it, which stays on the stack
+	OpCheckArray: {"OpCheckArray", []int{2, 2, 1}}, // required and pattern elements, 1 if there is a rest
+	OpCheckHash:  {"OpCheckHash", []int{}},
+	OpElement:    {"OpElement", []int{2}},  // index, null past the end
+	OpRest:       {"OpRest", []int{2}},     // index of the first element of the rest
+	OpField:      {"OpField", []int{2, 1}}, // constant index of the key, 1 if required
+	// pops the value on top of the stack if it is null, otherwise jumps
+	// over the instructions computing a default value
+	OpJumpNotNull: {"OpJumpNotNull", []int{2}},
+
+	// duplicates the value on top of the stack
+	OpDup: {"OpDup", []int{}},
+
+	// matching: the tests push whether the value on top of the stack, which
+	// stays on the stack, has the shape of the pattern
+	OpMatchArray: {"OpMatchArray", []int{2, 2, 1}}, // required and pattern elements, 1 if there is a rest
+	OpMatchHash:  {"OpMatchHash", []int{}},
+	OpHasKey:     {"OpHasKey", []int{2}}, // constant index of the key
+
+	// call with the elements of the arrays above the callee as arguments
+	OpCallSpread: {"OpCallSpread", []int{1, 1}}, // number of arrays, 1 if in tail position
+
+	// stores of const declarations, the only ones to the slots of
+	// constants, which OpSetGlobal and OpSetEnv refuse
+	OpInitGlobal: {"OpInitGlobal", []int{2}},
+	OpInitEnv:    {"OpInitEnv", []int{1, 1}}, // depth, index
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+
+	return def, nil
+}
+
+// Make encodes an instruction, operands are big-endian.
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+
+	return instruction
+}
+
+// ReadOperands decodes the operands following an opcode and returns them
+// along with the number of bytes read.
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+
+	return operands, offset
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 {
+	return uint8(ins[0])
+}
+
+// Location is the source position of the instructions starting at Offset.
+type Location struct {
+	Offset int
+	Line   int
+	Column int
+}
+
+// SourceMap maps instructions back to the source they were compiled from.
+// Locations are ordered by offset and only recorded when the position
+// changes, so an instruction belongs to the last location at or before it.
+type SourceMap []Location
+
+// Add records the position of the instruction at offset.
+func (sm SourceMap) Add(offset, line, column int) SourceMap {
+	if n := len(sm); n > 0 && sm[n-1].Line == line && sm[n-1].Column == column {
+		return sm
+	}
+	return append(sm, Location{Offset: offset, Line: line, Column: column})
+}
+
+// Lookup returns the location of the instruction at offset.
+func (sm SourceMap) Lookup(offset int) (Location, bool) {
+	i := sort.Search(len(sm), func(i int) bool { return sm[i].Offset > offset })
+	if i == 0 {
+		return Location{}, false
+	}
+	return sm[i-1], true
+}
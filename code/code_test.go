@@ -0,0 +1,134 @@
+package code
+
+import "testing"
+
+func TestMake(t *testing.T) {
+	tests := []struct {
+		op       Opcode
+		operands []int
+		expected []byte
+	}{
+		{OpConstant, []int{65534}, []byte{byte(OpConstant), 255, 254}},
+		{OpAdd, []int{}, []byte{byte(OpAdd)}},
+		{OpSetGlobal, []int{1}, []byte{byte(OpSetGlobal), 0, 1}},
+		{OpGetLocal, []int{255}, []byte{byte(OpGetLocal), 255}},
+		{OpGetEnv, []int{2, 255}, []byte{byte(OpGetEnv), 2, 255}},
+		{OpIterNext, []int{258, 2}, []byte{byte(OpIterNext), 1, 2, 2}},
+		{OpCheckArray, []int{1, 258, 1}, []byte{byte(OpCheckArray), 0, 1, 1, 2, 1}},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		if len(instruction) != len(tt.expected) {
+			t.Fatalf("instruction has wrong length. expected=%d, got=%d", len(tt.expected), len(instruction))
+		}
+
+		for i, b := range tt.expected {
+			if instruction[i] != tt.expected[i] {
+				t.Errorf("wrong byte at pos %d. expected=%d, got=%d", i, b, instruction[i])
+			}
+		}
+	}
+}
+
+func TestInstructionsString(t *testing.T) {
+	instructions := []Instructions{
+		Make(OpAdd),
+		Make(OpConstant, 2),
+		Make(OpConstant, 65535),
+		Make(OpGetGlobal, 1),
+		Make(OpGetLocal, 1),
+		Make(OpSetEnv, 1, 3),
+		Make(OpMatchArray, 1, 2, 1),
+		Make(OpPop),
+	}
+
+	expected := `0000 OpAdd
+0001 OpConstant 2
+0004 OpConstant 65535
+0007 OpGetGlobal 1
+0010 OpGetLocal 1
+0012 OpSetEnv 1 3
+0015 OpMatchArray 1 2 1
+0021 OpPop
+`
+
+	concatted := Instructions{}
+	for _, ins := range instructions {
+		concatted = append(concatted, ins...)
+	}
+
+	if concatted.String() != expected {
+		t.Errorf("instructions wrongly formatted.\nexpected=%q\ngot=%q", expected, concatted.String())
+	}
+}
+
+func TestReadOperands(t *testing.T) {
+	tests := []struct {
+		op        Opcode
+		operands  []int
+		bytesRead int
+	}{
+		{OpConstant, []int{65535}, 2},
+		{OpGetLocal, []int{255}, 1},
+		{OpGetEnv, []int{1, 2}, 2},
+		{OpIterNext, []int{65535, 1}, 3},
+		{OpCheckArray, []int{2, 3, 1}, 5},
+		{OpPop, []int{}, 0},
+	}
+
+	for _, tt := range tests {
+		instruction := Make(tt.op, tt.operands...)
+
+		def, err := Lookup(byte(tt.op))
+		if err != nil {
+			t.Fatalf("definition not found: %q\n", err)
+		}
+
+		operandsRead, n := ReadOperands(def, instruction[1:])
+		if n != tt.bytesRead {
+			t.Fatalf("n wrong. expected=%d, got=%d", tt.bytesRead, n)
+		}
+
+		for i, expected := range tt.operands {
+			if operandsRead[i] != expected {
+				t.Errorf("operand wrong. expected=%d, got=%d", expected, operandsRead[i])
+			}
+		}
+	}
+}
+
+func TestSourceMap(t *testing.T) {
+	var sm SourceMap
+	sm = sm.Add(0, 1, 1)
+	sm = sm.Add(3, 1, 1)
+	sm = sm.Add(4, 2, 5)
+	sm = sm.Add(7, 1, 3)
+
+	if len(sm) != 3 {
+		t.Fatalf("expected repeated positions to be merged, got=%+v", sm)
+	}
+
+	tests := []struct {
+		offset   int
+		expected Location
+	}{
+		{0, Location{0, 1, 1}},
+		{3, Location{0, 1, 1}},
+		{4, Location{4, 2, 5}},
+		{6, Location{4, 2, 5}},
+		{100, Location{7, 1, 3}},
+	}
+
+	for _, tt := range tests {
+		location, ok := sm.Lookup(tt.offset)
+		if !ok || location != tt.expected {
+			t.Errorf("expected offset %d at %+v, got=%+v (%t)", tt.offset, tt.expected, location, ok)
+		}
+	}
+
+	if _, ok := (SourceMap{}).Lookup(0); ok {
+		t.Errorf("expected no location in an empty source map")
+	}
+}
@@ -0,0 +1,1066 @@
+package compiler
+
+import (
+	"fmt"
+
+	"interpreter/ast"
+	"interpreter/code"
+	"interpreter/object"
+	"interpreter/token"
+)
+
+type Compiler struct {
+	constants   []object.Object
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	tailCalls map[*ast.CallExpression]bool // compiled to OpTailCall
+
+	line   int // source position of the node being compiled
+	column int
+}
+
+// CompilationScope holds the instructions of the function being compiled.
+type CompilationScope struct {
+	instructions        code.Instructions
+	sourceMap           code.SourceMap
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+
+	loops []*loop // enclosing the code being compiled, innermost last
+}
+
+// loop collects the jumps of the break and continue statements of a loop,
+// back-patched once their targets are known.
+type loop struct {
+	breaks    []int
+	continues []int
+}
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+	Symbols      []Symbol // global bindings
+	Builtins     []string // names of the builtins the program refers to by index
+	SourceMap    code.SourceMap
+}
+
+func New() *Compiler {
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: NewSymbolTable(),
+		scopes: []CompilationScope{
+			{instructions: code.Instructions{}},
+		},
+		tailCalls: make(map[*ast.CallExpression]bool),
+	}
+}
+
+// NewWithState returns a compiler that continues where a previous one left
+// off, so that globals defined earlier stay visible, e.g. in the REPL.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	c := New()
+	c.symbolTable = s
+	c.constants = constants
+	return c
+}
+
+func (c *Compiler) Compile(node ast.Node) error {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+		if len(node.Statements) > 0 && !node.EndsWithExpression() {
+			// the value of the program is the last popped one, which
+			// would otherwise be left over by an earlier statement
+			c.emit(code.OpNull)
+			c.emit(code.OpPop)
+		}
+
+	case *ast.ExpressionStatement:
+		defer c.at(node.Token)()
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.LetStatement:
+		defer c.at(node.Token)()
+		if node.Pattern != nil {
+			if err := c.Compile(node.Value); err != nil {
+				return err
+			}
+			return c.destructure(node.Pattern, node.Const)
+		}
+		if c.symbolTable.Constant(node.Name.Value) {
+			return redeclaredConstant(node.Name)
+		}
+
+		// a function may refer to the binding it is assigned to
+		var symbol Symbol
+		_, isFunction := node.Value.(*ast.FunctionLiteral)
+		if isFunction {
+			symbol = c.define(node.Name.Value, node.Const)
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if !isFunction {
+			symbol = c.define(node.Name.Value, node.Const)
+		}
+		c.initSymbol(symbol)
+
+	case *ast.ReturnStatement:
+		defer c.at(node.Token)()
+		if c.scopeIndex == 0 {
+			return fmt.Errorf("return outside of a function")
+		}
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.WhileStatement:
+		defer c.at(node.Token)()
+		start := len(c.currentInstructions())
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		exit := c.emit(code.OpJumpNotTruthy, 9999)
+
+		l, err := c.loopBody(node.Body)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, start)
+
+		end := len(c.currentInstructions())
+		c.changeOperand(exit, end)
+		c.patchJumps(l.breaks, end)
+		c.patchJumps(l.continues, start)
+
+	case *ast.ForStatement:
+		defer c.at(node.Token)()
+		if node.Init != nil {
+			if err := c.Compile(node.Init); err != nil {
+				return err
+			}
+		}
+
+		start := len(c.currentInstructions())
+		exit := -1
+		if node.Condition != nil {
+			if err := c.Compile(node.Condition); err != nil {
+				return err
+			}
+			exit = c.emit(code.OpJumpNotTruthy, 9999)
+		}
+
+		l, err := c.loopBody(node.Body)
+		if err != nil {
+			return err
+		}
+
+		next := len(c.currentInstructions())
+		if node.Update != nil {
+			if err := c.Compile(node.Update); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpJump, start)
+
+		end := len(c.currentInstructions())
+		if exit >= 0 {
+			c.changeOperand(exit, end)
+		}
+		c.patchJumps(l.breaks, end)
+		c.patchJumps(l.continues, next)
+
+	case *ast.ForInStatement:
+		defer c.at(node.Token)()
+		if err := c.Compile(node.Iterable); err != nil {
+			return err
+		}
+		// the iterator stays on the stack for the duration of the loop
+		c.emit(code.OpIterator)
+
+		next := c.emit(code.OpIterNext, 9999, len(node.Variables))
+		symbols := make([]Symbol, len(node.Variables))
+		for i, v := range node.Variables {
+			if c.symbolTable.Constant(v.Value) {
+				return redeclaredConstant(v)
+			}
+			symbols[i] = c.symbolTable.Define(v.Value)
+		}
+		for i := len(symbols) - 1; i >= 0; i-- {
+			c.storeSymbol(symbols[i])
+		}
+
+		l, err := c.loopBody(node.Body)
+		if err != nil {
+			return err
+		}
+		c.emit(code.OpJump, next)
+
+		end := c.emit(code.OpPop)
+		c.changeOperand(next, end)
+		c.patchJumps(l.breaks, end)
+		c.patchJumps(l.continues, next)
+
+	case *ast.BreakStatement:
+		defer c.at(node.Token)()
+		l := c.currentLoop()
+		if l == nil {
+			return fmt.Errorf("break outside of a loop")
+		}
+		l.breaks = append(l.breaks, c.emit(code.OpJump, 9999))
+
+	case *ast.ContinueStatement:
+		defer c.at(node.Token)()
+		l := c.currentLoop()
+		if l == nil {
+			return fmt.Errorf("continue outside of a loop")
+		}
+		l.continues = append(l.continues, c.emit(code.OpJump, 9999))
+
+	case *ast.Identifier:
+		defer c.at(node.Token)()
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			return &UndefinedVariableError{Name: node.Value}
+		}
+		c.loadSymbol(symbol)
+
+	case *ast.PrefixExpression:
+		defer c.at(node.Token)()
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		switch node.Operator {
+		case "!":
+			c.emit(code.OpBang)
+		case "-":
+			c.emit(code.OpMinus)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.InfixExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+
+		// blame the operator for errors of the operation
+		defer c.at(node.Token)()
+
+		op, ok := infixOpcodes[node.Operator]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		c.emit(op)
+
+	case *ast.AssignExpression:
+		switch target := node.Target.(type) {
+		case *ast.Identifier:
+			return c.assignVariable(node, target)
+		case *ast.IndexExpression:
+			return c.assignIndex(node, target)
+		default:
+			return fmt.Errorf("cannot assign to %s", node.Target)
+		}
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+
+		defer c.at(node.Token)()
+		c.emit(code.OpIndex)
+
+	case *ast.RangeExpression:
+		if err := c.Compile(node.Start); err != nil {
+			return err
+		}
+		if err := c.Compile(node.End); err != nil {
+			return err
+		}
+		if node.Step != nil {
+			if err := c.Compile(node.Step); err != nil {
+				return err
+			}
+		}
+
+		defer c.at(node.Token)()
+		if node.Step == nil {
+			c.emit(code.OpConstant, c.addConstant(&object.Integer{Value: 1}))
+		}
+		inclusive := 0
+		if node.Inclusive {
+			inclusive = 1
+		}
+		c.emit(code.OpRange, inclusive)
+
+	case *ast.IfExpression:
+		defer c.at(node.Token)()
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+
+		// bogus offset, back-patched below
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+		if err := c.Compile(node.Consequence); err != nil {
+			return err
+		}
+		c.keepBlockValue()
+
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+		if node.Alternative == nil {
+			c.emit(code.OpNull)
+		} else {
+			if err := c.Compile(node.Alternative); err != nil {
+				return err
+			}
+			c.keepBlockValue()
+		}
+
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	case *ast.MatchExpression:
+		defer c.at(node.Token)()
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+
+		// the value stays on the stack until an arm matches, each arm
+		// matching a copy of it
+		var ends []int
+		for _, arm := range node.Arms {
+			var fails matchFailures
+			if err := c.matchArm(arm, &fails); err != nil {
+				return err
+			}
+			ends = append(ends, c.emit(code.OpJump, 9999))
+
+			// failed tests land on the pops of the parts of the value they
+			// left on the stack, falling through to the next arm
+			for depth := len(fails) - 1; depth >= 0; depth-- {
+				for _, pos := range fails[depth] {
+					c.changeOperand(pos, len(c.currentInstructions()))
+				}
+				if depth > 0 {
+					c.emit(code.OpPop)
+				}
+			}
+		}
+
+		c.emit(code.OpPop)
+		c.emit(code.OpNull)
+		for _, pos := range ends {
+			c.changeOperand(pos, len(c.currentInstructions()))
+		}
+
+	case *ast.FunctionLiteral:
+		defer c.at(node.Token)()
+		c.enterScope()
+		c.symbolTable.Captured = node.NestsFunctions()
+		for call := range ast.TailCalls(node) {
+			c.tailCalls[call] = true
+		}
+
+		params := []Symbol{}
+		for _, p := range node.Parameters {
+			params = append(params, c.symbolTable.Define(p.Value))
+		}
+		if node.Rest != nil {
+			c.symbolTable.Define(node.Rest.Value)
+		}
+
+		// missing arguments are null, which the defaults replace in order
+		for i, symbol := range params {
+			if d := node.Default(i); d != nil {
+				c.loadSymbol(symbol)
+				jump := c.emit(code.OpJumpNotNull, 9999)
+				if err := c.Compile(d); err != nil {
+					return err
+				}
+				c.changeOperand(jump, len(c.currentInstructions()))
+				c.storeSymbol(symbol)
+			}
+		}
+
+		if err := c.Compile(node.Body); err != nil {
+			return err
+		}
+
+		// the value of the last expression statement is returned implicitly
+		if c.lastInstructionIs(code.OpPop) {
+			c.replaceLastPopWithReturn()
+		}
+		if !c.lastInstructionIs(code.OpReturnValue) {
+			c.emit(code.OpReturn)
+		}
+
+		numLocals := c.symbolTable.numDefinitions
+		captured := c.symbolTable.Captured
+		var consts []string
+		if captured {
+			consts = ConstNames(c.symbolTable.Symbols())
+		}
+		required, _ := node.Arity()
+		instructions, sourceMap := c.leaveScope()
+
+		fn := &object.CompiledFunction{
+			Instructions:  instructions,
+			SourceMap:     sourceMap,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+			NumDefaults:   len(node.Parameters) - required,
+			Variadic:      node.Rest != nil,
+			Name:          node.Name,
+			Captured:      captured,
+			ConstLocals:   consts,
+		}
+
+		// only functions nested in others have an environment to capture
+		if c.symbolTable.Captured {
+			c.emit(code.OpClosure, c.addConstant(fn))
+		} else {
+			c.emit(code.OpConstant, c.addConstant(fn))
+		}
+
+	case *ast.CallExpression:
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+		if spreads(node.Arguments) {
+			return c.callSpread(node)
+		}
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+
+		defer c.at(node.Token)()
+		if len(node.Arguments) > 255 {
+			return fmt.Errorf("too many arguments: %d", len(node.Arguments))
+		}
+		if c.tailCalls[node] {
+			c.emit(code.OpTailCall, len(node.Arguments))
+		} else {
+			c.emit(code.OpCall, len(node.Arguments))
+		}
+
+	case *ast.IntegerLiteral:
+		defer c.at(node.Token)()
+		integer := &object.Integer{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(integer))
+
+	case *ast.StringLiteral:
+		defer c.at(node.Token)()
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+
+	case *ast.ArrayLiteral:
+		defer c.at(node.Token)()
+		for _, el := range node.Elements {
+			if err := c.Compile(el); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpArray, len(node.Elements))
+
+	case *ast.HashLiteral:
+		defer c.at(node.Token)()
+		for _, pair := range node.Pairs {
+			if err := c.Compile(pair.Key); err != nil {
+				return err
+			}
+			if err := c.Compile(pair.Value); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpHash, len(node.Pairs)*2)
+
+	case *ast.Boolean:
+		defer c.at(node.Token)()
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	default:
+		return fmt.Errorf("cannot compile %T", node)
+	}
+
+	return nil
+}
+
+// Compiles an assignment to the nearest binding of the variable. The
+// assigned value is the value of the expression.
+func (c *Compiler) assignVariable(node *ast.AssignExpression, target *ast.Identifier) error {
+	symbol, ok := c.symbolTable.Resolve(target.Value)
+	if !ok {
+		return &UndefinedVariableError{Name: target.Value}
+	}
+	if symbol.Scope == BuiltinScope {
+		return fmt.Errorf("cannot assign to builtin %s", target.Value)
+	}
+	if symbol.Const {
+		return assignedConstant(node)
+	}
+
+	defer c.at(node.Token)()
+	if node.Operator != "" {
+		c.loadSymbol(symbol)
+	}
+	if err := c.Compile(node.Value); err != nil {
+		return err
+	}
+	if err := c.compoundOperator(node); err != nil {
+		return err
+	}
+	c.storeSymbol(symbol)
+	c.loadSymbol(symbol)
+	return nil
+}
+
+// Defines a name bound by a let or const statement.
+func (c *Compiler) define(name string, constant bool) Symbol {
+	if constant {
+		return c.symbolTable.DefineConst(name)
+	}
+	return c.symbolTable.Define(name)
+}
+
+// Binds the names of the pattern to the parts of the value on top of the
+// stack, which it pops. The value is checked against the shape of the
+// pattern as it is taken apart, before any name is bound.
+func (c *Compiler) destructure(pattern ast.Pattern, constant bool) error {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		if c.symbolTable.Constant(pattern.Value) {
+			return redeclaredConstant(pattern)
+		}
+		c.initSymbol(c.define(pattern.Value, constant))
+
+	case *ast.ArrayPattern:
+		defer c.at(pattern.Token)()
+		required, rest := arrayBounds(pattern)
+		c.emit(code.OpCheckArray, required, len(pattern.Elements), rest)
+
+		for i, e := range pattern.Elements {
+			c.emit(code.OpElement, i)
+			if err := c.destructure(e, constant); err != nil {
+				return err
+			}
+		}
+		if pattern.Rest != nil {
+			c.emit(code.OpRest, len(pattern.Elements))
+			if err := c.destructure(pattern.Rest, constant); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpPop)
+
+	case *ast.HashPattern:
+		defer c.at(pattern.Token)()
+		c.emit(code.OpCheckHash)
+
+		for _, pair := range pattern.Pairs {
+			key := c.addConstant(&object.String{Value: pair.Key.Value})
+			required := 1
+			if _, ok := pair.Value.(*ast.DefaultPattern); ok {
+				required = 0
+			}
+			restore := c.at(pair.Key.Token)
+			c.emit(code.OpField, key, required)
+			restore()
+
+			if err := c.destructure(pair.Value, constant); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpPop)
+
+	case *ast.DefaultPattern:
+		jump := c.emit(code.OpJumpNotNull, 9999)
+		if err := c.Compile(pattern.Default); err != nil {
+			return err
+		}
+		c.changeOperand(jump, len(c.currentInstructions()))
+		return c.destructure(pattern.Target, constant)
+
+	default:
+		return fmt.Errorf("cannot destructure with %T", pattern)
+	}
+	return nil
+}
+
+// Returns the number of elements an array must have for pattern, those
+// after the last one without a default may be missing, and 1 if it has a
+// rest.
+func arrayBounds(pattern *ast.ArrayPattern) (required, rest int) {
+	for i, e := range pattern.Elements {
+		if _, ok := e.(*ast.DefaultPattern); !ok {
+			required = i + 1
+		}
+	}
+	if pattern.Rest != nil {
+		rest = 1
+	}
+	return required, rest
+}
+
+// The jumps of the failed tests of a match arm, by the number of parts of
+// the value they leave on the stack above it.
+type matchFailures [][]int
+
+func (f *matchFailures) add(depth, pos int) {
+	for len(*f) <= depth {
+		*f = append(*f, nil)
+	}
+	(*f)[depth] = append((*f)[depth], pos)
+}
+
+// Compiles an arm of a match, testing a copy of the value on top of the
+// stack. The names of its pattern are new variables that only its guard
+// and body see, so that neither the enclosing scope nor the other arms are
+// affected by its bindings.
+func (c *Compiler) matchArm(arm *ast.MatchArm, fails *matchFailures) error {
+	defer c.symbolTable.Shadow(ast.PatternNames(arm.Pattern))()
+
+	c.emit(code.OpDup)
+	if err := c.match(arm.Pattern, 1, fails); err != nil {
+		return err
+	}
+	if arm.Guard != nil {
+		if err := c.Compile(arm.Guard); err != nil {
+			return err
+		}
+		fails.add(0, c.emit(code.OpJumpNotTruthy, 9999))
+	}
+
+	c.emit(code.OpPop)
+	return c.Compile(arm.Body)
+}
+
+// Compiles the test of the pattern of a match arm against the value on top
+// of the stack, which is popped once it matched and its parts are bound.
+// depth is the number of values on the stack above the value of the match,
+// including this one.
+func (c *Compiler) match(pattern ast.Pattern, depth int, fails *matchFailures) error {
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+		c.emit(code.OpPop)
+
+	case *ast.Identifier:
+		c.storeSymbol(c.symbolTable.Define(pattern.Value))
+
+	case *ast.LiteralPattern:
+		c.emit(code.OpDup)
+		if err := c.Compile(pattern.Value); err != nil {
+			return err
+		}
+		c.emit(code.OpEqual)
+		fails.add(depth, c.emit(code.OpJumpNotTruthy, 9999))
+		c.emit(code.OpPop)
+
+	case *ast.ArrayPattern:
+		defer c.at(pattern.Token)()
+		required, rest := arrayBounds(pattern)
+		c.emit(code.OpMatchArray, required, len(pattern.Elements), rest)
+		fails.add(depth, c.emit(code.OpJumpNotTruthy, 9999))
+
+		for i, e := range pattern.Elements {
+			c.emit(code.OpElement, i)
+			if err := c.match(e, depth+1, fails); err != nil {
+				return err
+			}
+		}
+		if pattern.Rest != nil {
+			c.emit(code.OpRest, len(pattern.Elements))
+			if err := c.match(pattern.Rest, depth+1, fails); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpPop)
+
+	case *ast.HashPattern:
+		defer c.at(pattern.Token)()
+		c.emit(code.OpMatchHash)
+		fails.add(depth, c.emit(code.OpJumpNotTruthy, 9999))
+
+		for _, pair := range pattern.Pairs {
+			key := c.addConstant(&object.String{Value: pair.Key.Value})
+			if _, ok := pair.Value.(*ast.DefaultPattern); !ok {
+				c.emit(code.OpHasKey, key)
+				fails.add(depth, c.emit(code.OpJumpNotTruthy, 9999))
+			}
+			c.emit(code.OpField, key, 0)
+
+			if err := c.match(pair.Value, depth+1, fails); err != nil {
+				return err
+			}
+		}
+		c.emit(code.OpPop)
+
+	case *ast.DefaultPattern:
+		jump := c.emit(code.OpJumpNotNull, 9999)
+		if err := c.Compile(pattern.Default); err != nil {
+			return err
+		}
+		c.changeOperand(jump, len(c.currentInstructions()))
+		return c.match(pattern.Target, depth, fails)
+
+	default:
+		return fmt.Errorf("cannot match with %T", pattern)
+	}
+	return nil
+}
+
+// Compiles an assignment to an element of an array or a hash, which
+// evaluates the indexed object and the index once even for compound
+// assignments.
+func (c *Compiler) assignIndex(node *ast.AssignExpression, target *ast.IndexExpression) error {
+	if err := c.Compile(target.Left); err != nil {
+		return err
+	}
+	if err := c.Compile(target.Index); err != nil {
+		return err
+	}
+
+	defer c.at(node.Token)()
+	if node.Operator != "" {
+		c.emit(code.OpDupPair)
+		c.emit(code.OpIndex)
+	}
+	if err := c.Compile(node.Value); err != nil {
+		return err
+	}
+	if err := c.compoundOperator(node); err != nil {
+		return err
+	}
+	c.emit(code.OpSetIndex)
+	return nil
+}
+
+// Emits the operation of a compound assignment, whose current value and
+// operand are on top of the stack.
+func (c *Compiler) compoundOperator(node *ast.AssignExpression) error {
+	if node.Operator == "" {
+		return nil
+	}
+	op, ok := infixOpcodes[node.Operator]
+	if !ok {
+		return fmt.Errorf("unknown operator %s", node.Operator)
+	}
+	c.emit(op)
+	return nil
+}
+
+var infixOpcodes = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"%":  code.OpMod,
+	"**": code.OpPow,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	">":  code.OpGreaterThan,
+	"<":  code.OpLessThan,
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	return &Bytecode{
+		Instructions: c.currentInstructions(),
+		Constants:    c.constants,
+		Symbols:      c.symbolTable.Symbols(),
+		Builtins:     c.symbolTable.Builtins(),
+		SourceMap:    c.scopes[c.scopeIndex].sourceMap,
+	}
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+// Appends the instruction and returns its position.
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+
+	c.setLastInstruction(op, pos)
+	if c.line > 0 {
+		scope := &c.scopes[c.scopeIndex]
+		scope.sourceMap = scope.sourceMap.Add(pos, c.line, c.column)
+	}
+
+	return pos
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	c.scopes[c.scopeIndex].instructions = append(c.currentInstructions(), ins...)
+	return posNewInstruction
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+// Makes a block leave its value on the stack: the value of its last
+// expression statement, or null if it does not end with one.
+func (c *Compiler) keepBlockValue() {
+	if c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else if !c.lastInstructionIs(code.OpReturnValue) {
+		c.emit(code.OpNull)
+	}
+}
+
+func (c *Compiler) removeLastPop() {
+	scope := &c.scopes[c.scopeIndex]
+	last := scope.lastInstruction
+
+	scope.instructions = scope.instructions[:last.Position]
+	scope.lastInstruction = scope.previousInstruction
+
+	// drop the location of the removed instruction
+	if n := len(scope.sourceMap); n > 0 && scope.sourceMap[n-1].Offset >= last.Position {
+		scope.sourceMap = scope.sourceMap[:n-1]
+	}
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+	copy(ins[pos:], newInstruction)
+}
+
+// Replaces the first operand of the instruction at opPos, keeping the
+// others.
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	ins := c.currentInstructions()
+	def, err := code.Lookup(ins[opPos])
+	if err != nil {
+		panic(err)
+	}
+	operands, _ := code.ReadOperands(def, ins[opPos+1:])
+	operands[0] = operand
+	c.replaceInstruction(opPos, code.Make(code.Opcode(ins[opPos]), operands...))
+}
+
+// Compiles the body of a loop and returns the jumps of its break and
+// continue statements.
+func (c *Compiler) loopBody(body *ast.BlockStatement) (*loop, error) {
+	l := &loop{}
+	scope := c.scopeIndex
+	c.scopes[scope].loops = append(c.scopes[scope].loops, l)
+	if err := c.Compile(body); err != nil {
+		return nil, err
+	}
+
+	loops := c.scopes[scope].loops
+	c.scopes[scope].loops = loops[:len(loops)-1]
+	return l, nil
+}
+
+func (c *Compiler) currentLoop() *loop {
+	loops := c.scopes[c.scopeIndex].loops
+	if len(loops) == 0 {
+		return nil
+	}
+	return loops[len(loops)-1]
+}
+
+func (c *Compiler) patchJumps(positions []int, target int) {
+	for _, pos := range positions {
+		c.changeOperand(pos, target)
+	}
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case EnvScope:
+		c.emit(code.OpGetEnv, s.Depth, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	}
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpSetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpSetLocal, s.Index)
+	case EnvScope:
+		c.emit(code.OpSetEnv, s.Depth, s.Index)
+	}
+}
+
+// Stores the value of the declaration of s, which is the only store to a
+// global or captured constant that the VM allows.
+func (c *Compiler) initSymbol(s Symbol) {
+	switch {
+	case s.Const && s.Scope == GlobalScope:
+		c.emit(code.OpInitGlobal, s.Index)
+	case s.Const && s.Scope == EnvScope:
+		c.emit(code.OpInitEnv, s.Depth, s.Index)
+	default:
+		c.storeSymbol(s)
+	}
+}
+
+// Reports whether some of the arguments are spread.
+func spreads(args []ast.Expression) bool {
+	for _, a := range args {
+		if _, ok := a.(*ast.SpreadExpression); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Compiles a call spreading some of its arguments, whose callee has been
+// compiled. The arguments are passed as arrays: the spread values and the
+// runs of other arguments between them.
+func (c *Compiler) callSpread(node *ast.CallExpression) error {
+	numArrays, run := 0, 0
+	endRun := func() {
+		if run > 0 {
+			c.emit(code.OpArray, run)
+			numArrays, run = numArrays+1, 0
+		}
+	}
+	for _, a := range node.Arguments {
+		if spread, ok := a.(*ast.SpreadExpression); ok {
+			endRun()
+			if err := c.Compile(spread.Value); err != nil {
+				return err
+			}
+			numArrays++
+			continue
+		}
+		if err := c.Compile(a); err != nil {
+			return err
+		}
+		run++
+	}
+	endRun()
+
+	defer c.at(node.Token)()
+	if numArrays > 255 {
+		return fmt.Errorf("too many arguments: %d", len(node.Arguments))
+	}
+	tail := 0
+	if c.tailCalls[node] {
+		tail = 1
+	}
+	c.emit(code.OpCallSpread, numArrays, tail)
+	return nil
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, CompilationScope{instructions: code.Instructions{}})
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() (code.Instructions, code.SourceMap) {
+	scope := c.scopes[c.scopeIndex]
+
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+
+	return scope.instructions, scope.sourceMap
+}
+
+// Attributes the instructions emitted from now on to the position of tok,
+// until the returned function restores the previous position. Meant to be
+// deferred when compiling a node.
+func (c *Compiler) at(tok token.Token) func() {
+	line, column := c.line, c.column
+	c.line, c.column = tok.Line, tok.Column
+	return func() {
+		c.line, c.column = line, column
+	}
+}
+
+// UndefinedVariableError is returned for a name that resolves to no
+// symbol.
+type UndefinedVariableError struct {
+	Name string
+}
+
+func (e *UndefinedVariableError) Error() string {
+	return fmt.Sprintf("undefined variable %s", e.Name)
+}
+
+// Returns the error for redeclaring a constant in its own scope, positioned
+// at the redeclared name.
+func redeclaredConstant(name *ast.Identifier) error {
+	return fmt.Errorf("%d:%d: cannot redeclare constant %s", name.Token.Line, name.Token.Column, name.Value)
+}
+
+// Returns the error for assigning to a constant, positioned at the
+// assignment operator.
+func assignedConstant(node *ast.AssignExpression) error {
+	return fmt.Errorf("%d:%d: cannot assign to constant %s", node.Token.Line, node.Token.Column, node.Target)
+}
@@ -0,0 +1,182 @@
+package compiler
+
+import "sort"
+
+type SymbolScope string
+
+const (
+	GlobalScope  SymbolScope = "GLOBAL"
+	LocalScope   SymbolScope = "LOCAL"
+	BuiltinScope SymbolScope = "BUILTIN"
+	EnvScope     SymbolScope = "ENV"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+	Depth int  // environments to go up for EnvScope symbols
+	Const bool // defined by a const statement, cannot be assigned
+}
+
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	// Captured is set for functions containing nested functions, their
+	// locals are defined in EnvScope.
+	Captured bool
+
+	store          map[string]Symbol
+	numDefinitions int
+	builtins       []string // names of the builtins by index, even if shadowed
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{store: make(map[string]Symbol)}
+}
+
+// DefineBuiltin makes name refer to the builtin with the given index. Builtins
+// have their own index space and can be shadowed by other definitions.
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Scope: BuiltinScope, Index: index}
+	s.store[name] = symbol
+	for len(s.builtins) <= index {
+		s.builtins = append(s.builtins, "")
+	}
+	s.builtins[index] = name
+	return symbol
+}
+
+// Builtins returns the names of the builtins defined in this table by
+// index, "" for indices without one, which programs compiled with the table
+// must be run with.
+func (s *SymbolTable) Builtins() []string {
+	return s.builtins
+}
+
+// NewEnclosedSymbolTable returns a table for the locals of a function
+// nested in outer.
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+func (s *SymbolTable) Define(name string) Symbol {
+	symbol := Symbol{Name: name, Index: s.numDefinitions}
+	switch {
+	case s.Outer == nil:
+		symbol.Scope = GlobalScope
+	case s.Captured:
+		symbol.Scope = EnvScope
+	default:
+		symbol.Scope = LocalScope
+	}
+
+	s.store[name] = symbol
+	s.numDefinitions++
+	return symbol
+}
+
+// DefineConst defines name like Define, marking it as a constant.
+func (s *SymbolTable) DefineConst(name string) Symbol {
+	symbol := s.Define(name)
+	symbol.Const = true
+	s.store[name] = symbol
+	return symbol
+}
+
+// Constant reports whether name is a constant defined in this table, which
+// cannot be redeclared in the same scope. Constants of enclosing tables can
+// still be shadowed.
+func (s *SymbolTable) Constant(name string) bool {
+	return s.store[name].Const
+}
+
+// Clone returns a copy of the table that can be extended without affecting
+// the original, e.g. to only keep the definitions of a successful run.
+func (s *SymbolTable) Clone() *SymbolTable {
+	clone := &SymbolTable{
+		Outer:          s.Outer,
+		Captured:       s.Captured,
+		store:          make(map[string]Symbol, len(s.store)),
+		numDefinitions: s.numDefinitions,
+		builtins:       append([]string(nil), s.builtins...),
+	}
+	for name, symbol := range s.store {
+		clone.store[name] = symbol
+	}
+	return clone
+}
+
+// Shadow returns a function restoring the definitions of names as they are
+// now, which ends the scope of the definitions made for them in between,
+// e.g. by the pattern of a match arm. Their indices are not reused.
+func (s *SymbolTable) Shadow(names []string) func() {
+	saved := make(map[string]Symbol, len(names))
+	for _, name := range names {
+		if symbol, ok := s.store[name]; ok {
+			saved[name] = symbol
+		}
+	}
+	return func() {
+		for _, name := range names {
+			if symbol, ok := saved[name]; ok {
+				s.store[name] = symbol
+			} else {
+				delete(s.store, name)
+			}
+		}
+	}
+}
+
+// Resolve looks up name in this table and then in the enclosing ones.
+// The depth of EnvScope symbols is relative to the environment of this
+// table's function, which is one more level down if the function has one.
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	symbol, ok := s.store[name]
+	if ok || s.Outer == nil {
+		return symbol, ok
+	}
+
+	symbol, ok = s.Outer.Resolve(name)
+	if ok && symbol.Scope == EnvScope && s.Captured {
+		symbol.Depth++
+	}
+	return symbol, ok
+}
+
+// NumDefinitions returns how many symbols were defined in this table,
+// counting redefined names once per definition. Their indices are below it.
+func (s *SymbolTable) NumDefinitions() int {
+	return s.numDefinitions
+}
+
+// ConstNames returns the names of the constants among symbols by index, ""
+// for the other indices, or nil if there are none.
+func ConstNames(symbols []Symbol) []string {
+	var names []string
+	for _, symbol := range symbols {
+		if !symbol.Const {
+			continue
+		}
+		for len(names) <= symbol.Index {
+			names = append(names, "")
+		}
+		names[symbol.Index] = symbol.Name
+	}
+	return names
+}
+
+// Symbols returns the defined symbols ordered by index, leaving out
+// builtins.
+func (s *SymbolTable) Symbols() []Symbol {
+	symbols := make([]Symbol, 0, len(s.store))
+	for _, symbol := range s.store {
+		if symbol.Scope != BuiltinScope {
+			symbols = append(symbols, symbol)
+		}
+	}
+	sort.Slice(symbols, func(i, j int) bool { return symbols[i].Index < symbols[j].Index })
+	return symbols
+}
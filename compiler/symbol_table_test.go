@@ -0,0 +1,158 @@
+package compiler
+
+import "testing"
+
+func TestDefine(t *testing.T) {
+	expected := map[string]Symbol{
+		"a": {Name: "a", Scope: GlobalScope, Index: 0},
+		"b": {Name: "b", Scope: GlobalScope, Index: 1},
+	}
+
+	global := NewSymbolTable()
+
+	a := global.Define("a")
+	if a != expected["a"] {
+		t.Errorf("expected a=%+v, got=%+v", expected["a"], a)
+	}
+
+	b := global.Define("b")
+	if b != expected["b"] {
+		t.Errorf("expected b=%+v, got=%+v", expected["b"], b)
+	}
+}
+
+func TestResolveGlobal(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+	global.Define("b")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "b", Scope: GlobalScope, Index: 1},
+	}
+
+	for _, sym := range expected {
+		result, ok := global.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+
+	if _, ok := global.Resolve("c"); ok {
+		t.Errorf("expected c to be unresolvable")
+	}
+}
+
+func TestResolveLocal(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	local := NewEnclosedSymbolTable(global)
+	local.Define("c")
+	local.Define("a")
+
+	expected := []Symbol{
+		{Name: "a", Scope: LocalScope, Index: 1},
+		{Name: "c", Scope: LocalScope, Index: 0},
+	}
+
+	for _, sym := range expected {
+		result, ok := local.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+
+	global.Define("b")
+	if result, ok := local.Resolve("b"); !ok || result.Scope != GlobalScope {
+		t.Errorf("expected b to resolve to a global, got=%+v", result)
+	}
+}
+
+func TestClone(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	clone := global.Clone()
+	b := clone.Define("b")
+
+	if b.Index != 1 {
+		t.Errorf("expected b to get index 1, got=%d", b.Index)
+	}
+	if clone.NumDefinitions() != 2 || global.NumDefinitions() != 1 {
+		t.Errorf("expected 2 and 1 definitions, got=%d and %d", clone.NumDefinitions(), global.NumDefinitions())
+	}
+	if _, ok := clone.Resolve("a"); !ok {
+		t.Errorf("expected a to be resolvable in the clone")
+	}
+	if _, ok := global.Resolve("b"); ok {
+		t.Errorf("expected b not to be defined in the original")
+	}
+}
+
+func TestShadow(t *testing.T) {
+	global := NewSymbolTable()
+	a := global.DefineConst("a")
+
+	restore := global.Shadow([]string{"a", "b"})
+	global.Define("a")
+	global.Define("b")
+	restore()
+
+	if symbol, ok := global.Resolve("a"); !ok || symbol != a {
+		t.Errorf("expected a to resolve to %+v, got=%+v", a, symbol)
+	}
+	if _, ok := global.Resolve("b"); ok {
+		t.Errorf("expected b to be undefined after its scope")
+	}
+	if c := global.Define("c"); c.Index != 3 {
+		t.Errorf("expected c to get index 3, got=%d", c.Index)
+	}
+}
+
+func TestResolveEnv(t *testing.T) {
+	global := NewSymbolTable()
+	global.Define("a")
+
+	// fn(b) { let c = ...; fn() { fn(d) { ... } } }
+	outer := NewEnclosedSymbolTable(global)
+	outer.Captured = true
+	outer.Define("b")
+	outer.Define("c")
+
+	middle := NewEnclosedSymbolTable(outer)
+	middle.Captured = true
+
+	inner := NewEnclosedSymbolTable(middle)
+	inner.Define("d")
+
+	expected := []Symbol{
+		{Name: "a", Scope: GlobalScope, Index: 0},
+		{Name: "b", Scope: EnvScope, Index: 0, Depth: 1},
+		{Name: "c", Scope: EnvScope, Index: 1, Depth: 1},
+		{Name: "d", Scope: LocalScope, Index: 0},
+	}
+
+	for _, sym := range expected {
+		result, ok := inner.Resolve(sym.Name)
+		if !ok {
+			t.Errorf("name %s not resolvable", sym.Name)
+			continue
+		}
+		if result != sym {
+			t.Errorf("expected %s to resolve to %+v, got=%+v", sym.Name, sym, result)
+		}
+	}
+
+	if result, _ := outer.Resolve("c"); result.Depth != 0 {
+		t.Errorf("expected c to be in the environment of its own function, got=%+v", result)
+	}
+}
@@ -0,0 +1,67 @@
+package cst
+
+import (
+	"bytes"
+
+	"interpreter/ast"
+	"interpreter/token"
+)
+
+// File is a concrete syntax tree of a whole program. Unlike ast.Program it
+// keeps every token, including semicolons and, when the tokens come from a
+// lossless lexer, all whitespace and comments.
+type File struct {
+	Statements []*Statement
+	EOF        token.Token // carries the trivia at the end of the file
+}
+
+// String prints the file back as source text.
+func (f *File) String() string {
+	var out bytes.Buffer
+
+	for _, s := range f.Statements {
+		out.WriteString(s.String())
+	}
+	writeToken(&out, f.EOF)
+
+	return out.String()
+}
+
+// Program lowers the file to the AST, dropping statements that failed to
+// parse.
+func (f *File) Program() *ast.Program {
+	program := &ast.Program{}
+
+	for _, s := range f.Statements {
+		if s.Node != nil {
+			program.Statements = append(program.Statements, s.Node)
+		}
+	}
+
+	return program
+}
+
+type Statement struct {
+	Tokens []token.Token
+	Node   ast.Statement // nil if the statement failed to parse
+}
+
+func (s *Statement) String() string {
+	var out bytes.Buffer
+
+	for _, tok := range s.Tokens {
+		writeToken(&out, tok)
+	}
+
+	return out.String()
+}
+
+func writeToken(out *bytes.Buffer, tok token.Token) {
+	for _, t := range tok.Leading {
+		out.WriteString(t.Text)
+	}
+	out.WriteString(tok.Literal)
+	for _, t := range tok.Trailing {
+		out.WriteString(t.Text)
+	}
+}
@@ -0,0 +1,60 @@
+package cst
+
+import (
+	"interpreter/ast"
+	"interpreter/token"
+	"testing"
+)
+
+func TestString(t *testing.T) {
+	let := &ast.LetStatement{
+		Token: token.Token{Type: token.LET, Literal: "let"},
+		Name: &ast.Identifier{
+			Token: token.Token{Type: token.IDENT, Literal: "x"},
+			Value: "x",
+		},
+	}
+	file := &File{
+		Statements: []*Statement{
+			{
+				Tokens: []token.Token{
+					{
+						Type:    token.LET,
+						Literal: "let",
+						Leading: []token.Trivia{
+							{Type: token.COMMENT, Text: "// answer"},
+							{Type: token.NEWLINE, Text: "\n"},
+						},
+						Trailing: []token.Trivia{{Type: token.WHITESPACE, Text: " "}},
+					},
+					{
+						Type:     token.IDENT,
+						Literal:  "x",
+						Trailing: []token.Trivia{{Type: token.WHITESPACE, Text: "  "}},
+					},
+					{Type: token.ASSIGN, Literal: "=", Trailing: []token.Trivia{{Type: token.WHITESPACE, Text: "\t"}}},
+					{Type: token.INT, Literal: "42"},
+					{Type: token.SEMICOLON, Literal: ";"},
+				},
+				Node: let,
+			},
+			{
+				Tokens: []token.Token{{Type: token.ILLEGAL, Literal: "@"}},
+			},
+		},
+		EOF: token.Token{
+			Type:    token.EOF,
+			Leading: []token.Trivia{{Type: token.NEWLINE, Text: "\n"}},
+		},
+	}
+
+	expected := "// answer\nlet x  =\t42;@\n"
+	if actual := file.String(); actual != expected {
+		t.Errorf("expected file.String() to be %q, got %q", expected, actual)
+	}
+
+	program := file.Program()
+	if len(program.Statements) != 1 || program.Statements[0] != let {
+		t.Errorf("expected program to contain only the let statement, got %v", program.Statements)
+	}
+}
@@ -1,22 +1,72 @@
 package lexer
 
-import "interpreter/token"
+import (
+	"sort"
+	"strings"
+
+	"interpreter/token"
+)
 
 type Lexer struct {
 	input        string
 	position     int // current position of ch
 	readPosition int
 	ch           byte
+
+	line      int // line of ch
+	lineStart int // position of the first char of the line
+
+	// keep whitespace and comments as token trivia
+	lossless bool
+
+	// extra operators, longest first
+	operators []operator
+}
+
+type operator struct {
+	literal   string
+	tokenType token.TokenType
 }
 
+// New returns a lexer that discards whitespace and `//` comments. Comments
+// are skipped in this mode too, so that both modes produce the same tokens and
+// a source parses to the same program whether or not its trivia is kept.
 func New(input string) *Lexer {
-	l := &Lexer{input: input}
+	l := &Lexer{input: input, line: 1}
 	l.readChar()
 	return l
 }
 
+// NewLossless returns a lexer that attaches whitespace and comments to the
+// tokens it produces instead of discarding them, so that concatenating the
+// trivia and literals of all tokens gives back the input.
+func NewLossless(input string) *Lexer {
+	l := New(input)
+	l.lossless = true
+	return l
+}
+
+// AddOperator makes the lexer recognize literal as a token of the given
+// type. Added operators take priority over the built-in ones, and longer
+// operators over shorter ones.
+func (l *Lexer) AddOperator(literal string, tokenType token.TokenType) {
+	if literal == "" {
+		// would match everywhere without consuming input
+		return
+	}
+	l.operators = append(l.operators, operator{literal, tokenType})
+	sort.SliceStable(l.operators, func(i, j int) bool {
+		return len(l.operators[i].literal) > len(l.operators[j].literal)
+	})
+}
+
 // Sets next char and advances position in the input string
 func (l *Lexer) readChar() {
+	if l.ch == '\n' {
+		l.line++
+		l.lineStart = l.readPosition
+	}
+
 	if l.readPosition >= len(l.input) {
 		// end of input, ch = NUL
 		l.ch = 0
@@ -40,9 +90,33 @@ func (l *Lexer) peekChar() byte {
 }
 
 func (l *Lexer) NextToken() token.Token {
+	if !l.lossless {
+		l.skipWhitespace()
+		return l.readPositionedToken()
+	}
+
+	leading := l.readTrivia(false)
+	tok := l.readPositionedToken()
+	tok.Leading = leading
+	if tok.Type != token.EOF {
+		tok.Trailing = l.readTrivia(true)
+	}
+	return tok
+}
+
+func (l *Lexer) readPositionedToken() token.Token {
+	line, column := l.line, l.position-l.lineStart+1
+	tok := l.readToken()
+	tok.Line, tok.Column = line, column
+	return tok
+}
+
+func (l *Lexer) readToken() token.Token {
 	var tok token.Token
 
-	l.skipWhitespace()
+	if tok, ok := l.readOperator(); ok {
+		return tok
+	}
 
 	switch l.ch {
 	// operators
@@ -53,13 +127,16 @@ func (l *Lexer) NextToken() token.Token {
 			literal := string(ch) + string(l.ch)
 			tok.Literal = literal
 			tok.Type = token.EQ
+		} else if l.peekChar() == '>' {
+			l.readChar()
+			tok = token.Token{Type: token.ARROW, Literal: "=>"}
 		} else {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.readAssignable(token.PLUS, token.PLUS_ASSIGN)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		tok = l.readAssignable(token.MINUS, token.MINUS_ASSIGN)
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -71,19 +148,53 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.BANG, l.ch)
 		}
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		tok = l.readAssignable(token.SLASH, token.SLASH_ASSIGN)
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		if l.peekChar() == '*' {
+			ch := l.ch
+			l.readChar()
+			literal := string(ch) + string(l.ch)
+			tok.Literal = literal
+			tok.Type = token.POWER
+		} else {
+			tok = l.readAssignable(token.ASTERISK, token.ASTERISK_ASSIGN)
+		}
+	case '%':
+		tok = l.readAssignable(token.PERCENT, token.PERCENT_ASSIGN)
+	case '|':
+		if l.peekChar() != '>' {
+			tok = l.newIllegal()
+			break
+		}
+		l.readChar()
+		tok = token.Token{Type: token.PIPELINE, Literal: "|>"}
 	case '<':
 		tok = newToken(token.LT, l.ch)
 	case '>':
 		tok = newToken(token.GT, l.ch)
+	case '.':
+		if l.peekChar() != '.' {
+			tok = l.newIllegal()
+			break
+		}
+		l.readChar()
+		if l.peekChar() == '=' {
+			l.readChar()
+			tok = token.Token{Type: token.RANGE_INCLUSIVE, Literal: "..="}
+		} else if l.peekChar() == '.' {
+			l.readChar()
+			tok = token.Token{Type: token.ELLIPSIS, Literal: "..."}
+		} else {
+			tok = token.Token{Type: token.RANGE, Literal: ".."}
+		}
 
 	// delimiters
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
 	case ';':
 		tok = newToken(token.SEMICOLON, l.ch)
+	case ':':
+		tok = newToken(token.COLON, l.ch)
 	case '(':
 		tok = newToken(token.LPAREN, l.ch)
 	case ')':
@@ -92,8 +203,26 @@ func (l *Lexer) NextToken() token.Token {
 		tok = newToken(token.LBRACE, l.ch)
 	case '}':
 		tok = newToken(token.RBRACE, l.ch)
+	case '[':
+		tok = newToken(token.LBRACKET, l.ch)
+	case ']':
+		tok = newToken(token.RBRACKET, l.ch)
+
+	case '"':
+		literal, terminated := l.readString()
+		tok.Literal = literal
+		if !terminated {
+			tok.Type = token.ILLEGAL
+			return tok
+		}
+		tok.Type = token.STRING
 
 	case 0:
+		if l.position < len(l.input) {
+			// a NUL byte inside the input
+			tok = l.newIllegal()
+			break
+		}
 		tok.Literal = ""
 		tok.Type = token.EOF
 	default:
@@ -106,7 +235,7 @@ func (l *Lexer) NextToken() token.Token {
 			tok.Type = token.INT
 			return tok
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch)
+			tok = l.newIllegal()
 		}
 	}
 
@@ -114,6 +243,34 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
+func (l *Lexer) readOperator() (token.Token, bool) {
+	if l.position >= len(l.input) {
+		return token.Token{}, false
+	}
+
+	for _, op := range l.operators {
+		if strings.HasPrefix(l.input[l.position:], op.literal) {
+			for i := 0; i < len(op.literal); i++ {
+				l.readChar()
+			}
+			return token.Token{Type: op.tokenType, Literal: op.literal}, true
+		}
+	}
+
+	return token.Token{}, false
+}
+
+// Reads the operator under the cursor, or its compound assignment if it is
+// followed by =.
+func (l *Lexer) readAssignable(op, assign token.TokenType) token.Token {
+	if l.peekChar() != '=' {
+		return newToken(op, l.ch)
+	}
+	ch := l.ch
+	l.readChar()
+	return token.Token{Type: assign, Literal: string(ch) + "="}
+}
+
 func (l *Lexer) readIdentifier() string {
 	position := l.position
 	for isLetter(l.ch) {
@@ -122,6 +279,27 @@ func (l *Lexer) readIdentifier() string {
 	return l.input[position:l.position]
 }
 
+// Reads a string literal up to its closing quote, which is left as the
+// current character. Escaped characters are skipped and decoded by the
+// parser. Returns false if the input ends first.
+func (l *Lexer) readString() (string, bool) {
+	position := l.position
+	for {
+		l.readChar()
+		switch {
+		case l.ch == 0 && l.position >= len(l.input):
+			return l.input[position:], false
+		case l.ch == '\\':
+			l.readChar()
+			if l.position >= len(l.input) {
+				return l.input[position:], false
+			}
+		case l.ch == '"':
+			return l.input[position : l.position+1], true
+		}
+	}
+}
+
 func (l *Lexer) readNumber() string {
 	position := l.position
 	for isDigit(l.ch) {
@@ -131,9 +309,62 @@ func (l *Lexer) readNumber() string {
 }
 
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+	for {
+		if isWhitespace(l.ch) || l.ch == '\n' {
+			l.readChar()
+		} else if l.isCommentStart() {
+			l.readComment()
+		} else {
+			return
+		}
+	}
+}
+
+// Collects whitespace, newlines and comments. Trailing trivia stops before
+// the first newline so that it stays on the line of its token; the rest
+// becomes leading trivia of the next token.
+func (l *Lexer) readTrivia(trailing bool) []token.Trivia {
+	var trivia []token.Trivia
+
+	for {
+		position := l.position
+		switch {
+		case l.ch == '\n':
+			if trailing {
+				return trivia
+			}
+			l.readChar()
+			trivia = append(trivia, token.Trivia{Type: token.NEWLINE, Text: "\n"})
+		case isWhitespace(l.ch):
+			for isWhitespace(l.ch) {
+				l.readChar()
+			}
+			trivia = append(trivia, token.Trivia{Type: token.WHITESPACE, Text: l.input[position:l.position]})
+		case l.isCommentStart():
+			trivia = append(trivia, token.Trivia{Type: token.COMMENT, Text: l.readComment()})
+		default:
+			return trivia
+		}
+	}
+}
+
+func (l *Lexer) isCommentStart() bool {
+	return l.ch == '/' && l.peekChar() == '/'
+}
+
+// Reads a `//` comment up to, but not including, the line break.
+func (l *Lexer) readComment() string {
+	position := l.position
+	for l.ch != '\n' && !(l.ch == '\r' && l.peekChar() == '\n') && l.position < len(l.input) {
 		l.readChar()
 	}
+	return l.input[position:l.position]
+}
+
+// Illegal tokens keep the raw input byte, as `string(l.ch)` would re-encode
+// non-ASCII bytes as UTF-8.
+func (l *Lexer) newIllegal() token.Token {
+	return token.Token{Type: token.ILLEGAL, Literal: l.input[l.position:l.readPosition]}
 }
 
 func newToken(tokenType token.TokenType, ch byte) token.Token {
@@ -146,6 +377,10 @@ func isLetter(ch byte) bool {
 		ch == '_'
 }
 
+func isWhitespace(ch byte) bool {
+	return ch == ' ' || ch == '\t' || ch == '\r'
+}
+
 func isDigit(ch byte) bool {
 	return '0' <= ch && ch <= '9'
 }
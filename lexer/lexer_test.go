@@ -2,6 +2,8 @@ package lexer
 
 import (
 	"interpreter/token"
+	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -26,6 +28,17 @@ if (5 < 10) {
 
 10 == 10;
 10 != 9;
+2 ** 3 % 4;
+"foobar"
+"foo bar"
+"say \"hi\"\n"
+while for break continue const
+[1, 2];
+{"a": 1}
+for (x in 0..10) 0..=9 . ...
+x += 1 -= 2 *= 3 /= 4 %= 5 **=
+match _ => ==>
+xs |> f
 `
 
 	tests := []struct {
@@ -105,6 +118,65 @@ if (5 < 10) {
 		{token.NOT_EQ, "!="},
 		{token.INT, "9"},
 		{token.SEMICOLON, ";"},
+		{token.INT, "2"},
+		{token.POWER, "**"},
+		{token.INT, "3"},
+		{token.PERCENT, "%"},
+		{token.INT, "4"},
+		{token.SEMICOLON, ";"},
+		{token.STRING, `"foobar"`},
+		{token.STRING, `"foo bar"`},
+		{token.STRING, `"say \"hi\"\n"`},
+		{token.WHILE, "while"},
+		{token.FOR, "for"},
+		{token.BREAK, "break"},
+		{token.CONTINUE, "continue"},
+		{token.CONST, "const"},
+		{token.LBRACKET, "["},
+		{token.INT, "1"},
+		{token.COMMA, ","},
+		{token.INT, "2"},
+		{token.RBRACKET, "]"},
+		{token.SEMICOLON, ";"},
+		{token.LBRACE, "{"},
+		{token.STRING, `"a"`},
+		{token.COLON, ":"},
+		{token.INT, "1"},
+		{token.RBRACE, "}"},
+		{token.FOR, "for"},
+		{token.LPAREN, "("},
+		{token.IDENT, "x"},
+		{token.IN, "in"},
+		{token.INT, "0"},
+		{token.RANGE, ".."},
+		{token.INT, "10"},
+		{token.RPAREN, ")"},
+		{token.INT, "0"},
+		{token.RANGE_INCLUSIVE, "..="},
+		{token.INT, "9"},
+		{token.ILLEGAL, "."},
+		{token.ELLIPSIS, "..."},
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "1"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "2"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "3"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "4"},
+		{token.PERCENT_ASSIGN, "%="},
+		{token.INT, "5"},
+		{token.POWER, "**"},
+		{token.ASSIGN, "="},
+		{token.MATCH, "match"},
+		{token.IDENT, "_"},
+		{token.ARROW, "=>"},
+		{token.EQ, "=="},
+		{token.GT, ">"},
+		{token.IDENT, "xs"},
+		{token.PIPELINE, "|>"},
+		{token.IDENT, "f"},
 		{token.EOF, ""},
 	}
 
@@ -122,3 +194,227 @@ if (5 < 10) {
 		}
 	}
 }
+
+func TestNextTokenSkipsComments(t *testing.T) {
+	input := `// leading comment
+let x = 5; // trailing comment
+x / 2 // end`
+
+	expected := []token.TokenType{
+		token.LET, token.IDENT, token.ASSIGN, token.INT, token.SEMICOLON,
+		token.IDENT, token.SLASH, token.INT, token.EOF,
+	}
+
+	lexer := New(input)
+
+	for i, tt := range expected {
+		tok := lexer.NextToken()
+		if tok.Type != tt {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt, tok.Type)
+		}
+		if tok.Leading != nil || tok.Trailing != nil {
+			t.Fatalf("tests[%d] - expected no trivia, got leading=%v, trailing=%v", i, tok.Leading, tok.Trailing)
+		}
+	}
+}
+
+func TestNextTokenLossless(t *testing.T) {
+	input := "// header\n\nlet x  = 5; // five\r\n\tx\n"
+
+	tests := []struct {
+		expectedType     token.TokenType
+		expectedLiteral  string
+		expectedLeading  []token.Trivia
+		expectedTrailing []token.Trivia
+	}{
+		{token.LET, "let", []token.Trivia{
+			{Type: token.COMMENT, Text: "// header"},
+			{Type: token.NEWLINE, Text: "\n"},
+			{Type: token.NEWLINE, Text: "\n"},
+		}, []token.Trivia{
+			{Type: token.WHITESPACE, Text: " "},
+		}},
+		{token.IDENT, "x", nil, []token.Trivia{
+			{Type: token.WHITESPACE, Text: "  "},
+		}},
+		{token.ASSIGN, "=", nil, []token.Trivia{
+			{Type: token.WHITESPACE, Text: " "},
+		}},
+		{token.INT, "5", nil, nil},
+		{token.SEMICOLON, ";", nil, []token.Trivia{
+			{Type: token.WHITESPACE, Text: " "},
+			{Type: token.COMMENT, Text: "// five"},
+			{Type: token.WHITESPACE, Text: "\r"},
+		}},
+		{token.IDENT, "x", []token.Trivia{
+			{Type: token.NEWLINE, Text: "\n"},
+			{Type: token.WHITESPACE, Text: "\t"},
+		}, nil},
+		{token.EOF, "", []token.Trivia{
+			{Type: token.NEWLINE, Text: "\n"},
+		}, nil},
+	}
+
+	lexer := NewLossless(input)
+
+	for i, tt := range tests {
+		tok := lexer.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if !reflect.DeepEqual(tok.Leading, tt.expectedLeading) {
+			t.Fatalf("tests[%d] - leading trivia wrong. expected=%q, got=%q", i, tt.expectedLeading, tok.Leading)
+		}
+		if !reflect.DeepEqual(tok.Trailing, tt.expectedTrailing) {
+			t.Fatalf("tests[%d] - trailing trivia wrong. expected=%q, got=%q", i, tt.expectedTrailing, tok.Trailing)
+		}
+	}
+}
+
+func TestIllegalTokensKeepRawBytes(t *testing.T) {
+	input := "a\x00\xc3@"
+
+	expected := []string{"a", "\x00", "\xc3", "@", ""}
+
+	lexer := New(input)
+
+	for i, literal := range expected {
+		tok := lexer.NextToken()
+		if tok.Literal != literal {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, literal, tok.Literal)
+		}
+	}
+}
+
+func TestUnterminatedString(t *testing.T) {
+	tests := []string{`"abc`, `"abc\`, `"abc\"`}
+
+	for _, input := range tests {
+		tok := New(input).NextToken()
+		if tok.Type != token.ILLEGAL || tok.Literal != input {
+			t.Errorf("expected ILLEGAL %q, got=%s %q", input, tok.Type, tok.Literal)
+		}
+	}
+}
+
+func TestTokenPositions(t *testing.T) {
+	input := "let x = 10;\n  x ** 2 // square\n\n!x"
+
+	tests := []struct {
+		expectedLiteral string
+		expectedLine    int
+		expectedColumn  int
+	}{
+		{"let", 1, 1},
+		{"x", 1, 5},
+		{"=", 1, 7},
+		{"10", 1, 9},
+		{";", 1, 11},
+		{"x", 2, 3},
+		{"**", 2, 5},
+		{"2", 2, 8},
+		{"!", 4, 1},
+		{"x", 4, 2},
+		{"", 4, 3},
+	}
+
+	for _, lexer := range []*Lexer{New(input), NewLossless(input)} {
+		for i, tt := range tests {
+			tok := lexer.NextToken()
+
+			if tok.Literal != tt.expectedLiteral {
+				t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+			}
+			if tok.Line != tt.expectedLine || tok.Column != tt.expectedColumn {
+				t.Fatalf("tests[%d] - position wrong. expected=%d:%d, got=%d:%d",
+					i, tt.expectedLine, tt.expectedColumn, tok.Line, tok.Column)
+			}
+		}
+	}
+}
+
+func TestAddOperator(t *testing.T) {
+	input := "a ** b * c |> d->e"
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{"POW", "**"},
+		{token.IDENT, "b"},
+		{token.ASTERISK, "*"},
+		{token.IDENT, "c"},
+		{"PIPE", "|>"},
+		{token.IDENT, "d"},
+		{"ARROW", "->"},
+		{token.IDENT, "e"},
+		{token.EOF, ""},
+	}
+
+	lexer := New(input)
+	lexer.AddOperator("|>", "PIPE")
+	lexer.AddOperator("->", "ARROW")
+	lexer.AddOperator("**", "POW")
+
+	for i, tt := range tests {
+		tok := lexer.NextToken()
+
+		if tok.Type != tt.expectedType {
+			t.Fatalf("tests[%d] - tokentype wrong. expected=%q, got=%q", i, tt.expectedType, tok.Type)
+		}
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+	}
+}
+
+func FuzzNextToken(f *testing.F) {
+	seeds := []string{
+		"let five = 5;\nlet ten = 10;\n\nlet add = fn(x, y) {\n\tx + y;\n};\n",
+		"let result = add(five, ten);\n!-/*5;\n5 < 10 > 5;\n",
+		"if (5 < 10) {\n\treturn true;\n} else {\n\treturn false;\n}\n",
+		"10 == 10;\n10 != 9;\n",
+		"// leading comment\nlet x = 5; // trailing comment\nx // end",
+		"// header\n\nlet x  = 5; // five\r\n\tx\n",
+		"a\x00\xc3@",
+		"let s = \"a \\\"quoted\\\" string\\n\"; \"unterminated",
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		plain := New(input)
+		lossless := NewLossless(input)
+
+		var out strings.Builder
+		// every token but EOF consumes at least one byte
+		for i := 0; i <= len(input); i++ {
+			tok := lossless.NextToken()
+			if expected := plain.NextToken(); tok.Type != expected.Type || tok.Literal != expected.Literal {
+				t.Fatalf("lossless token %+v differs from %+v", tok, expected)
+			}
+
+			for _, trivia := range tok.Leading {
+				out.WriteString(trivia.Text)
+			}
+			out.WriteString(tok.Literal)
+			for _, trivia := range tok.Trailing {
+				out.WriteString(trivia.Text)
+			}
+
+			if tok.Type == token.EOF {
+				if out.String() != input {
+					t.Fatalf("tokens spell %q, expected %q", out.String(), input)
+				}
+				return
+			}
+		}
+		t.Fatalf("no EOF after %d tokens", len(input)+1)
+	})
+}
@@ -1,17 +1,266 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
+	"interpreter/ast"
+	"interpreter/bytecode"
+	"interpreter/compiler"
+	"interpreter/lexer"
+	"interpreter/monkey"
+	"interpreter/object"
+	"interpreter/optimizer"
+	"interpreter/parser"
+	"interpreter/regvm"
 	"interpreter/repl"
+	"interpreter/transpile"
+	"interpreter/vm"
 	"os"
 	"os/user"
+	"strings"
 )
 
+const usage = `usage:
+	monkey                  start the REPL
+	monkey build FILE...    compile each FILE to FILE` + bytecode.CacheSuffix + `
+	monkey run [-engine=vm|register] FILE
+	                        run FILE, using its compiled cache when up to date
+	monkey disasm FILE      print the compiled instructions of FILE
+	monkey optimize FILE    print the simplifications made to FILE
+	monkey transpile FILE   print FILE converted to a Go program
+`
+
 func main() {
+	if len(os.Args) < 2 {
+		startRepl()
+		return
+	}
+
+	var err error
+	switch cmd, args := os.Args[1], os.Args[2:]; {
+	case cmd == "build" && len(args) > 0:
+		err = build(args)
+	case cmd == "run":
+		err = run(args)
+	case cmd == "disasm" && len(args) == 1:
+		err = disasm(args[0])
+	case cmd == "optimize" && len(args) == 1:
+		err = optimize(args[0])
+	case cmd == "transpile" && len(args) == 1:
+		err = transpileFile(args[0])
+	default:
+		fmt.Fprint(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func startRepl() {
 	usr, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
 	fmt.Printf("Hello %s! Welcome to the Monkey programming language!\n", usr.Username)
-	repl.Start(os.Stdin, os.Stdout)
+	repl.Start(os.Stdin, os.Stdout, builtins)
+}
+
+// The builtins of the command line, which trusts its scripts with every
+// capability. Their names are compiled into the caches.
+var (
+	builtins = monkey.New(monkey.WithCapabilities(
+		monkey.IO, monkey.FS, monkey.Time, monkey.Env, monkey.Random,
+	)).Builtins()
+	builtinNames = names(builtins)
+)
+
+func names(builtins []*object.Builtin) []string {
+	names := make([]string, len(builtins))
+	for i, b := range builtins {
+		names[i] = b.Name
+	}
+	return names
+}
+
+func build(paths []string) error {
+	for _, path := range paths {
+		if _, err := bytecode.Build(path, os.Stderr, builtinNames...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Runs the program and prints its value: that of its last statement if it is
+// an expression statement, null otherwise.
+func run(args []string) error {
+	flags := flag.NewFlagSet("run", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	engine := flags.String("engine", "vm", "execution backend: vm or register, which has no builtins")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+	path := flags.Arg(0)
+
+	var result object.Object
+	var err error
+	switch *engine {
+	case "vm":
+		result, err = runStackVM(path)
+	case "register":
+		result, err = runRegisterVM(path)
+	default:
+		return fmt.Errorf("unknown engine %q", *engine)
+	}
+	if err != nil {
+		return err
+	}
+
+	if result != nil {
+		fmt.Println(result.Inspect())
+	}
+	return nil
+}
+
+func runStackVM(path string) (object.Object, error) {
+	bc, err := bytecode.Load(path, os.Stderr, builtinNames...)
+	if err != nil {
+		return nil, err
+	}
+
+	machine := vm.NewWithBuiltins(bc, make([]object.Object, vm.GlobalsSize), builtins)
+	if err := machine.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return machine.LastPoppedStackElem(), nil
+}
+
+// The register backend has no on-disk format and always compiles from
+// source. It has no builtins.
+func runRegisterVM(path string) (object.Object, error) {
+	program, err := parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	compiled, err := regvm.Compile(optimizer.New().Optimize(program))
+	if err != nil {
+		return nil, compileError(path, program, err)
+	}
+
+	machine := regvm.New(compiled)
+	if err := machine.Run(); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return machine.Result(), nil
+}
+
+// Returns the error of compiling the program at path for the register VM or
+// the transpiler, which have no builtins: a program failing because it uses
+// one is told so rather than that the builtin is undefined.
+func compileError(path string, program *ast.Program, err error) error {
+	var undefined *compiler.UndefinedVariableError
+	if errors.As(compiler.New().Compile(program), &undefined) {
+		for _, name := range builtinNames {
+			if name == undefined.Name {
+				return fmt.Errorf("%s: %s is a builtin, builtins are vm-only", path, name)
+			}
+		}
+	}
+	return fmt.Errorf("%s: %w", path, err)
+}
+
+func parse(path string) (*ast.Program, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	p := parser.New(lexer.New(string(src)))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, fmt.Errorf("%s: parser errors:\n\t%s", path, strings.Join(p.Errors(), "\n\t"))
+	}
+	for _, msg := range p.Warnings() {
+		fmt.Fprintf(os.Stderr, "%s: warning: %s\n", path, msg)
+	}
+	return program, nil
+}
+
+// Prints the changes the optimizer makes to the program, as done when it is
+// compiled.
+func optimize(path string) error {
+	program, err := parse(path)
+	if err != nil {
+		return err
+	}
+
+	o := optimizer.New()
+	o.Debug = true
+	o.Optimize(program)
+
+	for _, change := range o.Changes() {
+		fmt.Printf("%s:%s\n", path, change)
+	}
+	return nil
+}
+
+func transpileFile(path string) error {
+	program, err := parse(path)
+	if err != nil {
+		return err
+	}
+
+	src, err := transpile.Transpile(optimizer.New().Optimize(program), path)
+	if err != nil {
+		return compileError(path, program, err)
+	}
+
+	_, err = os.Stdout.Write(src)
+	return err
+}
+
+func disasm(path string) error {
+	bc, err := bytecode.Load(path, os.Stderr, builtinNames...)
+	if err != nil {
+		return err
+	}
+
+	printBytecode(bc)
+	return nil
+}
+
+func printBytecode(bc *compiler.Bytecode) {
+	fmt.Println("constants:")
+	for i, constant := range bc.Constants {
+		fmt.Printf("%04d %s %s\n", i, constant.Type(), constant.Inspect())
+		if fn, ok := constant.(*object.CompiledFunction); ok {
+			fmt.Print(indent(fn.Instructions.String(), "\t"))
+		}
+	}
+
+	fmt.Println("globals:")
+	for _, symbol := range bc.Symbols {
+		fmt.Printf("%04d %s\n", symbol.Index, symbol.Name)
+	}
+
+	fmt.Println("instructions:")
+	fmt.Print(bc.Instructions)
+}
+
+func indent(s, prefix string) string {
+	lines := strings.SplitAfter(s, "\n")
+	for i, line := range lines {
+		if line != "" {
+			lines[i] = prefix + line
+		}
+	}
+	return strings.Join(lines, "")
 }
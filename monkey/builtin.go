@@ -0,0 +1,226 @@
+package monkey
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"interpreter/compiler"
+	"interpreter/object"
+)
+
+// BuiltinFunc is the native signature of functions callable from Monkey.
+// A returned error aborts the script with a *RuntimeError located at the
+// call.
+type BuiltinFunc = func(args ...Value) (Value, error)
+
+// Register makes fn callable from Monkey under name, replacing a builtin
+// of the same name.
+//
+// fn is either a BuiltinFunc, which checks its arguments itself, possibly
+// taking a context.Context first, or any Go
+// function whose parameters are integers, bools, strings, Value or
+// interface{} and whose results are at most one value convertible by
+// ToValue, optionally followed by an error. Such functions are called with
+// the converted arguments after their count and types have been checked.
+// Functions that block should take a context.Context first: they are given
+// the context of the evaluation calling them, which is canceled when the
+// evaluation is.
+func (in *Interpreter) Register(name string, fn interface{}) error {
+	builtin, err := newBuiltin(name, fn)
+	if err != nil {
+		return err
+	}
+
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	delete(in.denied, name)
+	if symbol, ok := in.symbols.Resolve(name); ok && symbol.Scope == compiler.BuiltinScope {
+		in.builtins[symbol.Index] = builtin
+		return nil
+	}
+
+	in.symbols.DefineBuiltin(len(in.builtins), name)
+	in.builtins = append(in.builtins, builtin)
+	return nil
+}
+
+// Builtins returns the builtins of the interpreter ordered by index, those
+// of capabilities that were not granted included. Bytecode compiled with
+// their names, see bytecode.Compile, runs on a VM given these builtins.
+func (in *Interpreter) Builtins() []*object.Builtin {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	return append([]*object.Builtin(nil), in.builtins...)
+}
+
+var (
+	valueType   = reflect.TypeOf((*Value)(nil)).Elem()
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+func newBuiltin(name string, fn interface{}) (*object.Builtin, error) {
+	switch fn := fn.(type) {
+	case BuiltinFunc:
+		return &object.Builtin{Name: name, Fn: fn}, nil
+	case object.BuiltinFunction:
+		return &object.Builtin{Name: name, Fn: fn}, nil
+	case func(context.Context, ...Value) (Value, error):
+		return &object.Builtin{Name: name, ContextFn: fn}, nil
+	case object.ContextBuiltinFunction:
+		return &object.Builtin{Name: name, ContextFn: fn}, nil
+	}
+
+	rv := reflect.ValueOf(fn)
+	if rv.Kind() != reflect.Func || rv.IsNil() {
+		return nil, fmt.Errorf("cannot register %T as builtin %s: not a function", fn, name)
+	}
+
+	t := rv.Type()
+	// the context is not a Monkey argument
+	first := 0
+	if t.NumIn() > 0 && t.In(0) == contextType {
+		first = 1
+	}
+	for i := first; i < t.NumIn(); i++ {
+		param := t.In(i)
+		if t.IsVariadic() && i == t.NumIn()-1 {
+			param = param.Elem()
+		}
+		if !isArgumentType(param) {
+			return nil, fmt.Errorf("cannot register builtin %s: unsupported parameter type %s", name, param)
+		}
+	}
+
+	switch {
+	case t.NumOut() > 2,
+		t.NumOut() == 2 && t.Out(1) != errorType,
+		t.NumOut() >= 1 && t.Out(0) != errorType && !isResultType(t.Out(0)):
+		return nil, fmt.Errorf("cannot register builtin %s: unsupported results %s", name, t)
+	}
+
+	return &object.Builtin{Name: name, ContextFn: func(ctx context.Context, args ...object.Object) (object.Object, error) {
+		in, err := convertArguments(name, t, first, args)
+		if err != nil {
+			return nil, err
+		}
+		if first == 1 {
+			in = append([]reflect.Value{reflect.ValueOf(&ctx).Elem()}, in...)
+		}
+		return convertResults(name, rv.Call(in))
+	}}, nil
+}
+
+func isArgumentType(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Bool, reflect.String:
+		return true
+	case reflect.Interface:
+		return t == valueType || t.NumMethod() == 0
+	}
+	return false
+}
+
+func isResultType(t reflect.Type) bool {
+	return isArgumentType(t) || t.Implements(valueType)
+}
+
+// Converts the arguments to the parameters of t from the first one on.
+func convertArguments(name string, t reflect.Type, first int, args []object.Object) ([]reflect.Value, error) {
+	numIn := t.NumIn() - first
+	if t.IsVariadic() {
+		if len(args) < numIn-1 {
+			return nil, fmt.Errorf("wrong number of arguments to %s: want at least %d, got=%d", name, numIn-1, len(args))
+		}
+	} else if len(args) != numIn {
+		return nil, fmt.Errorf("wrong number of arguments to %s: want=%d, got=%d", name, numIn, len(args))
+	}
+
+	in := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		var param reflect.Type
+		if t.IsVariadic() && i >= numIn-1 {
+			param = t.In(t.NumIn() - 1).Elem()
+		} else {
+			param = t.In(first + i)
+		}
+
+		v, err := convertArgument(param, arg)
+		if err != nil {
+			return nil, fmt.Errorf("argument %d to %s %s", i+1, name, err)
+		}
+		in[i] = v
+	}
+	return in, nil
+}
+
+func convertArgument(t reflect.Type, arg object.Object) (reflect.Value, error) {
+	v := reflect.New(t).Elem()
+
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		integer, ok := arg.(*object.Integer)
+		if !ok {
+			return v, fmt.Errorf("must be %s, got %s", IntegerType, arg.Type())
+		}
+		if v.OverflowInt(integer.Value) {
+			return v, fmt.Errorf("is out of range: %d", integer.Value)
+		}
+		v.SetInt(integer.Value)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		integer, ok := arg.(*object.Integer)
+		if !ok {
+			return v, fmt.Errorf("must be %s, got %s", IntegerType, arg.Type())
+		}
+		if integer.Value < 0 || v.OverflowUint(uint64(integer.Value)) {
+			return v, fmt.Errorf("is out of range: %d", integer.Value)
+		}
+		v.SetUint(uint64(integer.Value))
+
+	case reflect.Bool:
+		boolean, ok := arg.(*object.Boolean)
+		if !ok {
+			return v, fmt.Errorf("must be %s, got %s", BooleanType, arg.Type())
+		}
+		v.SetBool(boolean.Value)
+
+	case reflect.String:
+		str, ok := arg.(*object.String)
+		if !ok {
+			return v, fmt.Errorf("must be %s, got %s", StringType, arg.Type())
+		}
+		v.SetString(str.Value)
+
+	case reflect.Interface:
+		if t == valueType {
+			v.Set(reflect.ValueOf(&arg).Elem())
+		} else if g := ToGo(arg); g != nil {
+			v.Set(reflect.ValueOf(g))
+		}
+	}
+
+	return v, nil
+}
+
+func convertResults(name string, out []reflect.Value) (object.Object, error) {
+	if len(out) > 0 {
+		if err, ok := out[len(out)-1].Interface().(error); ok && err != nil {
+			return nil, err
+		}
+	}
+	if len(out) == 0 || out[0].Type() == errorType {
+		return Null, nil
+	}
+
+	value, err := ToValue(out[0].Interface())
+	if err != nil {
+		return nil, fmt.Errorf("builtin %s returned an unsupported value: %w", name, err)
+	}
+	return value, nil
+}
@@ -0,0 +1,173 @@
+package monkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"interpreter/object"
+)
+
+func TestRegister(t *testing.T) {
+	m := New()
+
+	register := func(name string, fn interface{}) {
+		t.Helper()
+		if err := m.Register(name, fn); err != nil {
+			t.Fatalf("register error for %s: %s", name, err)
+		}
+	}
+
+	register("sum", func(args ...Value) (Value, error) {
+		total := int64(0)
+		for _, arg := range args {
+			integer, ok := arg.(*object.Integer)
+			if !ok {
+				return nil, fmt.Errorf("sum: cannot add %s", arg.Type())
+			}
+			total += integer.Value
+		}
+		return ToValue(total)
+	})
+	register("double", func(x int) int { return 2 * x })
+	register("small", func(x int8) int8 { return x })
+	register("unsigned", func(x uint) uint { return x })
+	register("both", func(a, b bool) bool { return a && b })
+	register("max", func(first int64, rest ...int64) int64 {
+		for _, r := range rest {
+			if r > first {
+				first = r
+			}
+		}
+		return first
+	})
+	register("identity", func(v Value) Value { return v })
+	register("text", func() interface{} { return "text" })
+	register("shout", func(s string) string { return s + "!" })
+	register("float", func() interface{} { return 1.5 })
+	register("describe", func(v interface{}) (Value, error) {
+		switch v.(type) {
+		case int64:
+			return ToValue(1)
+		case bool:
+			return ToValue(2)
+		case nil:
+			return ToValue(3)
+		}
+		return ToValue(4)
+	})
+	register("checked", func(x int) (int, error) {
+		if x < 0 {
+			return 0, errors.New("negative input")
+		}
+		return x, nil
+	})
+	register("nothing", func() {})
+	register("alive", func(ctx context.Context, codes ...int64) bool { return ctx.Err() == nil })
+	register("count", func(ctx context.Context, args ...Value) (Value, error) {
+		return ToValue(len(args))
+	})
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"sum(1, 2, 3)", int64(6)},
+		{"sum()", int64(0)},
+		{"double(21)", int64(42)},
+		{"let f = fn(x) { double(x) + 1 }; f(double(2))", int64(9)},
+		{"small(-128)", int64(-128)},
+		{"unsigned(7)", int64(7)},
+		{"both(true, 1 < 2)", true},
+		{"max(3)", int64(3)},
+		{"max(3, 9, 4)", int64(9)},
+		{"describe(1) + describe(false) + describe(if (false) { 1 }) + describe(double)", int64(10)},
+		{"checked(5)", int64(5)},
+		{"nothing()", nil},
+		{"identity(true)", true},
+		{`text() == "text"`, true},
+		{`shout("hey") == "hey!"`, true},
+		{`describe("x")`, int64(4)},
+		{"alive(1, 2)", true},
+		{"count(1, true)", int64(2)},
+
+		// errors carry the position of the call
+		{"sum(1, true)", "1:4: sum: cannot add BOOLEAN"},
+		{"double()", "1:7: wrong number of arguments to double: want=1, got=0"},
+		{"double(1, 2)", "1:7: wrong number of arguments to double: want=1, got=2"},
+		{"max()", "1:4: wrong number of arguments to max: want at least 1, got=0"},
+		{"\n  double(true)", "2:9: argument 1 to double must be INTEGER, got BOOLEAN"},
+		{"both(true, 1)", "1:5: argument 2 to both must be BOOLEAN, got INTEGER"},
+		{"max(1, 2, false)", "1:4: argument 3 to max must be INTEGER, got BOOLEAN"},
+		{"small(200)", "1:6: argument 1 to small is out of range: 200"},
+		{"unsigned(-1)", "1:9: argument 1 to unsigned is out of range: -1"},
+		{"checked(-1)", "1:8: negative input"},
+		{"shout(1)", "1:6: argument 1 to shout must be STRING, got INTEGER"},
+		{"alive(1, false)", "1:6: argument 2 to alive must be INTEGER, got BOOLEAN"},
+		{"float()", "1:6: builtin float returned an unsupported value: cannot convert float64 to a Monkey value"},
+	}
+
+	for _, tt := range tests {
+		value, err := m.Eval(context.Background(), tt.input)
+
+		if expected, ok := tt.expected.(string); ok {
+			var runtimeErr *RuntimeError
+			if !errors.As(err, &runtimeErr) || err.Error() != expected {
+				t.Errorf("expected runtime error %q for %q, got=%v", expected, tt.input, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatalf("eval error for %q: %s", tt.input, err)
+		}
+		if actual := ToGo(value); actual != tt.expected {
+			t.Errorf("wrong value for %q. expected=%v, got=%v", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestRegisterReplacesAndShadows(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	m.Register("answer", func() int { return 1 })
+	m.Register("answer", func() int { return 42 })
+	if value, err := m.Eval(ctx, "answer()"); err != nil || ToGo(value) != int64(42) {
+		t.Errorf("expected 42, got=%v (%v)", value, err)
+	}
+
+	if value, ok := m.Get("answer"); !ok || value.Type() != BuiltinType {
+		t.Errorf("expected answer to be a builtin, got=%v", value)
+	}
+
+	// a global of the same name shadows the builtin
+	if err := m.Set("answer", 7); err != nil {
+		t.Fatalf("set error: %s", err)
+	}
+	if value, err := m.Eval(ctx, "answer"); err != nil || ToGo(value) != int64(7) {
+		t.Errorf("expected 7, got=%v (%v)", value, err)
+	}
+}
+
+func TestRegisterErrors(t *testing.T) {
+	tests := []struct {
+		fn       interface{}
+		expected string
+	}{
+		{42, "cannot register int as builtin f: not a function"},
+		{func(c complex128) {}, "cannot register builtin f: unsupported parameter type complex128"},
+		{func(xs ...float64) {}, "cannot register builtin f: unsupported parameter type float64"},
+		{func() (int, int) { return 0, 0 }, "cannot register builtin f: unsupported results func() (int, int)"},
+		{func() []int { return nil }, "cannot register builtin f: unsupported results func() []int"},
+		{func() float64 { return 0 }, "cannot register builtin f: unsupported results func() float64"},
+	}
+
+	for _, tt := range tests {
+		err := New().Register("f", tt.fn)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("expected error %q, got=%v", tt.expected, err)
+		}
+	}
+}
@@ -0,0 +1,163 @@
+package monkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"time"
+
+	"interpreter/object"
+)
+
+// Capability is a group of builtins giving scripts access to the host. An
+// interpreter only provides the builtins of the capabilities it was granted,
+// see WithCapabilities.
+type Capability string
+
+const (
+	IO     Capability = "io"     // puts
+	FS     Capability = "fs"     // readFile, writeFile, fileExists
+	Time   Capability = "time"   // now, sleep
+	Env    Capability = "env"    // getenv
+	Random Capability = "random" // random
+)
+
+// WithCapabilities grants the builtins of the capabilities to the scripts.
+// Scripts using the builtins of other capabilities fail to compile with a
+// *CapabilityError.
+func WithCapabilities(capabilities ...Capability) Option {
+	return func(in *Interpreter) {
+		for _, c := range capabilities {
+			in.granted[c] = true
+		}
+	}
+}
+
+// WithStdout sets where puts writes, os.Stdout by default.
+func WithStdout(w io.Writer) Option {
+	return func(in *Interpreter) {
+		in.stdout = w
+	}
+}
+
+// CapabilityError reports the use of a builtin whose capability was not
+// granted to the interpreter.
+type CapabilityError struct {
+	Capability Capability
+	Builtin    string
+}
+
+func (e *CapabilityError) Error() string {
+	return fmt.Sprintf("%s: capability %q not granted", e.Builtin, e.Capability)
+}
+
+type libraryBuiltin struct {
+	capability Capability
+	name       string
+	fn         interface{}
+}
+
+// Returns the builtins of all capabilities. Their order must not change:
+// every interpreter gives them the same indices, granted or not.
+func (in *Interpreter) library() []libraryBuiltin {
+	return []libraryBuiltin{
+		{IO, "puts", in.puts},
+		{FS, "readFile", readFile},
+		{FS, "writeFile", writeFile},
+		{FS, "fileExists", fileExists},
+		{Time, "now", now},
+		{Time, "sleep", sleep},
+		{Env, "getenv", getenv},
+		{Random, "random", random},
+	}
+}
+
+// Registers the granted builtins. The others get a placeholder raising a
+// *CapabilityError, in case bytecode compiled elsewhere refers to them, but
+// no symbol, so that scripts using them do not compile.
+func (in *Interpreter) loadLibrary() {
+	for _, b := range in.library() {
+		if in.granted[b.capability] {
+			if err := in.Register(b.name, b.fn); err != nil {
+				panic(err)
+			}
+			continue
+		}
+
+		denied := &CapabilityError{Capability: b.capability, Builtin: b.name}
+		in.builtins = append(in.builtins, &object.Builtin{
+			Name: b.name,
+			Fn: func(args ...object.Object) (object.Object, error) {
+				return nil, denied
+			},
+		})
+		in.denied[b.name] = b.capability
+	}
+}
+
+// Writes the values on a line each.
+func (in *Interpreter) puts(args ...Value) error {
+	for _, arg := range args {
+		if _, err := fmt.Fprintln(in.stdout, arg.Inspect()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readFile(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	return string(content), err
+}
+
+func writeFile(path, content string) error {
+	return os.WriteFile(path, []byte(content), 0644)
+}
+
+func fileExists(path string) (bool, error) {
+	_, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Returns the time in milliseconds since the Unix epoch.
+func now() int64 {
+	return time.Now().UnixNano() / int64(time.Millisecond)
+}
+
+// Waits for ms milliseconds, or until the evaluation is canceled.
+func sleep(ctx context.Context, ms int64) error {
+	if ms < 0 {
+		return fmt.Errorf("sleep: negative duration %d", ms)
+	}
+	timer := time.NewTimer(time.Duration(ms) * time.Millisecond)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Returns null for variables that are not set.
+func getenv(name string) interface{} {
+	if value, ok := os.LookupEnv(name); ok {
+		return value
+	}
+	return nil
+}
+
+// Returns a random integer in [0, n).
+func random(n int64) (int64, error) {
+	if n <= 0 {
+		return 0, fmt.Errorf("random: bound must be positive, got %d", n)
+	}
+	return rand.Int63n(n), nil
+}
@@ -0,0 +1,166 @@
+package monkey
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"interpreter/ast"
+	"interpreter/compiler"
+	"interpreter/lexer"
+	"interpreter/object"
+	"interpreter/parser"
+	"interpreter/vm"
+)
+
+func TestCapabilities(t *testing.T) {
+	ctx := context.Background()
+	var out bytes.Buffer
+	m := New(WithCapabilities(IO, Env), WithStdout(&out))
+
+	if _, err := m.Eval(ctx, `puts("hello", 1 + 2)`); err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+	if out.String() != "hello\n3\n" {
+		t.Errorf("wrong output. got=%q", out.String())
+	}
+
+	t.Setenv("MONKEY_TEST_VAR", "banana")
+	value, err := m.Eval(ctx, `getenv("MONKEY_TEST_VAR") + "!"`)
+	if err != nil || ToGo(value) != "banana!" {
+		t.Errorf("expected banana!, got=%v (%v)", value, err)
+	}
+	if value, err := m.Eval(ctx, `getenv("MONKEY_TEST_UNSET")`); err != nil || value != Null {
+		t.Errorf("expected null, got=%v (%v)", value, err)
+	}
+
+	// builtins of other capabilities do not compile
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`readFile("/etc/passwd")`, `readFile: capability "fs" not granted`},
+		{"let f = fn() { now() };", `now: capability "time" not granted`},
+		{"random", `random: capability "random" not granted`},
+	}
+
+	for _, tt := range tests {
+		_, err := m.Eval(ctx, tt.input)
+		var capabilityErr *CapabilityError
+		if !errors.As(err, &capabilityErr) || err.Error() != tt.expected {
+			t.Errorf("expected capability error %q for %q, got=%v", tt.expected, tt.input, err)
+		}
+	}
+	if _, ok := m.Get("readFile"); ok {
+		t.Errorf("expected readFile to be undefined")
+	}
+
+	// scripts may still define the names themselves
+	if value, err := m.Eval(ctx, "let now = fn() { 1 }; now()"); err != nil || ToGo(value) != int64(1) {
+		t.Errorf("expected 1, got=%v (%v)", value, err)
+	}
+}
+
+func TestCapabilityAtRuntime(t *testing.T) {
+	// bytecode compiled for an interpreter granting fs refers to the
+	// placeholders when run with the builtins of one that does not
+	granting := New(WithCapabilities(FS))
+	comp := compiler.NewWithState(granting.symbols.Clone(), []object.Object{})
+	if err := comp.Compile(parse(t, `fileExists("/")`)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	denying := New()
+	machine := vm.NewWithBuiltins(comp.Bytecode(), denying.globals, denying.builtins)
+	err := machine.Run()
+
+	var capabilityErr *CapabilityError
+	if !errors.As(err, &capabilityErr) || err.Error() != `1:11: fileExists: capability "fs" not granted` {
+		t.Errorf("expected a capability error, got=%v", err)
+	}
+}
+
+func TestFSCapability(t *testing.T) {
+	ctx := context.Background()
+	m := New(WithCapabilities(FS))
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	m.Set("path", path)
+
+	value, err := m.Eval(ctx, `
+let before = fileExists(path);
+writeFile(path, "line\n");
+if (!before) { readFile(path) }`)
+	if err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+	if ToGo(value) != "line\n" {
+		t.Errorf("expected the written content, got=%q", value.Inspect())
+	}
+
+	_, err = m.Eval(ctx, `readFile(path + ".missing")`)
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a RuntimeError for a missing file, got=%v", err)
+	}
+}
+
+func TestTimeAndRandomCapabilities(t *testing.T) {
+	ctx := context.Background()
+	m := New(WithCapabilities(Time, Random))
+
+	value, err := m.Eval(ctx, "let start = now(); sleep(5); now() - start")
+	if err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+	if elapsed := ToGo(value).(int64); elapsed < 5 {
+		t.Errorf("expected at least 5ms to elapse, got=%d", elapsed)
+	}
+
+	value, err = m.Eval(ctx, "random(10)")
+	if err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+	if n := ToGo(value).(int64); n < 0 || n >= 10 {
+		t.Errorf("expected a number in [0, 10), got=%d", n)
+	}
+
+	if _, err := m.Eval(ctx, "random(0)"); err == nil || err.Error() != "1:7: random: bound must be positive, got 0" {
+		t.Errorf("expected an error for random(0), got=%v", err)
+	}
+}
+
+func TestSleepStopsWhenCanceled(t *testing.T) {
+	m := New(WithCapabilities(Time))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err := m.Eval(ctx, "sleep(100000)")
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Fatalf("sleep ignored the deadline, took %s", elapsed)
+	}
+
+	var canceled *CanceledError
+	if !errors.As(err, &canceled) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a CanceledError, got=%v", err)
+	}
+	if err.Error() != "1:6: execution stopped: context deadline exceeded" {
+		t.Errorf("wrong error message, got=%q", err.Error())
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors: %v", p.Errors())
+	}
+	return program
+}
@@ -0,0 +1,48 @@
+package monkey_test
+
+import (
+	"context"
+	"fmt"
+
+	"interpreter/monkey"
+)
+
+func Example() {
+	ctx := context.Background()
+	m := monkey.New()
+
+	m.Set("limit", 10)
+	if _, err := m.Eval(ctx, "let double = fn(x) { x * 2 };"); err != nil {
+		panic(err)
+	}
+
+	v, err := m.Eval(ctx, "double(limit) > 15")
+	if err != nil {
+		panic(err)
+	}
+	fmt.Println(monkey.ToGo(v))
+	// Output: true
+}
+
+func ExampleProgram() {
+	ctx := context.Background()
+	m := monkey.New()
+	m.Set("amount", 0)
+
+	rule, err := m.Compile("amount > 100")
+	if err != nil {
+		panic(err)
+	}
+
+	// a Program can be run from several goroutines at once
+	for _, amount := range []int{50, 150} {
+		v, err := rule.Run(ctx, map[string]interface{}{"amount": amount})
+		if err != nil {
+			panic(err)
+		}
+		fmt.Println(monkey.ToGo(v))
+	}
+	// Output:
+	// false
+	// true
+}
@@ -0,0 +1,248 @@
+// Package monkey is the API for embedding the Monkey language in Go
+// programs. It is the stable entry point: the other packages of this module
+// may change between versions, this one only grows. The exception are the
+// aliases of types of those packages, Value, RuntimeError, StackFrame and
+// the limit errors: their names are stable, the methods and fields of the
+// types they alias are not.
+//
+// An Interpreter keeps the globals defined by the snippets it evaluates, so
+// that later snippets can use them:
+//
+//	m := monkey.New()
+//	m.Set("limit", 10)
+//	m.Eval(ctx, "let double = fn(x) { x * 2 };")
+//	v, err := m.Eval(ctx, "double(limit) > 15")
+//
+// Scripts cannot reach the host unless the interpreter is granted the
+// capabilities of the builtins they use:
+//
+//	m := monkey.New(monkey.WithCapabilities(monkey.IO, monkey.Time))
+//	m.Eval(ctx, "puts(now())")
+//
+// An Interpreter may be used by several goroutines, its evaluations run one
+// at a time. To evaluate a script concurrently, compile it to a Program:
+//
+//	rule, err := m.Compile("amount > limit")
+//	// in each request
+//	v, err := rule.Run(ctx, map[string]interface{}{"amount": amount})
+package monkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"interpreter/compiler"
+	"interpreter/lexer"
+	"interpreter/object"
+	"interpreter/optimizer"
+	"interpreter/parser"
+	"interpreter/vm"
+)
+
+type Interpreter struct {
+	mu sync.Mutex
+
+	optimize bool
+	limits   vm.Limits
+	granted  map[Capability]bool
+	denied   map[string]Capability // builtins of the other capabilities
+	stdout   io.Writer
+
+	// state shared by the snippets, only updated when one succeeds
+	symbols   *compiler.SymbolTable
+	constants []object.Object
+	globals   []object.Object
+	builtins  []*object.Builtin
+}
+
+// Option configures an Interpreter.
+type Option func(*Interpreter)
+
+// WithOptimizer enables or disables the optimization of snippets before they
+// are compiled. It is enabled by default.
+func WithOptimizer(enabled bool) Option {
+	return func(in *Interpreter) {
+		in.optimize = enabled
+	}
+}
+
+// WithStepLimit limits each evaluation to n executed instructions.
+func WithStepLimit(n int64) Option {
+	return func(in *Interpreter) {
+		in.limits.MaxSteps = n
+	}
+}
+
+// WithDepthLimit limits each evaluation to n nested function calls.
+func WithDepthLimit(n int) Option {
+	return func(in *Interpreter) {
+		in.limits.MaxDepth = n
+	}
+}
+
+// WithMemoryLimit limits each evaluation to allocating n bytes of values.
+func WithMemoryLimit(n int64) Option {
+	return func(in *Interpreter) {
+		in.limits.MaxMemory = n
+	}
+}
+
+// New returns an interpreter without any globals, whose only builtins are
+// those of the granted capabilities.
+func New(opts ...Option) *Interpreter {
+	in := &Interpreter{
+		optimize:  true,
+		granted:   make(map[Capability]bool),
+		denied:    make(map[string]Capability),
+		stdout:    os.Stdout,
+		symbols:   compiler.NewSymbolTable(),
+		constants: []object.Object{},
+		globals:   make([]object.Object, vm.GlobalsSize),
+	}
+	for _, opt := range opts {
+		opt(in)
+	}
+	in.loadLibrary()
+	return in
+}
+
+// SyntaxError reports the parser errors of a snippet.
+type SyntaxError struct {
+	Errors []string
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("syntax errors:\n\t%s", strings.Join(e.Errors, "\n\t"))
+}
+
+// RuntimeError is an error raised while running a snippet, located at the
+// source of the failed operation. Its Stack holds the Monkey functions
+// being executed, innermost first. It aliases a type of the vm package, see
+// the package documentation.
+type RuntimeError = vm.Error
+
+type StackFrame = vm.StackFrame
+
+// Errors of evaluations stopped early, wrapped in a *RuntimeError.
+type (
+	StepLimitError   = vm.StepLimitError
+	DepthLimitError  = vm.DepthLimitError
+	MemoryLimitError = vm.MemoryLimitError
+
+	// CanceledError unwraps to the error of the context.
+	CanceledError = vm.CanceledError
+)
+
+// Eval evaluates the snippet src and returns the value of its last
+// statement if it is an expression statement, Null otherwise. Parser errors
+// are reported as a *SyntaxError, builtins of capabilities that were not
+// granted as a *CapabilityError and failed operations as a *RuntimeError,
+// which wraps a *CanceledError when ctx is done before the evaluation ends
+// and a limit error when a limit of the interpreter is exceeded.
+//
+// The globals defined by src are only kept if it evaluates successfully.
+func (in *Interpreter) Eval(ctx context.Context, src string) (Value, error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	bytecode, symbols, err := in.compile(src)
+	if err != nil {
+		return nil, err
+	}
+
+	machine := vm.NewWithBuiltins(bytecode, in.globals, in.builtins)
+	machine.SetLimits(in.limits)
+	if err := machine.RunContext(ctx); err != nil {
+		return nil, err
+	}
+
+	in.symbols = symbols
+	in.constants = bytecode.Constants
+
+	return result(machine), nil
+}
+
+// Compiles src against a copy of the symbols, which is returned along with
+// the bytecode so that the caller decides whether to keep the definitions.
+func (in *Interpreter) compile(src string) (*compiler.Bytecode, *compiler.SymbolTable, error) {
+	p := parser.New(lexer.New(src))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		return nil, nil, &SyntaxError{Errors: p.Errors()}
+	}
+	if in.optimize {
+		program = optimizer.New().Optimize(program)
+	}
+
+	symbols := in.symbols.Clone()
+	// capped so that the compiler appends to a copy: programs compiled
+	// earlier keep sharing the constants they were compiled with
+	constants := in.constants[:len(in.constants):len(in.constants)]
+	comp := compiler.NewWithState(symbols, constants)
+	if err := comp.Compile(program); err != nil {
+		var undefined *compiler.UndefinedVariableError
+		if errors.As(err, &undefined) {
+			if capability, ok := in.denied[undefined.Name]; ok {
+				return nil, nil, &CapabilityError{Capability: capability, Builtin: undefined.Name}
+			}
+		}
+		return nil, nil, err
+	}
+	return comp.Bytecode(), symbols, nil
+}
+
+func result(machine *vm.VM) Value {
+	if result := machine.LastPoppedStackElem(); result != nil {
+		return result
+	}
+	return Null
+}
+
+// Set defines or replaces the global name with the Monkey value of v, see
+// ToValue. Globals declared with const cannot be replaced.
+func (in *Interpreter) Set(name string, v interface{}) error {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	value, err := ToValue(v)
+	if err != nil {
+		return err
+	}
+
+	symbol, ok := in.symbols.Resolve(name)
+	if !ok || symbol.Scope != compiler.GlobalScope {
+		symbol = in.symbols.Define(name)
+	}
+	if symbol.Const {
+		return fmt.Errorf("cannot set %s: constant", name)
+	}
+	if symbol.Index >= len(in.globals) {
+		return fmt.Errorf("too many globals")
+	}
+
+	in.globals[symbol.Index] = value
+	return nil
+}
+
+// Get returns the value of the global or builtin name.
+func (in *Interpreter) Get(name string) (Value, bool) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	symbol, ok := in.symbols.Resolve(name)
+	if !ok {
+		return nil, false
+	}
+	if symbol.Scope == compiler.BuiltinScope {
+		return in.builtins[symbol.Index], true
+	}
+	if in.globals[symbol.Index] == nil {
+		return nil, false
+	}
+	return in.globals[symbol.Index], true
+}
@@ -0,0 +1,294 @@
+package monkey
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"interpreter/object"
+)
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"1 + 2 * 3", int64(7)},
+		{"1 < 2", true},
+		{"if (false) { 1 }", nil},
+		{"", nil},
+		{"let x = 5;", nil},
+		{"1; let y = 7;", nil},
+		{"let n = 0; for (x in [1, 2]) { n += x; }", nil},
+		{"for (k, v in {\"a\": 1}) { k; }", nil},
+		{"let i = 0; while (i < 3) { i += 1; }", nil},
+		{"let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(10)", int64(55)},
+	}
+
+	for _, tt := range tests {
+		value, err := New().Eval(context.Background(), tt.input)
+		if err != nil {
+			t.Fatalf("eval error for %q: %s", tt.input, err)
+		}
+		if actual := ToGo(value); actual != tt.expected {
+			t.Errorf("wrong value for %q. expected=%v, got=%v", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestGlobalsPersist(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	if _, err := m.Eval(ctx, "let double = fn(x) { x * 2 }; let a = 5;"); err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+	value, err := m.Eval(ctx, "double(a) + 1")
+	if err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+	if ToGo(value) != int64(11) {
+		t.Errorf("expected 11, got=%s", value.Inspect())
+	}
+
+	// closures keep their environment between snippets
+	if _, err := m.Eval(ctx, "let addTwo = fn(a) { fn(b) { a + b } }(2);"); err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+	if value, ok := m.Get("addTwo"); !ok || value.Type() != ClosureType {
+		t.Errorf("expected addTwo to be a closure, got=%v", value)
+	}
+	if value, err := m.Eval(ctx, "addTwo(3)"); err != nil || ToGo(value) != int64(5) {
+		t.Errorf("expected 5, got=%v (%v)", value, err)
+	}
+}
+
+func TestSetGet(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	if err := m.Set("limit", 10); err != nil {
+		t.Fatalf("set error: %s", err)
+	}
+	if err := m.Set("enabled", true); err != nil {
+		t.Fatalf("set error: %s", err)
+	}
+
+	value, err := m.Eval(ctx, "let result = if (enabled) { limit * 3 } else { 0 }; result")
+	if err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+	if ToGo(value) != int64(30) {
+		t.Errorf("expected 30, got=%s", value.Inspect())
+	}
+
+	result, ok := m.Get("result")
+	if !ok || ToGo(result) != int64(30) {
+		t.Errorf("expected result to be 30, got=%v (%t)", result, ok)
+	}
+
+	// replacing a global defined by a script
+	if err := m.Set("result", uint8(7)); err != nil {
+		t.Fatalf("set error: %s", err)
+	}
+	if value, _ := m.Eval(ctx, "result"); ToGo(value) != int64(7) {
+		t.Errorf("expected 7, got=%s", value.Inspect())
+	}
+
+	if _, ok := m.Get("missing"); ok {
+		t.Errorf("expected missing to be undefined")
+	}
+	if err := m.Set("x", 1.5); err == nil {
+		t.Errorf("expected an error setting a float")
+	}
+
+	if _, err := m.Eval(ctx, "const max = 3;"); err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+	if err := m.Set("max", 4); err == nil || err.Error() != "cannot set max: constant" {
+		t.Errorf("expected an error setting a constant, got=%v", err)
+	}
+	if _, err := m.Eval(ctx, "max = 4"); err == nil || err.Error() != "1:5: cannot assign to constant max" {
+		t.Errorf("expected an error assigning a constant, got=%v", err)
+	}
+	if value, _ := m.Eval(ctx, "max"); ToGo(value) != int64(3) {
+		t.Errorf("expected 3, got=%s", value.Inspect())
+	}
+}
+
+func TestEvalErrors(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+
+	_, err := m.Eval(ctx, "let = 1;")
+	var syntaxErr *SyntaxError
+	if !errors.As(err, &syntaxErr) || len(syntaxErr.Errors) == 0 {
+		t.Errorf("expected a SyntaxError, got=%v", err)
+	}
+
+	_, err = m.Eval(ctx, "let a = 1;\nlet b = a / 0;")
+	var runtimeErr *RuntimeError
+	if !errors.As(err, &runtimeErr) || runtimeErr.Line != 2 || runtimeErr.Column != 11 {
+		t.Errorf("expected a RuntimeError at 2:11, got=%v", err)
+	}
+
+	// the failed snippet defined nothing
+	if _, ok := m.Get("a"); ok {
+		t.Errorf("expected a to be undefined after a failed snippet")
+	}
+	if _, err := m.Eval(ctx, "b"); err == nil || err.Error() != "undefined variable b" {
+		t.Errorf("expected b to be undefined, got=%v", err)
+	}
+
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	var canceledErr *CanceledError
+	if _, err := m.Eval(canceled, "1"); !errors.As(err, &canceledErr) || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a CanceledError for context.Canceled, got=%v", err)
+	}
+}
+
+func TestWithOptimizer(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		value, err := New(WithOptimizer(enabled)).Eval(context.Background(), "-5 * (10 + 2)")
+		if err != nil {
+			t.Fatalf("eval error: %s", err)
+		}
+		if ToGo(value) != int64(-60) {
+			t.Errorf("expected -60 with optimizer=%t, got=%s", enabled, value.Inspect())
+		}
+
+		// the optimizer must not remove operations failing on strings
+		_, err = New(WithOptimizer(enabled)).Eval(context.Background(), `let s = "a"; (s + s) * 1`)
+		var runtimeErr *RuntimeError
+		if !errors.As(err, &runtimeErr) {
+			t.Errorf("expected a runtime error with optimizer=%t, got=%v", enabled, err)
+		}
+	}
+}
+
+func TestToValue(t *testing.T) {
+	tests := []struct {
+		input    interface{}
+		expected string
+	}{
+		{42, "42"},
+		{int8(-3), "-3"},
+		{uint32(7), "7"},
+		{true, "true"},
+		{"text", "text"},
+		{nil, "null"},
+		{Null, "null"},
+		{[]interface{}{1, "a", []int{2}}, "[1, a, [2]]"},
+		{[]interface{}(nil), "null"},
+		{map[string]interface{}{"b": 1, "a": []string{"x"}}, "{a: [x], b: 1}"},
+	}
+
+	for _, tt := range tests {
+		value, err := ToValue(tt.input)
+		if err != nil {
+			t.Fatalf("ToValue(%v) error: %s", tt.input, err)
+		}
+		if value.Inspect() != tt.expected {
+			t.Errorf("ToValue(%v) = %s, expected %s", tt.input, value.Inspect(), tt.expected)
+		}
+	}
+
+	if _, err := ToValue(uint64(1 << 63)); err == nil {
+		t.Errorf("expected an error for an out of range integer")
+	}
+	if _, err := ToValue(1.5); err == nil {
+		t.Errorf("expected an error for a float")
+	}
+	if _, err := ToValue([]interface{}{1.5}); err == nil {
+		t.Errorf("expected an error for an array of floats")
+	}
+	if _, err := ToValue(map[int]interface{}{1: 2}); err == nil {
+		t.Errorf("expected an error for a map with integer keys")
+	}
+
+	cyclic := []interface{}{1, nil}
+	cyclic[1] = cyclic
+	value, err := ToValue(cyclic)
+	if err != nil {
+		t.Fatalf("ToValue(cyclic) error: %s", err)
+	}
+	if array := value.(*object.Array); array.Elements[1] != array {
+		t.Errorf("expected the array to contain itself, got %s", array.Inspect())
+	}
+}
+
+func TestToGo(t *testing.T) {
+	value, err := New().Eval(context.Background(), `[1, "a", [true, {}], {"k": [if (false) { 1 }]}]`)
+	if err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+	expected := []interface{}{
+		int64(1), "a",
+		[]interface{}{true, map[string]interface{}{}},
+		map[string]interface{}{"k": []interface{}{nil}},
+	}
+	if actual := ToGo(value); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("wrong value. expected=%#v, got=%#v", expected, actual)
+	}
+
+	// hashes with other keys have no Go map to become
+	value, err = New().Eval(context.Background(), `{1: "one"}`)
+	if err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+	if actual := ToGo(value); actual != value {
+		t.Errorf("expected the hash unchanged, got=%#v", actual)
+	}
+
+	value, err = New().Eval(context.Background(), "let a = [1, 2]; a[1] = a; a")
+	if err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+	cyclic := ToGo(value).([]interface{})
+	if inner := cyclic[1].([]interface{}); &inner[0] != &cyclic[0] {
+		t.Errorf("expected the slice to contain itself")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	const recursion = `
+let down = fn(n) {
+  if (n == 0) { return 0; }
+  down(n - 1) + 1
+};
+down(100)`
+
+	ctx := context.Background()
+
+	_, err := New(WithStepLimit(100)).Eval(ctx, recursion)
+	var stepErr *StepLimitError
+	if !errors.As(err, &stepErr) {
+		t.Errorf("expected a StepLimitError, got=%v", err)
+	}
+
+	_, err = New(WithDepthLimit(10)).Eval(ctx, recursion)
+	var depthErr *DepthLimitError
+	if !errors.As(err, &depthErr) {
+		t.Errorf("expected a DepthLimitError, got=%v", err)
+	}
+	var runtimeErr *RuntimeError
+	if errors.As(err, &runtimeErr) && len(runtimeErr.Stack) != 11 {
+		t.Errorf("expected 10 calls and the main program on the stack, got=%v", runtimeErr.Stack)
+	}
+
+	_, err = New(WithMemoryLimit(64)).Eval(ctx, recursion)
+	var memoryErr *MemoryLimitError
+	if !errors.As(err, &memoryErr) {
+		t.Errorf("expected a MemoryLimitError, got=%v", err)
+	}
+
+	// limits apply to each evaluation
+	m := New(WithStepLimit(2000), WithDepthLimit(101), WithMemoryLimit(1<<20))
+	for i := 0; i < 3; i++ {
+		if value, err := m.Eval(ctx, recursion); err != nil || ToGo(value) != int64(100) {
+			t.Fatalf("expected 100, got=%v (%v)", value, err)
+		}
+	}
+}
@@ -0,0 +1,142 @@
+package monkey
+
+import (
+	"context"
+	"fmt"
+
+	"interpreter/compiler"
+	"interpreter/object"
+	"interpreter/vm"
+)
+
+// Program is a compiled snippet. It is immutable and safe to run from
+// several goroutines at once: every run starts from the globals the
+// interpreter had when the program was compiled and its definitions are
+// discarded when it ends.
+type Program struct {
+	bytecode *compiler.Bytecode
+	symbols  *compiler.SymbolTable
+	globals  []object.Object
+	builtins []*object.Builtin
+	limits   vm.Limits
+}
+
+// Compile compiles the snippet src, reporting errors like Eval. The
+// globals it defines are only visible to its runs, later changes to the
+// interpreter do not affect it.
+func (in *Interpreter) Compile(src string) (*Program, error) {
+	in.mu.Lock()
+	defer in.mu.Unlock()
+
+	bytecode, symbols, err := in.compile(src)
+	if err != nil {
+		return nil, err
+	}
+
+	globals := copyGlobals(in.globals[:symbols.NumDefinitions()])
+
+	return &Program{
+		bytecode: bytecode,
+		symbols:  symbols,
+		globals:  globals,
+		builtins: append([]*object.Builtin(nil), in.builtins...),
+		limits:   in.limits,
+	}, nil
+}
+
+// Run runs the program with the globals named in vars set to their Monkey
+// values, see ToValue, and returns the value of its last statement like
+// Eval. vars may only name globals not declared with const.
+func (p *Program) Run(ctx context.Context, vars map[string]interface{}) (Value, error) {
+	globals := copyGlobals(p.globals)
+
+	for name, v := range vars {
+		symbol, ok := p.symbols.Resolve(name)
+		if !ok || symbol.Scope != compiler.GlobalScope {
+			return nil, fmt.Errorf("cannot set %s: not a global", name)
+		}
+		if symbol.Const {
+			return nil, fmt.Errorf("cannot set %s: constant", name)
+		}
+		value, err := ToValue(v)
+		if err != nil {
+			return nil, err
+		}
+		globals[symbol.Index] = value
+	}
+
+	machine := vm.NewWithBuiltins(p.bytecode, globals, p.builtins)
+	machine.SetLimits(p.limits)
+	if err := machine.RunContext(ctx); err != nil {
+		return nil, err
+	}
+	return result(machine), nil
+}
+
+// Copies the globals with their arrays, hashes and the environments
+// captured by closures, which scripts can change in place, so that runs
+// neither see nor make changes of others.
+func copyGlobals(globals []object.Object) []object.Object {
+	copies := make(map[interface{}]interface{})
+	result := make([]object.Object, len(globals))
+	for i, v := range globals {
+		result[i] = copyValue(v, copies)
+	}
+	return result
+}
+
+func copyValue(v object.Object, copies map[interface{}]interface{}) object.Object {
+	switch v := v.(type) {
+	case *object.Array:
+		if c, ok := copies[v]; ok {
+			return c.(*object.Array)
+		}
+		array := &object.Array{Elements: make([]object.Object, len(v.Elements))}
+		copies[v] = array
+		for i, e := range v.Elements {
+			array.Elements[i] = copyValue(e, copies)
+		}
+		return array
+
+	case *object.Hash:
+		if c, ok := copies[v]; ok {
+			return c.(*object.Hash)
+		}
+		hash := object.NewHash(v.Len())
+		copies[v] = hash
+		for _, pair := range v.Pairs() {
+			hash.Set(pair.Key.(object.Hashable), copyValue(pair.Value, copies))
+		}
+		return hash
+
+	case *object.Closure:
+		if c, ok := copies[v]; ok {
+			return c.(*object.Closure)
+		}
+		closure := &object.Closure{Fn: v.Fn}
+		copies[v] = closure
+		closure.Env = copyEnvironment(v.Env, copies)
+		return closure
+
+	default:
+		return v
+	}
+}
+
+// Copies the environment and those enclosing it, which closures made in
+// the same call share.
+func copyEnvironment(env *object.Environment, copies map[interface{}]interface{}) *object.Environment {
+	if env == nil {
+		return nil
+	}
+	if c, ok := copies[env]; ok {
+		return c.(*object.Environment)
+	}
+	e := &object.Environment{Store: make([]object.Object, len(env.Store)), Consts: env.Consts}
+	copies[env] = e
+	e.Outer = copyEnvironment(env.Outer, copies)
+	for i, v := range env.Store {
+		e.Store[i] = copyValue(v, copies)
+	}
+	return e
+}
@@ -0,0 +1,268 @@
+package monkey
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestProgram(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+	m.Set("amount", 0)
+	if _, err := m.Eval(ctx, "let limit = 100; let over = fn(x) { x > limit };"); err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+
+	rule, err := m.Compile("let checked = amount; over(amount)")
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	tests := []struct {
+		vars     map[string]interface{}
+		expected bool
+	}{
+		{map[string]interface{}{"amount": 150}, true},
+		{map[string]interface{}{"amount": 50}, false},
+		{map[string]interface{}{"amount": 150, "limit": 200}, false},
+		{nil, false},
+	}
+
+	for _, tt := range tests {
+		value, err := rule.Run(ctx, tt.vars)
+		if err != nil {
+			t.Fatalf("run error for %v: %s", tt.vars, err)
+		}
+		if ToGo(value) != tt.expected {
+			t.Errorf("expected %t for %v, got=%s", tt.expected, tt.vars, value.Inspect())
+		}
+	}
+
+	// runs neither see later changes of the interpreter nor change it
+	m.Set("limit", 10)
+	if value, _ := rule.Run(ctx, map[string]interface{}{"amount": 50}); ToGo(value) != false {
+		t.Errorf("expected the program to keep its limit, got=%s", value.Inspect())
+	}
+	if _, ok := m.Get("checked"); ok {
+		t.Errorf("expected checked to be undefined in the interpreter")
+	}
+
+	if _, err := rule.Run(ctx, map[string]interface{}{"over": 1, "missing": 1}); err == nil {
+		t.Errorf("expected an error setting an undefined global")
+	}
+	if _, err := rule.Run(ctx, map[string]interface{}{"amount": 1.5}); err == nil {
+		t.Errorf("expected an error setting a float")
+	}
+}
+
+func TestProgramErrors(t *testing.T) {
+	m := New()
+
+	var syntaxErr *SyntaxError
+	if _, err := m.Compile("let = 1;"); !errors.As(err, &syntaxErr) {
+		t.Errorf("expected a SyntaxError, got=%v", err)
+	}
+	var capabilityErr *CapabilityError
+	if _, err := m.Compile("now()"); !errors.As(err, &capabilityErr) {
+		t.Errorf("expected a CapabilityError, got=%v", err)
+	}
+
+	constant, err := m.Compile("const rate = 2; rate")
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	vars := map[string]interface{}{"rate": 3}
+	if _, err := constant.Run(context.Background(), vars); err == nil || err.Error() != "cannot set rate: constant" {
+		t.Errorf("expected an error setting a constant, got=%v", err)
+	}
+
+	rule, err := New(WithStepLimit(50)).Compile("let f = fn(n) { if (n == 0) { return 0; } f(n - 1) }; f(100)")
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	var stepErr *StepLimitError
+	if _, err := rule.Run(context.Background(), nil); !errors.As(err, &stepErr) {
+		t.Errorf("expected a StepLimitError, got=%v", err)
+	}
+}
+
+// Run with -race to check that concurrent runs share nothing mutable.
+func TestProgramCopiesCollections(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+	if _, err := m.Eval(ctx, `let counts = {"runs": 0}; let xs = [counts, 0]; xs[1] = xs;`); err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+
+	count, err := m.Compile(`counts["runs"] += 1; xs[1][0]["runs"]`)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	// every run starts from the collections the program was compiled with
+	for i := 0; i < 3; i++ {
+		value, err := count.Run(ctx, nil)
+		if err != nil {
+			t.Fatalf("run error: %s", err)
+		}
+		if ToGo(value) != int64(1) {
+			t.Errorf("expected 1, got=%s", value.Inspect())
+		}
+	}
+	if value, _ := m.Eval(ctx, `counts["runs"]`); ToGo(value) != int64(0) {
+		t.Errorf("expected the interpreter to keep its count, got=%s", value.Inspect())
+	}
+}
+
+func TestProgramCopiesClosures(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+	if _, err := m.Eval(ctx, `let counter = fn() { let count = 0; fn() { count += 1 } }(); let twice = fn() { counter(); counter() };`); err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+
+	count, err := m.Compile("twice()")
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	// the runs share neither the count nor its environment, which the
+	// race detector checks
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				value, err := count.Run(ctx, nil)
+				if err != nil {
+					errs <- err
+					return
+				}
+				if ToGo(value) != int64(2) {
+					errs <- fmt.Errorf("expected 2, got=%s", value.Inspect())
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	if value, _ := m.Eval(ctx, "counter()"); ToGo(value) != int64(1) {
+		t.Errorf("expected the interpreter to keep its count, got=%s", value.Inspect())
+	}
+}
+
+func TestProgramKeepsItsConstants(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+	// leaves spare capacity after the constants of the interpreter
+	if _, err := m.Eval(ctx, "1; 2; 3"); err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+
+	sum, err := m.Compile("1000 + 2000")
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+	if _, err := m.Eval(ctx, "7 + 8"); err != nil {
+		t.Fatalf("eval error: %s", err)
+	}
+
+	value, err := sum.Run(ctx, nil)
+	if err != nil {
+		t.Fatalf("run error: %s", err)
+	}
+	if ToGo(value) != int64(3000) {
+		t.Errorf("expected 3000, got=%s", value.Inspect())
+	}
+}
+
+func TestProgramConcurrentRuns(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+	m.Set("n", 0)
+
+	fib, err := m.Compile(`
+let fib = fn(x) { if (x < 2) { return x; } fib(x - 1) + fib(x - 2) };
+let label = "fib";
+fib(n)`)
+	if err != nil {
+		t.Fatalf("compile error: %s", err)
+	}
+
+	expected := []int64{0, 1, 1, 2, 3, 5, 8, 13, 21, 34, 55, 89}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 64)
+	for g := 0; g < 16; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 20; i++ {
+				n := (g + i) % len(expected)
+				value, err := fib.Run(ctx, map[string]interface{}{"n": n})
+				if err != nil {
+					errs <- err
+					return
+				}
+				if ToGo(value) != expected[n] {
+					errs <- fmt.Errorf("fib(%d): expected %d, got=%s", n, expected[n], value.Inspect())
+					return
+				}
+			}
+		}(g)
+	}
+
+	// the interpreter stays usable while the program runs
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			if _, err := m.Eval(ctx, fmt.Sprintf("let n = %d; n * 2", i)); err != nil {
+				errs <- err
+				return
+			}
+			m.Get("n")
+		}
+	}()
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestInterpreterConcurrentEvals(t *testing.T) {
+	ctx := context.Background()
+	m := New()
+	m.Set("total", 0)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 25; i++ {
+				if _, err := m.Eval(ctx, "let total = total + 1;"); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	if total, _ := m.Get("total"); ToGo(total) != int64(200) {
+		t.Errorf("expected 200, got=%s", total.Inspect())
+	}
+}
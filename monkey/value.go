@@ -0,0 +1,185 @@
+package monkey
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+
+	"interpreter/object"
+	"interpreter/vm"
+)
+
+// Value is a Monkey value. Its Type is one of the Type constants and
+// Inspect returns its Monkey representation. It aliases a type of the
+// object package, see the package documentation.
+type Value = object.Object
+
+// Types of Monkey values.
+const (
+	IntegerType  = object.INTEGER_OBJ
+	BooleanType  = object.BOOLEAN_OBJ
+	StringType   = object.STRING_OBJ
+	NullType     = object.NULL_OBJ
+	ArrayType    = object.ARRAY_OBJ
+	HashType     = object.HASH_OBJ
+	RangeType    = object.RANGE_OBJ
+	FunctionType = object.COMPILED_FUNCTION_OBJ
+	ClosureType  = object.CLOSURE_OBJ // function defined in another one
+	BuiltinType  = object.BUILTIN_OBJ
+)
+
+var (
+	True  Value = vm.True
+	False Value = vm.False
+	Null  Value = vm.Null
+)
+
+// ToValue converts a Go value to a Monkey value. Integers of any size
+// become Monkey integers, bools booleans, strings Monkey strings and nil
+// becomes null. Slices and arrays become Monkey arrays and maps with string
+// keys Monkey hashes, with their elements converted the same way and their
+// keys in sorted order. Nil slices and maps become null.
+// Values are returned unchanged.
+func ToValue(v interface{}) (Value, error) {
+	return toValue(v, make(map[reference]Value))
+}
+
+// reference identifies a Go slice or map, so that one contained in itself
+// is converted once.
+type reference struct {
+	kind    reflect.Kind
+	pointer uintptr
+	length  int
+}
+
+func toValue(v interface{}, converted map[reference]Value) (Value, error) {
+	switch v := v.(type) {
+	case nil:
+		return Null, nil
+	case Value:
+		return v, nil
+	case bool:
+		if v {
+			return True, nil
+		}
+		return False, nil
+	}
+
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: rv.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if rv.Uint() > math.MaxInt64 {
+			return nil, fmt.Errorf("cannot convert %d to a Monkey integer: out of range", rv.Uint())
+		}
+		return &object.Integer{Value: int64(rv.Uint())}, nil
+	case reflect.Bool:
+		return toValue(rv.Bool(), converted)
+	case reflect.String:
+		return &object.String{Value: rv.String()}, nil
+
+	case reflect.Slice, reflect.Array:
+		if rv.Kind() == reflect.Slice && rv.IsNil() {
+			return Null, nil
+		}
+		var ref reference
+		if rv.Kind() == reflect.Slice {
+			ref = reference{reflect.Slice, rv.Pointer(), rv.Len()}
+			if array, ok := converted[ref]; ok {
+				return array, nil
+			}
+		}
+		array := &object.Array{Elements: make([]object.Object, rv.Len())}
+		if rv.Kind() == reflect.Slice {
+			converted[ref] = array
+		}
+		for i := range array.Elements {
+			element, err := toValue(rv.Index(i).Interface(), converted)
+			if err != nil {
+				return nil, err
+			}
+			array.Elements[i] = element
+		}
+		return array, nil
+
+	case reflect.Map:
+		if rv.Type().Key().Kind() != reflect.String {
+			break
+		}
+		if rv.IsNil() {
+			return Null, nil
+		}
+		ref := reference{reflect.Map, rv.Pointer(), 0}
+		if hash, ok := converted[ref]; ok {
+			return hash, nil
+		}
+		hash := object.NewHash(rv.Len())
+		converted[ref] = hash
+
+		keys := rv.MapKeys()
+		sort.Slice(keys, func(i, j int) bool { return keys[i].String() < keys[j].String() })
+		for _, key := range keys {
+			value, err := toValue(rv.MapIndex(key).Interface(), converted)
+			if err != nil {
+				return nil, err
+			}
+			hash.Set(&object.String{Value: key.String()}, value)
+		}
+		return hash, nil
+	}
+
+	return nil, fmt.Errorf("cannot convert %T to a Monkey value", v)
+}
+
+// ToGo converts a Monkey value to a Go value: integers become int64,
+// booleans bool, strings string, null nil, arrays []interface{} and hashes
+// whose keys are all strings map[string]interface{}, with their elements
+// converted the same way. Other values are returned unchanged.
+func ToGo(v Value) interface{} {
+	return toGo(v, make(map[Value]interface{}))
+}
+
+func toGo(v Value, converted map[Value]interface{}) interface{} {
+	switch v := v.(type) {
+	case *object.Integer:
+		return v.Value
+	case *object.Boolean:
+		return v.Value
+	case *object.String:
+		return v.Value
+	case *object.Null, nil:
+		return nil
+
+	case *object.Array:
+		if slice, ok := converted[v]; ok {
+			return slice
+		}
+		slice := make([]interface{}, len(v.Elements))
+		converted[v] = slice
+		for i, element := range v.Elements {
+			slice[i] = toGo(element, converted)
+		}
+		return slice
+
+	case *object.Hash:
+		if m, ok := converted[v]; ok {
+			return m
+		}
+		for _, pair := range v.Pairs() {
+			if _, ok := pair.Key.(*object.String); !ok {
+				return v
+			}
+		}
+		m := make(map[string]interface{}, v.Len())
+		converted[v] = m
+		for _, pair := range v.Pairs() {
+			m[pair.Key.(*object.String).Value] = toGo(pair.Value, converted)
+		}
+		return m
+
+	default:
+		return v
+	}
+}
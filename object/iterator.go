@@ -0,0 +1,100 @@
+package object
+
+import (
+	"fmt"
+	"unicode/utf8"
+)
+
+const ITERATOR_OBJ = "ITERATOR"
+
+// Iterator steps through the elements of an iterable object: arrays by
+// element, hashes by pair in insertion order, strings by code point and
+// ranges by value. It only lives on the stack of a running loop.
+type Iterator struct {
+	obj    Object
+	pos    int   // elements produced so far
+	offset int   // byte offset of the next code point of a string
+	next   int64 // next value of a range
+	done   bool  // set when the next value of a range would overflow
+}
+
+// NewIterator returns an iterator over obj, or false if obj is not
+// iterable.
+func NewIterator(obj Object) (*Iterator, bool) {
+	switch obj := obj.(type) {
+	case *Array, *Hash, *String:
+		return &Iterator{obj: obj}, true
+	case *Range:
+		return &Iterator{obj: obj, next: obj.Start}, true
+	}
+	return nil, false
+}
+
+// Next returns the next element along with its key, which is its position
+// except for hashes. ok is false once the elements are exhausted.
+func (it *Iterator) Next() (key, value Object, ok bool) {
+	switch obj := it.obj.(type) {
+	case *Array:
+		if it.pos >= len(obj.Elements) {
+			return nil, nil, false
+		}
+		key, value = &Integer{Value: int64(it.pos)}, obj.Elements[it.pos]
+
+	case *Hash:
+		if it.pos >= len(obj.pairs) {
+			return nil, nil, false
+		}
+		key, value = obj.pairs[it.pos].Key, obj.pairs[it.pos].Value
+
+	case *String:
+		if it.offset >= len(obj.Value) {
+			return nil, nil, false
+		}
+		_, size := utf8.DecodeRuneInString(obj.Value[it.offset:])
+		key, value = &Integer{Value: int64(it.pos)}, &String{Value: obj.Value[it.offset : it.offset+size]}
+		it.offset += size
+
+	case *Range:
+		if it.done || !obj.includes(it.next) {
+			return nil, nil, false
+		}
+		key, value = &Integer{Value: int64(it.pos)}, &Integer{Value: it.next}
+		next := it.next + obj.Step
+		it.done = (obj.Step > 0) != (next > it.next)
+		it.next = next
+
+	default:
+		return nil, nil, false
+	}
+
+	it.pos++
+	return key, value, true
+}
+
+// Keys reports whether a loop with a single variable takes the keys
+// rather than the elements, which is the case for hashes.
+func (it *Iterator) Keys() bool {
+	_, ok := it.obj.(*Hash)
+	return ok
+}
+
+func (it *Iterator) Type() ObjectType {
+	return ITERATOR_OBJ
+}
+func (it *Iterator) Inspect() string {
+	return fmt.Sprintf("Iterator[%s]", it.obj.Type())
+}
+
+// Reports whether v is within the bounds of the range.
+func (r *Range) includes(v int64) bool {
+	switch {
+	case r.Step > 0 && r.Inclusive:
+		return v <= r.End
+	case r.Step > 0:
+		return v < r.End
+	case r.Inclusive:
+		return v >= r.End
+	default:
+		return v > r.End
+	}
+}
@@ -0,0 +1,319 @@
+package object
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"interpreter/code"
+)
+
+type ObjectType string
+
+const (
+	INTEGER_OBJ = "INTEGER"
+	BOOLEAN_OBJ = "BOOLEAN"
+	NULL_OBJ    = "NULL"
+	STRING_OBJ  = "STRING"
+	ARRAY_OBJ   = "ARRAY"
+	HASH_OBJ    = "HASH"
+	RANGE_OBJ   = "RANGE"
+
+	COMPILED_FUNCTION_OBJ = "COMPILED_FUNCTION"
+	CLOSURE_OBJ           = "CLOSURE"
+	BUILTIN_OBJ           = "BUILTIN"
+)
+
+type Object interface {
+	Type() ObjectType
+	Inspect() string
+}
+
+type Integer struct {
+	Value int64
+}
+
+func (i *Integer) Type() ObjectType {
+	return INTEGER_OBJ
+}
+func (i *Integer) Inspect() string {
+	return fmt.Sprintf("%d", i.Value)
+}
+
+type Boolean struct {
+	Value bool
+}
+
+func (b *Boolean) Type() ObjectType {
+	return BOOLEAN_OBJ
+}
+func (b *Boolean) Inspect() string {
+	return fmt.Sprintf("%t", b.Value)
+}
+
+type String struct {
+	Value string
+}
+
+func (s *String) Type() ObjectType {
+	return STRING_OBJ
+}
+func (s *String) Inspect() string {
+	return s.Value
+}
+
+type Array struct {
+	Elements []Object
+}
+
+func (a *Array) Type() ObjectType {
+	return ARRAY_OBJ
+}
+func (a *Array) Inspect() string {
+	return inspect(a, nil)
+}
+
+// HashKey identifies the value of a hash key, so that equal integers,
+// booleans or strings find the same pair.
+type HashKey struct {
+	Type  ObjectType
+	Value int64
+	Text  string
+}
+
+// Hashable is implemented by the objects that can be hash keys.
+type Hashable interface {
+	Object
+	HashKey() HashKey
+}
+
+func (i *Integer) HashKey() HashKey {
+	return HashKey{Type: i.Type(), Value: i.Value}
+}
+
+func (b *Boolean) HashKey() HashKey {
+	if b.Value {
+		return HashKey{Type: b.Type(), Value: 1}
+	}
+	return HashKey{Type: b.Type()}
+}
+
+func (s *String) HashKey() HashKey {
+	return HashKey{Type: s.Type(), Text: s.Value}
+}
+
+type HashPair struct {
+	Key   Object
+	Value Object
+}
+
+// Hash keeps its pairs in insertion order.
+type Hash struct {
+	pairs []HashPair
+	index map[HashKey]int // position in pairs
+}
+
+func NewHash(size int) *Hash {
+	return &Hash{
+		pairs: make([]HashPair, 0, size),
+		index: make(map[HashKey]int, size),
+	}
+}
+
+// Get returns the value of key.
+func (h *Hash) Get(key Hashable) (Object, bool) {
+	i, ok := h.index[key.HashKey()]
+	if !ok {
+		return nil, false
+	}
+	return h.pairs[i].Value, true
+}
+
+// Set sets the value of key, which keeps its position if it is already
+// present.
+func (h *Hash) Set(key Hashable, value Object) {
+	hk := key.HashKey()
+	if i, ok := h.index[hk]; ok {
+		h.pairs[i].Value = value
+		return
+	}
+	h.index[hk] = len(h.pairs)
+	h.pairs = append(h.pairs, HashPair{Key: key, Value: value})
+}
+
+func (h *Hash) Len() int {
+	return len(h.pairs)
+}
+
+// Pairs returns the pairs in insertion order. The slice must not be
+// modified.
+func (h *Hash) Pairs() []HashPair {
+	return h.pairs
+}
+
+func (h *Hash) Type() ObjectType {
+	return HASH_OBJ
+}
+func (h *Hash) Inspect() string {
+	return inspect(h, nil)
+}
+
+// Formats arrays and hashes, which may contain themselves once assigned
+// to: an array or hash already being formatted is shown as [...] or {...}.
+func inspect(obj Object, seen map[Object]bool) string {
+	switch obj := obj.(type) {
+	case *Array:
+		if seen[obj] {
+			return "[...]"
+		}
+		seen = mark(seen, obj)
+		elements := make([]string, len(obj.Elements))
+		for i, e := range obj.Elements {
+			elements[i] = inspect(e, seen)
+		}
+		delete(seen, obj)
+		return "[" + strings.Join(elements, ", ") + "]"
+
+	case *Hash:
+		if seen[obj] {
+			return "{...}"
+		}
+		seen = mark(seen, obj)
+		pairs := make([]string, len(obj.pairs))
+		for i, p := range obj.pairs {
+			pairs[i] = p.Key.Inspect() + ": " + inspect(p.Value, seen)
+		}
+		delete(seen, obj)
+		return "{" + strings.Join(pairs, ", ") + "}"
+
+	default:
+		return obj.Inspect()
+	}
+}
+
+func mark(seen map[Object]bool, obj Object) map[Object]bool {
+	if seen == nil {
+		seen = make(map[Object]bool)
+	}
+	seen[obj] = true
+	return seen
+}
+
+// Range is the integers from Start up to End, counting by Step. End is
+// left out unless Inclusive, Step is never 0.
+type Range struct {
+	Start     int64
+	End       int64
+	Step      int64
+	Inclusive bool
+}
+
+func (r *Range) Type() ObjectType {
+	return RANGE_OBJ
+}
+func (r *Range) Inspect() string {
+	op := ".."
+	if r.Inclusive {
+		op = "..="
+	}
+	if r.Step == 1 {
+		return fmt.Sprintf("%d%s%d", r.Start, op, r.End)
+	}
+	return fmt.Sprintf("%d%s%d step %d", r.Start, op, r.End, r.Step)
+}
+
+type Null struct{}
+
+func (n *Null) Type() ObjectType {
+	return NULL_OBJ
+}
+func (n *Null) Inspect() string {
+	return "null"
+}
+
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	SourceMap     code.SourceMap
+	NumLocals     int
+	NumParameters int
+	NumDefaults   int    // trailing parameters with a default, null when missing
+	Variadic      bool   // extra arguments go to an array after the parameters
+	Name          string // empty for anonymous functions
+
+	// Captured is set when the function contains nested functions, its
+	// locals then live in an Environment created by each call.
+	Captured bool
+	// ConstLocals has the names of the locals of a captured function
+	// declared with const by index, "" for the others, nil if there are
+	// none.
+	ConstLocals []string
+}
+
+func (cf *CompiledFunction) Type() ObjectType {
+	return COMPILED_FUNCTION_OBJ
+}
+func (cf *CompiledFunction) Inspect() string {
+	if cf.Name != "" {
+		return fmt.Sprintf("CompiledFunction[%s]", cf.Name)
+	}
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// Environment holds the locals of a call captured by nested functions.
+// Outer is the environment of the enclosing function's call.
+type Environment struct {
+	Store []Object
+	Outer *Environment
+
+	Consts []string // names of the constants in Store, see CompiledFunction.ConstLocals
+}
+
+func NewEnclosedEnvironment(outer *Environment, size int) *Environment {
+	return &Environment{Store: make([]Object, size), Outer: outer}
+}
+
+// At returns the environment depth levels up the chain.
+func (e *Environment) At(depth int) *Environment {
+	for ; depth > 0; depth-- {
+		e = e.Outer
+	}
+	return e
+}
+
+// Closure is a function created inside another one, along with the
+// environment it was defined in.
+type Closure struct {
+	Fn  *CompiledFunction
+	Env *Environment
+}
+
+func (c *Closure) Type() ObjectType {
+	return CLOSURE_OBJ
+}
+func (c *Closure) Inspect() string {
+	if c.Fn.Name != "" {
+		return fmt.Sprintf("Closure[%s]", c.Fn.Name)
+	}
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// BuiltinFunction is a function of the host. Returned errors abort the
+// program and are reported at the call site.
+type BuiltinFunction func(args ...Object) (Object, error)
+
+// ContextBuiltinFunction is a BuiltinFunction that is also given the context
+// of the run calling it, e.g. to stop waiting when the run is canceled.
+type ContextBuiltinFunction func(ctx context.Context, args ...Object) (Object, error)
+
+type Builtin struct {
+	Name      string
+	Fn        BuiltinFunction
+	ContextFn ContextBuiltinFunction // called instead of Fn if set
+}
+
+func (b *Builtin) Type() ObjectType {
+	return BUILTIN_OBJ
+}
+func (b *Builtin) Inspect() string {
+	return fmt.Sprintf("Builtin[%s]", b.Name)
+}
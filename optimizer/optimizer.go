@@ -0,0 +1,466 @@
+// Package optimizer simplifies Monkey programs before they are compiled.
+//
+// It folds prefix and infix expressions over integer and boolean literals
+// and removes identities such as x * 1. A rewrite is only made when it
+// cannot change the behavior of the program: operations that fail at
+// runtime, like division by zero, are left in place so that they still
+// fail, and identities are only removed when the remaining operand is known
+// to be an integer.
+package optimizer
+
+import (
+	"fmt"
+	"strconv"
+
+	"interpreter/ast"
+	"interpreter/token"
+)
+
+// Change describes a rewritten expression.
+type Change struct {
+	Line   int
+	Column int
+	Before string
+	After  string
+}
+
+func (c Change) String() string {
+	return fmt.Sprintf("%d:%d: %s => %s", c.Line, c.Column, c.Before, c.After)
+}
+
+type Optimizer struct {
+	// Debug makes the optimizer record its changes, see Changes.
+	Debug bool
+
+	changes []Change
+}
+
+func New() *Optimizer {
+	return &Optimizer{}
+}
+
+// Optimize rewrites the program in place and returns it.
+func (o *Optimizer) Optimize(program *ast.Program) *ast.Program {
+	for _, s := range program.Statements {
+		o.statement(s)
+	}
+	return program
+}
+
+// Changes returns the outermost rewritten expressions, in source order, if
+// Debug is set.
+func (o *Optimizer) Changes() []Change {
+	return o.changes
+}
+
+func (o *Optimizer) statement(s ast.Statement) {
+	switch s := s.(type) {
+	case *ast.LetStatement:
+		s.Value = o.expression(s.Value, false)
+		o.pattern(s.Pattern)
+	case *ast.ReturnStatement:
+		s.ReturnValue = o.expression(s.ReturnValue, false)
+	case *ast.ExpressionStatement:
+		s.Expression = o.expression(s.Expression, false)
+	case *ast.BlockStatement:
+		o.block(s)
+	case *ast.WhileStatement:
+		s.Condition = o.expression(s.Condition, true)
+		o.block(s.Body)
+	case *ast.ForStatement:
+		if s.Init != nil {
+			o.statement(s.Init)
+		}
+		s.Condition = o.expression(s.Condition, true)
+		if s.Update != nil {
+			o.statement(s.Update)
+		}
+		o.block(s.Body)
+	case *ast.ForInStatement:
+		s.Iterable = o.expression(s.Iterable, false)
+		o.block(s.Body)
+	}
+}
+
+// Optimizes the default values and literals of a pattern, which are
+// evaluated after the value it destructures or matches.
+func (o *Optimizer) pattern(p ast.Pattern) {
+	switch p := p.(type) {
+	case *ast.ArrayPattern:
+		for _, e := range p.Elements {
+			o.pattern(e)
+		}
+	case *ast.HashPattern:
+		for _, pair := range p.Pairs {
+			o.pattern(pair.Value)
+		}
+	case *ast.DefaultPattern:
+		o.pattern(p.Target)
+		p.Default = o.expression(p.Default, false)
+	case *ast.LiteralPattern:
+		p.Value = o.expression(p.Value, false)
+	}
+}
+
+func (o *Optimizer) block(b *ast.BlockStatement) {
+	if b == nil {
+		return
+	}
+	for _, s := range b.Statements {
+		o.statement(s)
+	}
+}
+
+// Optimizes the expression and returns its replacement, which is e itself
+// if only its children changed. Expressions in a boolean context only
+// matter for their truthiness.
+func (o *Optimizer) expression(e ast.Expression, boolean bool) ast.Expression {
+	if e == nil {
+		return nil
+	}
+
+	var before string
+	mark := len(o.changes)
+	if o.Debug {
+		before = e.String()
+	}
+
+	result := o.simplify(e, boolean)
+
+	// the change of e replaces those of its children
+	if o.Debug && result != e {
+		line, column := position(e)
+		o.changes = append(o.changes[:mark], Change{
+			Line:   line,
+			Column: column,
+			Before: before,
+			After:  result.String(),
+		})
+	}
+
+	return result
+}
+
+func (o *Optimizer) simplify(e ast.Expression, boolean bool) ast.Expression {
+	switch e := e.(type) {
+	case *ast.PrefixExpression:
+		e.Right = o.expression(e.Right, e.Operator == "!")
+		return o.prefix(e, boolean)
+
+	case *ast.InfixExpression:
+		e.Left = o.expression(e.Left, false)
+		e.Right = o.expression(e.Right, false)
+		return o.infix(e)
+
+	case *ast.AssignExpression:
+		// the target itself must stay assignable
+		if target, ok := e.Target.(*ast.IndexExpression); ok {
+			target.Left = o.expression(target.Left, false)
+			target.Index = o.expression(target.Index, false)
+		}
+		e.Value = o.expression(e.Value, false)
+
+	case *ast.IfExpression:
+		e.Condition = o.expression(e.Condition, true)
+		o.block(e.Consequence)
+		o.block(e.Alternative)
+
+	case *ast.MatchExpression:
+		e.Value = o.expression(e.Value, false)
+		for _, arm := range e.Arms {
+			o.pattern(arm.Pattern)
+			arm.Guard = o.expression(arm.Guard, true)
+			arm.Body = o.expression(arm.Body, false)
+		}
+
+	case *ast.FunctionLiteral:
+		for i, d := range e.Defaults {
+			e.Defaults[i] = o.expression(d, false)
+		}
+		o.block(e.Body)
+
+	case *ast.CallExpression:
+		e.Function = o.expression(e.Function, false)
+		for i, a := range e.Arguments {
+			e.Arguments[i] = o.expression(a, false)
+		}
+
+	case *ast.SpreadExpression:
+		e.Value = o.expression(e.Value, false)
+
+	case *ast.ArrayLiteral:
+		for i, el := range e.Elements {
+			e.Elements[i] = o.expression(el, false)
+		}
+
+	case *ast.HashLiteral:
+		for _, pair := range e.Pairs {
+			pair.Key = o.expression(pair.Key, false)
+			pair.Value = o.expression(pair.Value, false)
+		}
+
+	case *ast.IndexExpression:
+		e.Left = o.expression(e.Left, false)
+		e.Index = o.expression(e.Index, false)
+
+	case *ast.RangeExpression:
+		e.Start = o.expression(e.Start, false)
+		e.End = o.expression(e.End, false)
+		e.Step = o.expression(e.Step, false)
+	}
+
+	return e
+}
+
+func (o *Optimizer) prefix(e *ast.PrefixExpression, boolean bool) ast.Expression {
+	switch right := e.Right.(type) {
+	case *ast.IntegerLiteral:
+		switch e.Operator {
+		case "-":
+			return integerLiteral(e, -right.Value)
+		case "!":
+			// integers are truthy
+			return booleanLiteral(e, false)
+		}
+
+	case *ast.Boolean:
+		if e.Operator == "!" {
+			return booleanLiteral(e, !right.Value)
+		}
+
+	case *ast.PrefixExpression:
+		// !!b is b where only truthiness matters or b is a boolean anyway
+		if e.Operator == "!" && right.Operator == "!" && (boolean || isBoolean(right.Right)) {
+			return right.Right
+		}
+	}
+
+	return e
+}
+
+func (o *Optimizer) infix(e *ast.InfixExpression) ast.Expression {
+	left, leftInt := e.Left.(*ast.IntegerLiteral)
+	right, rightInt := e.Right.(*ast.IntegerLiteral)
+
+	if leftInt && rightInt {
+		if folded := foldIntegers(e, left.Value, right.Value); folded != nil {
+			return folded
+		}
+		return e
+	}
+
+	leftBool, isLeftBool := e.Left.(*ast.Boolean)
+	rightBool, isRightBool := e.Right.(*ast.Boolean)
+
+	switch {
+	case isLeftBool && isRightBool:
+		switch e.Operator {
+		case "==":
+			return booleanLiteral(e, leftBool.Value == rightBool.Value)
+		case "!=":
+			return booleanLiteral(e, leftBool.Value != rightBool.Value)
+		}
+		return e
+
+	// an integer never equals a boolean
+	case leftInt && isRightBool, isLeftBool && rightInt:
+		switch e.Operator {
+		case "==":
+			return booleanLiteral(e, false)
+		case "!=":
+			return booleanLiteral(e, true)
+		}
+		return e
+	}
+
+	return simplifyIdentity(e, left, right)
+}
+
+// Folds an operation over two integers, or returns nil if it fails at
+// runtime. Overflow wraps around like in the VM.
+func foldIntegers(e *ast.InfixExpression, left, right int64) ast.Expression {
+	switch e.Operator {
+	case "+":
+		return integerLiteral(e, left+right)
+	case "-":
+		return integerLiteral(e, left-right)
+	case "*":
+		return integerLiteral(e, left*right)
+	case "/":
+		if right == 0 {
+			return nil
+		}
+		return integerLiteral(e, left/right)
+	case "%":
+		if right == 0 {
+			return nil
+		}
+		return integerLiteral(e, left%right)
+	case "**":
+		if right < 0 {
+			return nil
+		}
+		return integerLiteral(e, power(left, right))
+	case "==":
+		return booleanLiteral(e, left == right)
+	case "!=":
+		return booleanLiteral(e, left != right)
+	case ">":
+		return booleanLiteral(e, left > right)
+	case "<":
+		return booleanLiteral(e, left < right)
+	}
+	return nil
+}
+
+// Removes x + 0, 0 + x, x - 0, x * 1, 1 * x, x / 1 and x ** 1. The
+// remaining operand must be an integer, as x * 1 fails for any other x.
+func simplifyIdentity(e *ast.InfixExpression, left, right *ast.IntegerLiteral) ast.Expression {
+	is := func(lit *ast.IntegerLiteral, value int64) bool {
+		return lit != nil && lit.Value == value
+	}
+
+	switch e.Operator {
+	case "+":
+		if is(right, 0) && isInteger(e.Left) {
+			return e.Left
+		}
+		if is(left, 0) && isInteger(e.Right) {
+			return e.Right
+		}
+	case "-":
+		if is(right, 0) && isInteger(e.Left) {
+			return e.Left
+		}
+	case "*":
+		if is(right, 1) && isInteger(e.Left) {
+			return e.Left
+		}
+		if is(left, 1) && isInteger(e.Right) {
+			return e.Right
+		}
+	case "/", "**":
+		if is(right, 1) && isInteger(e.Left) {
+			return e.Left
+		}
+	}
+	return e
+}
+
+// Reports whether e evaluates to an integer whenever it evaluates at all.
+func isInteger(e ast.Expression) bool {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return true
+	case *ast.PrefixExpression:
+		return e.Operator == "-"
+	case *ast.InfixExpression:
+		switch e.Operator {
+		case "+":
+			// also concatenates strings
+			return isInteger(e.Left) && isInteger(e.Right)
+		case "-", "*", "/", "%", "**":
+			return true
+		}
+	}
+	return false
+}
+
+// Reports whether e evaluates to a boolean whenever it evaluates at all.
+func isBoolean(e ast.Expression) bool {
+	switch e := e.(type) {
+	case *ast.Boolean:
+		return true
+	case *ast.PrefixExpression:
+		return e.Operator == "!"
+	case *ast.InfixExpression:
+		switch e.Operator {
+		case "==", "!=", ">", "<":
+			return true
+		}
+	}
+	return false
+}
+
+func power(base, exp int64) int64 {
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return result
+}
+
+// The replacements take the position of the expression they replace.
+
+func integerLiteral(replaced ast.Expression, value int64) *ast.IntegerLiteral {
+	literal := strconv.FormatInt(value, 10)
+	return &ast.IntegerLiteral{Token: newToken(replaced, token.INT, literal), Value: value}
+}
+
+func booleanLiteral(replaced ast.Expression, value bool) *ast.Boolean {
+	if value {
+		return &ast.Boolean{Token: newToken(replaced, token.TRUE, "true"), Value: true}
+	}
+	return &ast.Boolean{Token: newToken(replaced, token.FALSE, "false"), Value: false}
+}
+
+func newToken(replaced ast.Expression, t token.TokenType, literal string) token.Token {
+	line, column := position(replaced)
+	return token.Token{Type: t, Literal: literal, Line: line, Column: column}
+}
+
+// Returns the position where the source of e starts.
+func position(e ast.Expression) (int, int) {
+	switch e := e.(type) {
+	case *ast.InfixExpression:
+		if e.Left != nil {
+			return position(e.Left)
+		}
+		return e.Token.Line, e.Token.Column
+	case *ast.CallExpression:
+		if e.Function != nil {
+			return position(e.Function)
+		}
+		return e.Token.Line, e.Token.Column
+	case *ast.AssignExpression:
+		if e.Target != nil {
+			return position(e.Target)
+		}
+		return e.Token.Line, e.Token.Column
+	case *ast.IndexExpression:
+		if e.Left != nil {
+			return position(e.Left)
+		}
+		return e.Token.Line, e.Token.Column
+	case *ast.RangeExpression:
+		if e.Start != nil {
+			return position(e.Start)
+		}
+		return e.Token.Line, e.Token.Column
+	case *ast.ArrayLiteral:
+		return e.Token.Line, e.Token.Column
+	case *ast.HashLiteral:
+		return e.Token.Line, e.Token.Column
+	case *ast.IntegerLiteral:
+		return e.Token.Line, e.Token.Column
+	case *ast.Boolean:
+		return e.Token.Line, e.Token.Column
+	case *ast.PrefixExpression:
+		return e.Token.Line, e.Token.Column
+	case *ast.Identifier:
+		return e.Token.Line, e.Token.Column
+	case *ast.IfExpression:
+		return e.Token.Line, e.Token.Column
+	case *ast.MatchExpression:
+		return e.Token.Line, e.Token.Column
+	case *ast.FunctionLiteral:
+		return e.Token.Line, e.Token.Column
+	case *ast.SpreadExpression:
+		return e.Token.Line, e.Token.Column
+	}
+	return 0, 0
+}
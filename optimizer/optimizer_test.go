@@ -0,0 +1,144 @@
+package optimizer
+
+import (
+	"testing"
+
+	"interpreter/ast"
+	"interpreter/lexer"
+	"interpreter/parser"
+)
+
+func TestOptimize(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		// folding
+		{"-5 * (10 + 2)", "-60"},
+		{"2 ** 3 ** 2 % 7", "1"},
+		{"7 / 2 - 1", "2"},
+		{"1 < 2 == true", "true"},
+		{"!true", "false"},
+		{"!!true", "true"},
+		{"!5", "false"},
+		{"1 == true", "false"},
+		{"false != 0", "true"},
+		{"9223372036854775807 + 1", "-9223372036854775808"},
+		{"a + 2 * 3", "(a + 6)"},
+
+		// runtime errors are preserved
+		{"1 / 0", "(1 / 0)"},
+		{"(2 + 3) % (1 - 1)", "(5 % 0)"},
+		{"2 ** -1", "(2 ** -1)"},
+		{"-true", "(-true)"},
+		{"true + 1", "(true + 1)"},
+		{"1 > false", "(1 > false)"},
+
+		// identities
+		{"(a - b) * 1", "(a - b)"},
+		{"((a * b) + (c - d)) * 1", "((a * b) + (c - d))"},
+		{"1 * -a", "(-a)"},
+		{"(a * b) + 0", "(a * b)"},
+		{"0 + (a - b)", "(a - b)"},
+		{"(a % b) - 0", "(a % b)"},
+		{"(a / b) / 1", "(a / b)"},
+		{"(a ** b) ** (3 - 2)", "(a ** b)"},
+		{"a * 1", "(a * 1)"},
+		{"a + 0", "(a + 0)"},
+		{"0 - a", "(0 - a)"},
+		{"f() * 0", "(f() * 0)"},
+
+		// + also concatenates strings, which are not integers
+		{"(a + b) * 1", "((a + b) * 1)"},
+		{"(s + s) * 1", "((s + s) * 1)"},
+		{"(s + 1) + 0", "((s + 1) + 0)"},
+		{"0 + (a + \"b\")", "(0 + (a + \"b\"))"},
+		{"\"a\" * 1", "(\"a\" * 1)"},
+
+		// double negation
+		{"!!(a < b)", "(a < b)"},
+		{"!!!a", "(!a)"},
+		{"!!a", "(!(!a))"},
+		{"if (!!a) { 1 }", "ifa 1"},
+		{"if (!(!(!!a))) { 1 } else { !!b }", "ifa 1else (!(!b))"},
+
+		// nested nodes
+		{"let x = 1 + 1;", "let x = 2;"},
+		{"let [a = 1 + 1, {b = 2 * 3}] = [1 + 1];", "let [a = 2, {b = 6}] = [2];"},
+		{"fn() { return 2 * 3; }", "fn() return 6;"},
+		{"f(1 + 1, !false)", "f(2, true)"},
+		{"x = 2 * 3", "x = 6"},
+		{"xs[1 + 1] += 2 * 3", "(xs[2]) += 6"},
+		{"while (!!a) { x = 1 + 1; }", "whilea x = 2"},
+		{"for (let i = 0 + 1; !!(i < 2); i = i + 0 * 1) { }", "for (let i = 1; (i < 2); i = (i + 0)) "},
+		{"if (1 > 2) { 3 * 3 }", "iffalse 9"},
+		{"[1 + 1, {2 * 2: !true}][0 * 1]", "([2, {4: false}][0])"},
+		{"for (x in 0..2 * 5 step 1 + 1) { x * 1 }", "for (x in (0..10 step 2)) (x * 1)"},
+		{"match (1 + 1) { -1 => 2 * 2, [a = 0 - 0] if !!a => a * 1, _ => !true }", "match (2) { -1 => 4, [a = 0] if a => (a * 1), _ => false }"},
+		{"fn(x, y = 2 * 5, ...r) { x }(...[1 + 1], 0 + 3)", "fn(x, y = 10, ...r) x(...[2], 3)"},
+	}
+
+	for _, tt := range tests {
+		program := New().Optimize(parse(t, tt.input))
+
+		if actual := program.String(); actual != tt.expected {
+			t.Errorf("wrong result for %q. expected=%q, got=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestOptimizePositions(t *testing.T) {
+	program := New().Optimize(parse(t, "let a =\n  (2 + 3) * 4;"))
+
+	literal, ok := program.Statements[0].(*ast.LetStatement).Value.(*ast.IntegerLiteral)
+	if !ok {
+		t.Fatalf("value is not IntegerLiteral. got=%T", program.Statements[0].(*ast.LetStatement).Value)
+	}
+	if literal.Token.Line != 2 || literal.Token.Column != 4 {
+		t.Errorf("wrong position. expected=2:4, got=%d:%d", literal.Token.Line, literal.Token.Column)
+	}
+}
+
+func TestChanges(t *testing.T) {
+	input := `let a = -5 * (10 + 2);
+if (!!a) { a * 1 }
+f(1 + 2, 3 * 4, 1 / 0)`
+
+	o := New()
+	o.Debug = true
+	o.Optimize(parse(t, input))
+
+	expected := []string{
+		"1:9: ((-5) * (10 + 2)) => -60",
+		"2:5: (!(!a)) => a",
+		"3:3: (1 + 2) => 3",
+		"3:10: (3 * 4) => 12",
+	}
+
+	changes := o.Changes()
+	if len(changes) != len(expected) {
+		t.Fatalf("wrong number of changes. expected=%d, got=%d (%v)", len(expected), len(changes), changes)
+	}
+	for i, change := range changes {
+		if change.String() != expected[i] {
+			t.Errorf("wrong change %d. expected=%q, got=%q", i, expected[i], change)
+		}
+	}
+
+	o = New()
+	o.Optimize(parse(t, input))
+	if len(o.Changes()) != 0 {
+		t.Errorf("expected no changes to be recorded without Debug. got=%v", o.Changes())
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
@@ -0,0 +1,143 @@
+package parser
+
+import (
+	"fmt"
+
+	"interpreter/ast"
+	"interpreter/lexer"
+	"interpreter/token"
+)
+
+type Associativity int
+
+const (
+	LeftAssoc Associativity = iota
+	RightAssoc
+)
+
+type (
+	// PrefixParseFn parses an expression starting at `p.CurToken()` and
+	// leaves the parser on its last token.
+	PrefixParseFn func(p *Parser) ast.Expression
+
+	// InfixParseFn parses the rest of an expression whose operator is
+	// `p.CurToken()`, given the already parsed left operand.
+	InfixParseFn func(p *Parser, left ast.Expression) ast.Expression
+)
+
+// Config extends the built-in grammar with host defined operators. A Config
+// can be shared by any number of parsers once it is set up.
+type Config struct {
+	operators      map[string]token.TokenType
+	prefixParseFns map[token.TokenType]PrefixParseFn
+	infixParseFns  map[token.TokenType]InfixParseFn
+	precedences    map[token.TokenType]int
+	associativity  map[token.TokenType]Associativity
+}
+
+func NewConfig() *Config {
+	return &Config{
+		operators:      make(map[string]token.TokenType),
+		prefixParseFns: make(map[token.TokenType]PrefixParseFn),
+		infixParseFns:  make(map[token.TokenType]InfixParseFn),
+		precedences:    make(map[token.TokenType]int),
+		associativity:  make(map[token.TokenType]Associativity),
+	}
+}
+
+// RegisterOperator makes the lexer produce a token of type t for literal,
+// which must be made of symbols, e.g. "**" or "|>".
+func (c *Config) RegisterOperator(literal string, t token.TokenType) {
+	c.operators[literal] = t
+}
+
+// RegisterPrefix sets the parse function for expressions starting with a
+// token of type t. A nil fn parses an ast.PrefixExpression.
+func (c *Config) RegisterPrefix(t token.TokenType, fn PrefixParseFn) {
+	c.prefixParseFns[t] = fn
+}
+
+// RegisterInfix makes tokens of type t infix operators binding with the
+// given precedence, one of the precedence constants such as SUM or PRODUCT.
+// A nil fn parses an ast.InfixExpression.
+func (c *Config) RegisterInfix(t token.TokenType, precedence int, assoc Associativity, fn InfixParseFn) {
+	c.infixParseFns[t] = fn
+	c.precedences[t] = precedence
+	c.associativity[t] = assoc
+}
+
+// New returns a parser for the tokens of l, using the operators of c in
+// addition to the built-in ones.
+func (c *Config) New(l *lexer.Lexer) *Parser {
+	return newParser(l, c)
+}
+
+func (c *Config) apply(p *Parser) {
+	for literal, t := range c.operators {
+		p.l.AddOperator(literal, t)
+	}
+
+	for t, fn := range c.prefixParseFns {
+		if fn == nil {
+			p.registerPrefix(t, p.parsePrefixExpression)
+			continue
+		}
+		fn := fn
+		p.registerPrefix(t, func() ast.Expression { return fn(p) })
+	}
+
+	for t, fn := range c.infixParseFns {
+		if fn == nil {
+			p.registerInfix(t, p.parseInfixExpression)
+		} else {
+			fn := fn
+			p.registerInfix(t, func(left ast.Expression) ast.Expression { return fn(p, left) })
+		}
+		p.precedences[t] = c.precedences[t]
+		p.associativity[t] = c.associativity[t]
+	}
+}
+
+// The methods below are meant for custom parse functions.
+
+func (p *Parser) CurToken() token.Token {
+	return p.curToken
+}
+
+func (p *Parser) PeekToken() token.Token {
+	return p.peekToken
+}
+
+func (p *Parser) NextToken() {
+	p.nextToken()
+}
+
+// ExpectPeek advances to the next token if it is of type t, and records an
+// error otherwise.
+func (p *Parser) ExpectPeek(t token.TokenType) bool {
+	return p.expectPeek(t)
+}
+
+// ParseExpression parses an expression starting at the current token,
+// consuming infix operators that bind tighter than precedence.
+func (p *Parser) ParseExpression(precedence int) ast.Expression {
+	return p.parseExpression(precedence)
+}
+
+// ParseOperand advances past the infix operator in the current token and
+// parses its right operand according to the operator's precedence and
+// associativity.
+func (p *Parser) ParseOperand() ast.Expression {
+	precedence := p.curPrecedence()
+	if p.associativity[p.curToken.Type] == RightAssoc {
+		// let the operand absorb operators of the same precedence
+		precedence--
+	}
+
+	p.nextToken()
+	return p.parseExpression(precedence)
+}
+
+func (p *Parser) Errorf(format string, args ...interface{}) {
+	p.errors = append(p.errors, fmt.Sprintf(format, args...))
+}
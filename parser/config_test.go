@@ -0,0 +1,162 @@
+package parser
+
+import (
+	"fmt"
+	"testing"
+
+	"interpreter/ast"
+	"interpreter/lexer"
+	"interpreter/token"
+)
+
+const (
+	CARET     = "^"
+	SPACESHIP = "<=>"
+	QUESTION  = "?"
+	COLON     = ":"
+	HASH      = "#"
+)
+
+// A host defined node for `cond ? a : b`.
+type conditional struct {
+	ast.ExpressionNode
+	Token       token.Token
+	Condition   ast.Expression
+	Consequence ast.Expression
+	Alternative ast.Expression
+}
+
+func (c *conditional) TokenLiteral() string { return c.Token.Literal }
+func (c *conditional) String() string {
+	return fmt.Sprintf("(%s ? %s : %s)", c.Condition, c.Consequence, c.Alternative)
+}
+
+// A host defined node for `#name`.
+type reference struct {
+	ast.ExpressionNode
+	Token token.Token
+	Name  string
+}
+
+func (r *reference) TokenLiteral() string { return r.Token.Literal }
+func (r *reference) String() string       { return "#" + r.Name }
+
+func parseConditional(p *Parser, left ast.Expression) ast.Expression {
+	expr := &conditional{Token: p.CurToken(), Condition: left}
+
+	p.NextToken()
+	expr.Consequence = p.ParseExpression(LOWEST)
+
+	if !p.ExpectPeek(COLON) {
+		return nil
+	}
+
+	p.NextToken()
+	expr.Alternative = p.ParseExpression(LOWEST)
+
+	return expr
+}
+
+func parseReference(p *Parser) ast.Expression {
+	expr := &reference{Token: p.CurToken()}
+
+	if !p.ExpectPeek(token.IDENT) {
+		return nil
+	}
+	expr.Name = p.CurToken().Literal
+
+	return expr
+}
+
+func testConfig() *Config {
+	c := NewConfig()
+
+	c.RegisterOperator("^", CARET)
+	c.RegisterInfix(CARET, EXPONENT, RightAssoc, nil)
+
+	c.RegisterOperator("<=>", SPACESHIP)
+	c.RegisterInfix(SPACESHIP, LESSGREATER, LeftAssoc, nil)
+
+	c.RegisterOperator("?", QUESTION)
+	c.RegisterOperator(":", COLON)
+	c.RegisterInfix(QUESTION, EQUALS, RightAssoc, parseConditional)
+
+	c.RegisterOperator("#", HASH)
+	c.RegisterPrefix(HASH, parseReference)
+
+	return c
+}
+
+func TestConfigOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"2 ^ 3 ^ 2", "(2 ^ (3 ^ 2))"},
+		{"-2 ^ 2", "(-(2 ^ 2))"},
+		{"a * b ^ c", "(a * (b ^ c))"},
+		{"a <=> b <=> c", "((a <=> b) <=> c)"},
+		{"a + b <=> c < d", "(((a + b) <=> c) < d)"},
+		{"a == b ? c : d", "((a == b) ? c : d)"},
+		{"a ? b : c ? d : e", "(a ? b : (c ? d : e))"},
+		{"#x + 1", "(#x + 1)"},
+		{"a < b", "(a < b)"},
+	}
+
+	c := testConfig()
+
+	for _, tt := range tests {
+		p := c.New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		actual := program.String()
+		if actual != tt.expected {
+			t.Errorf("expected=%q, got=%q", tt.expected, actual)
+		}
+	}
+}
+
+func TestConfigCustomNodes(t *testing.T) {
+	p := testConfig().New(lexer.New("ready ? #yes : #no"))
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	cond, ok := stmt.Expression.(*conditional)
+	if !ok {
+		t.Fatalf("expected stmt.Expression to be a *conditional, got=%T", stmt.Expression)
+	}
+	if !testIdentifier(t, cond.Condition, "ready") {
+		return
+	}
+
+	ref, ok := cond.Alternative.(*reference)
+	if !ok {
+		t.Fatalf("expected cond.Alternative to be a *reference, got=%T", cond.Alternative)
+	}
+	if ref.Name != "no" {
+		t.Errorf("expected ref.Name to be 'no', got=%s", ref.Name)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	p := testConfig().New(lexer.New("a ? b"))
+	p.ParseProgram()
+
+	expected := []string{"expected next token to be :, got EOF instead"}
+	if fmt.Sprint(p.Errors()) != fmt.Sprint(expected) {
+		t.Errorf("expected errors %q, got=%q", expected, p.Errors())
+	}
+}
+
+func TestDefaultParserIgnoresConfig(t *testing.T) {
+	testConfig()
+
+	p := New(lexer.New("2 ^ 3"))
+	p.ParseProgram()
+
+	if len(p.Errors()) == 0 {
+		t.Errorf("expected the default parser to reject `^`")
+	}
+}
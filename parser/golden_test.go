@@ -0,0 +1,145 @@
+package parser
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"interpreter/ast"
+	"interpreter/lexer"
+	"interpreter/token"
+)
+
+// Regenerate the golden files with `go test ./parser -run TestGolden -update`.
+var update = flag.Bool("update", false, "update golden files in testdata")
+
+// TestGolden parses every `testdata/*.mk` file and compares the tokens, the
+// AST and the parser errors against the `.tokens`, `.ast` and `.errors`
+// files next to it.
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "*.mk"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no golden inputs in testdata")
+	}
+
+	for _, file := range files {
+		file := file
+		name := strings.TrimSuffix(filepath.Base(file), ".mk")
+
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			input := string(src)
+
+			p := New(lexer.New(input))
+			program := p.ParseProgram()
+
+			base := strings.TrimSuffix(file, ".mk")
+			checkGolden(t, base+".tokens", dumpTokens(input))
+			checkGolden(t, base+".ast", dumpAST(program))
+			checkGolden(t, base+".errors", dumpErrors(p.Errors()))
+		})
+	}
+}
+
+func checkGolden(t *testing.T, path string, actual string) {
+	t.Helper()
+
+	if *update {
+		if err := os.WriteFile(path, []byte(actual), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+
+	expected, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if string(expected) != actual {
+		t.Errorf("%s differs from the golden file\n--- expected\n%s\n--- got\n%s", path, expected, actual)
+	}
+}
+
+func dumpTokens(input string) string {
+	var out strings.Builder
+
+	l := lexer.New(input)
+	for {
+		tok := l.NextToken()
+		fmt.Fprintf(&out, "%d:%d %s %q\n", tok.Line, tok.Column, tok.Type, tok.Literal)
+		if tok.Type == token.EOF {
+			return out.String()
+		}
+	}
+}
+
+func dumpErrors(errors []string) string {
+	var out strings.Builder
+
+	for _, msg := range errors {
+		out.WriteString(msg + "\n")
+	}
+
+	return out.String()
+}
+
+// Prints the exported fields of every node, one per line and indented by
+// depth. Tokens are left out as they are covered by the `.tokens` file.
+func dumpAST(node ast.Node) string {
+	var out strings.Builder
+	dumpValue(&out, reflect.ValueOf(node), "")
+	return out.String()
+}
+
+var tokenType = reflect.TypeOf(token.Token{})
+
+func dumpValue(out *strings.Builder, v reflect.Value, indent string) {
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		if v.IsNil() {
+			out.WriteString("nil\n")
+			return
+		}
+		if v.Kind() == reflect.Interface {
+			dumpValue(out, v.Elem(), indent)
+			return
+		}
+		out.WriteString(v.Type().String() + "\n")
+		dumpFields(out, v.Elem(), indent+"  ")
+	case reflect.Slice:
+		out.WriteString(fmt.Sprintf("[%d]\n", v.Len()))
+		for i := 0; i < v.Len(); i++ {
+			out.WriteString(indent + "  - ")
+			dumpValue(out, v.Index(i), indent+"    ")
+		}
+	case reflect.String:
+		out.WriteString(fmt.Sprintf("%q\n", v.String()))
+	default:
+		out.WriteString(fmt.Sprintf("%v\n", v.Interface()))
+	}
+}
+
+func dumpFields(out *strings.Builder, v reflect.Value, indent string) {
+	if v.Kind() != reflect.Struct {
+		return
+	}
+
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Type().Field(i)
+		if field.PkgPath != "" || field.Type == tokenType {
+			continue
+		}
+		out.WriteString(indent + field.Name + ": ")
+		dumpValue(out, v.Field(i), indent)
+	}
+}
@@ -3,8 +3,10 @@ package parser
 import (
 	"fmt"
 	"strconv"
+	"strings"
 
 	"interpreter/ast"
+	"interpreter/cst"
 	"interpreter/lexer"
 	"interpreter/token"
 )
@@ -13,15 +15,25 @@ import (
 const (
 	_ = iota
 	LOWEST
+	ASSIGN      // = or +=
 	EQUALS      // ==
 	LESSGREATER // > or <
+	PIPELINE    // xs |> f()
+	RANGE       // 0..10
 	SUM         // +
 	PRODUCT     // *
 	PREFIX      // -X or !X
+	EXPONENT    // **
 	CALL        // myFunction(X)
+	INDEX       // array[index]
 )
 
+// Expressions, patterns and blocks nested deeper than this are rejected, so
+// that hostile input cannot exhaust the stack of the recursive descent.
+const maxDepth = 1000
+
 var tokenPrecedences = map[token.TokenType]int{
+	token.ASSIGN:   ASSIGN,
 	token.EQ:       EQUALS,
 	token.NOT_EQ:   EQUALS,
 	token.LT:       LESSGREATER,
@@ -30,6 +42,20 @@ var tokenPrecedences = map[token.TokenType]int{
 	token.MINUS:    SUM,
 	token.ASTERISK: PRODUCT,
 	token.SLASH:    PRODUCT,
+	token.PERCENT:  PRODUCT,
+	token.POWER:    EXPONENT,
+	token.LPAREN:   CALL,
+	token.LBRACKET: INDEX,
+
+	token.RANGE:           RANGE,
+	token.RANGE_INCLUSIVE: RANGE,
+	token.PIPELINE:        PIPELINE,
+
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.PERCENT_ASSIGN:  ASSIGN,
 }
 
 type (
@@ -38,26 +64,61 @@ type (
 )
 
 type Parser struct {
-	l      *lexer.Lexer
-	errors []string
+	l        *lexer.Lexer
+	errors   []string
+	warnings []string
 
 	curToken  token.Token
 	peekToken token.Token
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
+	precedences    map[token.TokenType]int
+	associativity  map[token.TokenType]Associativity
+
+	// tokens consumed so far, only kept by `ParseFile`
+	tokens []token.Token
+
+	// nesting level of expressions and patterns
+	depth int
+
+	// nesting level of blocks, counted apart so that the statements of a
+	// block too deep are skipped rather than failing one by one
+	blocks int
+
+	// loops enclosing the current statement within its function
+	loops int
+
+	// whether the pattern being parsed is the pattern of a match arm, which
+	// may contain literals and wildcards
+	matching bool
+
+	// whether `=>` ends the expression being parsed, the guard of a match
+	// arm, rather than starting an arrow function
+	guarding bool
 }
 
 func New(l *lexer.Lexer) *Parser {
+	return newParser(l, nil)
+}
+
+func newParser(l *lexer.Lexer, c *Config) *Parser {
 	p := &Parser{l: l}
 
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.BANG, p.parsePrefixExpression)
 	p.registerPrefix(token.MINUS, p.parsePrefixExpression)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
+	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
+	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
+	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
+	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.MATCH, p.parseMatchExpression)
 
 	p.infixParseFns = make(map[token.TokenType]infixParseFn)
 	p.registerInfix(token.EQ, p.parseInfixExpression)
@@ -68,6 +129,35 @@ func New(l *lexer.Lexer) *Parser {
 	p.registerInfix(token.MINUS, p.parseInfixExpression)
 	p.registerInfix(token.ASTERISK, p.parseInfixExpression)
 	p.registerInfix(token.SLASH, p.parseInfixExpression)
+	p.registerInfix(token.PERCENT, p.parseInfixExpression)
+	p.registerInfix(token.POWER, p.parseInfixExpression)
+	p.registerInfix(token.LPAREN, p.parseCallExpression)
+	p.registerInfix(token.ASSIGN, p.parseAssignExpression)
+	for t := range compoundAssignments {
+		p.registerInfix(t, p.parseAssignExpression)
+	}
+	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
+	p.registerInfix(token.RANGE, p.parseRangeExpression)
+	p.registerInfix(token.RANGE_INCLUSIVE, p.parseRangeExpression)
+	p.registerInfix(token.PIPELINE, p.parsePipelineExpression)
+
+	p.precedences = make(map[token.TokenType]int)
+	for t, precedence := range tokenPrecedences {
+		p.precedences[t] = precedence
+	}
+	p.associativity = map[token.TokenType]Associativity{
+		// 2 ** 3 ** 2 == 2 ** (3 ** 2)
+		token.POWER: RightAssoc,
+		// a = b = c is a = (b = c)
+		token.ASSIGN: RightAssoc,
+	}
+	for t := range compoundAssignments {
+		p.associativity[t] = RightAssoc
+	}
+
+	if c != nil {
+		c.apply(p)
+	}
 
 	// read twice to set both `curToken` and `peekToken`
 	p.nextToken()
@@ -80,7 +170,16 @@ func (p *Parser) Errors() []string {
 	return p.errors
 }
 
+// Warnings returns the problems found in programs that parse but are likely
+// wrong, such as a match without a wildcard arm.
+func (p *Parser) Warnings() []string {
+	return p.warnings
+}
+
 func (p *Parser) nextToken() {
+	if p.tokens != nil && p.curToken.Type != token.EOF {
+		p.tokens = append(p.tokens, p.peekToken)
+	}
 	p.curToken = p.peekToken
 	p.peekToken = p.l.NextToken()
 }
@@ -99,30 +198,108 @@ func (p *Parser) ParseProgram() *ast.Program {
 	return program
 }
 
+// ParseFile parses the program like `ParseProgram` but also groups the
+// consumed tokens by statement. With a lexer from `lexer.NewLossless` the
+// resulting file prints back to the exact input.
+func (p *Parser) ParseFile() *cst.File {
+	file := &cst.File{}
+	p.tokens = []token.Token{p.curToken}
+	start := 0
+
+	for p.curToken.Type != token.EOF {
+		stmt := p.parseStatement()
+
+		// the statement ends at `curToken`, unless it ran into the end of
+		// the input, which belongs to the file
+		end := len(p.tokens)
+		if p.curTokenIs(token.EOF) {
+			end--
+		}
+		file.Statements = append(file.Statements, &cst.Statement{
+			Tokens: p.tokens[start:end:end],
+			Node:   stmt,
+		})
+		start = end
+
+		p.nextToken()
+	}
+
+	// `curToken` may be a repeated EOF by now, the first one has the trivia
+	file.EOF = p.tokens[len(p.tokens)-1]
+	p.tokens = nil
+
+	return file
+}
+
 func (p *Parser) parseStatement() ast.Statement {
+	// avoid wrapping a nil *ast.LetStatement or *ast.ReturnStatement in a
+	// non-nil ast.Statement
 	switch p.curToken.Type {
-	case token.LET:
-		return p.parseLetStatement()
+	case token.LET, token.CONST:
+		if stmt := p.parseLetStatement(); stmt != nil {
+			return stmt
+		}
 	case token.RETURN:
-		return p.parseReturnStatement()
+		if stmt := p.parseReturnStatement(); stmt != nil {
+			return stmt
+		}
+	case token.WHILE:
+		if stmt := p.parseWhileStatement(); stmt != nil {
+			return stmt
+		}
+	case token.FOR:
+		if stmt := p.parseForStatement(); stmt != nil {
+			return stmt
+		}
+	case token.BREAK:
+		if stmt := p.parseBreakStatement(); stmt != nil {
+			return stmt
+		}
+	case token.CONTINUE:
+		if stmt := p.parseContinueStatement(); stmt != nil {
+			return stmt
+		}
 	default:
 		return p.parseExpressionStatement()
 	}
+	return nil
 }
 
 func (p *Parser) parseLetStatement() *ast.LetStatement {
-	stmt := &ast.LetStatement{
-		Token: p.curToken,
+	stmt := p.parseLet()
+	if stmt == nil {
+		return nil
 	}
 
-	// next token should be an identifier
-	if !p.expectPeek(token.IDENT) {
-		return nil
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
 	}
 
-	stmt.Name = &ast.Identifier{
+	return stmt
+}
+
+// Parses a let up to its value, leaving the semicolon to the caller.
+func (p *Parser) parseLet() *ast.LetStatement {
+	stmt := &ast.LetStatement{
 		Token: p.curToken,
-		Value: p.curToken.Literal,
+		Const: p.curTokenIs(token.CONST),
+	}
+
+	// next token should be an identifier or start a pattern
+	if p.peekTokenIs(token.LBRACKET) || p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		stmt.Pattern = p.parsePattern()
+		if stmt.Pattern == nil {
+			return nil
+		}
+	} else {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Name = &ast.Identifier{
+			Token: p.curToken,
+			Value: p.curToken.Literal,
+		}
 	}
 
 	// next token should be '='
@@ -130,120 +307,1137 @@ func (p *Parser) parseLetStatement() *ast.LetStatement {
 		return nil
 	}
 
-	// skipping expression until encountering a semicolon
-	for !p.curTokenIs(token.SEMICOLON) {
-		p.nextToken()
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok && stmt.Name != nil {
+		fl.Name = stmt.Name.Value
 	}
 
 	return stmt
 }
 
+// Parses the identifier, array pattern or hash pattern starting at
+// `curToken`, leaving `curToken` on its last token. Literals and wildcards
+// are also accepted in the arms of a match.
+func (p *Parser) parsePattern() ast.Pattern {
+	defer func() { p.depth-- }()
+	if p.nestTooDeep("pattern") {
+		return nil
+	}
+
+	if p.matching {
+		switch p.curToken.Type {
+		case token.INT, token.STRING, token.TRUE, token.FALSE, token.MINUS:
+			return p.parseLiteralPattern()
+		case token.IDENT:
+			if p.curToken.Literal == "_" {
+				return &ast.WildcardPattern{Token: p.curToken}
+			}
+		}
+	}
+
+	switch p.curToken.Type {
+	case token.IDENT:
+		return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	case token.LBRACKET:
+		return p.parseArrayPattern()
+	case token.LBRACE:
+		return p.parseHashPattern()
+	}
+
+	msg := fmt.Sprintf("%d:%d: expected a pattern, got %s", p.curToken.Line, p.curToken.Column, p.curToken.Type)
+	p.errors = append(p.errors, msg)
+	return nil
+}
+
+// Parses an element of a pattern, which may have a default value.
+func (p *Parser) parsePatternElement() ast.Pattern {
+	target := p.parsePattern()
+	if target == nil {
+		return nil
+	}
+	return p.parseDefault(target)
+}
+
+// Parses the default value of target if there is one.
+func (p *Parser) parseDefault(target ast.Pattern) ast.Pattern {
+	if !p.peekTokenIs(token.ASSIGN) {
+		return target
+	}
+	p.nextToken()
+
+	pattern := &ast.DefaultPattern{Token: p.curToken, Target: target}
+	p.nextToken()
+	matching := p.matching
+	p.matching = false
+	pattern.Default = p.parseExpression(LOWEST)
+	p.matching = matching
+	if pattern.Default == nil {
+		return nil
+	}
+	return pattern
+}
+
+func (p *Parser) parseLiteralPattern() ast.Pattern {
+	pattern := &ast.LiteralPattern{Token: p.curToken}
+
+	switch p.curToken.Type {
+	case token.INT:
+		pattern.Value = p.parseIntegerLiteral()
+	case token.STRING:
+		pattern.Value = p.parseStringLiteral()
+	case token.TRUE, token.FALSE:
+		pattern.Value = p.parseBoolean()
+	case token.MINUS:
+		// only negative integers, `-x` is not a literal
+		expr := &ast.PrefixExpression{Token: p.curToken, Operator: p.curToken.Literal}
+		if !p.expectPeek(token.INT) {
+			return nil
+		}
+		expr.Right = p.parseIntegerLiteral()
+		if expr.Right == nil {
+			return nil
+		}
+		pattern.Value = expr
+	}
+	if pattern.Value == nil {
+		return nil
+	}
+
+	return pattern
+}
+
+func (p *Parser) parseArrayPattern() ast.Pattern {
+	pattern := &ast.ArrayPattern{Token: p.curToken}
+
+	if p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		return pattern
+	}
+
+	for {
+		p.nextToken()
+
+		// the rest must be the last element
+		if p.curTokenIs(token.ELLIPSIS) {
+			if !p.expectPeek(token.IDENT) {
+				return nil
+			}
+			pattern.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			break
+		}
+
+		element := p.parsePatternElement()
+		if element == nil {
+			return nil
+		}
+		pattern.Elements = append(pattern.Elements, element)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return pattern
+}
+
+func (p *Parser) parseHashPattern() ast.Pattern {
+	pattern := &ast.HashPattern{Token: p.curToken}
+
+	if p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		return pattern
+	}
+
+	for {
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		pair := &ast.HashPatternPair{
+			Key: &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal},
+		}
+
+		if p.peekTokenIs(token.COLON) {
+			p.nextToken()
+			p.nextToken()
+			pair.Value = p.parsePatternElement()
+		} else {
+			// `{name}` binds the key to a variable of the same name
+			name := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			pair.Value = p.parseDefault(name)
+		}
+		if pair.Value == nil {
+			return nil
+		}
+		pattern.Pairs = append(pattern.Pairs, pair)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	return pattern
+}
+
 func (p *Parser) parseReturnStatement() *ast.ReturnStatement {
 	stmt := &ast.ReturnStatement{
 		Token: p.curToken,
 	}
 
 	p.nextToken()
+	stmt.ReturnValue = p.parseExpression(LOWEST)
 
-	// skipping expression until encountering a semicolon
-	for !p.curTokenIs(token.SEMICOLON) {
+	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
 	}
 
 	return stmt
 }
 
-func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
-	stmt := &ast.ExpressionStatement{
+func (p *Parser) parseWhileStatement() *ast.WhileStatement {
+	stmt := &ast.WhileStatement{
 		Token: p.curToken,
 	}
 
-	stmt.Expression = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
 
-	for p.peekTokenIs(token.SEMICOLON) {
-		p.nextToken()
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+	if stmt.Condition == nil {
+		return nil
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+	if stmt.Body == nil {
+		return nil
 	}
 
 	return stmt
 }
 
-func (p *Parser) parseExpression(precedence int) ast.Expression {
-	prefixFn := p.prefixParseFns[p.curToken.Type]
-	if prefixFn == nil {
-		p.noPrefixParseFnError(p.curToken.Type)
+func (p *Parser) parseForStatement() ast.Statement {
+	tok := p.curToken
+
+	if !p.expectPeek(token.LPAREN) {
 		return nil
 	}
-	leftExpr := prefixFn()
 
-	for !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
-		infixFn := p.infixParseFns[p.peekToken.Type]
-		if infixFn == nil {
-			return leftExpr
+	p.nextToken()
+
+	// `for (x in` and `for (k, v in` start a for-in loop, no expression
+	// starts with an identifier followed by a comma
+	if p.curTokenIs(token.IDENT) && (p.peekTokenIs(token.IN) || p.peekTokenIs(token.COMMA)) {
+		if stmt := p.parseForInStatement(tok); stmt != nil {
+			return stmt
 		}
+		return nil
+	}
 
-		p.nextToken()
+	if stmt := p.parseCStyleForStatement(tok); stmt != nil {
+		return stmt
+	}
+	return nil
+}
 
-		leftExpr = infixFn(leftExpr)
+// Parses the rest of `for (init; condition; update) { ... }` from the
+// token after the parenthesis. Each of init, condition and update may be
+// left out.
+func (p *Parser) parseCStyleForStatement(tok token.Token) *ast.ForStatement {
+	stmt := &ast.ForStatement{
+		Token: tok,
 	}
 
-	return leftExpr
-}
+	if !p.curTokenIs(token.SEMICOLON) {
+		stmt.Init = p.parseForClause()
+		if stmt.Init == nil || !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
+	}
 
-func (p *Parser) parseIdentifier() ast.Expression {
-	return &ast.Identifier{
-		Token: p.curToken,
-		Value: p.curToken.Literal,
+	p.nextToken()
+	if !p.curTokenIs(token.SEMICOLON) {
+		stmt.Condition = p.parseExpression(LOWEST)
+		if stmt.Condition == nil || !p.expectPeek(token.SEMICOLON) {
+			return nil
+		}
 	}
-}
 
-func (p *Parser) parseIntegerLiteral() ast.Expression {
-	lit := &ast.IntegerLiteral{
-		Token: p.curToken,
+	p.nextToken()
+	if !p.curTokenIs(token.RPAREN) {
+		stmt.Update = p.parseForClause()
+		if stmt.Update == nil || !p.expectPeek(token.RPAREN) {
+			return nil
+		}
 	}
 
-	val, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
-	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
+	if !p.expectPeek(token.LBRACE) {
 		return nil
 	}
-	lit.Value = val
 
-	return lit
+	stmt.Body = p.parseLoopBody()
+	if stmt.Body == nil {
+		return nil
+	}
+
+	return stmt
 }
 
-func (p *Parser) parsePrefixExpression() ast.Expression {
-	expr := &ast.PrefixExpression{
-		Token:    p.curToken,
-		Operator: p.curToken.Literal,
+// Parses the rest of `for (k, v in iterable) { ... }` from the first
+// variable.
+func (p *Parser) parseForInStatement(tok token.Token) *ast.ForInStatement {
+	stmt := &ast.ForInStatement{
+		Token: tok,
+	}
+
+	stmt.Variables = append(stmt.Variables, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+	if p.peekTokenIs(token.COMMA) {
+		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
+		stmt.Variables = append(stmt.Variables, &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal})
+	}
+
+	if !p.expectPeek(token.IN) {
+		return nil
 	}
 
 	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+	if stmt.Iterable == nil {
+		return nil
+	}
 
-	expr.Right = p.parseExpression(PREFIX)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
 
-	return expr
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	stmt.Body = p.parseLoopBody()
+	if stmt.Body == nil {
+		return nil
+	}
+
+	return stmt
 }
 
-func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
-	expr := &ast.InfixExpression{
-		Token:    p.curToken,
-		Left:     left,
-		Operator: p.curToken.Literal,
+// Parses the init or update of a for loop, a let or an expression, leaving
+// `curToken` on its last token.
+func (p *Parser) parseForClause() ast.Statement {
+	if p.curTokenIs(token.LET) || p.curTokenIs(token.CONST) {
+		if stmt := p.parseLet(); stmt != nil {
+			return stmt
+		}
+		return nil
 	}
 
-	precedence := p.curPrecedence()
-	p.nextToken()
-	expr.Right = p.parseExpression(precedence)
+	stmt := &ast.ExpressionStatement{
+		Token: p.curToken,
+	}
+	stmt.Expression = p.parseExpression(LOWEST)
+	if stmt.Expression == nil {
+		return nil
+	}
 
-	return expr
+	return stmt
 }
 
-func (p *Parser) parseBoolean() ast.Expression {
-	return &ast.Boolean{
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loops++
+	defer func() { p.loops-- }()
+
+	return p.parseBlockStatement()
+}
+
+func (p *Parser) parseBreakStatement() *ast.BreakStatement {
+	stmt := &ast.BreakStatement{
+		Token: p.curToken,
+	}
+
+	if p.loops == 0 {
+		msg := fmt.Sprintf("%d:%d: break outside of a loop", p.curToken.Line, p.curToken.Column)
+		p.errors = append(p.errors, msg)
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseContinueStatement() *ast.ContinueStatement {
+	stmt := &ast.ContinueStatement{
 		Token: p.curToken,
-		Value: p.curTokenIs(token.TRUE),
 	}
+
+	if p.loops == 0 {
+		msg := fmt.Sprintf("%d:%d: continue outside of a loop", p.curToken.Line, p.curToken.Column)
+		p.errors = append(p.errors, msg)
+	}
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+func (p *Parser) parseExpressionStatement() *ast.ExpressionStatement {
+	stmt := &ast.ExpressionStatement{
+		Token: p.curToken,
+	}
+
+	stmt.Expression = p.parseExpression(LOWEST)
+
+	for p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+
+	return stmt
+}
+
+// Enters a level of nesting, which the caller leaves by decrementing
+// p.depth. Reports whether the input is nested too deeply, recording an
+// error for the construct if so.
+func (p *Parser) nestTooDeep(construct string) bool {
+	p.depth++
+	if p.depth <= maxDepth {
+		return false
+	}
+	msg := fmt.Sprintf("%s nested deeper than %d levels", construct, maxDepth)
+	p.errors = append(p.errors, msg)
+	return true
+}
+
+func (p *Parser) parseExpression(precedence int) ast.Expression {
+	defer func() { p.depth-- }()
+	if p.nestTooDeep("expression") {
+		return nil
+	}
+
+	prefixFn := p.prefixParseFns[p.curToken.Type]
+	if prefixFn == nil {
+		p.noPrefixParseFnError(p.curToken.Type)
+		return nil
+	}
+	leftExpr := prefixFn()
+
+	for leftExpr != nil && !p.peekTokenIs(token.SEMICOLON) && precedence < p.peekPrecedence() {
+		infixFn := p.infixParseFns[p.peekToken.Type]
+		if infixFn == nil {
+			return leftExpr
+		}
+
+		p.nextToken()
+
+		leftExpr = infixFn(leftExpr)
+	}
+
+	return leftExpr
+}
+
+func (p *Parser) parseIdentifier() ast.Expression {
+	ident := &ast.Identifier{
+		Token: p.curToken,
+		Value: p.curToken.Literal,
+	}
+
+	if p.peekTokenIs(token.ARROW) && !p.guarding {
+		return p.parseArrowFunction(ident.Token, []ast.Expression{ident})
+	}
+	return ident
+}
+
+func (p *Parser) parseIntegerLiteral() ast.Expression {
+	lit := &ast.IntegerLiteral{
+		Token: p.curToken,
+	}
+
+	val, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
+	if err != nil {
+		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+	lit.Value = val
+
+	return lit
+}
+
+func (p *Parser) parseStringLiteral() ast.Expression {
+	value, err := unquote(p.curToken.Literal)
+	if err != nil {
+		p.errors = append(p.errors, err.Error())
+		return nil
+	}
+
+	return &ast.StringLiteral{Token: p.curToken, Value: value}
+}
+
+// Decodes the escape sequences of a quoted string literal.
+func unquote(literal string) (string, error) {
+	body := literal[1 : len(literal)-1]
+
+	var out strings.Builder
+	for i := 0; i < len(body); i++ {
+		if body[i] != '\\' {
+			out.WriteByte(body[i])
+			continue
+		}
+
+		i++
+		switch body[i] {
+		case 'n':
+			out.WriteByte('\n')
+		case 't':
+			out.WriteByte('\t')
+		case '"', '\\':
+			out.WriteByte(body[i])
+		default:
+			return "", fmt.Errorf("invalid escape sequence \\%c in %s", body[i], literal)
+		}
+	}
+	return out.String(), nil
+}
+
+func (p *Parser) parsePrefixExpression() ast.Expression {
+	expr := &ast.PrefixExpression{
+		Token:    p.curToken,
+		Operator: p.curToken.Literal,
+	}
+
+	p.nextToken()
+
+	expr.Right = p.parseExpression(PREFIX)
+	if expr.Right == nil {
+		return nil
+	}
+
+	return expr
+}
+
+func (p *Parser) parseInfixExpression(left ast.Expression) ast.Expression {
+	expr := &ast.InfixExpression{
+		Token:    p.curToken,
+		Left:     left,
+		Operator: p.curToken.Literal,
+	}
+
+	expr.Right = p.ParseOperand()
+	if expr.Right == nil {
+		return nil
+	}
+
+	return expr
+}
+
+// compoundAssignments maps the compound assignments to the operators they
+// apply.
+var compoundAssignments = map[token.TokenType]string{
+	token.PLUS_ASSIGN:     "+",
+	token.MINUS_ASSIGN:    "-",
+	token.ASTERISK_ASSIGN: "*",
+	token.SLASH_ASSIGN:    "/",
+	token.PERCENT_ASSIGN:  "%",
+}
+
+func (p *Parser) parseAssignExpression(target ast.Expression) ast.Expression {
+	expr := &ast.AssignExpression{
+		Token:    p.curToken,
+		Target:   target,
+		Operator: compoundAssignments[p.curToken.Type],
+	}
+
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		msg := fmt.Sprintf("%d:%d: cannot assign to %s", p.curToken.Line, p.curToken.Column, target)
+		p.errors = append(p.errors, msg)
+		return nil
+	}
+
+	expr.Value = p.ParseOperand()
+	if expr.Value == nil {
+		return nil
+	}
+
+	return expr
+}
+
+func (p *Parser) parseBoolean() ast.Expression {
+	return &ast.Boolean{
+		Token: p.curToken,
+		Value: p.curTokenIs(token.TRUE),
+	}
+}
+
+// Parses a parenthesized expression, or the parameters of an arrow
+// function when there are several, none or a rest one, or `=>` follows.
+func (p *Parser) parseGroupedExpression() ast.Expression {
+	start := p.curToken
+	list, ok := p.parseExpressionList(token.RPAREN, true)
+	if !ok {
+		return nil
+	}
+
+	arrow := p.peekTokenIs(token.ARROW) && !p.guarding
+	if len(list) == 1 && !arrow {
+		if _, ok := list[0].(*ast.SpreadExpression); !ok {
+			return list[0]
+		}
+	}
+	return p.parseArrowFunction(start, list)
+}
+
+// Parses an arrow function `params => body` from its parsed parameters,
+// lowering it to the function literal `fn(params) { body }`. A body starting
+// with `{` is a block, like that of a function literal: a hash literal
+// body must be parenthesized.
+func (p *Parser) parseArrowFunction(start token.Token, params []ast.Expression) ast.Expression {
+	lit := &ast.FunctionLiteral{
+		Token:      token.Token{Type: token.FUNCTION, Literal: "fn", Line: start.Line, Column: start.Column},
+		Parameters: []*ast.Identifier{},
+	}
+
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+	arrow := p.curToken
+	p.nextToken()
+	if p.curTokenIs(token.LBRACE) {
+		lit.Body = p.parseBlockStatement()
+		if lit.Body == nil {
+			return nil
+		}
+	} else {
+		body := p.parseExpression(LOWEST)
+		if body == nil {
+			return nil
+		}
+		lit.Body = &ast.BlockStatement{
+			Token:      arrow,
+			Statements: []ast.Statement{&ast.ExpressionStatement{Token: arrow, Expression: body}},
+		}
+	}
+
+	defaults := []ast.Expression{}
+	hasDefaults := false
+	for i, param := range params {
+		var name, value ast.Expression = param, nil
+		if assign, ok := param.(*ast.AssignExpression); ok && assign.Operator == "" {
+			name, value = assign.Target, assign.Value
+		}
+		if spread, ok := param.(*ast.SpreadExpression); ok && i == len(params)-1 {
+			if rest, ok := spread.Value.(*ast.Identifier); ok {
+				lit.Rest = rest
+				break
+			}
+		}
+
+		ident, ok := name.(*ast.Identifier)
+		if !ok {
+			msg := fmt.Sprintf("%d:%d: expected a parameter, got %s", start.Line, start.Column, param.String())
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+		if value != nil {
+			hasDefaults = true
+		} else if hasDefaults {
+			msg := fmt.Sprintf("%d:%d: parameter %s without a default follows one with a default",
+				ident.Token.Line, ident.Token.Column, ident.Value)
+			p.errors = append(p.errors, msg)
+			return nil
+		}
+		lit.Parameters = append(lit.Parameters, ident)
+		defaults = append(defaults, value)
+	}
+	if hasDefaults {
+		lit.Defaults = defaults
+	}
+
+	return lit
+}
+
+func (p *Parser) parseIfExpression() ast.Expression {
+	expr := &ast.IfExpression{
+		Token: p.curToken,
+	}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	expr.Condition = p.parseExpression(LOWEST)
+	if expr.Condition == nil {
+		return nil
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	expr.Consequence = p.parseBlockStatement()
+	if expr.Consequence == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.ELSE) {
+		p.nextToken()
+
+		if !p.expectPeek(token.LBRACE) {
+			return nil
+		}
+
+		expr.Alternative = p.parseBlockStatement()
+		if expr.Alternative == nil {
+			return nil
+		}
+	}
+
+	return expr
+}
+
+func (p *Parser) parseMatchExpression() ast.Expression {
+	expr := &ast.MatchExpression{
+		Token: p.curToken,
+	}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	p.nextToken()
+	expr.Value = p.parseExpression(LOWEST)
+	if expr.Value == nil {
+		return nil
+	}
+
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	// arms are separated by commas, with an optional trailing one
+	for !p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		arm := p.parseMatchArm()
+		if arm == nil {
+			return nil
+		}
+		expr.Arms = append(expr.Arms, arm)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	if !hasWildcardArm(expr) {
+		msg := fmt.Sprintf("%d:%d: match has no wildcard arm, unmatched values give null", expr.Token.Line, expr.Token.Column)
+		p.warnings = append(p.warnings, msg)
+	}
+
+	return expr
+}
+
+func (p *Parser) parseMatchArm() *ast.MatchArm {
+	p.matching = true
+	pattern := p.parsePattern()
+	p.matching = false
+	if pattern == nil {
+		return nil
+	}
+	arm := &ast.MatchArm{Pattern: pattern}
+
+	if p.peekTokenIs(token.IF) {
+		p.nextToken()
+		p.nextToken()
+		p.guarding = true
+		arm.Guard = p.parseExpression(LOWEST)
+		p.guarding = false
+		if arm.Guard == nil {
+			return nil
+		}
+	}
+
+	if !p.expectPeek(token.ARROW) {
+		return nil
+	}
+
+	p.nextToken()
+	arm.Body = p.parseExpression(LOWEST)
+	if arm.Body == nil {
+		return nil
+	}
+
+	return arm
+}
+
+// Reports whether some arm of the match matches any value: one without a
+// guard whose pattern is a wildcard or an identifier.
+func hasWildcardArm(expr *ast.MatchExpression) bool {
+	for _, arm := range expr.Arms {
+		if arm.Guard != nil {
+			continue
+		}
+		switch arm.Pattern.(type) {
+		case *ast.WildcardPattern, *ast.Identifier:
+			return true
+		}
+	}
+	return false
+}
+
+// Parses statements up to the closing brace, leaving `curToken` on it.
+func (p *Parser) parseBlockStatement() *ast.BlockStatement {
+	p.blocks++
+	defer func() { p.blocks-- }()
+	if p.blocks > maxDepth {
+		msg := fmt.Sprintf("block nested deeper than %d levels", maxDepth)
+		p.errors = append(p.errors, msg)
+		p.skipBlock()
+		return nil
+	}
+
+	block := &ast.BlockStatement{
+		Token: p.curToken,
+	}
+
+	p.nextToken()
+
+	for !p.curTokenIs(token.RBRACE) {
+		if p.curTokenIs(token.EOF) {
+			p.errors = append(p.errors, "expected } before end of input")
+			return nil
+		}
+
+		stmt := p.parseStatement()
+		if stmt != nil {
+			block.Statements = append(block.Statements, stmt)
+		}
+		p.nextToken()
+	}
+
+	return block
+}
+
+// Skips the block starting at `curToken`, leaving `curToken` on its
+// closing brace or at the end of the input.
+func (p *Parser) skipBlock() {
+	for open := 0; !p.curTokenIs(token.EOF); p.nextToken() {
+		switch p.curToken.Type {
+		case token.LBRACE:
+			open++
+		case token.RBRACE:
+			open--
+			if open == 0 {
+				return
+			}
+		}
+	}
+}
+
+func (p *Parser) parseFunctionLiteral() ast.Expression {
+	lit := &ast.FunctionLiteral{
+		Token: p.curToken,
+	}
+
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+
+	if !p.parseFunctionParameters(lit) {
+		return nil
+	}
+
+	if !p.expectPeek(token.LBRACE) {
+		return nil
+	}
+
+	// a function body starts outside of any loop
+	loops := p.loops
+	p.loops = 0
+	lit.Body = p.parseBlockStatement()
+	p.loops = loops
+	if lit.Body == nil {
+		return nil
+	}
+
+	return lit
+}
+
+// Parses parameters, which may have a default value and be followed by a
+// rest parameter.
+func (p *Parser) parseFunctionParameters(lit *ast.FunctionLiteral) bool {
+	lit.Parameters = []*ast.Identifier{}
+
+	if p.peekTokenIs(token.RPAREN) {
+		p.nextToken()
+		return true
+	}
+
+	defaults := []ast.Expression{}
+	hasDefaults := false
+	for {
+		// the rest must be the last parameter
+		if p.peekTokenIs(token.ELLIPSIS) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return false
+			}
+			lit.Rest = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			break
+		}
+
+		if !p.expectPeek(token.IDENT) {
+			return false
+		}
+		param := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+		lit.Parameters = append(lit.Parameters, param)
+
+		var value ast.Expression
+		if p.peekTokenIs(token.ASSIGN) {
+			p.nextToken()
+			p.nextToken()
+			if value = p.parseExpression(LOWEST); value == nil {
+				return false
+			}
+			hasDefaults = true
+		} else if hasDefaults {
+			msg := fmt.Sprintf("%d:%d: parameter %s without a default follows one with a default",
+				param.Token.Line, param.Token.Column, param.Value)
+			p.errors = append(p.errors, msg)
+			return false
+		}
+		defaults = append(defaults, value)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+	if hasDefaults {
+		lit.Defaults = defaults
+	}
+
+	return p.expectPeek(token.RPAREN)
+}
+
+func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
+	expr := &ast.CallExpression{
+		Token:    p.curToken,
+		Function: function,
+	}
+
+	args, ok := p.parseExpressionList(token.RPAREN, true)
+	if !ok {
+		return nil
+	}
+	expr.Arguments = args
+
+	return expr
+}
+
+// Parses `value |> f(args)`, lowering it to the call `f(value, args)`. A
+// right operand other than a call is called with the value alone.
+func (p *Parser) parsePipelineExpression(value ast.Expression) ast.Expression {
+	tok := p.curToken
+
+	right := p.ParseOperand()
+	if right == nil {
+		return nil
+	}
+
+	call, ok := right.(*ast.CallExpression)
+	if !ok {
+		return &ast.CallExpression{Token: tok, Function: right, Arguments: []ast.Expression{value}}
+	}
+	call.Arguments = append([]ast.Expression{value}, call.Arguments...)
+	return call
+}
+
+// Parses comma separated expressions up to the end token, leaving
+// `curToken` on it. With spread, elements may be spread as `...value`.
+func (p *Parser) parseExpressionList(end token.TokenType, spread bool) ([]ast.Expression, bool) {
+	list := []ast.Expression{}
+
+	// arrow functions may be arguments or elements within a guard
+	guarding := p.guarding
+	p.guarding = false
+	defer func() { p.guarding = guarding }()
+
+	if p.peekTokenIs(end) {
+		p.nextToken()
+		return list, true
+	}
+
+	for {
+		p.nextToken()
+
+		var expr ast.Expression
+		if spread && p.curTokenIs(token.ELLIPSIS) {
+			expr = p.parseSpreadExpression()
+		} else {
+			expr = p.parseExpression(LOWEST)
+		}
+		if expr == nil {
+			return nil, false
+		}
+		list = append(list, expr)
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(end) {
+		return nil, false
+	}
+
+	return list, true
+}
+
+func (p *Parser) parseSpreadExpression() ast.Expression {
+	expr := &ast.SpreadExpression{Token: p.curToken}
+
+	p.nextToken()
+	expr.Value = p.parseExpression(LOWEST)
+	if expr.Value == nil {
+		return nil
+	}
+
+	return expr
+}
+
+func (p *Parser) parseArrayLiteral() ast.Expression {
+	array := &ast.ArrayLiteral{
+		Token: p.curToken,
+	}
+
+	elements, ok := p.parseExpressionList(token.RBRACKET, false)
+	if !ok {
+		return nil
+	}
+	array.Elements = elements
+
+	return array
+}
+
+func (p *Parser) parseHashLiteral() ast.Expression {
+	hash := &ast.HashLiteral{
+		Token: p.curToken,
+		Pairs: []*ast.HashPair{},
+	}
+
+	if p.peekTokenIs(token.RBRACE) {
+		p.nextToken()
+		return hash
+	}
+
+	for {
+		p.nextToken()
+		key := p.parseExpression(LOWEST)
+		if key == nil || !p.expectPeek(token.COLON) {
+			return nil
+		}
+
+		p.nextToken()
+		value := p.parseExpression(LOWEST)
+		if value == nil {
+			return nil
+		}
+		hash.Pairs = append(hash.Pairs, &ast.HashPair{Key: key, Value: value})
+
+		if !p.peekTokenIs(token.COMMA) {
+			break
+		}
+		p.nextToken()
+	}
+
+	if !p.expectPeek(token.RBRACE) {
+		return nil
+	}
+
+	return hash
+}
+
+func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
+	expr := &ast.IndexExpression{
+		Token: p.curToken,
+		Left:  left,
+	}
+
+	p.nextToken()
+	expr.Index = p.parseExpression(LOWEST)
+	if expr.Index == nil || !p.expectPeek(token.RBRACKET) {
+		return nil
+	}
+
+	return expr
+}
+
+// Parses `start..end` or `start..=end`, optionally followed by `step n`.
+// step is only special after a range, elsewhere it is an identifier.
+func (p *Parser) parseRangeExpression(start ast.Expression) ast.Expression {
+	expr := &ast.RangeExpression{
+		Token:     p.curToken,
+		Start:     start,
+		Inclusive: p.curTokenIs(token.RANGE_INCLUSIVE),
+	}
+
+	expr.End = p.ParseOperand()
+	if expr.End == nil {
+		return nil
+	}
+
+	if p.peekTokenIs(token.IDENT) && p.peekToken.Literal == "step" {
+		p.nextToken()
+		p.nextToken()
+		expr.Step = p.parseExpression(RANGE)
+		if expr.Step == nil {
+			return nil
+		}
+	}
+
+	return expr
 }
 
 func (p *Parser) peekTokenIs(t token.TokenType) bool {
@@ -275,7 +1469,7 @@ func (p *Parser) noPrefixParseFnError(tokenType token.TokenType) {
 }
 
 func (p *Parser) peekPrecedence() int {
-	if p, ok := tokenPrecedences[p.peekToken.Type]; ok {
+	if p, ok := p.precedences[p.peekToken.Type]; ok {
 		return p
 	}
 
@@ -283,7 +1477,7 @@ func (p *Parser) peekPrecedence() int {
 }
 
 func (p *Parser) curPrecedence() int {
-	if p, ok := tokenPrecedences[p.curToken.Type]; ok {
+	if p, ok := p.precedences[p.curToken.Type]; ok {
 		return p
 	}
 
@@ -2,43 +2,127 @@ package parser
 
 import (
 	"fmt"
+	"reflect"
+	"strings"
 	"testing"
 
 	"interpreter/ast"
 	"interpreter/lexer"
+	"interpreter/token"
 )
 
 func TestLetStatements(t *testing.T) {
-	input := `
-let x = 5;
-let y = 10;
-let foobar = 838383;
-`
-	l := lexer.New(input)
-	p := New(l)
+	tests := []struct {
+		input              string
+		expectedIdentifier string
+		expectedValue      interface{}
+	}{
+		{"let x = 5;", "x", 5},
+		{"let y = true;", "y", true},
+		{"let foobar = y;", "foobar", "y"},
+		{"let z = 1", "z", 1},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if len(program.Statements) != 1 {
+			t.Fatalf("expected len of program.Statements to be 1, got=%d", len(program.Statements))
+		}
+
+		stmt := program.Statements[0]
+		if !testLetStatement(t, stmt, tt.expectedIdentifier) {
+			return
+		}
 
+		value := stmt.(*ast.LetStatement).Value
+		if !testLiteralExpression(t, value, tt.expectedValue) {
+			return
+		}
+	}
+}
+
+func TestConstStatements(t *testing.T) {
+	l := lexer.New("const x = 5; let y = x; for (const i = 0; i < 1; i) {}")
+	p := New(l)
 	program := p.ParseProgram()
 	checkParserErrors(t, p)
 
-	if program == nil {
-		t.Fatalf("ParseProgram() returned nil")
+	tests := []struct {
+		stmt     ast.Statement
+		constant bool
+	}{
+		{program.Statements[0], true},
+		{program.Statements[1], false},
+		{program.Statements[2].(*ast.ForStatement).Init, true},
 	}
-	if len(program.Statements) != 3 {
-		t.Fatalf("expected len of program.Statements to be 3, got=%d", len(program.Statements))
+	for i, tt := range tests {
+		stmt, ok := tt.stmt.(*ast.LetStatement)
+		if !ok {
+			t.Fatalf("tests[%d]: expected *ast.LetStatement, got=%T", i, tt.stmt)
+		}
+		if stmt.Const != tt.constant {
+			t.Errorf("tests[%d]: expected Const to be %t, got=%t", i, tt.constant, stmt.Const)
+		}
 	}
 
+	if got := program.Statements[0].String(); got != "const x = 5;" {
+		t.Errorf("expected %q, got=%q", "const x = 5;", got)
+	}
+}
+
+func TestDestructuringLet(t *testing.T) {
 	tests := []struct {
-		expectedIdentifier string
+		input    string
+		expected string
 	}{
-		{"x"},
-		{"y"},
-		{"foobar"},
+		{"let [a, b, ...rest] = xs", "let [a, b, ...rest] = xs;"},
+		{"let [] = xs;", "let [] = xs;"},
+		{"let [x = 1 + 2, [y]] = xs", "let [x = (1 + 2), [y]] = xs;"},
+		{"let {name, age: years} = p", "let {name, age: years} = p;"},
+		{"const {name = \"anon\", address: {city} = {}} = p", `const {name = "anon", address: {city} = {}} = p;`},
 	}
 
-	for i, tt := range tests {
-		stmt := program.Statements[i]
-		if !testLetStatement(t, stmt, tt.expectedIdentifier) {
-			return
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt, ok := program.Statements[0].(*ast.LetStatement)
+		if !ok {
+			t.Fatalf("expected *ast.LetStatement, got=%T", program.Statements[0])
+		}
+		if stmt.Name != nil || stmt.Pattern == nil {
+			t.Errorf("expected a pattern instead of a name for %q", tt.input)
+		}
+		if got := stmt.String(); got != tt.expected {
+			t.Errorf("expected %q, got=%q", tt.expected, got)
+		}
+	}
+}
+
+func TestMatchWarnings(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"match (x) { 0 => a, _ => b }", nil},
+		{"match (x) { 0 => a, n => b }", nil},
+		{"match (x) { 0 => a, [n] => b }", []string{"1:1: match has no wildcard arm, unmatched values give null"}},
+		{"let y = match (x) { _ if x => a }", []string{"1:9: match has no wildcard arm, unmatched values give null"}},
+		{"match (x) { }", []string{"1:1: match has no wildcard arm, unmatched values give null"}},
+	}
+
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		checkParserErrors(t, p)
+
+		if !reflect.DeepEqual(p.Warnings(), tt.expected) {
+			t.Errorf("input %q: expected warnings %q, got=%q", tt.input, tt.expected, p.Warnings())
 		}
 	}
 }
@@ -69,33 +153,103 @@ func testLetStatement(t *testing.T, s ast.Statement, name string) bool {
 }
 
 func TestReturnStatements(t *testing.T) {
-	input := `
-return 5;
-return 10;
-return add(15);
-`
-	l := lexer.New(input)
-	p := New(l)
+	tests := []struct {
+		input         string
+		expectedValue interface{}
+	}{
+		{"return 5;", 5},
+		{"return true;", true},
+		{"return foobar;", "foobar"},
+		{"return 10", 10},
+	}
 
-	program := p.ParseProgram()
-	checkParserErrors(t, p)
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
 
-	if program == nil {
-		t.Fatalf("ParseProgram() returned nil")
-	}
-	if len(program.Statements) != 3 {
-		t.Fatalf("expected len of program.Statements to be 3, got=%d", len(program.Statements))
-	}
+		if len(program.Statements) != 1 {
+			t.Fatalf("expected len of program.Statements to be 1, got=%d", len(program.Statements))
+		}
 
-	for _, stmt := range program.Statements {
+		stmt := program.Statements[0]
 		returnStmt, ok := stmt.(*ast.ReturnStatement)
 		if !ok {
-			t.Errorf("expected stmt to be a *ast.ReturnStatement, got=%T", stmt)
-			continue
+			t.Fatalf("expected stmt to be a *ast.ReturnStatement, got=%T", stmt)
 		}
 		if returnStmt.TokenLiteral() != "return" {
 			t.Errorf("expected returnStmt.TokenLiteral() to be 'return', got=%q", returnStmt.TokenLiteral())
 		}
+		if !testLiteralExpression(t, returnStmt.ReturnValue, tt.expectedValue) {
+			return
+		}
+	}
+}
+
+func TestInvalidInput(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedErrors int
+	}{
+		{"let", 1},
+		{"let x", 1},
+		{"let x =", 1},
+		{"return", 1},
+		{"-", 1},
+		{"!-", 1},
+		{"1 +", 1},
+		{"1 + * 2", 1},
+		{"let x = 1 + ;", 1},
+		{"(1 + 2", 1},
+		{"if (x) { x", 1},
+		{"if x { x }", 3},
+		{"fn(x, 1) { x }", 4},
+		{"fn(x) { x ", 1},
+		{"f(1, 2", 1},
+		{"f(1,)", 1},
+		{"1 = 2", 1},
+		{"a + b = c", 1},
+		{"f() += 1", 1},
+		{"x -=", 1},
+		{"x =", 1},
+		{"while (x) x", 1},
+		{"while x { x }", 3},
+		{"for (let i = 0 i < 1;) { }", 2},
+		{"for (;) { }", 1},
+		{"for (; ; let i = 1; ) { }", 3},
+		{"[1, 2", 1},
+		{"{1: 2", 1},
+		{"{1 2}", 2},
+		{"{1: 2,}", 1},
+		{"a[1", 1},
+		{"a[]", 1},
+		{"for (x in ) { }", 1},
+		{"for (k, in h) { }", 3},
+		{"for (x in xs) x", 1},
+		{"0..", 1},
+		{"0..1 step", 1},
+		{"break", 1},
+		{"while (x) { fn() { continue } }", 1},
+		{strings.Repeat("-", maxDepth+1) + "1", 1},
+		{"let " + strings.Repeat("[", maxDepth+1), 1},
+		{"let " + strings.Repeat("{a: ", maxDepth+1), 2},
+		{"match (x) { " + strings.Repeat("[", maxDepth+1), 2},
+		{strings.Repeat("while (true) {", maxDepth+1) + strings.Repeat("}", maxDepth+1), 1},
+		{strings.Repeat("for (x in y) {", maxDepth+1) + strings.Repeat("}", maxDepth+1), 1},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+
+		if len(p.Errors()) != tt.expectedErrors {
+			t.Errorf("input %q: expected %d errors, got=%q", tt.input, tt.expectedErrors, p.Errors())
+		}
+
+		// must not panic on incomplete nodes
+		_ = program.String()
 	}
 }
 
@@ -255,6 +409,8 @@ func TestInfixExpressions(t *testing.T) {
 		{"5 - 5;", 5, "-", 5},
 		{"5 * 5;", 5, "*", 5},
 		{"5 / 5;", 5, "/", 5},
+		{"5 % 5;", 5, "%", 5},
+		{"5 ** 5;", 5, "**", 5},
 		{"5 > 5;", 5, ">", 5},
 		{"5 < 5;", 5, "<", 5},
 		{"5 == 5;", 5, "==", 5},
@@ -356,6 +512,106 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"3 < 5 == true",
 			"((3 < 5) == true)",
 		},
+		{
+			"a + b % c * d",
+			"(a + ((b % c) * d))",
+		},
+		{
+			"2 ** 3 ** 2",
+			"(2 ** (3 ** 2))",
+		},
+		{
+			"-2 ** 2",
+			"(-(2 ** 2))",
+		},
+		{
+			"!a ** b",
+			"(!(a ** b))",
+		},
+		{
+			"2 ** -1",
+			"(2 ** (-1))",
+		},
+		{
+			"a * b ** c * d",
+			"((a * (b ** c)) * d)",
+		},
+		{
+			"a ** b % c",
+			"((a ** b) % c)",
+		},
+		{
+			"1 + (2 + 3) + 4",
+			"((1 + (2 + 3)) + 4)",
+		},
+		{
+			"(5 + 5) * 2",
+			"((5 + 5) * 2)",
+		},
+		{
+			"-(5 + 5)",
+			"(-(5 + 5))",
+		},
+		{
+			"!(true == true)",
+			"(!(true == true))",
+		},
+		{
+			"(-2) ** 2",
+			"((-2) ** 2)",
+		},
+		{
+			"a + add(b * c) + d",
+			"((a + add((b * c))) + d)",
+		},
+		{
+			"add(a, b, 1, 2 * 3, 4 + 5, add(6, 7 * 8))",
+			"add(a, b, 1, (2 * 3), (4 + 5), add(6, (7 * 8)))",
+		},
+		{
+			"add(a + b + c * d / f + g)",
+			"add((((a + b) + ((c * d) / f)) + g))",
+		},
+		{
+			"-f(x) ** 2",
+			"(-(f(x) ** 2))",
+		},
+		{
+			"a = b = c + 1 == d",
+			"a = b = ((c + 1) == d)",
+		},
+		{
+			"a[i + 1] += b -= c * 2",
+			"(a[(i + 1)]) += b -= (c * 2)",
+		},
+		{
+			"f(x = 2) + y",
+			"(f(x = 2) + y)",
+		},
+		{
+			"a * [1, 2, 3, 4][b * c] * d",
+			"((a * ([1, 2, 3, 4][(b * c)])) * d)",
+		},
+		{
+			"add(a * b[2], b[1], 2 * [1, 2][1])",
+			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
+		},
+		{
+			"{\"a\": 1 + 2, b: []}[k]",
+			"({\"a\": (1 + 2), b: []}[k])",
+		},
+		{
+			"x == 0..n - 1",
+			"(x == (0..(n - 1)))",
+		},
+		{
+			"0..=10 step 2 * k < 3",
+			"((0..=10 step (2 * k)) < 3)",
+		},
+		{
+			"step..step step step",
+			"(step..step step step)",
+		},
 	}
 
 	for _, tt := range tests {
@@ -371,6 +627,200 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 	}
 }
 
+func TestIfExpression(t *testing.T) {
+	input := `if (x < y) { x }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	if len(program.Statements) != 1 {
+		t.Fatalf("expected len of program.Statements to be 1, got=%d", len(program.Statements))
+	}
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("expected stmt to be a *ast.ExpressionStatement, got=%T", program.Statements[0])
+	}
+
+	expr, ok := stmt.Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("expected stmt.Expression to be a *ast.IfExpression, got=%T", stmt.Expression)
+	}
+
+	if !testInfixExpression(t, expr.Condition, "x", "<", "y") {
+		return
+	}
+
+	if len(expr.Consequence.Statements) != 1 {
+		t.Fatalf("expected consequence to have 1 statement, got=%d", len(expr.Consequence.Statements))
+	}
+
+	consequence, ok := expr.Consequence.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("expected consequence to be a *ast.ExpressionStatement, got=%T", expr.Consequence.Statements[0])
+	}
+	if !testIdentifier(t, consequence.Expression, "x") {
+		return
+	}
+
+	if expr.Alternative != nil {
+		t.Errorf("expected expr.Alternative to be nil, got=%+v", expr.Alternative)
+	}
+}
+
+func TestIfElseExpression(t *testing.T) {
+	input := `if (x < y) { x } else { y }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	expr, ok := stmt.Expression.(*ast.IfExpression)
+	if !ok {
+		t.Fatalf("expected stmt.Expression to be a *ast.IfExpression, got=%T", stmt.Expression)
+	}
+
+	if !testInfixExpression(t, expr.Condition, "x", "<", "y") {
+		return
+	}
+
+	if expr.Alternative == nil || len(expr.Alternative.Statements) != 1 {
+		t.Fatalf("expected alternative to have 1 statement, got=%+v", expr.Alternative)
+	}
+
+	alternative, ok := expr.Alternative.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("expected alternative to be a *ast.ExpressionStatement, got=%T", expr.Alternative.Statements[0])
+	}
+	if !testIdentifier(t, alternative.Expression, "y") {
+		return
+	}
+}
+
+func TestFunctionLiteralParsing(t *testing.T) {
+	input := `fn(x, y) { x + y; }`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	function, ok := stmt.Expression.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("expected stmt.Expression to be a *ast.FunctionLiteral, got=%T", stmt.Expression)
+	}
+
+	if len(function.Parameters) != 2 {
+		t.Fatalf("expected 2 parameters, got=%d", len(function.Parameters))
+	}
+	testLiteralExpression(t, function.Parameters[0], "x")
+	testLiteralExpression(t, function.Parameters[1], "y")
+
+	if len(function.Body.Statements) != 1 {
+		t.Fatalf("expected function.Body to have 1 statement, got=%d", len(function.Body.Statements))
+	}
+
+	bodyStmt, ok := function.Body.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("expected body statement to be a *ast.ExpressionStatement, got=%T", function.Body.Statements[0])
+	}
+	testInfixExpression(t, bodyStmt.Expression, "x", "+", "y")
+}
+
+func TestFunctionParameterParsing(t *testing.T) {
+	tests := []struct {
+		input          string
+		expectedParams []string
+	}{
+		{input: "fn() {};", expectedParams: []string{}},
+		{input: "fn(x) {};", expectedParams: []string{"x"}},
+		{input: "fn(x, y, z) {};", expectedParams: []string{"x", "y", "z"}},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		function := stmt.Expression.(*ast.FunctionLiteral)
+
+		if len(function.Parameters) != len(tt.expectedParams) {
+			t.Errorf("expected %d parameters, got=%d", len(tt.expectedParams), len(function.Parameters))
+		}
+
+		for i, ident := range tt.expectedParams {
+			testLiteralExpression(t, function.Parameters[i], ident)
+		}
+	}
+}
+
+func TestArrowFunctionToken(t *testing.T) {
+	// the lowered function starts at the parameters
+	program := New(lexer.New("1 + (a, b) => a")).ParseProgram()
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	fn := stmt.Expression.(*ast.InfixExpression).Right.(*ast.FunctionLiteral)
+	if fn.Token.Type != token.FUNCTION || fn.Token.Line != 1 || fn.Token.Column != 5 {
+		t.Errorf("wrong token for arrow function: %+v", fn.Token)
+	}
+}
+
+func TestFunctionLiteralWithName(t *testing.T) {
+	input := `let myFunction = fn() { };`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("expected stmt to be a *ast.LetStatement, got=%T", program.Statements[0])
+	}
+
+	function, ok := stmt.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("expected stmt.Value to be a *ast.FunctionLiteral, got=%T", stmt.Value)
+	}
+
+	if function.Name != "myFunction" {
+		t.Errorf("expected function.Name to be 'myFunction', got=%q", function.Name)
+	}
+}
+
+func TestCallExpressionParsing(t *testing.T) {
+	input := "add(1, 2 * 3, 4 + 5);"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	expr, ok := stmt.Expression.(*ast.CallExpression)
+	if !ok {
+		t.Fatalf("expected stmt.Expression to be a *ast.CallExpression, got=%T", stmt.Expression)
+	}
+
+	if !testIdentifier(t, expr.Function, "add") {
+		return
+	}
+
+	if len(expr.Arguments) != 3 {
+		t.Fatalf("expected 3 arguments, got=%d", len(expr.Arguments))
+	}
+
+	testLiteralExpression(t, expr.Arguments[0], 1)
+	testInfixExpression(t, expr.Arguments[1], 2, "*", 3)
+	testInfixExpression(t, expr.Arguments[2], 4, "+", 5)
+}
+
 func testIntegerLiteral(t *testing.T, expr ast.Expression, value int64) bool {
 	il, ok := expr.(*ast.IntegerLiteral)
 	if !ok {
@@ -492,3 +942,81 @@ func checkParserErrors(t *testing.T, p *Parser) {
 	}
 	t.FailNow()
 }
+
+func TestParseFile(t *testing.T) {
+	tests := []string{
+		"",
+		"\n\n",
+		"let x = 5;",
+		"// the answer\nlet answer = 42; // trailing\n\n-a * b;;\n",
+		"a + b\r\n!true // negated\n",
+		"5 + ;\n) @ 3",
+		"- // dangling\n",
+		"for (let i = 0; i < 3; i = i + 1) {\n  if (i == 1) { continue; } // skip\n  puts(i)\n}\nbreak",
+	}
+
+	for _, input := range tests {
+		p := New(lexer.NewLossless(input))
+		file := p.ParseFile()
+
+		if actual := file.String(); actual != input {
+			t.Errorf("expected file.String() to be %q, got %q", input, actual)
+		}
+
+		expected := New(lexer.New(input)).ParseProgram().String()
+		if actual := file.Program().String(); actual != expected {
+			t.Errorf("expected file.Program().String() to be %q, got %q", expected, actual)
+		}
+	}
+}
+
+func FuzzParseProgram(f *testing.F) {
+	seeds := []string{
+		"let x = 5;\nlet y = 10;\nlet foobar = 838383;",
+		"return 5;\nreturn 10;\nreturn add(15);",
+		"foobar;",
+		"5;",
+		"true;",
+		"!5",
+		"-15",
+		"!true;",
+		"5 != 5;",
+		"true == true",
+		"a + b * c + d / e - f",
+		"3 + 4; -5 * 5",
+		"3 + 4 * 5 == 3 * 1 + 4 * 5",
+		"3 < 5 == true",
+		"// the answer\nlet answer = 42; // trailing\n\n-a * b;;\n",
+		"5 + ;\n) @ 3",
+		"let",
+		"while (x < 10) { x = x + 1; if (x == 5) { break; } }",
+		"for (let i = 0; i < 10; i = i + 1) { continue; }",
+		"a = b = 1",
+		"xs[i] += 1; h[\"k\"] %= 2",
+		"let " + strings.Repeat("[", maxDepth+1),
+		"let " + strings.Repeat("{a: ", maxDepth+1),
+		"match (x) { " + strings.Repeat("[", maxDepth+1),
+		strings.Repeat("while (true) {", maxDepth+1),
+		strings.Repeat("for (x in y) {", maxDepth+1),
+	}
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, input string) {
+		p := New(lexer.New(input))
+		program := p.ParseProgram()
+		if program == nil {
+			t.Fatalf("ParseProgram() returned nil")
+		}
+		expected := program.String()
+
+		file := New(lexer.NewLossless(input)).ParseFile()
+		if actual := file.String(); actual != input {
+			t.Fatalf("expected file.String() to be %q, got %q", input, actual)
+		}
+		if actual := file.Program().String(); actual != expected {
+			t.Fatalf("expected file.Program().String() to be %q, got %q", expected, actual)
+		}
+	})
+}
@@ -0,0 +1,24 @@
+let double = x => x * 2;
+let add = (a, b = 1) => a + b;
+let last = (...xs) => xs;
+let all = (x, y = 10, ...rest) => [x, y, rest];
+let curry = x => y => x + y;
+let inc = (x) => { let y = x; y + 1 };
+let wrap = x => ({"a": x});
+let nothing = () => {};
+let total = [1, 2, 3] |> map(double) |> reduce(add, 0);
+1 + 2 |> double == 6;
+1 + 2 |> f == 0..3 |> g();
+a = xs |> f(1)(2) |> h;
+xs |> x => x * 2;
+xs |> map(x => x * 2) |> (f);
+f(x => x, 1);
+match (x) { n if n => n => n, n if (n) => 1, n if f(y => y) => 1, _ => 0 };
+() => 0;
+(a, 1) => a;
+(a = 1, b) => a;
+(...a, b) => a;
+(a, b);
+a | b;
+xs |> ;
+x => { x
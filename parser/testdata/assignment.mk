@@ -0,0 +1,12 @@
+let xs = [1, 2];
+x = 1;
+x += 2 * 3;
+x -= 1;
+x *= y /= 2;
+x %= 3;
+xs[0] = 10;
+h["k"] += 1;
+m[i][j] = x = 0;
+1 = 2;
+f() += 1;
+a + b -= 1;
@@ -0,0 +1,6 @@
+[];
+[1, "two", [3]];
+{};
+{"name": "monkey", 1: true, key: fn() { 2 }};
+xs[0][i + 1];
+{"a": [1, 2]}["a"][1]
@@ -0,0 +1,3 @@
+// comments are skipped by the lexer
+let x = 1; // up to the end of the line
+x // even at the end of the input
@@ -0,0 +1,4 @@
+const limit = 10;
+let count = limit;
+const greet = fn(name) { "hello " + name };
+for (const i = 0; i < limit; i) {}
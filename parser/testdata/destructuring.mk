@@ -0,0 +1,9 @@
+let [a, b, ...rest] = xs;
+let {name, age: years} = person;
+let [x = 0, [y, z] = pair] = point;
+const {first = "anon", address: {city}} = user;
+let [] = [];
+let [...all] = xs;
+let [a, ...rest, b] = xs;
+let {1: one} = h;
+let [...] = xs;
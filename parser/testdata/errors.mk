@@ -0,0 +1,4 @@
+let = 5;
+let x 5;
+1 + ;
+@
@@ -0,0 +1,7 @@
+for (x in [1, 2, 3]) { puts(x); }
+for (k, v in {"a": 1}) { if (v > 0) { continue; } }
+for (c in "héllo") { }
+for (i in 0..10) { }
+for (i in 0..=10 step 2) { break; }
+let r = n..0 step -1;
+for (x, in xs) { }
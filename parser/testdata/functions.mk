@@ -0,0 +1,5 @@
+fn() {};
+fn(x, y) { x + y; };
+let add = fn(a, b) { return a + b; };
+add(1, 2 * 3, fn(x) { x }(4));
+(5 + 5) * 2;
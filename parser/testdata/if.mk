@@ -0,0 +1,3 @@
+if (x < y) { x };
+if (x < y) { x } else { let z = y; z };
+if (true) { }
@@ -0,0 +1,11 @@
+5 + 5;
+5 - 5;
+5 * 5;
+5 / 5;
+5 > 5;
+5 < 5;
+5 == 5;
+5 != 5;
+true != false;
+5 % 5;
+5 ** 5;
@@ -0,0 +1,3 @@
+let x = 5;
+let isReady = true;
+let alias = x
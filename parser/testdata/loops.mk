@@ -0,0 +1,7 @@
+while (x < 10) { x = x + 1; }
+for (let i = 0; i < 10; i = i + 1) { if (i == 5) { break; } continue; }
+for (;;) { break }
+for (f(); ; g()) { while (true) { } }
+while (true) { let f = fn() { break; }; }
+continue;
+a = b = 1;
@@ -0,0 +1,25 @@
+let describe = fn(x) {
+  match (x) {
+    0 => "zero",
+    -1 => "minus one",
+    [a, b] => a + b,
+    [first, ...rest] => rest,
+    {type: "user", name} => name,
+    {id = 0} => id,
+    n if n > 10 => "big",
+    _ => "other",
+  }
+};
+match (x) { true => 1, _ => 2 };
+match (x) { x + 1 => 1 };
+match x { _ => 1 };
+match (x) { n if => 1 };
+let [1] = xs;
+match (x) { -1 => a, "s" => b, };
+match (x) { [_, y = 1] => y, {_: z} => z };
+match (x) { };
+match (x) { 1 };
+match (x) { 1 => };
+match (x) { 1 => 2 3 => 4 };
+match (x) { -y => 1 };
+let [1, a] = xs;
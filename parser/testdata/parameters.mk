@@ -0,0 +1,11 @@
+let greet = fn(name, greeting = "hello", ...rest) { greeting + " " + name };
+let sum = fn(...xs) { xs };
+greet("world", ...["hi"], ...rest);
+sum(...[1, 2], 3);
+fn(x = 1, y = x * 2) { x };
+fn(x = ) { x };
+fn(...) { 1 };
+f(...);
+fn(x = 1, y) { x };
+fn(...xs, y) { xs };
+[...xs];
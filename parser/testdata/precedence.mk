@@ -0,0 +1,7 @@
+-a * b;
+a + b * c + d / e - f;
+5 > 4 == 3 < 4;
+3 + 4 * 5 == 3 * 1 + 4 * 5;
+-2 ** 2;
+2 ** 3 ** 2;
+a * b % c;
@@ -0,0 +1,4 @@
+!5;
+-15;
+!true;
+!-a;
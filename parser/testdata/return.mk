@@ -0,0 +1,3 @@
+return 5;
+return answer;
+return -1
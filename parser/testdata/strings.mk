@@ -0,0 +1,4 @@
+"hello world";
+"tab\tand \"quotes\"\n";
+let s = "";
+"bad \q";
@@ -0,0 +1,1371 @@
+package regvm
+
+import (
+	"fmt"
+
+	"interpreter/ast"
+	"interpreter/object"
+	"interpreter/token"
+)
+
+// Program is the result of compiling an ast.Program.
+type Program struct {
+	Main    *Function
+	Globals []string // names of the globals, by index
+}
+
+// resultRegister of the main function holds the value of the program: the
+// value of its last statement if it is an expression statement, null
+// otherwise.
+const resultRegister = 0
+
+type Compiler struct {
+	globals map[string]int
+	names   []string
+	consts  map[string]bool // globals declared with const
+
+	fs *funcState // function being compiled
+
+	line   int // source position of the node being compiled
+	column int
+}
+
+// funcState is the state of a function being compiled.
+type funcState struct {
+	parent *funcState
+	fn     *Function
+
+	registers map[string]int  // locals, assigned before compiling the body
+	slots     map[string]int  // environment slots of the locals of captured functions
+	defined   map[string]bool // locals whose let has been compiled
+	consts    map[string]bool // locals declared with const
+	constants map[int64]int
+	free      int // first free register
+
+	tailCalls map[*ast.CallExpression]bool
+	loops     []*loop // enclosing the code being compiled, innermost last
+}
+
+// loop collects the jumps of the break and continue statements of a loop,
+// patched once their targets are known.
+type loop struct {
+	breaks    []int
+	continues []int
+}
+
+func NewCompiler() *Compiler {
+	return &Compiler{globals: make(map[string]int), consts: make(map[string]bool)}
+}
+
+// Compile compiles program into the main function of a Program.
+func Compile(program *ast.Program) (*Program, error) {
+	return NewCompiler().Compile(program)
+}
+
+func (c *Compiler) Compile(program *ast.Program) (*Program, error) {
+	c.fs = newFuncState(nil, &Function{})
+	c.fs.free = resultRegister + 1
+	c.fs.fn.NumRegs = c.fs.free
+
+	for _, s := range program.Statements {
+		if err := c.statement(s); err != nil {
+			return nil, err
+		}
+	}
+	if len(program.Statements) > 0 && !program.EndsWithExpression() {
+		// the register may hold the value of an earlier statement
+		c.emit(LOADNULL, resultRegister, 0, 0)
+	}
+	c.emit(RETURN, resultRegister, 0, 0)
+
+	return &Program{Main: c.fs.fn, Globals: c.names}, nil
+}
+
+func newFuncState(parent *funcState, fn *Function) *funcState {
+	return &funcState{
+		parent:    parent,
+		fn:        fn,
+		registers: make(map[string]int),
+		slots:     make(map[string]int),
+		defined:   make(map[string]bool),
+		consts:    make(map[string]bool),
+		constants: make(map[int64]int),
+	}
+}
+
+func (c *Compiler) statement(s ast.Statement) error {
+	mark := c.fs.free
+	defer func() { c.fs.free = mark }()
+
+	switch s := s.(type) {
+	case *ast.ExpressionStatement:
+		defer c.at(s.Token)()
+		if c.fs.parent == nil {
+			return c.expression(s.Expression, resultRegister)
+		}
+		return c.expression(s.Expression, c.allocRegister())
+
+	case *ast.LetStatement:
+		defer c.at(s.Token)()
+		return c.let(s)
+
+	case *ast.ReturnStatement:
+		defer c.at(s.Token)()
+		if c.fs.parent == nil {
+			return fmt.Errorf("return outside of a function")
+		}
+		rk, err := c.operand(s.ReturnValue)
+		if err != nil {
+			return err
+		}
+		c.emit(RETURN, rk, 0, 0)
+		return nil
+
+	case *ast.WhileStatement:
+		defer c.at(s.Token)()
+		start := len(c.fs.fn.Instrs)
+		cond, err := c.operand(s.Condition)
+		if err != nil {
+			return err
+		}
+		exit := c.emit(JMPIFNOT, cond, 0, 0)
+
+		l, err := c.loopBody(s.Body)
+		if err != nil {
+			return err
+		}
+		c.emit(JMP, 0, start, 0)
+
+		end := len(c.fs.fn.Instrs)
+		c.fs.fn.Instrs[exit].B = end
+		c.patchJumps(l.breaks, end)
+		c.patchJumps(l.continues, start)
+		return nil
+
+	case *ast.ForStatement:
+		defer c.at(s.Token)()
+		if s.Init != nil {
+			if err := c.statement(s.Init); err != nil {
+				return err
+			}
+		}
+
+		start := len(c.fs.fn.Instrs)
+		exit := -1
+		if s.Condition != nil {
+			cond, err := c.operand(s.Condition)
+			if err != nil {
+				return err
+			}
+			exit = c.emit(JMPIFNOT, cond, 0, 0)
+		}
+
+		l, err := c.loopBody(s.Body)
+		if err != nil {
+			return err
+		}
+
+		next := len(c.fs.fn.Instrs)
+		if s.Update != nil {
+			if err := c.statement(s.Update); err != nil {
+				return err
+			}
+		}
+		c.emit(JMP, 0, start, 0)
+
+		end := len(c.fs.fn.Instrs)
+		if exit >= 0 {
+			c.fs.fn.Instrs[exit].B = end
+		}
+		c.patchJumps(l.breaks, end)
+		c.patchJumps(l.continues, next)
+		return nil
+
+	case *ast.ForInStatement:
+		defer c.at(s.Token)()
+		return c.forIn(s)
+
+	case *ast.BreakStatement:
+		defer c.at(s.Token)()
+		if len(c.fs.loops) == 0 {
+			return fmt.Errorf("break outside of a loop")
+		}
+		l := c.fs.loops[len(c.fs.loops)-1]
+		l.breaks = append(l.breaks, c.emit(JMP, 0, 0, 0))
+		return nil
+
+	case *ast.ContinueStatement:
+		defer c.at(s.Token)()
+		if len(c.fs.loops) == 0 {
+			return fmt.Errorf("continue outside of a loop")
+		}
+		l := c.fs.loops[len(c.fs.loops)-1]
+		l.continues = append(l.continues, c.emit(JMP, 0, 0, 0))
+		return nil
+
+	default:
+		return fmt.Errorf("cannot compile %T", s)
+	}
+}
+
+func (c *Compiler) let(s *ast.LetStatement) error {
+	if s.Pattern != nil {
+		reg := c.allocRegister()
+		if err := c.expression(s.Value, reg); err != nil {
+			return err
+		}
+		return c.destructure(s.Pattern, reg, s.Const)
+	}
+
+	name := s.Name.Value
+	if c.isConstant(name) {
+		return redeclaredConstant(s.Name)
+	}
+	if s.Const {
+		c.declareConstant(name)
+	}
+
+	// a function may refer to the binding it is assigned to
+	_, isFunction := s.Value.(*ast.FunctionLiteral)
+
+	if c.fs.fn.Captured {
+		var v variable
+		if isFunction {
+			v = c.defineSlot(name)
+		}
+		reg := c.allocRegister()
+		if err := c.expression(s.Value, reg); err != nil {
+			return err
+		}
+		if !isFunction {
+			v = c.defineSlot(name)
+		}
+		c.store(v, reg)
+		return nil
+	}
+
+	if c.fs.parent != nil {
+		reg := c.fs.registers[name]
+		if isFunction {
+			c.fs.defined[name] = true
+		}
+		if err := c.expression(s.Value, reg); err != nil {
+			return err
+		}
+		c.fs.defined[name] = true
+		return nil
+	}
+
+	index, ok := c.globals[name]
+	if !ok && isFunction {
+		index = c.defineGlobal(name)
+	}
+	reg := c.allocRegister()
+	if err := c.expression(s.Value, reg); err != nil {
+		return err
+	}
+	if !ok && !isFunction {
+		index = c.defineGlobal(name)
+	}
+	c.emit(SETGLOBAL, reg, index, 0)
+	return nil
+}
+
+// Binds the names of the pattern to the parts of the value in register src,
+// checking the value against the shape of the pattern.
+func (c *Compiler) destructure(pattern ast.Pattern, src int, constant bool) error {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		name := pattern.Value
+		if c.isConstant(name) {
+			return redeclaredConstant(pattern)
+		}
+		if constant {
+			c.declareConstant(name)
+		}
+		if c.fs.fn.Captured {
+			c.store(c.defineSlot(name), src)
+			return nil
+		}
+		if c.fs.parent != nil {
+			c.emit(MOVE, c.fs.registers[name], src, 0)
+			c.fs.defined[name] = true
+			return nil
+		}
+		index, ok := c.globals[name]
+		if !ok {
+			index = c.defineGlobal(name)
+		}
+		c.emit(SETGLOBAL, src, index, 0)
+
+	case *ast.ArrayPattern:
+		defer c.at(pattern.Token)()
+		min, max := arrayBounds(pattern)
+		c.emit(CHECKARRAY, src, min, max)
+
+		for i, e := range pattern.Elements {
+			element := c.allocRegister()
+			c.emit(INDEX, element, src, constantOperand(c.constant(int64(i))))
+			if err := c.destructure(e, element, constant); err != nil {
+				return err
+			}
+		}
+		if pattern.Rest != nil {
+			rest := c.allocRegister()
+			c.emit(REST, rest, src, len(pattern.Elements))
+			if err := c.destructure(pattern.Rest, rest, constant); err != nil {
+				return err
+			}
+		}
+
+	case *ast.HashPattern:
+		defer c.at(pattern.Token)()
+		c.emit(CHECKHASH, src, 0, 0)
+
+		for _, pair := range pattern.Pairs {
+			value := c.allocRegister()
+			key := constantOperand(c.addConstant(&object.String{Value: pair.Key.Value}))
+			restore := c.at(pair.Key.Token)
+			if _, ok := pair.Value.(*ast.DefaultPattern); ok {
+				c.emit(INDEX, value, src, key)
+			} else {
+				c.emit(FIELD, value, src, key)
+			}
+			restore()
+
+			if err := c.destructure(pair.Value, value, constant); err != nil {
+				return err
+			}
+		}
+
+	case *ast.DefaultPattern:
+		jump := c.emit(JMPNOTNULL, src, 0, 0)
+		if err := c.expression(pattern.Default, src); err != nil {
+			return err
+		}
+		c.fs.fn.Instrs[jump].B = len(c.fs.fn.Instrs)
+		return c.destructure(pattern.Target, src, constant)
+
+	default:
+		return fmt.Errorf("cannot destructure with %T", pattern)
+	}
+	return nil
+}
+
+// Returns the number of elements an array may have for pattern, max is -1 if
+// it has a rest. Elements after the last one without a default may be
+// missing.
+func arrayBounds(pattern *ast.ArrayPattern) (min, max int) {
+	for i, e := range pattern.Elements {
+		if _, ok := e.(*ast.DefaultPattern); !ok {
+			min = i + 1
+		}
+	}
+	max = len(pattern.Elements)
+	if pattern.Rest != nil {
+		max = -1
+	}
+	return min, max
+}
+
+// Compiles an arm of a match testing the value in register value, whose
+// body leaves its result in dst. The names of its pattern are new variables
+// that only its guard and body see, so that neither the enclosing scope nor
+// the other arms are affected by its bindings.
+func (c *Compiler) matchArm(arm *ast.MatchArm, value, dst int, fails *[]int) error {
+	defer c.shadow(ast.PatternNames(arm.Pattern))()
+
+	if err := c.match(arm.Pattern, value, fails); err != nil {
+		return err
+	}
+	if arm.Guard != nil {
+		cond, err := c.operand(arm.Guard)
+		if err != nil {
+			return err
+		}
+		*fails = append(*fails, c.emit(JMPIFNOT, cond, 0, 0))
+	}
+	return c.expression(arm.Body, dst)
+}
+
+// Compiles the test of the pattern of a match arm against the value in
+// register src, binding its names. The jumps taken when the value does not
+// match are added to fails.
+func (c *Compiler) match(pattern ast.Pattern, src int, fails *[]int) error {
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+
+	case *ast.Identifier:
+		c.bindNew(pattern.Value, src)
+
+	case *ast.LiteralPattern:
+		literal, err := c.operand(pattern.Value)
+		if err != nil {
+			return err
+		}
+		equal := c.allocRegister()
+		c.emit(EQ, equal, src, literal)
+		*fails = append(*fails, c.emit(JMPIFNOT, equal, 0, 0))
+
+	case *ast.ArrayPattern:
+		defer c.at(pattern.Token)()
+		min, max := arrayBounds(pattern)
+		c.emit(TESTARRAY, src, min, max)
+		*fails = append(*fails, c.emit(JMP, 0, 0, 0))
+
+		for i, e := range pattern.Elements {
+			element := c.allocRegister()
+			c.emit(INDEX, element, src, constantOperand(c.constant(int64(i))))
+			if err := c.match(e, element, fails); err != nil {
+				return err
+			}
+		}
+		if pattern.Rest != nil {
+			rest := c.allocRegister()
+			c.emit(REST, rest, src, len(pattern.Elements))
+			if err := c.match(pattern.Rest, rest, fails); err != nil {
+				return err
+			}
+		}
+
+	case *ast.HashPattern:
+		defer c.at(pattern.Token)()
+		c.emit(TESTHASH, src, 0, 0)
+		*fails = append(*fails, c.emit(JMP, 0, 0, 0))
+
+		for _, pair := range pattern.Pairs {
+			value := c.allocRegister()
+			key := constantOperand(c.addConstant(&object.String{Value: pair.Key.Value}))
+			if _, ok := pair.Value.(*ast.DefaultPattern); !ok {
+				c.emit(HASKEY, src, key, 0)
+				*fails = append(*fails, c.emit(JMP, 0, 0, 0))
+			}
+			c.emit(INDEX, value, src, key)
+
+			if err := c.match(pair.Value, value, fails); err != nil {
+				return err
+			}
+		}
+
+	case *ast.DefaultPattern:
+		jump := c.emit(JMPNOTNULL, src, 0, 0)
+		if err := c.expression(pattern.Default, src); err != nil {
+			return err
+		}
+		c.fs.fn.Instrs[jump].B = len(c.fs.fn.Instrs)
+		return c.match(pattern.Target, src, fails)
+
+	default:
+		return fmt.Errorf("cannot match with %T", pattern)
+	}
+	return nil
+}
+
+// Compiles a for-in loop. The iterator lives in a register for the duration
+// of the loop and the values of each iteration arrive in the registers after
+// it, from which they are moved to the loop variables.
+func (c *Compiler) forIn(s *ast.ForInStatement) error {
+	it := c.allocRegister()
+	for range s.Variables {
+		c.allocRegister()
+	}
+	iterable, err := c.operand(s.Iterable)
+	if err != nil {
+		return err
+	}
+	c.emit(ITER, it, iterable, 0)
+
+	next := c.emit(ITERNEXT, it, 0, len(s.Variables))
+	for i, v := range s.Variables {
+		value := it + 1 + i
+		if c.isConstant(v.Value) {
+			return redeclaredConstant(v)
+		}
+		if c.fs.fn.Captured {
+			c.store(c.defineSlot(v.Value), value)
+			continue
+		}
+		if c.fs.parent != nil {
+			c.fs.defined[v.Value] = true
+			c.emit(MOVE, c.fs.registers[v.Value], value, 0)
+			continue
+		}
+		index, ok := c.globals[v.Value]
+		if !ok {
+			index = c.defineGlobal(v.Value)
+		}
+		c.emit(SETGLOBAL, value, index, 0)
+	}
+
+	l, err := c.loopBody(s.Body)
+	if err != nil {
+		return err
+	}
+	c.emit(JMP, 0, next, 0)
+
+	end := len(c.fs.fn.Instrs)
+	c.fs.fn.Instrs[next].B = end
+	c.patchJumps(l.breaks, end)
+	c.patchJumps(l.continues, next)
+	return nil
+}
+
+// Compiles the statements of a loop body and returns the jumps of its
+// break and continue statements.
+func (c *Compiler) loopBody(body *ast.BlockStatement) (*loop, error) {
+	l := &loop{}
+	fs := c.fs
+	fs.loops = append(fs.loops, l)
+	defer func() { fs.loops = fs.loops[:len(fs.loops)-1] }()
+
+	for _, s := range body.Statements {
+		if err := c.statement(s); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+func (c *Compiler) patchJumps(jumps []int, target int) {
+	for _, pos := range jumps {
+		c.fs.fn.Instrs[pos].B = target
+	}
+}
+
+func (c *Compiler) defineGlobal(name string) int {
+	index := len(c.names)
+	c.globals[name] = index
+	c.names = append(c.names, name)
+	return index
+}
+
+// Compiles the block so that its value ends up in dst: the value of its
+// last expression statement, or null if it does not end with one.
+func (c *Compiler) block(b *ast.BlockStatement, dst int) error {
+	for i, s := range b.Statements {
+		if es, ok := s.(*ast.ExpressionStatement); ok && i == len(b.Statements)-1 {
+			defer c.at(es.Token)()
+			return c.expression(es.Expression, dst)
+		}
+		if err := c.statement(s); err != nil {
+			return err
+		}
+	}
+
+	c.emit(LOADNULL, dst, 0, 0)
+	return nil
+}
+
+// Compiles the expression so that its value ends up in register dst.
+func (c *Compiler) expression(e ast.Expression, dst int) error {
+	mark := c.fs.free
+	defer func() { c.fs.free = mark }()
+
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		defer c.at(e.Token)()
+		c.emit(LOADK, dst, c.constant(e.Value), 0)
+
+	case *ast.StringLiteral:
+		defer c.at(e.Token)()
+		c.emit(LOADK, dst, c.addConstant(&object.String{Value: e.Value}), 0)
+
+	case *ast.Boolean:
+		defer c.at(e.Token)()
+		b := 0
+		if e.Value {
+			b = 1
+		}
+		c.emit(LOADBOOL, dst, b, 0)
+
+	case *ast.Identifier:
+		defer c.at(e.Token)()
+		v, err := c.resolve(e.Value)
+		if err != nil {
+			return err
+		}
+		c.load(v, dst)
+
+	case *ast.PrefixExpression:
+		defer c.at(e.Token)()
+		rk, err := c.operand(e.Right)
+		if err != nil {
+			return err
+		}
+		switch e.Operator {
+		case "!":
+			c.emit(NOT, dst, rk, 0)
+		case "-":
+			c.emit(NEG, dst, rk, 0)
+		default:
+			return fmt.Errorf("unknown operator %s", e.Operator)
+		}
+
+	case *ast.InfixExpression:
+		left, err := c.operand(e.Left)
+		if err != nil {
+			return err
+		}
+		right, err := c.operand(e.Right)
+		if err != nil {
+			return err
+		}
+
+		// blame the operator for errors of the operation
+		defer c.at(e.Token)()
+
+		op, ok := infixOpcodes[e.Operator]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", e.Operator)
+		}
+		c.emit(op, dst, left, right)
+
+	case *ast.AssignExpression:
+		switch target := e.Target.(type) {
+		case *ast.Identifier:
+			return c.assignVariable(e, target, dst)
+		case *ast.IndexExpression:
+			return c.assignIndex(e, target, dst)
+		default:
+			return fmt.Errorf("cannot assign to %s", e.Target)
+		}
+
+	case *ast.IfExpression:
+		defer c.at(e.Token)()
+		cond, err := c.operand(e.Condition)
+		if err != nil {
+			return err
+		}
+
+		jumpIfNot := c.emit(JMPIFNOT, cond, 0, 0)
+		if err := c.block(e.Consequence, dst); err != nil {
+			return err
+		}
+
+		jump := c.emit(JMP, 0, 0, 0)
+		c.fs.fn.Instrs[jumpIfNot].B = len(c.fs.fn.Instrs)
+		if e.Alternative == nil {
+			c.emit(LOADNULL, dst, 0, 0)
+		} else if err := c.block(e.Alternative, dst); err != nil {
+			return err
+		}
+		c.fs.fn.Instrs[jump].B = len(c.fs.fn.Instrs)
+
+	case *ast.MatchExpression:
+		defer c.at(e.Token)()
+		value := c.allocRegister()
+		if err := c.expression(e.Value, value); err != nil {
+			return err
+		}
+
+		var ends []int
+		for _, arm := range e.Arms {
+			mark := c.fs.free
+			var fails []int
+			if err := c.matchArm(arm, value, dst, &fails); err != nil {
+				return err
+			}
+			ends = append(ends, c.emit(JMP, 0, 0, 0))
+			for _, pos := range fails {
+				c.fs.fn.Instrs[pos].B = len(c.fs.fn.Instrs)
+			}
+			c.fs.free = mark
+		}
+
+		c.emit(LOADNULL, dst, 0, 0)
+		for _, pos := range ends {
+			c.fs.fn.Instrs[pos].B = len(c.fs.fn.Instrs)
+		}
+
+	case *ast.FunctionLiteral:
+		defer c.at(e.Token)()
+		fn, err := c.function(e)
+		if err != nil {
+			return err
+		}
+		// only functions nested in others have an environment to capture
+		if c.fs.fn.Captured {
+			c.emit(CLOSURE, dst, c.addConstant(fn), 0)
+		} else {
+			c.emit(LOADK, dst, c.addConstant(fn), 0)
+		}
+
+	case *ast.ArrayLiteral:
+		base := c.consecutive(e.Elements)
+		if err := c.expressions(e.Elements, base); err != nil {
+			return err
+		}
+		defer c.at(e.Token)()
+		c.emit(NEWARRAY, dst, base, len(e.Elements))
+
+	case *ast.HashLiteral:
+		elements := make([]ast.Expression, 0, len(e.Pairs)*2)
+		for _, pair := range e.Pairs {
+			elements = append(elements, pair.Key, pair.Value)
+		}
+		base := c.consecutive(elements)
+		if err := c.expressions(elements, base); err != nil {
+			return err
+		}
+		defer c.at(e.Token)()
+		c.emit(NEWHASH, dst, base, len(elements))
+
+	case *ast.IndexExpression:
+		left, err := c.operand(e.Left)
+		if err != nil {
+			return err
+		}
+		index, err := c.operand(e.Index)
+		if err != nil {
+			return err
+		}
+		defer c.at(e.Token)()
+		c.emit(INDEX, dst, left, index)
+
+	case *ast.RangeExpression:
+		step := e.Step
+		if step == nil {
+			step = &ast.IntegerLiteral{Token: e.Token, Value: 1}
+		}
+		bounds := []ast.Expression{e.Start, e.End, step}
+		base := c.consecutive(bounds)
+		if err := c.expressions(bounds, base); err != nil {
+			return err
+		}
+		defer c.at(e.Token)()
+		inclusive := 0
+		if e.Inclusive {
+			inclusive = 1
+		}
+		c.emit(RANGE, dst, base, inclusive)
+
+	case *ast.CallExpression:
+		// the callee and its arguments go to consecutive registers, which
+		// become the first registers of the callee's window
+		base := c.allocRegister()
+		if err := c.expression(e.Function, base); err != nil {
+			return err
+		}
+		if spreads(e.Arguments) {
+			return c.callSpread(e, base, dst)
+		}
+		for range e.Arguments {
+			c.allocRegister()
+		}
+		for i, a := range e.Arguments {
+			if err := c.expression(a, base+1+i); err != nil {
+				return err
+			}
+		}
+
+		defer c.at(e.Token)()
+		if c.fs.tailCalls[e] {
+			c.emit(TAILCALL, base, len(e.Arguments), 0)
+		} else {
+			c.emit(CALL, base, len(e.Arguments), 0)
+		}
+		if dst != base {
+			c.emit(MOVE, dst, base, 0)
+		}
+
+	default:
+		return fmt.Errorf("cannot compile %T", e)
+	}
+
+	return nil
+}
+
+// Reports whether some of the arguments are spread.
+func spreads(args []ast.Expression) bool {
+	for _, a := range args {
+		if _, ok := a.(*ast.SpreadExpression); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Compiles a call spreading some of its arguments, whose callee is in the
+// base register. The arguments are passed as arrays in the registers after
+// it: the spread values and the runs of other arguments between them.
+func (c *Compiler) callSpread(e *ast.CallExpression, base, dst int) error {
+	var arrays []ast.Expression
+	var run *ast.ArrayLiteral
+	for _, a := range e.Arguments {
+		if spread, ok := a.(*ast.SpreadExpression); ok {
+			arrays = append(arrays, spread.Value)
+			run = nil
+			continue
+		}
+		if run == nil {
+			run = &ast.ArrayLiteral{Token: e.Token}
+			arrays = append(arrays, run)
+		}
+		run.Elements = append(run.Elements, a)
+	}
+
+	for range arrays {
+		c.allocRegister()
+	}
+	if err := c.expressions(arrays, base+1); err != nil {
+		return err
+	}
+
+	defer c.at(e.Token)()
+	if c.fs.tailCalls[e] {
+		c.emit(TAILSPREAD, base, len(arrays), 0)
+	} else {
+		c.emit(CALLSPREAD, base, len(arrays), 0)
+	}
+	if dst != base {
+		c.emit(MOVE, dst, base, 0)
+	}
+	return nil
+}
+
+// Allocates consecutive registers for the expressions and returns the
+// first.
+func (c *Compiler) consecutive(es []ast.Expression) int {
+	base := c.fs.free
+	for range es {
+		c.allocRegister()
+	}
+	return base
+}
+
+// Compiles the expressions into the registers from base on.
+func (c *Compiler) expressions(es []ast.Expression, base int) error {
+	for i, e := range es {
+		if err := c.expression(e, base+i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Compiles an assignment to a variable. The assigned value is the value of
+// the expression and ends up in dst.
+func (c *Compiler) assignVariable(e *ast.AssignExpression, target *ast.Identifier, dst int) error {
+	v, err := c.resolve(target.Value)
+	if err != nil {
+		return err
+	}
+	if v.constant {
+		return assignedConstant(e)
+	}
+
+	if e.Operator == "" {
+		if v.scope == registerScope {
+			if err := c.expression(e.Value, v.index); err != nil {
+				return err
+			}
+			c.load(v, dst)
+			return nil
+		}
+		if err := c.expression(e.Value, dst); err != nil {
+			return err
+		}
+		defer c.at(e.Token)()
+		c.store(v, dst)
+		return nil
+	}
+
+	// the current value is read before the operand is evaluated
+	current := c.allocRegister()
+	c.load(v, current)
+	operand, err := c.operand(e.Value)
+	if err != nil {
+		return err
+	}
+
+	defer c.at(e.Token)()
+	op, ok := infixOpcodes[e.Operator]
+	if !ok {
+		return fmt.Errorf("unknown operator %s", e.Operator)
+	}
+	if v.scope != registerScope {
+		c.emit(op, dst, current, operand)
+		c.store(v, dst)
+		return nil
+	}
+	c.emit(op, v.index, current, operand)
+	c.load(v, dst)
+	return nil
+}
+
+// Compiles an assignment to an element of an array or a hash, which
+// evaluates the indexed object and the index once even for compound
+// assignments.
+func (c *Compiler) assignIndex(e *ast.AssignExpression, target *ast.IndexExpression, dst int) error {
+	left, err := c.operand(target.Left)
+	if err != nil {
+		return err
+	}
+	index, err := c.operand(target.Index)
+	if err != nil {
+		return err
+	}
+
+	defer c.at(e.Token)()
+	value := c.allocRegister()
+	if e.Operator == "" {
+		if err := c.expression(e.Value, value); err != nil {
+			return err
+		}
+	} else {
+		c.emit(INDEX, value, left, index)
+		operand, err := c.operand(e.Value)
+		if err != nil {
+			return err
+		}
+		op, ok := infixOpcodes[e.Operator]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", e.Operator)
+		}
+		c.emit(op, value, value, operand)
+	}
+
+	c.emit(SETINDEX, left, index, value)
+	c.emit(MOVE, dst, value, 0)
+	return nil
+}
+
+var infixOpcodes = map[string]Opcode{
+	"+":  ADD,
+	"-":  SUB,
+	"*":  MUL,
+	"/":  DIV,
+	"%":  MOD,
+	"**": POW,
+	"==": EQ,
+	"!=": NE,
+	">":  GT,
+	"<":  LT,
+}
+
+// Compiles the expression to an RK operand. Integer literals and locals
+// are used in place, everything else is evaluated into a temporary register
+// that stays allocated until the enclosing expression is compiled.
+func (c *Compiler) operand(e ast.Expression) (int, error) {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return constantOperand(c.constant(e.Value)), nil
+	case *ast.Identifier:
+		if reg, ok := c.local(e.Value); ok {
+			return reg, nil
+		}
+	}
+
+	reg := c.allocRegister()
+	return reg, c.expression(e, reg)
+}
+
+func (c *Compiler) function(e *ast.FunctionLiteral) (*Function, error) {
+	required, _ := e.Arity()
+	fs := newFuncState(c.fs, &Function{
+		NumParams:   len(e.Parameters),
+		NumDefaults: len(e.Parameters) - required,
+		Variadic:    e.Rest != nil,
+		Name:        e.Name,
+		Captured:    e.NestsFunctions(),
+	})
+	c.fs = fs
+	defer func() { c.fs = fs.parent }()
+
+	params := e.Parameters
+	if e.Rest != nil {
+		params = append(params[:len(params):len(params)], e.Rest)
+	}
+	for i, p := range params {
+		fs.registers[p.Value] = i
+		fs.defined[p.Value] = true
+		if fs.fn.Captured {
+			// the call copies the arguments to the first slots
+			c.defineSlot(p.Value)
+		} else {
+			declareLocals(fs, e.Default(i))
+		}
+	}
+	fs.free = len(params)
+	if e.Body != nil && !fs.fn.Captured {
+		declareLocals(fs, e.Body)
+	}
+	fs.fn.NumRegs = fs.free
+	fs.tailCalls = ast.TailCalls(e)
+
+	// missing arguments are null, which the defaults replace in order
+	for i, p := range e.Parameters {
+		if d := e.Default(i); d != nil {
+			jump := c.emit(JMPNOTNULL, i, 0, 0)
+			if err := c.expression(d, i); err != nil {
+				return nil, err
+			}
+			if fs.fn.Captured {
+				c.store(variable{scope: envScope, index: fs.slots[p.Value]}, i)
+			}
+			fs.fn.Instrs[jump].B = len(fs.fn.Instrs)
+		}
+	}
+
+	if e.Body != nil {
+		result := c.allocRegister()
+		if err := c.block(e.Body, result); err != nil {
+			return nil, err
+		}
+		c.emit(RETURN, result, 0, 0)
+	}
+
+	return fs.fn, nil
+}
+
+// Assigns a register to every let of the function body, so that locals
+// never collide with the temporaries of the expressions around them.
+func declareLocals(fs *funcState, node ast.Node) {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			declareLocals(fs, s)
+		}
+	case *ast.LetStatement:
+		if node.Pattern != nil {
+			declarePattern(fs, node.Pattern)
+		} else {
+			declareLocal(fs, node.Name.Value)
+		}
+		declareLocals(fs, node.Value)
+	case *ast.ReturnStatement:
+		declareLocals(fs, node.ReturnValue)
+	case *ast.WhileStatement:
+		declareLocals(fs, node.Condition)
+		declareLocals(fs, node.Body)
+	case *ast.ForStatement:
+		declareLocals(fs, node.Init)
+		declareLocals(fs, node.Condition)
+		declareLocals(fs, node.Update)
+		declareLocals(fs, node.Body)
+	case *ast.ForInStatement:
+		for _, v := range node.Variables {
+			declareLocal(fs, v.Value)
+		}
+		declareLocals(fs, node.Iterable)
+		declareLocals(fs, node.Body)
+	case *ast.ExpressionStatement:
+		declareLocals(fs, node.Expression)
+	case *ast.PrefixExpression:
+		declareLocals(fs, node.Right)
+	case *ast.InfixExpression:
+		declareLocals(fs, node.Left)
+		declareLocals(fs, node.Right)
+	case *ast.AssignExpression:
+		declareLocals(fs, node.Target)
+		declareLocals(fs, node.Value)
+	case *ast.IfExpression:
+		declareLocals(fs, node.Condition)
+		declareLocals(fs, node.Consequence)
+		if node.Alternative != nil {
+			declareLocals(fs, node.Alternative)
+		}
+	case *ast.MatchExpression:
+		declareLocals(fs, node.Value)
+		for _, arm := range node.Arms {
+			declarePattern(fs, arm.Pattern)
+			declareLocals(fs, arm.Guard)
+			declareLocals(fs, arm.Body)
+		}
+	case *ast.CallExpression:
+		declareLocals(fs, node.Function)
+		for _, a := range node.Arguments {
+			declareLocals(fs, a)
+		}
+	case *ast.SpreadExpression:
+		declareLocals(fs, node.Value)
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			declareLocals(fs, el)
+		}
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			declareLocals(fs, pair.Key)
+			declareLocals(fs, pair.Value)
+		}
+	case *ast.IndexExpression:
+		declareLocals(fs, node.Left)
+		declareLocals(fs, node.Index)
+	case *ast.RangeExpression:
+		declareLocals(fs, node.Start)
+		declareLocals(fs, node.End)
+		if node.Step != nil {
+			declareLocals(fs, node.Step)
+		}
+	}
+}
+
+// Declares the names bound by a pattern and the locals of its default
+// values.
+func declarePattern(fs *funcState, pattern ast.Pattern) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		declareLocal(fs, pattern.Value)
+	case *ast.ArrayPattern:
+		for _, e := range pattern.Elements {
+			declarePattern(fs, e)
+		}
+		if pattern.Rest != nil {
+			declareLocal(fs, pattern.Rest.Value)
+		}
+	case *ast.HashPattern:
+		for _, pair := range pattern.Pairs {
+			declarePattern(fs, pair.Value)
+		}
+	case *ast.DefaultPattern:
+		declarePattern(fs, pattern.Target)
+		declareLocals(fs, pattern.Default)
+	}
+}
+
+func declareLocal(fs *funcState, name string) {
+	if _, ok := fs.registers[name]; !ok {
+		fs.registers[name] = fs.free
+		fs.free++
+	}
+}
+
+// Returns the register of a local of the current function.
+func (c *Compiler) local(name string) (int, bool) {
+	if c.fs.parent == nil || c.fs.fn.Captured || !c.fs.defined[name] {
+		return 0, false
+	}
+	return c.fs.registers[name], true
+}
+
+// variable is where the value of a name is kept.
+type variable struct {
+	scope    scope
+	index    int  // of the register, the environment slot or the global
+	depth    int  // environments to go up for envScope variables
+	constant bool // declared with const
+}
+
+type scope int
+
+const (
+	registerScope scope = iota
+	envScope
+	globalScope
+)
+
+// Resolves name to a register of the current function, a slot of the
+// environment of an enclosing one or a global. Only captured functions have
+// an environment, so the depth counts those between the current function
+// and the one defining name.
+func (c *Compiler) resolve(name string) (variable, error) {
+	if reg, ok := c.local(name); ok {
+		return variable{scope: registerScope, index: reg, constant: c.fs.consts[name]}, nil
+	}
+	depth := 0
+	for fs := c.fs; fs.parent != nil; fs = fs.parent {
+		if !fs.fn.Captured {
+			continue
+		}
+		if fs.defined[name] {
+			return variable{scope: envScope, index: fs.slots[name], depth: depth, constant: fs.consts[name]}, nil
+		}
+		depth++
+	}
+	if index, ok := c.globals[name]; ok {
+		return variable{scope: globalScope, index: index, constant: c.consts[name]}, nil
+	}
+	return variable{}, fmt.Errorf("undefined variable %s", name)
+}
+
+// Emits the instruction loading the value of v into register dst.
+func (c *Compiler) load(v variable, dst int) {
+	switch v.scope {
+	case registerScope:
+		if v.index != dst {
+			c.emit(MOVE, dst, v.index, 0)
+		}
+	case envScope:
+		c.emit(GETENV, dst, v.depth, v.index)
+	case globalScope:
+		c.emit(GETGLOBAL, dst, v.index, 0)
+	}
+}
+
+// Emits the instruction storing the value of register src in v.
+func (c *Compiler) store(v variable, src int) {
+	switch v.scope {
+	case registerScope:
+		if v.index != src {
+			c.emit(MOVE, v.index, src, 0)
+		}
+	case envScope:
+		c.emit(SETENV, src, v.depth, v.index)
+	case globalScope:
+		c.emit(SETGLOBAL, src, v.index, 0)
+	}
+}
+
+// Binds name to a new slot of the environment of the current function,
+// which must be captured. A redeclared name gets a new slot so that the
+// closures made before keep the previous binding.
+func (c *Compiler) defineSlot(name string) variable {
+	fn := c.fs.fn
+	slot := fn.EnvSize
+	fn.EnvSize++
+	c.fs.slots[name] = slot
+	c.fs.defined[name] = true
+	return variable{scope: envScope, index: slot}
+}
+
+// Binds name to a new variable holding the value of register src, which
+// shadows the binding of name in the current function or the main program:
+// a new slot in captured functions, a new register in the others and a new
+// global in the main program.
+func (c *Compiler) bindNew(name string, src int) {
+	switch {
+	case c.fs.fn.Captured:
+		c.store(c.defineSlot(name), src)
+		delete(c.fs.consts, name)
+	case c.fs.parent != nil:
+		reg := c.allocRegister()
+		c.emit(MOVE, reg, src, 0)
+		c.fs.registers[name] = reg
+		c.fs.defined[name] = true
+		delete(c.fs.consts, name)
+	default:
+		c.emit(SETGLOBAL, src, c.defineGlobal(name), 0)
+		delete(c.consts, name)
+	}
+}
+
+// Returns a function restoring the bindings of names as they are now,
+// which ends the scope of the variables bound to them in between by
+// bindNew. Their registers, slots and globals are not reused.
+func (c *Compiler) shadow(names []string) func() {
+	var restores []func()
+	if fs := c.fs; fs.parent != nil {
+		restores = append(restores, saveInts(fs.registers, names), saveInts(fs.slots, names),
+			saveBools(fs.defined, names), saveBools(fs.consts, names))
+	} else {
+		restores = append(restores, saveInts(c.globals, names), saveBools(c.consts, names))
+	}
+	return func() {
+		for _, restore := range restores {
+			restore()
+		}
+	}
+}
+
+// Returns a function restoring the entries of names in m as they are now.
+func saveInts(m map[string]int, names []string) func() {
+	saved := make(map[string]int, len(names))
+	for _, name := range names {
+		if v, ok := m[name]; ok {
+			saved[name] = v
+		}
+	}
+	return func() {
+		for _, name := range names {
+			if v, ok := saved[name]; ok {
+				m[name] = v
+			} else {
+				delete(m, name)
+			}
+		}
+	}
+}
+
+// Returns a function restoring the entries of names in m as they are now.
+func saveBools(m map[string]bool, names []string) func() {
+	saved := make(map[string]bool, len(names))
+	for _, name := range names {
+		if v, ok := m[name]; ok {
+			saved[name] = v
+		}
+	}
+	return func() {
+		for _, name := range names {
+			if v, ok := saved[name]; ok {
+				m[name] = v
+			} else {
+				delete(m, name)
+			}
+		}
+	}
+}
+
+// Reports whether name is declared with const in the current scope, the
+// current function or the main program.
+func (c *Compiler) isConstant(name string) bool {
+	if c.fs.parent != nil {
+		return c.fs.consts[name]
+	}
+	return c.consts[name]
+}
+
+func (c *Compiler) declareConstant(name string) {
+	if c.fs.parent != nil {
+		c.fs.consts[name] = true
+	} else {
+		c.consts[name] = true
+	}
+}
+
+// Returns the error for redeclaring a constant in its own scope, positioned
+// at the redeclared name.
+func redeclaredConstant(name *ast.Identifier) error {
+	return fmt.Errorf("%d:%d: cannot redeclare constant %s", name.Token.Line, name.Token.Column, name.Value)
+}
+
+// Returns the error for assigning to a constant, positioned at the
+// assignment operator.
+func assignedConstant(e *ast.AssignExpression) error {
+	return fmt.Errorf("%d:%d: cannot assign to constant %s", e.Token.Line, e.Token.Column, e.Target)
+}
+
+func (c *Compiler) allocRegister() int {
+	reg := c.fs.free
+	c.fs.free++
+	if c.fs.free > c.fs.fn.NumRegs {
+		c.fs.fn.NumRegs = c.fs.free
+	}
+	return reg
+}
+
+// Returns the index of an integer constant, adding it to the constants of
+// the function the first time.
+func (c *Compiler) constant(value int64) int {
+	if i, ok := c.fs.constants[value]; ok {
+		return i
+	}
+	i := c.addConstant(&object.Integer{Value: value})
+	c.fs.constants[value] = i
+	return i
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.fs.fn.Constants = append(c.fs.fn.Constants, obj)
+	return len(c.fs.fn.Constants) - 1
+}
+
+// Appends the instruction and returns its index.
+func (c *Compiler) emit(op Opcode, a, b, cc int) int {
+	fn := c.fs.fn
+	fn.Instrs = append(fn.Instrs, Instr{Op: op, A: a, B: b, C: cc})
+	pos := len(fn.Instrs) - 1
+	if c.line > 0 {
+		fn.SourceMap = fn.SourceMap.Add(pos, c.line, c.column)
+	}
+	return pos
+}
+
+// Attributes the instructions emitted from now on to the position of tok,
+// until the returned function restores the previous position.
+func (c *Compiler) at(tok token.Token) func() {
+	line, column := c.line, c.column
+	c.line, c.column = tok.Line, tok.Column
+	return func() {
+		c.line, c.column = line, column
+	}
+}
@@ -0,0 +1,259 @@
+package regvm
+
+import "testing"
+
+func TestCompile(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			"1 + 2 * 3",
+			"0000 MUL R1 K1 K2\n" +
+				"0001 ADD R0 K0 R1\n" +
+				"0002 RETURN R0\n",
+		},
+		{
+			"let a = -1; !a",
+			"0000 NEG R1 K0\n" +
+				"0001 SETGLOBAL R1 G0\n" +
+				"0002 GETGLOBAL R1 G0\n" +
+				"0003 NOT R0 R1\n" +
+				"0004 RETURN R0\n",
+		},
+		{
+			"let [a, b = 2] = [1]; let {c} = {}",
+			"0000 LOADK R2 K0\n" +
+				"0001 NEWARRAY R1 R2 1\n" +
+				"0002 CHECKARRAY R1 1 2\n" +
+				"0003 INDEX R2 R1 K1\n" +
+				"0004 SETGLOBAL R2 G0\n" +
+				"0005 INDEX R3 R1 K0\n" +
+				"0006 JMPNOTNULL R3 8\n" +
+				"0007 LOADK R3 K2\n" +
+				"0008 SETGLOBAL R3 G1\n" +
+				"0009 NEWHASH R1 R2 0\n" +
+				"0010 CHECKHASH R1\n" +
+				"0011 FIELD R2 R1 K3\n" +
+				"0012 SETGLOBAL R2 G2\n" +
+				"0013 LOADNULL R0\n" +
+				"0014 RETURN R0\n",
+		},
+		{
+			"if (true) { 1 }",
+			"0000 LOADBOOL R1 1\n" +
+				"0001 JMPIFNOT R1 4\n" +
+				"0002 LOADK R0 K0\n" +
+				"0003 JMP 5\n" +
+				"0004 LOADNULL R0\n" +
+				"0005 RETURN R0\n",
+		},
+		{
+			"match (1) { [a] => a, {k} if k => 2, 3 => 4 }",
+			"0000 LOADK R1 K0\n" +
+				"0001 TESTARRAY R1 1 1\n" +
+				"0002 JMP 7\n" +
+				"0003 INDEX R2 R1 K1\n" +
+				"0004 SETGLOBAL R2 G0\n" +
+				"0005 GETGLOBAL R0 G0\n" +
+				"0006 JMP 22\n" +
+				"0007 TESTHASH R1\n" +
+				"0008 JMP 17\n" +
+				"0009 HASKEY R1 K2\n" +
+				"0010 JMP 17\n" +
+				"0011 INDEX R2 R1 K2\n" +
+				"0012 SETGLOBAL R2 G1\n" +
+				"0013 GETGLOBAL R3 G1\n" +
+				"0014 JMPIFNOT R3 17\n" +
+				"0015 LOADK R0 K3\n" +
+				"0016 JMP 22\n" +
+				"0017 EQ R2 R1 K4\n" +
+				"0018 JMPIFNOT R2 21\n" +
+				"0019 LOADK R0 K5\n" +
+				"0020 JMP 22\n" +
+				"0021 LOADNULL R0\n" +
+				"0022 RETURN R0\n",
+		},
+		{
+			"let f = fn(a) { a }; f(2)",
+			"0000 LOADK R1 K0\n" +
+				"0001 SETGLOBAL R1 G0\n" +
+				"0002 GETGLOBAL R1 G0\n" +
+				"0003 LOADK R2 K1\n" +
+				"0004 CALL R1 1\n" +
+				"0005 MOVE R0 R1\n" +
+				"0006 RETURN R0\n",
+		},
+		{
+			"for (k, v in [1]) { }",
+			"0000 LOADK R5 K0\n" +
+				"0001 NEWARRAY R4 R5 1\n" +
+				"0002 ITER R1 R4\n" +
+				"0003 ITERNEXT R1 7 2\n" +
+				"0004 SETGLOBAL R2 G0\n" +
+				"0005 SETGLOBAL R3 G1\n" +
+				"0006 JMP 3\n" +
+				"0007 LOADNULL R0\n" +
+				"0008 RETURN R0\n",
+		},
+		{
+			"let a = [1]; a[0] += 2",
+			"0000 LOADK R2 K0\n" +
+				"0001 NEWARRAY R1 R2 1\n" +
+				"0002 SETGLOBAL R1 G0\n" +
+				"0003 GETGLOBAL R1 G0\n" +
+				"0004 INDEX R2 R1 K1\n" +
+				"0005 ADD R2 R2 K2\n" +
+				"0006 SETINDEX R1 K1 R2\n" +
+				"0007 MOVE R0 R2\n" +
+				"0008 RETURN R0\n",
+		},
+		{
+			// the runs of arguments between spread ones become arrays
+			input: "let f = fn(...xs) { xs }; f(1, ...[2])",
+			expected: "0000 LOADK R1 K0\n" +
+				"0001 SETGLOBAL R1 G0\n" +
+				"0002 GETGLOBAL R1 G0\n" +
+				"0003 LOADK R4 K1\n" +
+				"0004 NEWARRAY R2 R4 1\n" +
+				"0005 LOADK R4 K2\n" +
+				"0006 NEWARRAY R3 R4 1\n" +
+				"0007 CALLSPREAD R1 2\n" +
+				"0008 MOVE R0 R1\n" +
+				"0009 RETURN R0\n",
+		},
+	}
+
+	for _, tt := range tests {
+		program, err := Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		if actual := program.Main.String(); actual != tt.expected {
+			t.Errorf("wrong instructions for %q.\nexpected=%q\ngot=%q", tt.input, tt.expected, actual)
+		}
+	}
+}
+
+func TestCompileFunction(t *testing.T) {
+	program, err := Compile(parse("let f = fn(a, b) { let c = a * b; c - 1 }"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	fn, ok := program.Main.Constants[0].(*Function)
+	if !ok {
+		t.Fatalf("constant is not a function. got=%T", program.Main.Constants[0])
+	}
+
+	// parameters in R0 and R1, the local c in R2 and the result in R3
+	expected := "0000 MUL R2 R0 R1\n" +
+		"0001 SUB R3 R2 K0\n" +
+		"0002 RETURN R3\n"
+	if actual := fn.String(); actual != expected {
+		t.Errorf("wrong instructions.\nexpected=%q\ngot=%q", expected, actual)
+	}
+	if fn.NumParams != 2 || fn.NumRegs != 4 || fn.Name != "f" {
+		t.Errorf("wrong function metadata: %+v", fn)
+	}
+}
+
+func TestCompileClosure(t *testing.T) {
+	program, err := Compile(parse("let f = fn(a) { let b = a + 1; fn() { a + b } }"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	outer, ok := program.Main.Constants[0].(*Function)
+	if !ok {
+		t.Fatalf("constant is not a function. got=%T", program.Main.Constants[0])
+	}
+
+	// the argument a is copied to slot 0 of the environment, b goes to
+	// slot 1
+	expected := "0000 GETENV R3 E0 0\n" +
+		"0001 ADD R2 R3 K0\n" +
+		"0002 SETENV R2 E0 1\n" +
+		"0003 CLOSURE R1 K1\n" +
+		"0004 RETURN R1\n"
+	if actual := outer.String(); actual != expected {
+		t.Errorf("wrong instructions.\nexpected=%q\ngot=%q", expected, actual)
+	}
+	if !outer.Captured || outer.EnvSize != 2 {
+		t.Errorf("wrong function metadata: %+v", outer)
+	}
+
+	inner, ok := outer.Constants[1].(*Function)
+	if !ok {
+		t.Fatalf("constant is not a function. got=%T", outer.Constants[1])
+	}
+
+	// the closure has no environment of its own
+	expected = "0000 GETENV R1 E0 0\n" +
+		"0001 GETENV R2 E0 1\n" +
+		"0002 ADD R0 R1 R2\n" +
+		"0003 RETURN R0\n"
+	if actual := inner.String(); actual != expected {
+		t.Errorf("wrong instructions.\nexpected=%q\ngot=%q", expected, actual)
+	}
+	if inner.Captured {
+		t.Errorf("wrong function metadata: %+v", inner)
+	}
+}
+
+func TestCompileFunctionParameters(t *testing.T) {
+	program, err := Compile(parse("let f = fn(a, b = 2, ...c) { b }"))
+	if err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+
+	fn, ok := program.Main.Constants[0].(*Function)
+	if !ok {
+		t.Fatalf("constant is not a function. got=%T", program.Main.Constants[0])
+	}
+
+	// the default replaces a null b, the rest parameter c is in R2
+	expected := "0000 JMPNOTNULL R1 2\n" +
+		"0001 LOADK R1 K0\n" +
+		"0002 MOVE R3 R1\n" +
+		"0003 RETURN R3\n"
+	if actual := fn.String(); actual != expected {
+		t.Errorf("wrong instructions.\nexpected=%q\ngot=%q", expected, actual)
+	}
+	if fn.NumParams != 2 || fn.NumDefaults != 1 || !fn.Variadic || fn.NumRegs != 4 {
+		t.Errorf("wrong function metadata: %+v", fn)
+	}
+}
+
+func TestCompilerErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x", "undefined variable x"},
+		{"let x = x;", "undefined variable x"},
+		{"return 1;", "return outside of a function"},
+		{"fn() { a; let a = 1; }", "undefined variable a"},
+		{"x += 1", "undefined variable x"},
+		{"const x = 1; x = 2", "1:16: cannot assign to constant x"},
+		{"const x = 1; let x = 2;", "1:18: cannot redeclare constant x"},
+		{"const x = 1; for (x in []) {}", "1:19: cannot redeclare constant x"},
+		{"const x = 1; fn() { x += 2 };", "1:23: cannot assign to constant x"},
+		{"fn() { const x = 1; let x = 2; };", "1:25: cannot redeclare constant x"},
+		{"fn() { const x = 1; x = 2 };", "1:23: cannot assign to constant x"},
+		{"const [x, {y}] = [1, {}]; let [y] = [2];", "1:32: cannot redeclare constant y"},
+		{"fn() { const [x] = [1]; x += 1 };", "1:27: cannot assign to constant x"},
+		{"fn() { const x = 1; fn() { x = 2 } };", "1:30: cannot assign to constant x"},
+	}
+
+	for _, tt := range tests {
+		_, err := Compile(parse(tt.input))
+		if err == nil {
+			t.Fatalf("expected compiler error for %q", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("expected error %q, got=%q", tt.expected, err)
+		}
+	}
+}
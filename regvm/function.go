@@ -0,0 +1,252 @@
+// Package regvm implements a register-based backend for Monkey: a compiler
+// from ast.Program to three-address instructions and an interpreter for
+// them.
+//
+// Every function gets a window of registers on a shared register stack.
+// Parameters occupy the first registers, followed by the locals of the
+// function and the temporaries of the expression being evaluated. The
+// locals of functions containing other functions live in an environment
+// created by each call instead, shared with the closures it makes. Operands
+// marked RK name either a register or, when negative, a constant of the
+// function: -1 is the first constant, -2 the second and so on.
+package regvm
+
+import (
+	"bytes"
+	"fmt"
+
+	"interpreter/code"
+	"interpreter/object"
+)
+
+type Opcode byte
+
+const (
+	LOADK      Opcode = iota // R[A] = K[B]
+	LOADBOOL                 // R[A] = B != 0
+	LOADNULL                 // R[A] = null
+	MOVE                     // R[A] = R[B]
+	GETGLOBAL                // R[A] = G[B]
+	SETGLOBAL                // G[B] = R[A]
+	ADD                      // R[A] = RK(B) + RK(C)
+	SUB                      // R[A] = RK(B) - RK(C)
+	MUL                      // R[A] = RK(B) * RK(C)
+	DIV                      // R[A] = RK(B) / RK(C)
+	MOD                      // R[A] = RK(B) % RK(C)
+	POW                      // R[A] = RK(B) ** RK(C)
+	EQ                       // R[A] = RK(B) == RK(C)
+	NE                       // R[A] = RK(B) != RK(C)
+	GT                       // R[A] = RK(B) > RK(C)
+	LT                       // R[A] = RK(B) < RK(C)
+	NEG                      // R[A] = -RK(B)
+	NOT                      // R[A] = !RK(B)
+	JMP                      // jump to B
+	JMPIFNOT                 // if !RK(A) jump to B
+	CALL                     // R[A] = R[A](R[A+1], ..., R[A+B])
+	RETURN                   // return RK(A)
+	RETURNNULL               // return null
+	TAILCALL                 // return R[A](R[A+1], ..., R[A+B])
+	NEWARRAY                 // R[A] = [R[B], ..., R[B+C-1]]
+	NEWHASH                  // R[A] = {R[B]: R[B+1], ..., R[B+C-2]: R[B+C-1]}
+	INDEX                    // R[A] = RK(B)[RK(C)]
+	SETINDEX                 // RK(A)[RK(B)] = RK(C)
+	RANGE                    // R[A] = R[B]..R[B+1] step R[B+2], inclusive if C != 0
+	ITER                     // R[A] = iterator over RK(B)
+	ITERNEXT                 // R[A+1], ... = C values of the next iteration of R[A], or jump to B
+	CHECKARRAY               // fail unless R[A] is an array of B to C elements, at least B if C < 0
+	CHECKHASH                // fail unless R[A] is a hash
+	REST                     // R[A] = R[B][C:]
+	FIELD                    // R[A] = R[B][RK(C)], failing if the key is missing
+	JMPNOTNULL               // if R[A] != null jump to B
+	TESTARRAY                // skip the next instruction if R[A] is an array of B to C elements, at least B if C < 0
+	TESTHASH                 // skip the next instruction if R[A] is a hash
+	HASKEY                   // skip the next instruction if the hash R[A] has the key RK(B)
+	CALLSPREAD               // R[A] = R[A](elements of the arrays R[A+1], ..., R[A+B])
+	TAILSPREAD               // return R[A](elements of the arrays R[A+1], ..., R[A+B])
+	CLOSURE                  // R[A] = closure of K[B] over the current environment
+	GETENV                   // R[A] = E(B)[C], the environment B levels up
+	SETENV                   // E(B)[C] = R[A]
+)
+
+var opcodeNames = [...]string{
+	LOADK:      "LOADK",
+	LOADBOOL:   "LOADBOOL",
+	LOADNULL:   "LOADNULL",
+	MOVE:       "MOVE",
+	GETGLOBAL:  "GETGLOBAL",
+	SETGLOBAL:  "SETGLOBAL",
+	ADD:        "ADD",
+	SUB:        "SUB",
+	MUL:        "MUL",
+	DIV:        "DIV",
+	MOD:        "MOD",
+	POW:        "POW",
+	EQ:         "EQ",
+	NE:         "NE",
+	GT:         "GT",
+	LT:         "LT",
+	NEG:        "NEG",
+	NOT:        "NOT",
+	JMP:        "JMP",
+	JMPIFNOT:   "JMPIFNOT",
+	CALL:       "CALL",
+	RETURN:     "RETURN",
+	RETURNNULL: "RETURNNULL",
+	TAILCALL:   "TAILCALL",
+	NEWARRAY:   "NEWARRAY",
+	NEWHASH:    "NEWHASH",
+	INDEX:      "INDEX",
+	SETINDEX:   "SETINDEX",
+	RANGE:      "RANGE",
+	ITER:       "ITER",
+	ITERNEXT:   "ITERNEXT",
+	CHECKARRAY: "CHECKARRAY",
+	CHECKHASH:  "CHECKHASH",
+	REST:       "REST",
+	FIELD:      "FIELD",
+	JMPNOTNULL: "JMPNOTNULL",
+	TESTARRAY:  "TESTARRAY",
+	TESTHASH:   "TESTHASH",
+	HASKEY:     "HASKEY",
+	CALLSPREAD: "CALLSPREAD",
+	TAILSPREAD: "TAILSPREAD",
+	CLOSURE:    "CLOSURE",
+	GETENV:     "GETENV",
+	SETENV:     "SETENV",
+}
+
+func (op Opcode) String() string {
+	if int(op) < len(opcodeNames) {
+		return opcodeNames[op]
+	}
+	return fmt.Sprintf("Opcode(%d)", op)
+}
+
+// Instr is a single instruction. Which operands are used depends on Op.
+type Instr struct {
+	Op      Opcode
+	A, B, C int
+}
+
+// Function is a compiled Monkey function. The main program is compiled to a
+// function without parameters.
+type Function struct {
+	Instrs      []Instr
+	Constants   []object.Object
+	SourceMap   code.SourceMap // indexed by instruction
+	NumParams   int
+	NumDefaults int  // trailing parameters with a default, null when missing
+	Variadic    bool // extra arguments go to an array in the register after the parameters
+	NumRegs     int  // size of the register window
+	Name        string
+
+	// Captured is set when the function contains nested functions, its
+	// locals then live in an Environment of EnvSize slots created by each
+	// call instead of in registers. The arguments are copied to the first
+	// slots.
+	Captured bool
+	EnvSize  int
+}
+
+// Returns the number of registers holding the arguments, which include the
+// rest parameter.
+func (f *Function) numParamRegs() int {
+	if f.Variadic {
+		return f.NumParams + 1
+	}
+	return f.NumParams
+}
+
+func (f *Function) Type() object.ObjectType {
+	return object.COMPILED_FUNCTION_OBJ
+}
+func (f *Function) Inspect() string {
+	if f.Name != "" {
+		return fmt.Sprintf("Function[%s]", f.Name)
+	}
+	return fmt.Sprintf("Function[%p]", f)
+}
+
+// Closure is a function created inside another one, along with the
+// environment it was defined in.
+type Closure struct {
+	Fn  *Function
+	Env *object.Environment
+}
+
+func (c *Closure) Type() object.ObjectType {
+	return object.CLOSURE_OBJ
+}
+func (c *Closure) Inspect() string {
+	if c.Fn.Name != "" {
+		return fmt.Sprintf("Closure[%s]", c.Fn.Name)
+	}
+	return fmt.Sprintf("Closure[%p]", c)
+}
+
+// String disassembles the instructions of the function.
+func (f *Function) String() string {
+	var out bytes.Buffer
+
+	for i, ins := range f.Instrs {
+		fmt.Fprintf(&out, "%04d %s", i, ins.Op)
+
+		switch ins.Op {
+		case LOADK, CLOSURE:
+			fmt.Fprintf(&out, " R%d K%d", ins.A, ins.B)
+		case GETENV, SETENV:
+			fmt.Fprintf(&out, " R%d E%d %d", ins.A, ins.B, ins.C)
+		case LOADBOOL, CALL, TAILCALL, CALLSPREAD, TAILSPREAD:
+			fmt.Fprintf(&out, " R%d %d", ins.A, ins.B)
+		case LOADNULL:
+			fmt.Fprintf(&out, " R%d", ins.A)
+		case MOVE:
+			fmt.Fprintf(&out, " R%d R%d", ins.A, ins.B)
+		case GETGLOBAL, SETGLOBAL:
+			fmt.Fprintf(&out, " R%d G%d", ins.A, ins.B)
+		case NEG, NOT:
+			fmt.Fprintf(&out, " R%d %s", ins.A, rkString(ins.B))
+		case NEWARRAY, NEWHASH, RANGE:
+			fmt.Fprintf(&out, " R%d R%d %d", ins.A, ins.B, ins.C)
+		case ITER:
+			fmt.Fprintf(&out, " R%d %s", ins.A, rkString(ins.B))
+		case SETINDEX:
+			fmt.Fprintf(&out, " %s %s %s", rkString(ins.A), rkString(ins.B), rkString(ins.C))
+		case ITERNEXT, CHECKARRAY, TESTARRAY:
+			fmt.Fprintf(&out, " R%d %d %d", ins.A, ins.B, ins.C)
+		case CHECKHASH, TESTHASH:
+			fmt.Fprintf(&out, " R%d", ins.A)
+		case HASKEY:
+			fmt.Fprintf(&out, " R%d %s", ins.A, rkString(ins.B))
+		case REST:
+			fmt.Fprintf(&out, " R%d R%d %d", ins.A, ins.B, ins.C)
+		case JMPNOTNULL:
+			fmt.Fprintf(&out, " R%d %d", ins.A, ins.B)
+		case JMP:
+			fmt.Fprintf(&out, " %d", ins.B)
+		case JMPIFNOT:
+			fmt.Fprintf(&out, " %s %d", rkString(ins.A), ins.B)
+		case RETURN:
+			fmt.Fprintf(&out, " %s", rkString(ins.A))
+		case RETURNNULL:
+		default:
+			fmt.Fprintf(&out, " R%d %s %s", ins.A, rkString(ins.B), rkString(ins.C))
+		}
+
+		out.WriteString("\n")
+	}
+
+	return out.String()
+}
+
+// Returns the RK operand referring to the constant with index i.
+func constantOperand(i int) int {
+	return -1 - i
+}
+
+func rkString(operand int) string {
+	if operand < 0 {
+		return fmt.Sprintf("K%d", -1-operand)
+	}
+	return fmt.Sprintf("R%d", operand)
+}
@@ -0,0 +1,631 @@
+package regvm
+
+import (
+	"fmt"
+
+	"interpreter/object"
+	"interpreter/vm"
+)
+
+// StackSize is the number of registers shared by all frames.
+const StackSize = 16384
+
+// MaxFrames matches the call depth of the stack VM.
+const MaxFrames = vm.MaxFrames
+
+type frame struct {
+	fn   *Function
+	pc   int                 // instruction being executed
+	base int                 // index of the first register of the window
+	env  *object.Environment // of the call if fn is captured, else of its closure
+}
+
+type VM struct {
+	program *Program
+
+	registers []object.Object
+	globals   []object.Object
+
+	frames []frame
+	result object.Object
+}
+
+func New(program *Program) *VM {
+	return &VM{
+		program:   program,
+		registers: make([]object.Object, StackSize),
+		globals:   make([]object.Object, len(program.Globals)),
+		frames:    make([]frame, 0, 16),
+	}
+}
+
+// Result returns the value of the program, see ast.Program.EndsWithExpression.
+func (m *VM) Result() object.Object {
+	return m.result
+}
+
+// Run executes the main function. Runtime errors are *vm.Error values so
+// that both backends report them the same way.
+func (m *VM) Run() error {
+	m.frames = append(m.frames[:0], frame{fn: m.program.Main})
+	if m.program.Main.NumRegs > len(m.registers) {
+		return &vm.Error{Err: fmt.Errorf("stack overflow")}
+	}
+
+	if err := m.run(); err != nil {
+		f := m.frames[len(m.frames)-1]
+		location, _ := f.fn.SourceMap.Lookup(f.pc)
+		return &vm.Error{Line: location.Line, Column: location.Column, Err: err}
+	}
+	return nil
+}
+
+func (m *VM) run() error {
+	f := &m.frames[len(m.frames)-1]
+	regs := m.registers[f.base:]
+	constants := f.fn.Constants
+
+	rk := func(operand int) object.Object {
+		if operand < 0 {
+			return constants[-1-operand]
+		}
+		return regs[operand]
+	}
+
+	for {
+		ins := f.fn.Instrs[f.pc]
+
+		switch ins.Op {
+		case LOADK:
+			regs[ins.A] = constants[ins.B]
+
+		case LOADBOOL:
+			regs[ins.A] = nativeBoolToBooleanObject(ins.B != 0)
+
+		case LOADNULL:
+			regs[ins.A] = vm.Null
+
+		case MOVE:
+			regs[ins.A] = regs[ins.B]
+
+		case GETGLOBAL:
+			regs[ins.A] = m.globals[ins.B]
+
+		case SETGLOBAL:
+			m.globals[ins.B] = regs[ins.A]
+
+		case GETENV:
+			regs[ins.A] = f.env.At(ins.B).Store[ins.C]
+
+		case SETENV:
+			f.env.At(ins.B).Store[ins.C] = regs[ins.A]
+
+		case CLOSURE:
+			regs[ins.A] = &Closure{Fn: constants[ins.B].(*Function), Env: f.env}
+
+		case ADD, SUB, MUL, DIV, MOD, POW:
+			result, err := binaryOperation(ins.Op, rk(ins.B), rk(ins.C))
+			if err != nil {
+				return err
+			}
+			regs[ins.A] = result
+
+		case EQ, NE, GT, LT:
+			result, err := comparison(ins.Op, rk(ins.B), rk(ins.C))
+			if err != nil {
+				return err
+			}
+			regs[ins.A] = result
+
+		case NEG:
+			operand, ok := rk(ins.B).(*object.Integer)
+			if !ok {
+				return fmt.Errorf("unsupported type for negation: %s", rk(ins.B).Type())
+			}
+			regs[ins.A] = &object.Integer{Value: -operand.Value}
+
+		case NOT:
+			regs[ins.A] = nativeBoolToBooleanObject(!isTruthy(rk(ins.B)))
+
+		case NEWARRAY:
+			elements := make([]object.Object, ins.C)
+			copy(elements, regs[ins.B:ins.B+ins.C])
+			regs[ins.A] = &object.Array{Elements: elements}
+
+		case NEWHASH:
+			hash, err := newHash(regs[ins.B : ins.B+ins.C])
+			if err != nil {
+				return err
+			}
+			regs[ins.A] = hash
+
+		case INDEX:
+			result, err := index(rk(ins.B), rk(ins.C))
+			if err != nil {
+				return err
+			}
+			regs[ins.A] = result
+
+		case SETINDEX:
+			if err := setIndex(rk(ins.A), rk(ins.B), rk(ins.C)); err != nil {
+				return err
+			}
+
+		case RANGE:
+			result, err := newRange(regs[ins.B], regs[ins.B+1], regs[ins.B+2], ins.C != 0)
+			if err != nil {
+				return err
+			}
+			regs[ins.A] = result
+
+		case ITER:
+			it, ok := object.NewIterator(rk(ins.B))
+			if !ok {
+				return fmt.Errorf("cannot iterate over %s", rk(ins.B).Type())
+			}
+			regs[ins.A] = it
+
+		case ITERNEXT:
+			it := regs[ins.A].(*object.Iterator)
+			key, value, ok := it.Next()
+			if !ok {
+				f.pc = ins.B
+				continue
+			}
+			switch {
+			case ins.C == 2:
+				regs[ins.A+1], regs[ins.A+2] = key, value
+			case it.Keys():
+				regs[ins.A+1] = key
+			default:
+				regs[ins.A+1] = value
+			}
+
+		case JMP:
+			f.pc = ins.B
+			continue
+
+		case CHECKARRAY:
+			if err := checkArray(regs[ins.A], ins.B, ins.C); err != nil {
+				return err
+			}
+
+		case CHECKHASH:
+			if regs[ins.A].Type() != object.HASH_OBJ {
+				return fmt.Errorf("cannot destructure %s as a hash", regs[ins.A].Type())
+			}
+
+		case REST:
+			elements := regs[ins.B].(*object.Array).Elements[ins.C:]
+			regs[ins.A] = &object.Array{Elements: append([]object.Object(nil), elements...)}
+
+		case FIELD:
+			key := rk(ins.C).(*object.String)
+			value, ok := regs[ins.B].(*object.Hash).Get(key)
+			if !ok {
+				return fmt.Errorf("missing key %q in destructured hash", key.Value)
+			}
+			regs[ins.A] = value
+
+		case JMPNOTNULL:
+			if regs[ins.A] != vm.Null {
+				f.pc = ins.B
+				continue
+			}
+
+		case TESTARRAY:
+			if array, ok := regs[ins.A].(*object.Array); ok && fits(len(array.Elements), ins.B, ins.C) {
+				f.pc++
+			}
+
+		case TESTHASH:
+			if regs[ins.A].Type() == object.HASH_OBJ {
+				f.pc++
+			}
+
+		case HASKEY:
+			if _, ok := regs[ins.A].(*object.Hash).Get(rk(ins.B).(*object.String)); ok {
+				f.pc++
+			}
+
+		case JMPIFNOT:
+			if !isTruthy(rk(ins.A)) {
+				f.pc = ins.B
+				continue
+			}
+
+		case CALL, CALLSPREAD:
+			numArgs := ins.B
+			if ins.Op == CALLSPREAD {
+				n, err := spread(regs[ins.A+1:], ins.B)
+				if err != nil {
+					return err
+				}
+				numArgs = n
+			}
+			callee, env, err := function(regs[ins.A])
+			if err != nil {
+				return err
+			}
+
+			if err := arguments(callee, regs[ins.A+1:], numArgs); err != nil {
+				return err
+			}
+			base := f.base + ins.A + 1
+			if len(m.frames) >= MaxFrames || base+callee.NumRegs > len(m.registers) {
+				return fmt.Errorf("stack overflow")
+			}
+
+			// the caller resumes after the call once the callee returns
+			f.pc++
+			m.frames = append(m.frames, frame{fn: callee, base: base})
+
+			f = &m.frames[len(m.frames)-1]
+			regs = m.registers[f.base:]
+			constants = f.fn.Constants
+			enter(f, env, regs)
+			continue
+
+		case TAILCALL, TAILSPREAD:
+			numArgs := ins.B
+			if ins.Op == TAILSPREAD {
+				n, err := spread(regs[ins.A+1:], ins.B)
+				if err != nil {
+					return err
+				}
+				numArgs = n
+			}
+			callee, env, err := function(regs[ins.A])
+			if err != nil {
+				return err
+			}
+			if err := arguments(callee, regs[ins.A+1:], numArgs); err != nil {
+				return err
+			}
+			if f.base+callee.NumRegs > len(m.registers) {
+				return fmt.Errorf("stack overflow")
+			}
+
+			// the callee and its arguments replace those of the current
+			// call, whose caller gets the result
+			copy(m.registers[f.base-1:], regs[ins.A:ins.A+callee.numParamRegs()+1])
+			f.fn = callee
+			f.pc = 0
+			constants = f.fn.Constants
+			enter(f, env, regs)
+			continue
+
+		case RETURN, RETURNNULL:
+			var result object.Object = vm.Null
+			if ins.Op == RETURN {
+				result = rk(ins.A)
+			}
+
+			if len(m.frames) == 1 {
+				m.result = result
+				return nil
+			}
+
+			// the result replaces the callee in the caller's window
+			m.registers[f.base-1] = result
+			m.frames = m.frames[:len(m.frames)-1]
+
+			f = &m.frames[len(m.frames)-1]
+			regs = m.registers[f.base:]
+			constants = f.fn.Constants
+			continue
+
+		default:
+			return fmt.Errorf("unknown opcode %d", ins.Op)
+		}
+
+		f.pc++
+	}
+}
+
+// Returns the function called by calling callee and the environment it was
+// defined in, nil for functions that are not closures.
+func function(callee object.Object) (*Function, *object.Environment, error) {
+	switch callee := callee.(type) {
+	case *Function:
+		return callee, nil, nil
+	case *Closure:
+		return callee.Fn, callee.Env, nil
+	default:
+		return nil, nil, fmt.Errorf("calling non-function: %s", callee.Type())
+	}
+}
+
+// Sets up the frame of a call whose arguments are in the first registers of
+// regs, creating the environment of captured functions.
+func enter(f *frame, env *object.Environment, regs []object.Object) {
+	fn := f.fn
+	f.env = env
+	if fn.Captured {
+		f.env = object.NewEnclosedEnvironment(env, fn.EnvSize)
+		copy(f.env.Store, regs[:fn.numParamRegs()])
+	}
+
+	// clear the locals left over from previous calls
+	for i := fn.numParamRegs(); i < fn.NumRegs; i++ {
+		regs[i] = nil
+	}
+}
+
+func binaryOperation(op Opcode, left, right object.Object) (object.Object, error) {
+	if l, ok := left.(*object.String); ok && op == ADD {
+		if r, ok := right.(*object.String); ok {
+			return &object.String{Value: l.Value + r.Value}, nil
+		}
+	}
+
+	l, lok := left.(*object.Integer)
+	r, rok := right.(*object.Integer)
+	if !lok || !rok {
+		return nil, fmt.Errorf("unsupported types for binary operation: %s %s", left.Type(), right.Type())
+	}
+
+	var result int64
+
+	switch op {
+	case ADD:
+		result = l.Value + r.Value
+	case SUB:
+		result = l.Value - r.Value
+	case MUL:
+		result = l.Value * r.Value
+	case DIV:
+		if r.Value == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		result = l.Value / r.Value
+	case MOD:
+		if r.Value == 0 {
+			return nil, fmt.Errorf("division by zero")
+		}
+		result = l.Value % r.Value
+	case POW:
+		if r.Value < 0 {
+			return nil, fmt.Errorf("negative exponent: %d", r.Value)
+		}
+		result = 1
+		for base, exp := l.Value, r.Value; exp > 0; exp >>= 1 {
+			if exp&1 == 1 {
+				result *= base
+			}
+			base *= base
+		}
+	}
+
+	return &object.Integer{Value: result}, nil
+}
+
+func comparison(op Opcode, left, right object.Object) (object.Object, error) {
+	l, lok := left.(*object.Integer)
+	r, rok := right.(*object.Integer)
+	if lok && rok {
+		switch op {
+		case EQ:
+			return nativeBoolToBooleanObject(l.Value == r.Value), nil
+		case NE:
+			return nativeBoolToBooleanObject(l.Value != r.Value), nil
+		case GT:
+			return nativeBoolToBooleanObject(l.Value > r.Value), nil
+		default:
+			return nativeBoolToBooleanObject(l.Value < r.Value), nil
+		}
+	}
+
+	ls, lok := left.(*object.String)
+	rs, rok := right.(*object.String)
+	if lok && rok && (op == EQ || op == NE) {
+		return nativeBoolToBooleanObject((ls.Value == rs.Value) == (op == EQ)), nil
+	}
+
+	switch op {
+	case EQ:
+		return nativeBoolToBooleanObject(left == right), nil
+	case NE:
+		return nativeBoolToBooleanObject(left != right), nil
+	default:
+		return nil, fmt.Errorf("unknown operator: %s (%s %s)", comparisonOperators[op], left.Type(), right.Type())
+	}
+}
+
+// The source operators of the comparisons, for error messages.
+var comparisonOperators = map[Opcode]string{
+	EQ: "==",
+	NE: "!=",
+	GT: ">",
+	LT: "<",
+}
+
+// Builds a hash from keys and values in alternate registers.
+func newHash(regs []object.Object) (object.Object, error) {
+	hash := object.NewHash(len(regs) / 2)
+	for i := 0; i < len(regs); i += 2 {
+		key, ok := regs[i].(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", regs[i].Type())
+		}
+		hash.Set(key, regs[i+1])
+	}
+	return hash, nil
+}
+
+// Indexing past the end of an array or with a missing key gives null.
+func index(left, index object.Object) (object.Object, error) {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			break
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return vm.Null, nil
+		}
+		return left.Elements[i.Value], nil
+
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		if value, ok := left.Get(key); ok {
+			return value, nil
+		}
+		return vm.Null, nil
+	}
+
+	return nil, fmt.Errorf("index operator not supported: %s[%s]", left.Type(), index.Type())
+}
+
+// Sets an element of an array, which must exist, or the value of a key of
+// a hash.
+func setIndex(left, index, value object.Object) error {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := index.(*object.Integer)
+		if !ok {
+			break
+		}
+		if i.Value < 0 || i.Value >= int64(len(left.Elements)) {
+			return fmt.Errorf("index %d out of range for array of length %d", i.Value, len(left.Elements))
+		}
+		left.Elements[i.Value] = value
+		return nil
+
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		left.Set(key, value)
+		return nil
+	}
+
+	return fmt.Errorf("index assignment not supported: %s[%s]", left.Type(), index.Type())
+}
+
+func newRange(start, end, step object.Object, inclusive bool) (object.Object, error) {
+	var bounds [3]int64
+	for i, bound := range []object.Object{start, end, step} {
+		integer, ok := bound.(*object.Integer)
+		if !ok {
+			return nil, fmt.Errorf("range bounds must be integers, got %s", bound.Type())
+		}
+		bounds[i] = integer.Value
+	}
+	if bounds[2] == 0 {
+		return nil, fmt.Errorf("range step must not be zero")
+	}
+	return &object.Range{Start: bounds[0], End: bounds[1], Step: bounds[2], Inclusive: inclusive}, nil
+}
+
+// Replaces the arrays at the start of args with their elements, which are
+// the arguments of a call, and returns their number.
+func spread(args []object.Object, numArrays int) (int, error) {
+	elements := []object.Object{}
+	for _, value := range args[:numArrays] {
+		array, ok := value.(*object.Array)
+		if !ok {
+			return 0, fmt.Errorf("cannot spread %s as arguments", value.Type())
+		}
+		elements = append(elements, array.Elements...)
+	}
+	if len(elements) > len(args) {
+		return 0, fmt.Errorf("stack overflow")
+	}
+	copy(args, elements)
+	return len(elements), nil
+}
+
+// Fits the arguments at the start of args to the parameters of fn: missing
+// arguments with a default are null and the extra ones are collected into
+// an array for the rest parameter.
+func arguments(fn *Function, args []object.Object, numArgs int) error {
+	required := fn.NumParams - fn.NumDefaults
+	if numArgs < required || numArgs > fn.NumParams && !fn.Variadic {
+		return arityError(fn, numArgs)
+	}
+	if fn.numParamRegs() > len(args) {
+		return fmt.Errorf("stack overflow")
+	}
+
+	for i := numArgs; i < fn.NumParams; i++ {
+		args[i] = vm.Null
+	}
+	if fn.Variadic {
+		rest := []object.Object{}
+		if numArgs > fn.NumParams {
+			rest = append(rest, args[fn.NumParams:numArgs]...)
+		}
+		args[fn.NumParams] = &object.Array{Elements: rest}
+	}
+	return nil
+}
+
+// Names the function and the number of arguments it takes.
+func arityError(fn *Function, numArgs int) error {
+	name := fn.Name
+	if name == "" {
+		name = "anonymous function"
+	}
+
+	required := fn.NumParams - fn.NumDefaults
+	var want string
+	switch {
+	case fn.Variadic:
+		want = fmt.Sprintf("want at least %d", required)
+	case fn.NumDefaults > 0:
+		want = fmt.Sprintf("want %d to %d", required, fn.NumParams)
+	default:
+		want = fmt.Sprintf("want=%d", required)
+	}
+	return fmt.Errorf("wrong number of arguments to %s: %s, got=%d", name, want, numArgs)
+}
+
+// Checks that value is an array of min to max elements, or at least min if
+// max is negative.
+func checkArray(value object.Object, min, max int) error {
+	array, ok := value.(*object.Array)
+	if !ok {
+		return fmt.Errorf("cannot destructure %s as an array", value.Type())
+	}
+
+	n := len(array.Elements)
+	switch {
+	case fits(n, min, max):
+		return nil
+	case max < 0:
+		return fmt.Errorf("cannot destructure array of length %d into at least %d elements", n, min)
+	case min == max:
+		return fmt.Errorf("cannot destructure array of length %d into %d elements", n, max)
+	default:
+		return fmt.Errorf("cannot destructure array of length %d into %d to %d elements", n, min, max)
+	}
+}
+
+// Reports whether an array of n elements has min to max elements, or at
+// least min if max is negative.
+func fits(n, min, max int) bool {
+	return n >= min && (max < 0 || n <= max)
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	default:
+		return true
+	}
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return vm.True
+	}
+	return vm.False
+}
@@ -0,0 +1,480 @@
+package regvm
+
+import (
+	"fmt"
+	"testing"
+
+	"interpreter/ast"
+	"interpreter/lexer"
+	"interpreter/object"
+	"interpreter/parser"
+	"interpreter/vm"
+)
+
+type vmTestCase struct {
+	input    string
+	expected interface{}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1", 1},
+		{"1 + 2", 3},
+		{"1 - 2", -1},
+		{"50 / 2 * 2 + 10 - 5", 55},
+		{"5 + 2 * 10", 25},
+		{"-7 % 3", -1},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"-50 + 100 + -50", 0},
+		{"let a = 3; let b = a * a; b - a", 6},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true", true},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 != 2", true},
+		{"true == false", false},
+		{"1 == true", false},
+		{"!5", false},
+		{"!!true", true},
+		{"!(if (false) { 5; })", true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStringExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`"mon" + "key"`, "monkey"},
+		{`let s = "a"; s + s + "b"`, "aab"},
+		{`"monkey" == "mon" + "key"`, true},
+		{`"a" != "a"`, false},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (false) { 10 } else { 20 }", 20},
+		{"if (1 > 2) { 10 }", vm.Null},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+		{"if (true) { let a = 1; }", vm.Null},
+		{"let x = 5;", vm.Null},
+		{"1; let y = 7;", vm.Null},
+		{"let a = 1; if (true) { let a = 2; } a", 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let fivePlusTen = fn() { 5 + 10; }; fivePlusTen();", 15},
+		{"let a = fn() { 1 }; let b = fn() { a() + 1 }; let c = fn() { b() + 1 }; c();", 3},
+		{"let earlyExit = fn() { return 99; 100; }; earlyExit();", 99},
+		{"let noReturn = fn() { }; noReturn();", vm.Null},
+		{"let sum = fn(a, b) { let c = a + b; c; }; sum(1, 2) + sum(3, 4);", 10},
+		{"let globalNum = 10; let sum = fn(a, b) { let c = a + b; c + globalNum; }; sum(1, 2);", 13},
+		{"let returnsOne = fn() { 1; }; let returnsOneReturner = fn() { returnsOne; }; returnsOneReturner()();", 1},
+		{"let max = fn(a, b) { if (a > b) { return a; } b }; max(3, 7) + max(9, 2)", 16},
+		{"let f = fn(a) { let b = a + 1; let c = if (b > 2) { let d = b * 2; d } else { b }; c + 1 }; f(1) + f(5)", 16},
+		{"let f = fn(a) { let a = a * 2; a }; f(4)", 8},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRecursiveFibonacci(t *testing.T) {
+	tests := []vmTestCase{
+		{`
+		let fibonacci = fn(x) {
+			if (x < 2) { return x; }
+			fibonacci(x - 1) + fibonacci(x - 2);
+		};
+		fibonacci(15);`, 610},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		// adders made by a higher-order function
+		{"let newAdder = fn(a) { fn(b) { a + b } }; let addTwo = newAdder(2); addTwo(3)", 5},
+		{"let newAdder = fn(a) { fn(b) { a + b } }; newAdder(1)(1) + newAdder(10)(1)", 13},
+		{"let compose = fn(f, g) { fn(x) { g(f(x)) } }; compose(fn(x) { x + 1 }, fn(x) { x * 2 })(5)", 12},
+		{"let make = x => y => x + y; make(1)(2)", 3},
+
+		// every call gets its own environment
+		{`
+		let counter = fn(start) {
+			let count = fn(n) { if (n == 0) { start } else { count(n - 1) + 1 } };
+			count
+		};
+		let fromTen = counter(10);
+		let fromZero = counter(0);
+		fromTen(3) * 100 + fromZero(5)`, 1305},
+
+		// captured bindings are shared with the closures
+		{"let counter = fn() { let count = 0; fn() { count += 1 } }; let next = counter(); next(); next(); next()", 3},
+		{"let f = fn() { let a = 1; let set = fn(v) { a = v }; set(5); a }; f()", 5},
+		{"let f = fn() { let x = 0; let get = fn() { x }; while (x < 4) { x = x + 1; } get() }; f()", 4},
+		{"let f = fn() { let g = fn() { 0 }; for (i in 0..3) { g = fn() { i }; } g() }; f()", 2},
+
+		// several levels of nesting, with and without environments between
+		{"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)", 6},
+		{"let f = fn(a) { let b = a * 2; fn() { fn() { a + b } } }; f(4)()()", 12},
+
+		// inner bindings shadow outer ones without changing them
+		{"let f = fn(x) { let g = fn() { let x = 2; x }; g() * 10 + x }; f(1)", 21},
+		{"let f = fn(x) { let g = fn(x) { x }; g(5) + x }; f(1)", 6},
+		{"let x = 1; let f = fn() { let g = fn() { x }; let x = 5; g() }; f()", 1},
+		{"let f = fn() { let a = 1; let g = fn() { a }; let a = 2; g() + a }; f()", 3},
+		{"let f = fn() { let a = 1; let g = fn() { a = 2 }; let a = 3; g(); a }; f()", 3},
+
+		// parameters, defaults and patterns of captured functions
+		{"let f = fn(a, b = a + 1, ...rest) { fn() { [a, b, rest] } }; f(1)()[1]", 2},
+		{"let f = fn(...xs) { fn() { xs } }; f(1, 2)()[1]", 2},
+		{"let f = fn(a) { fn(b = a) { b } }; f(7)()", 7},
+		{"let f = fn(pair) { let [a, b] = pair; fn() { a * b } }; f([3, 4])()", 12},
+		{"let f = fn(v) { match (v) { [x] => fn() { x }, _ => fn() { 0 } } }; f([9])()", 9},
+		{"let loop = fn(n, acc) { if (n == 0) { fn() { acc } } else { loop(n - 1, acc + n) } }; loop(100, 0)()", 5050},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 1; a = a + 1; a", 2},
+		{"let a = 0; let b = 0; a = b = 7; a + b", 14},
+		{"let a = 1; (a = 5) * 2", 10},
+		{"let x = 1; let f = fn() { x = x + 10 }; f(); f(); x", 21},
+		{"let f = fn(n) { n = n * 2; n + 1 }; f(4)", 9},
+		{"let f = fn(a) { let b = 0; let c = (b = a + 1) * 2; b + c }; f(2)", 9},
+		{"let a = 10; a += 5; a -= 3; a *= 2; a /= 4; a %= 4; a", 2},
+		{"let f = fn(a) { a += 5; a -= 3; a *= 2; a /= 4; a %= 4; a }; f(10)", 2},
+		{"let f = fn(a) { let b = 2; a += b *= 3; a + b }; f(1)", 13},
+		{"let f = fn(a) { let b = (a += 1); a + b }; f(1)", 4},
+		{"let f = fn(a) { a += (a = 5); a }; f(1)", 6},
+		{"let a = [1, 2, 3]; a[1] = 20; a[0] + a[1] + a[2]", 24},
+		{"let f = fn(a, i) { a[i] *= 3 }; f([1, 2, 3], 2)", 9},
+		{"let a = [[0]]; a[0][0] += 5; a[0][0]", 5},
+		{`let h = {}; h["k"] = 1; h["k"] += 2; h["k"]`, 3},
+		{"let f = fn(xs) { xs[0] = 7 }; let a = [0]; f(a); a[0]", 7},
+		{"const a = [1]; a[0] += 1; a[0]", 2},
+		{"const x = 1; let f = fn() { let x = 2; x += 1; x }; f() + x", 4},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; let sum = 0; while (i < 5) { sum = sum + i; i = i + 1; } sum", 10},
+		{"let x = 5; while (false) { x = 0; } x", 5},
+		{"let sum = 0; for (let i = 0; i < 10; i = i + 1) { if (i % 2 == 0) { continue; } if (i > 7) { break; } sum = sum + i; } sum", 16},
+		{"let n = 0; for (let i = 0; i < 3; i = i + 1) { for (let j = 0; j < 10; j = j + 1) { if (j == i) { break; } n = n + 1; } } n", 3},
+		{"let f = fn(n) { let total = 0; while (true) { if (n == 0) { return total; } total = total + n; n = n - 1; } }; f(4)", 10},
+		{"let f = fn() { let i = 0; for (;;) { i = i + 1; if (i == 3) { break; } } i }; f()", 3},
+		{"let f = fn() { for (let i = 0; i < 3; i = i + 1) { } }; f()", vm.Null},
+		{"let f = fn(n) { let s = 0; for (let i = 0; i < n; i = i + 1) { let sq = i * i; s = s + sq; } s }; f(4)", 14},
+
+		// a program ending in a loop has no value
+		{"let i = 0; while (i < 3) { i += 1; }", vm.Null},
+		{"let n = 0; for (let i = 0; i < 3; i += 1) { n += i; }", vm.Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCollections(t *testing.T) {
+	tests := []vmTestCase{
+		{"[1, 2 * 2, 3 + 3][2]", 6},
+		{"let i = 0; [[1, 1, 1]][i][i + 2]", 1},
+		{"[1, 2, 3][3]", vm.Null},
+		{`{"one": 1, "two": 2}["two"]`, 2},
+		{"{1: 1, true: 2}[true]", 2},
+		{"{}[1]", vm.Null},
+		{"let f = fn(a) { let xs = [a, a * 2]; xs[1] }; f(3)", 6},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestForInLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let sum = 0; for (x in [1, 2, 3]) { sum = sum + x; } sum", 6},
+		{"let sum = 0; for (i, x in [10, 20, 30]) { sum = sum + i * x; } sum", 80},
+		{`let s = ""; for (k in {"b": 1, "a": 2, "c": 3}) { s = s + k; } s`, "bac"},
+		{`let s = ""; for (k, v in {"x": "1", "y": "2"}) { s = s + k + v; } s`, "x1y2"},
+		{`let s = ""; for (c in "héllo") { s = c + s; } s`, "olléh"},
+		{"let sum = 0; for (i in 0..=10 step 5) { sum = sum + i; } sum", 15},
+		{"let sum = 0; for (i in 10..0 step -2) { sum = sum + i; } sum", 30},
+		{"let sum = 0; for (i in 0..100) { if (i % 2 == 0) { continue; } if (i > 7) { break; } sum = sum + i; } sum", 16},
+		{"let n = 0; for (i in 0..3) { for (j in 0..10) { if (j == i) { break; } n = n + 1; } } n", 3},
+		{"let f = fn(xs) { for (x in xs) { if (x > 1) { return x; } } 0 }; f([1, 5, 2])", 5},
+		{"let f = fn(n) { let s = 0; for (i, x in 0..n) { s = s + i * x; } s }; f(4)", 14},
+
+		// the iterator does not become the value of the program
+		{"let n = 0; for (x in [1, 2]) { n += x; }", vm.Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTailCalls(t *testing.T) {
+	tests := []vmTestCase{
+		{"let loop = fn(n) { if (n == 0) { return 0; } loop(n - 1) }; loop(100000)", 0},
+		{"let sum = fn(n, acc) { if (n == 0) { acc } else { sum(n - 1, acc + n) } }; sum(100000, 0)", 5000050000},
+		{"let isEven = fn(n, even) { if (n == 0) { return even; } isEven(n - 1, !even) }; isEven(99999, true)", false},
+		{"let f = fn(a, b) { a - b }; let g = fn(n) { let x = n * 2; f(x, n) }; g(5) + 1", 6},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFunctionParameters(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn(x, y = 10) { x + y }; f(1)", 11},
+		{"let f = fn(x, y = 10) { x + y }; f(1, 2)", 3},
+		{"let f = fn(x, y = 10) { x + y }; f(1, if (false) { 2 })", 11},
+		{"let f = fn(x, y = x * 2) { y }; f(4)", 8},
+		{"let n = 0; let f = fn(x = n) { x }; n = 5; f()", 5},
+		{"let f = fn(x, ...rest) { rest }; f(1, 2, 3)", []int{2, 3}},
+		{"let f = fn(x, ...rest) { rest }; f(1)", []int{}},
+		{"let f = fn(x = 1, ...rest) { let y = 2; [x, y, rest] }; f()[2]", []int{}},
+		{"let f = fn(a, b, c) { a * 100 + b * 10 + c }; f(...[1, 2, 3])", 123},
+		{"let f = fn(a, b, c) { a * 100 + b * 10 + c }; let xs = [2]; f(1, ...xs, 3)", 123},
+		{"let f = fn(...xs) { xs }; f(...[1], ...[], 2, ...[3, 4])", []int{1, 2, 3, 4}},
+		{"let f = fn(x = match (1) { n => n + 1 }) { x }; f()", 2},
+		{"let g = fn(x = 1, x = 2) { x }; g()", 2},
+		{"let sum = fn(acc, ...xs) { match (xs) { [] => acc, [x, ...rest] => sum(acc + x, ...rest) } }; sum(0, 1, 2, 3)", 6},
+		{"let count = fn(n, acc = 0) { if (n == 0) { acc } else { count(...[n - 1, acc + 1]) } }; count(100000)", 100000},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []vmTestCase{
+		{"let [a, b] = [1, 2]; a * 10 + b", 12},
+		{"let [a, b, ...rest] = [1, 2, 3, 4]; rest", []int{3, 4}},
+		{"let [...all] = []; all", []int{}},
+		{"let [a, ...rest] = [1]; rest", []int{}},
+		{"let [[a, b], [c]] = [[1, 2], [3]]; a + b + c", 6},
+		{"let [a, b = a * 2] = [5]; b", 10},
+		{"let [a = 1, b = 2] = [if (false) { 0 }, 3]; a * 10 + b", 13},
+		{"let [a = 1, b] = [7, 8]; a + b", 15},
+		{`let {name, age: years} = {"name": "monkey", "age": 3, "extra": true}; name + "!"`, "monkey!"},
+		{`let {age: years} = {"age": 3}; years`, 3},
+		{`let {name = "anon", n = 1} = {"n": 2}; name`, "anon"},
+		{`let {point: [x, y], tag: {id}} = {"point": [1, 2], "tag": {"id": 3}}; x + y + id`, 6},
+		{`let [{a}, {a: b}] = [{"a": 1}, {"a": 2}]; a + b`, 3},
+		{"let [a, b] = [1, 2]; let [b, a] = [a, b]; a * 10 + b", 21},
+		{"let f = fn(pair) { let [a, b] = pair; a - b }; f([5, 3])", 2},
+		{"let f = fn(h) { let {x, y = 10} = h; x * y }; f({\"x\": 4})", 40},
+		{"const [a, b] = [1, 2]; let f = fn() { let a = 5; a + b }; f()", 7},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMatch(t *testing.T) {
+	tests := []vmTestCase{
+		{`match (1) { 0 => "zero", 1 => "one", _ => "many" }`, "one"},
+		{`match (-1) { -1 => "minus", _ => "other" }`, "minus"},
+		{`match ("b") { "a" => 1, "b" => 2 }`, 2},
+		{"match (true) { 1 => 1, true => 2 }", 2},
+		{"match (5) { 0 => 1 }", vm.Null},
+		{"match (5) { n => n * 2 }", 10},
+		{"match ([1, 2]) { [a] => a, [a, b] => a + b }", 3},
+		{"match ([1, 2, 3]) { [a, b] => 0, [a, ...rest] => rest }", []int{2, 3}},
+		{"match ([1, [2, 3]]) { [1, [x, 4]] => 0, [1, [x, 3]] => x }", 2},
+		{"match ([7]) { [a, b = 10] => a + b }", 17},
+		{`match ({"type": "user", "name": "monkey"}) { {type: "admin"} => 0, {type: "user", name} => name }`, "monkey"},
+		{`match ({"id": 1}) { {name} => name, {id, name = "anon"} => name }`, "anon"},
+		{`match ([1]) { {a} => 0, _ => 1 }`, 1},
+		{"match (12) { n if n > 10 => \"big\", n => \"small\" }", "big"},
+		{"match (2) { n if n > 10 => \"big\", n => \"small\" }", "small"},
+		{"match ([5, 6]) { [a, b] if a > b => a, [a, b] => b }", 6},
+		{"let f = fn(x) { match (x) { [h, ...t] => h + f(t), _ => 0 } }; f([1, 2, 3])", 6},
+		{"let f = fn(n, acc) { match (n) { 0 => acc, _ => f(n - 1, acc + n) } }; f(100000, 0)", 5000050000},
+		{"let i = 0; let n = 0; while (i < 100) { n += match ([i, {\"k\": i}]) { [0, {k: 1}] => 1, [_, {j}] => 1, [x, {k}] if x == k => 2 }; i += 1; } n", 200},
+		{"let x = 1; match (x) { [y] => y, y => x + y }", 2},
+		{"let x = 5; match (3) { x => x } + x", 8},
+		{"let n = 100; match ([1, 2]) { [n, 3] => 0, _ => n }", 100},
+		{"let n = 100; match ([1, 2]) { [n, 2] if n > 1 => 0, [_, n] => n }", 2},
+		{"const x = 5; match (3) { x => x } + x", 8},
+		{"const x = 1; match (2) { [x] => x, x => (x = 3) } + x", 4},
+		{"let f = fn(n) { let g = match ([1, 2]) { [n, 3] => 0, [_, n] => fn() { n } }; [g(), n] }; f(100)", []int{2, 100}},
+		{"let f = fn(n) { match (n) { n if n > 1 => n, _ => 0 } + n }; f(5)", 10},
+		{"let g = match (3) { x => fn() { x } }; let x = 4; g()", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestArrowFunctionsAndPipelines(t *testing.T) {
+	tests := []vmTestCase{
+		{"let double = x => x * 2; double(21)", 42},
+		{"let add = (a, b = 1) => a + b; [add(1), add(1, 2)]", []int{2, 3}},
+		{"let f = () => 7; f()", 7},
+		{"let f = (...xs) => xs; f(1, 2)", []int{1, 2}},
+		{"let inc = x => x + 1; 1 |> inc |> inc", 3},
+		{"let sub = fn(a, b) { a - b }; 10 |> sub(3)", 7},
+		{"let map = fn(xs, f) { let out = [0, 0]; for (i, x in xs) { out[i] = f(x); } out }; [1, 2] |> map(x => x * 10)", []int{10, 20}},
+		{"let sum = fn(xs) { let s = 0; for (x in xs) { s += x; } s }; 1..4 |> sum() == 6", true},
+		{"match (2) { n if n |> (x => x > 1) => 1, _ => 0 }", 1},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 / 0", "1:3: division by zero"},
+		{"2 ** -1", "1:3: negative exponent: -1"},
+		{"-true", "1:1: unsupported type for negation: BOOLEAN"},
+		{`"a" > "b"`, "1:5: unknown operator: > (STRING STRING)"},
+		{"1 < true", "1:3: unknown operator: < (INTEGER BOOLEAN)"},
+		{"let a = 1;\nlet b = a * 2;\n  -b + !b", "3:6: unsupported types for binary operation: INTEGER BOOLEAN"},
+		{"1()", "1:2: calling non-function: INTEGER"},
+		{"fn(a) { a }()", "1:12: wrong number of arguments to anonymous function: want=1, got=0"},
+		{"let f = fn(a, b = 1) { a }; f(1, 2, 3)", "1:30: wrong number of arguments to f: want 1 to 2, got=3"},
+		{"let f = fn(a, ...rest) { a }; f(...[])", "1:32: wrong number of arguments to f: want at least 1, got=0"},
+		{"let f = fn(a) { a }; f(...1)", "1:23: cannot spread INTEGER as arguments"},
+		{"let f = fn() {\n  1 / 0\n};\nf()", "2:5: division by zero"},
+		{"let f = fn() { 1 + f() };\nf()", "1:21: stack overflow"},
+		{"let g = fn(a, b) { a };\nlet f = fn(n) { g(n) };\nf(1)", "2:18: wrong number of arguments to g: want=2, got=1"},
+		{"let f = fn() { 1() };\nf()", "1:17: calling non-function: INTEGER"},
+		{"let i = 0;\nwhile (i < 3) { i = i + true; }", "2:23: unsupported types for binary operation: INTEGER BOOLEAN"},
+		{"1[0]", "1:2: index operator not supported: INTEGER[INTEGER]"},
+		{"{}[[]]", "1:3: unusable as hash key: ARRAY"},
+		{"0..10 step 0", "1:2: range step must not be zero"},
+		{"for (x in 1) { }", "1:1: cannot iterate over INTEGER"},
+		{"let a = [1];\na[1] = 2", "2:6: index 1 out of range for array of length 1"},
+		{`let s = "a"; s[0] = "b"`, "1:19: index assignment not supported: STRING[INTEGER]"},
+		{"let a = true; a -= 1", "1:17: unsupported types for binary operation: BOOLEAN INTEGER"},
+		{"let [a, b] = [1];", "1:5: cannot destructure array of length 1 into 2 elements"},
+		{"let [a] = [1, 2];", "1:5: cannot destructure array of length 2 into 1 elements"},
+		{"let [a, b = 1] = [];", "1:5: cannot destructure array of length 0 into 1 to 2 elements"},
+		{"let [a, b, ...c] = [1];", "1:5: cannot destructure array of length 1 into at least 2 elements"},
+		{"let [a] = 5;", "1:5: cannot destructure INTEGER as an array"},
+		{"let {a} = [1];", "1:5: cannot destructure ARRAY as a hash"},
+		{`let {a, b: [c]} = {"a": 1};`, `1:9: missing key "b" in destructured hash`},
+		{"let f = fn(p) { let [x, y] = p; x };\nf([1, [2]][1])", "1:21: cannot destructure array of length 1 into 2 elements"},
+	}
+
+	for _, tt := range tests {
+		program, err := Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		err = New(program).Run()
+		if err == nil {
+			t.Fatalf("expected VM error for %q", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("expected error %q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program, err := Compile(parse(tt.input))
+		if err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		machine := New(program)
+		if err := machine.Run(); err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+
+		testExpectedObject(t, tt.expected, machine.Result())
+	}
+}
+
+func testExpectedObject(t *testing.T, expected interface{}, actual object.Object) {
+	t.Helper()
+
+	switch expected := expected.(type) {
+	case int:
+		if err := testIntegerObject(int64(expected), actual); err != nil {
+			t.Errorf("testIntegerObject failed: %s", err)
+		}
+	case bool:
+		if err := testBooleanObject(expected, actual); err != nil {
+			t.Errorf("testBooleanObject failed: %s", err)
+		}
+	case string:
+		if err := testStringObject(expected, actual); err != nil {
+			t.Errorf("testStringObject failed: %s", err)
+		}
+	case *object.Null:
+		if actual != vm.Null {
+			t.Errorf("object is not Null: %T (%+v)", actual, actual)
+		}
+	}
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func testIntegerObject(expected int64, actual object.Object) error {
+	result, ok := actual.(*object.Integer)
+	if !ok {
+		return fmt.Errorf("object is not Integer. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. expected=%d, got=%d", expected, result.Value)
+	}
+
+	return nil
+}
+
+func testBooleanObject(expected bool, actual object.Object) error {
+	result, ok := actual.(*object.Boolean)
+	if !ok {
+		return fmt.Errorf("object is not Boolean. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. expected=%t, got=%t", expected, result.Value)
+	}
+
+	return nil
+}
+
+func testStringObject(expected string, actual object.Object) error {
+	result, ok := actual.(*object.String)
+	if !ok {
+		return fmt.Errorf("object is not String. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. expected=%q, got=%q", expected, result.Value)
+	}
+
+	return nil
+}
@@ -3,16 +3,29 @@ package repl
 import (
 	"bufio"
 	"fmt"
+	"interpreter/compiler"
 	"interpreter/lexer"
-	"interpreter/token"
+	"interpreter/object"
+	"interpreter/parser"
+	"interpreter/vm"
 	"io"
 )
 
 const PROMPT = ">> "
 
-func Start(in io.Reader, out io.Writer) {
+// Start reads lines from in and evaluates them, with the builtins callable
+// under their names.
+func Start(in io.Reader, out io.Writer, builtins []*object.Builtin) {
 	scanner := bufio.NewScanner(in)
 
+	// keep globals across lines
+	constants := []object.Object{}
+	globals := make([]object.Object, vm.GlobalsSize)
+	symbolTable := compiler.NewSymbolTable()
+	for i, b := range builtins {
+		symbolTable.DefineBuiltin(i, b.Name)
+	}
+
 	for {
 		fmt.Fprint(out, PROMPT)
 		scanned := scanner.Scan()
@@ -22,9 +35,42 @@ func Start(in io.Reader, out io.Writer) {
 
 		line := scanner.Text()
 		l := lexer.New(line)
+		p := parser.New(l)
 
-		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
-			fmt.Fprintf(out, "%+v\n", tok)
+		program := p.ParseProgram()
+		if len(p.Errors()) != 0 {
+			printParserErrors(out, p.Errors())
+			continue
 		}
+		for _, msg := range p.Warnings() {
+			fmt.Fprintf(out, "warning: %s\n", msg)
+		}
+
+		comp := compiler.NewWithState(symbolTable, constants)
+		if err := comp.Compile(program); err != nil {
+			fmt.Fprintf(out, "compilation failed:\n\t%s\n", err)
+			continue
+		}
+
+		code := comp.Bytecode()
+		constants = code.Constants
+
+		machine := vm.NewWithBuiltins(code, globals, builtins)
+		if err := machine.Run(); err != nil {
+			fmt.Fprintf(out, "executing bytecode failed:\n\t%s\n", err)
+			continue
+		}
+
+		// lines such as let statements have no value to print
+		if program.EndsWithExpression() {
+			fmt.Fprintln(out, machine.LastPoppedStackElem().Inspect())
+		}
+	}
+}
+
+func printParserErrors(out io.Writer, errors []string) {
+	fmt.Fprintln(out, "parser errors:")
+	for _, msg := range errors {
+		fmt.Fprintf(out, "\t%s\n", msg)
 	}
 }
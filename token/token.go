@@ -5,6 +5,16 @@ type TokenType string
 type Token struct {
 	Type    TokenType
 	Literal string
+
+	// 1-based position of the first byte of the token, columns count bytes.
+	// The compiler records it in the source map of the bytecode.
+	Line   int
+	Column int
+
+	// Whitespace and comments around the token. Only populated by a
+	// lossless lexer.
+	Leading  []Trivia
+	Trailing []Trivia
 }
 
 const (
@@ -12,8 +22,9 @@ const (
 	EOF     = "EOF"
 
 	// identifiers + literals
-	IDENT = "IDENT"
-	INT   = "INT"
+	IDENT  = "IDENT"
+	INT    = "INT"
+	STRING = "STRING" // literal keeps the quotes and escapes of the source
 
 	// operators
 	ASSIGN   = "="
@@ -22,40 +33,73 @@ const (
 	BANG     = "!"
 	ASTERISK = "*"
 	SLASH    = "/"
+	PERCENT  = "%"
+	POWER    = "**"
+
+	PLUS_ASSIGN     = "+="
+	MINUS_ASSIGN    = "-="
+	ASTERISK_ASSIGN = "*="
+	SLASH_ASSIGN    = "/="
+	PERCENT_ASSIGN  = "%="
 
 	LT = "<"
 	GT = ">"
 
 	EQ     = "=="
 	NOT_EQ = "!="
+	ARROW  = "=>"
+
+	PIPELINE = "|>"
+
+	RANGE           = ".."
+	RANGE_INCLUSIVE = "..="
+	ELLIPSIS        = "..."
 
 	// delimiters
 	COMMA     = ","
 	SEMICOLON = ";"
+	COLON     = ":"
 
 	LPAREN = "("
 	RPAREN = ")"
 	LBRACE = "{"
 	RBRACE = "}"
 
+	LBRACKET = "["
+	RBRACKET = "]"
+
 	// keywords
 	FUNCTION = "FUNCTION"
 	LET      = "LET"
+	CONST    = "CONST"
 	TRUE     = "TRUE"
 	FALSE    = "FALSE"
 	IF       = "IF"
 	ELSE     = "ELSE"
 	RETURN   = "RETURN"
+	WHILE    = "WHILE"
+	FOR      = "FOR"
+	BREAK    = "BREAK"
+	CONTINUE = "CONTINUE"
+	IN       = "IN"
+	MATCH    = "MATCH"
 )
 
 var keywords = map[string]TokenType{
-	"fn":     FUNCTION,
-	"let":    LET,
-	"true":   TRUE,
-	"false":  FALSE,
-	"if":     IF,
-	"else":   ELSE,
-	"return": RETURN,
+	"fn":       FUNCTION,
+	"let":      LET,
+	"const":    CONST,
+	"true":     TRUE,
+	"false":    FALSE,
+	"if":       IF,
+	"else":     ELSE,
+	"return":   RETURN,
+	"while":    WHILE,
+	"for":      FOR,
+	"break":    BREAK,
+	"continue": CONTINUE,
+	"in":       IN,
+	"match":    MATCH,
 }
 
 func LookupIdent(ident string) TokenType {
@@ -64,3 +108,18 @@ func LookupIdent(ident string) TokenType {
 	}
 	return IDENT
 }
+
+type TriviaType string
+
+// Trivia is source text that carries no meaning for the parser but has to
+// be kept to reproduce the source exactly.
+type Trivia struct {
+	Type TriviaType
+	Text string
+}
+
+const (
+	WHITESPACE = "WHITESPACE"
+	NEWLINE    = "NEWLINE"
+	COMMENT    = "COMMENT"
+)
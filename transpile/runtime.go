@@ -0,0 +1,602 @@
+package transpile
+
+// runtime is appended to every transpiled program. It mirrors the
+// semantics and error messages of the VM.
+const runtime = `
+type Value interface {
+	Type() string
+	Inspect() string
+}
+
+type Int int64
+
+func (i Int) Type() string    { return "INTEGER" }
+func (i Int) Inspect() string { return strconv.FormatInt(int64(i), 10) }
+
+type Bool bool
+
+func (b Bool) Type() string    { return "BOOLEAN" }
+func (b Bool) Inspect() string { return strconv.FormatBool(bool(b)) }
+
+type Str string
+
+func (s Str) Type() string    { return "STRING" }
+func (s Str) Inspect() string { return string(s) }
+
+type NullValue struct{}
+
+func (n NullValue) Type() string    { return "NULL" }
+func (n NullValue) Inspect() string { return "null" }
+
+var Null Value = NullValue{}
+
+type Array struct {
+	Elements []Value
+}
+
+func (a *Array) Type() string { return "ARRAY" }
+func (a *Array) Inspect() string { return inspect(a, map[Value]bool{}) }
+
+type HashPair struct {
+	Key   Value
+	Value Value
+}
+
+// Hash keeps its pairs in insertion order. Keys are integers, booleans or
+// strings, which are comparable Go values.
+type Hash struct {
+	Pairs []HashPair
+	index map[Value]int
+}
+
+func (h *Hash) Type() string { return "HASH" }
+func (h *Hash) Inspect() string { return inspect(h, map[Value]bool{}) }
+
+// inspect formats arrays and hashes that may contain themselves like the
+// VM: one already being formatted is shown as [...] or {...}.
+func inspect(v Value, seen map[Value]bool) string {
+	switch v := v.(type) {
+	case *Array:
+		if seen[v] {
+			return "[...]"
+		}
+		seen[v] = true
+		elements := make([]string, len(v.Elements))
+		for i, e := range v.Elements {
+			elements[i] = inspect(e, seen)
+		}
+		delete(seen, v)
+		return "[" + strings.Join(elements, ", ") + "]"
+
+	case *Hash:
+		if seen[v] {
+			return "{...}"
+		}
+		seen[v] = true
+		pairs := make([]string, len(v.Pairs))
+		for i, p := range v.Pairs {
+			pairs[i] = p.Key.Inspect() + ": " + inspect(p.Value, seen)
+		}
+		delete(seen, v)
+		return "{" + strings.Join(pairs, ", ") + "}"
+
+	default:
+		return v.Inspect()
+	}
+}
+
+type Range struct {
+	Start, End, Step Int
+	Inclusive        bool
+}
+
+func (r *Range) Type() string { return "RANGE" }
+func (r *Range) Inspect() string {
+	op := ".."
+	if r.Inclusive {
+		op = "..="
+	}
+	if r.Step == 1 {
+		return fmt.Sprintf("%d%s%d", r.Start, op, r.End)
+	}
+	return fmt.Sprintf("%d%s%d step %d", r.Start, op, r.End, r.Step)
+}
+
+type Func struct {
+	Name     string
+	Arity    int  // number of parameters, not counting the rest parameter
+	Defaults int  // trailing parameters with a default, null when missing
+	Variadic bool // extra arguments go to an array after the parameters
+	Closure  bool // made inside another function, like the closures of the VM
+	Fn       func(args []Value) Value
+}
+
+func (f *Func) Type() string {
+	if f.Closure {
+		return "CLOSURE"
+	}
+	return "COMPILED_FUNCTION"
+}
+func (f *Func) Inspect() string {
+	kind := "CompiledFunction"
+	if f.Closure {
+		kind = "Closure"
+	}
+	if f.Name != "" {
+		return fmt.Sprintf("%s[%s]", kind, f.Name)
+	}
+	return fmt.Sprintf("%s[%p]", kind, f)
+}
+
+// Error is a runtime error, raised with panic and reported by main.
+type Error struct {
+	Line   int
+	Column int
+	Msg    string
+}
+
+func fail(line, column int, format string, args ...interface{}) {
+	panic(&Error{Line: line, Column: column, Msg: fmt.Sprintf(format, args...)})
+}
+
+func main() {
+	defer func() {
+		if r := recover(); r != nil {
+			err, ok := r.(*Error)
+			if !ok {
+				panic(r)
+			}
+			fmt.Fprintf(os.Stderr, "%s:%d:%d: %s\n", source, err.Line, err.Column, err.Msg)
+			os.Exit(1)
+		}
+	}()
+
+	if result := run(); result != nil {
+		fmt.Println(result.Inspect())
+	}
+}
+
+const maxDepth = 1023
+
+var depth int
+
+func call(line, column int, callee Value, args ...Value) Value {
+	f, ok := callee.(*Func)
+	if !ok {
+		fail(line, column, "calling non-function: %s", callee.Type())
+	}
+	args = arguments(line, column, f, args)
+	if depth >= maxDepth {
+		fail(line, column, "stack overflow")
+	}
+
+	depth++
+	result := f.Fn(args)
+	for {
+		p, ok := result.(*pending)
+		if !ok {
+			break
+		}
+		result = p.f.Fn(p.args)
+	}
+	depth--
+	return result
+}
+
+// pending is a call in tail position, returned by the caller in place of
+// its result and made by call, so that tail calls take no stack.
+type pending struct {
+	f    *Func
+	args []Value
+}
+
+func (p *pending) Type() string    { return "PENDING" }
+func (p *pending) Inspect() string { return "pending" }
+
+func tailCall(line, column int, callee Value, args ...Value) Value {
+	f, ok := callee.(*Func)
+	if !ok {
+		fail(line, column, "calling non-function: %s", callee.Type())
+	}
+	return &pending{f: f, args: arguments(line, column, f, args)}
+}
+
+// arguments fits the arguments of a call to the parameters of f: missing
+// ones with a default are null and the extra ones are collected into an
+// array for the rest parameter.
+func arguments(line, column int, f *Func, args []Value) []Value {
+	required := f.Arity - f.Defaults
+	if len(args) < required || len(args) > f.Arity && !f.Variadic {
+		name := f.Name
+		if name == "" {
+			name = "anonymous function"
+		}
+		var want string
+		switch {
+		case f.Variadic:
+			want = fmt.Sprintf("want at least %d", required)
+		case f.Defaults > 0:
+			want = fmt.Sprintf("want %d to %d", required, f.Arity)
+		default:
+			want = fmt.Sprintf("want=%d", required)
+		}
+		fail(line, column, "wrong number of arguments to %s: %s, got=%d", name, want, len(args))
+	}
+	if len(args) == f.Arity && !f.Variadic {
+		return args
+	}
+
+	fitted := make([]Value, f.Arity, f.Arity+1)
+	copy(fitted, args)
+	for i := len(args); i < f.Arity; i++ {
+		fitted[i] = Null
+	}
+	if f.Variadic {
+		rest := []Value{}
+		if len(args) > f.Arity {
+			rest = append(rest, args[f.Arity:]...)
+		}
+		fitted = append(fitted, &Array{Elements: rest})
+	}
+	return fitted
+}
+
+// spread concatenates the arrays passed as the arguments of a call.
+func spread(line, column int, arrays ...Value) []Value {
+	args := []Value{}
+	for _, v := range arrays {
+		a, ok := v.(*Array)
+		if !ok {
+			fail(line, column, "cannot spread %s as arguments", v.Type())
+		}
+		args = append(args, a.Elements...)
+	}
+	return args
+}
+
+func array(elements ...Value) Value {
+	return &Array{Elements: elements}
+}
+
+func hashable(v Value) bool {
+	switch v.(type) {
+	case Int, Bool, Str:
+		return true
+	}
+	return false
+}
+
+// hash builds a hash from alternate keys and values.
+func hash(line, column int, keysAndValues ...Value) Value {
+	h := &Hash{index: make(map[Value]int)}
+	for i := 0; i < len(keysAndValues); i += 2 {
+		key, value := keysAndValues[i], keysAndValues[i+1]
+		if !hashable(key) {
+			fail(line, column, "unusable as hash key: %s", key.Type())
+		}
+		if j, ok := h.index[key]; ok {
+			h.Pairs[j].Value = value
+			continue
+		}
+		h.index[key] = len(h.Pairs)
+		h.Pairs = append(h.Pairs, HashPair{Key: key, Value: value})
+	}
+	return h
+}
+
+func index(line, column int, left, index Value) Value {
+	switch left := left.(type) {
+	case *Array:
+		i, ok := index.(Int)
+		if !ok {
+			break
+		}
+		if i < 0 || i >= Int(len(left.Elements)) {
+			return Null
+		}
+		return left.Elements[i]
+
+	case *Hash:
+		if !hashable(index) {
+			fail(line, column, "unusable as hash key: %s", index.Type())
+		}
+		if i, ok := left.index[index]; ok {
+			return left.Pairs[i].Value
+		}
+		return Null
+	}
+
+	fail(line, column, "index operator not supported: %s[%s]", left.Type(), index.Type())
+	return nil
+}
+
+func setIndex(line, column int, left, index, value Value) {
+	switch left := left.(type) {
+	case *Array:
+		i, ok := index.(Int)
+		if !ok {
+			break
+		}
+		if i < 0 || i >= Int(len(left.Elements)) {
+			fail(line, column, "index %d out of range for array of length %d", i, len(left.Elements))
+		}
+		left.Elements[i] = value
+		return
+
+	case *Hash:
+		if !hashable(index) {
+			fail(line, column, "unusable as hash key: %s", index.Type())
+		}
+		if i, ok := left.index[index]; ok {
+			left.Pairs[i].Value = value
+			return
+		}
+		left.index[index] = len(left.Pairs)
+		left.Pairs = append(left.Pairs, HashPair{Key: index, Value: value})
+		return
+	}
+
+	fail(line, column, "index assignment not supported: %s[%s]", left.Type(), index.Type())
+}
+
+// checkArray fails unless v is an array of min to max elements, or at least
+// min if max is negative.
+func checkArray(line, column int, v Value, min, max int) {
+	array, ok := v.(*Array)
+	if !ok {
+		fail(line, column, "cannot destructure %s as an array", v.Type())
+	}
+
+	n := len(array.Elements)
+	switch {
+	case max < 0 && n < min:
+		fail(line, column, "cannot destructure array of length %d into at least %d elements", n, min)
+	case max < 0:
+	case n < min || n > max:
+		if min == max {
+			fail(line, column, "cannot destructure array of length %d into %d elements", n, max)
+		}
+		fail(line, column, "cannot destructure array of length %d into %d to %d elements", n, min, max)
+	}
+}
+
+func checkHash(line, column int, v Value) {
+	if _, ok := v.(*Hash); !ok {
+		fail(line, column, "cannot destructure %s as a hash", v.Type())
+	}
+}
+
+// isArray reports whether v is an array of min to max elements, or at least
+// min if max is negative.
+func isArray(v Value, min, max int) bool {
+	array, ok := v.(*Array)
+	if !ok {
+		return false
+	}
+	n := len(array.Elements)
+	return n >= min && (max < 0 || n <= max)
+}
+
+func isHash(v Value) bool {
+	_, ok := v.(*Hash)
+	return ok
+}
+
+// hasKey reports whether the hash v has key.
+func hasKey(v Value, key Str) bool {
+	_, ok := v.(*Hash).index[key]
+	return ok
+}
+
+// rest copies the elements of an array from start on.
+func rest(v Value, start int) Value {
+	return &Array{Elements: append([]Value(nil), v.(*Array).Elements[start:]...)}
+}
+
+// field returns the value of key in a hash, failing if it is missing.
+func field(line, column int, v Value, key Str) Value {
+	h := v.(*Hash)
+	i, ok := h.index[key]
+	if !ok {
+		fail(line, column, "missing key %q in destructured hash", string(key))
+	}
+	return h.Pairs[i].Value
+}
+
+func rangeOf(line, column int, start, end, step Value, inclusive bool) Value {
+	var bounds [3]Int
+	for i, bound := range []Value{start, end, step} {
+		integer, ok := bound.(Int)
+		if !ok {
+			fail(line, column, "range bounds must be integers, got %s", bound.Type())
+		}
+		bounds[i] = integer
+	}
+	if bounds[2] == 0 {
+		fail(line, column, "range step must not be zero")
+	}
+	return &Range{Start: bounds[0], End: bounds[1], Step: bounds[2], Inclusive: inclusive}
+}
+
+// iterator steps through the elements of an iterable along with their
+// keys, which are their positions except for hashes.
+type iterator struct {
+	next func() (key, value Value, ok bool)
+	keys bool // a single loop variable takes the keys
+}
+
+func iterate(line, column int, v Value) *iterator {
+	pos := 0
+	switch v := v.(type) {
+	case *Array:
+		return &iterator{next: func() (Value, Value, bool) {
+			if pos >= len(v.Elements) {
+				return nil, nil, false
+			}
+			pos++
+			return Int(pos - 1), v.Elements[pos-1], true
+		}}
+
+	case *Hash:
+		return &iterator{keys: true, next: func() (Value, Value, bool) {
+			if pos >= len(v.Pairs) {
+				return nil, nil, false
+			}
+			pos++
+			return v.Pairs[pos-1].Key, v.Pairs[pos-1].Value, true
+		}}
+
+	case Str:
+		offset := 0
+		return &iterator{next: func() (Value, Value, bool) {
+			if offset >= len(v) {
+				return nil, nil, false
+			}
+			_, size := utf8.DecodeRuneInString(string(v[offset:]))
+			value := v[offset : offset+size]
+			offset += size
+			pos++
+			return Int(pos - 1), value, true
+		}}
+
+	case *Range:
+		next, done := v.Start, false
+		return &iterator{next: func() (Value, Value, bool) {
+			if done || !v.includes(next) {
+				return nil, nil, false
+			}
+			value := next
+			next += v.Step
+			done = (v.Step > 0) != (next > value)
+			pos++
+			return Int(pos - 1), value, true
+		}}
+	}
+
+	fail(line, column, "cannot iterate over %s", v.Type())
+	return nil
+}
+
+func (r *Range) includes(v Int) bool {
+	switch {
+	case r.Step > 0 && r.Inclusive:
+		return v <= r.End
+	case r.Step > 0:
+		return v < r.End
+	case r.Inclusive:
+		return v >= r.End
+	default:
+		return v > r.End
+	}
+}
+
+func truthy(v Value) bool {
+	switch v := v.(type) {
+	case Bool:
+		return bool(v)
+	case NullValue:
+		return false
+	default:
+		return true
+	}
+}
+
+func not(v Value) Value {
+	return Bool(!truthy(v))
+}
+
+func negate(line, column int, v Value) Value {
+	i, ok := v.(Int)
+	if !ok {
+		fail(line, column, "unsupported type for negation: %s", v.Type())
+	}
+	return -i
+}
+
+func integers(line, column int, left, right Value) (Int, Int) {
+	l, lok := left.(Int)
+	r, rok := right.(Int)
+	if !lok || !rok {
+		fail(line, column, "unsupported types for binary operation: %s %s", left.Type(), right.Type())
+	}
+	return l, r
+}
+
+func add(line, column int, left, right Value) Value {
+	if l, ok := left.(Str); ok {
+		if r, ok := right.(Str); ok {
+			return l + r
+		}
+	}
+	l, r := integers(line, column, left, right)
+	return l + r
+}
+
+func sub(line, column int, left, right Value) Value {
+	l, r := integers(line, column, left, right)
+	return l - r
+}
+
+func mul(line, column int, left, right Value) Value {
+	l, r := integers(line, column, left, right)
+	return l * r
+}
+
+func div(line, column int, left, right Value) Value {
+	l, r := integers(line, column, left, right)
+	if r == 0 {
+		fail(line, column, "division by zero")
+	}
+	return l / r
+}
+
+func mod(line, column int, left, right Value) Value {
+	l, r := integers(line, column, left, right)
+	if r == 0 {
+		fail(line, column, "division by zero")
+	}
+	return l % r
+}
+
+func pow(line, column int, left, right Value) Value {
+	base, exp := integers(line, column, left, right)
+	if exp < 0 {
+		fail(line, column, "negative exponent: %d", exp)
+	}
+	result := Int(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return result
+}
+
+func eq(line, column int, left, right Value) Value {
+	return Bool(left == right)
+}
+
+func ne(line, column int, left, right Value) Value {
+	return Bool(left != right)
+}
+
+func gt(line, column int, left, right Value) Value {
+	l, lok := left.(Int)
+	r, rok := right.(Int)
+	if !lok || !rok {
+		fail(line, column, "unknown operator: > (%s %s)", left.Type(), right.Type())
+	}
+	return Bool(l > r)
+}
+
+func lt(line, column int, left, right Value) Value {
+	l, lok := left.(Int)
+	r, rok := right.(Int)
+	if !lok || !rok {
+		fail(line, column, "unknown operator: < (%s %s)", left.Type(), right.Type())
+	}
+	return Bool(l < r)
+}
+`
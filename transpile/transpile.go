@@ -0,0 +1,1176 @@
+// Package transpile converts Monkey programs to Go source.
+//
+// The generated program is a single self-contained main package that
+// carries a small runtime for dynamic values. Running it prints the value
+// of the program, like "monkey run", and runtime errors
+// are reported with the same messages and positions as the VM.
+package transpile
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strconv"
+	"strings"
+
+	"interpreter/ast"
+	"interpreter/token"
+)
+
+// Transpile returns the gofmt-formatted Go source of program. The source
+// name is used in the runtime errors of the generated program.
+func Transpile(program *ast.Program, source string) ([]byte, error) {
+	t := &transpiler{globals: make(map[string]bool), consts: make(map[string]bool)}
+	t.scope = &scope{
+		defined: make(map[string]bool),
+		consts:  make(map[string]bool),
+		names:   make(map[string]string),
+	}
+
+	body, err := t.main(program)
+	if err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	out.WriteString("// Code generated by monkey transpile. DO NOT EDIT.\n\n")
+	out.WriteString("package main\n\n")
+	out.WriteString("import (\n\"fmt\"\n\"os\"\n\"strconv\"\n\"strings\"\n\"unicode/utf8\"\n)\n\n")
+	fmt.Fprintf(&out, "const source = %s\n\n", strconv.Quote(source))
+
+	if len(t.globalNames) > 0 || len(t.scope.vars) > 0 {
+		out.WriteString("var (\n")
+		for _, name := range t.globalNames {
+			fmt.Fprintf(&out, "%s Value\n", mangle(name))
+		}
+		for _, name := range t.scope.vars {
+			fmt.Fprintf(&out, "%s Value\n", name)
+		}
+		out.WriteString(")\n\n")
+	}
+
+	for i, fn := range t.functions {
+		fmt.Fprintf(&out, "var fn%d = %s\n\n", i, fn)
+	}
+
+	out.WriteString("func run() Value {\n")
+	out.Write(body)
+	out.WriteString("}\n")
+	out.WriteString(runtime)
+
+	return format.Source(out.Bytes())
+}
+
+type transpiler struct {
+	globals     map[string]bool
+	globalNames []string        // in order of definition
+	consts      map[string]bool // globals declared with const
+	functions   []string        // hoisted function literals
+	vars        int             // Go variables of captured locals, for unique names
+
+	scope *scope
+}
+
+// scope is the function being generated, or the main program whose
+// defined names are only those bound by the match arm being generated.
+type scope struct {
+	parent  *scope
+	out     bytes.Buffer
+	locals  map[string]bool // declared at the top of the function
+	defined map[string]bool // locals whose let has been generated
+	consts  map[string]bool // locals declared with const
+	temps   int
+
+	// captured is set when the function contains nested functions, which
+	// become Go closures. Every declaration of a local then gets a Go
+	// variable of its own in names, so that the closures made before a
+	// redeclaration keep the previous binding like in the VM. The names
+	// bound by match arms get one in every scope.
+	captured bool
+	names    map[string]string
+	vars     []string // declared at the top of the function, or the package
+
+	tailCalls map[*ast.CallExpression]bool
+}
+
+func (t *transpiler) main(program *ast.Program) ([]byte, error) {
+	s := t.scope
+	s.out.WriteString("var last Value\n")
+
+	for _, stmt := range program.Statements {
+		if err := t.statement(stmt); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(program.Statements) > 0 && !program.EndsWithExpression() {
+		s.out.WriteString("last = Null\n")
+	}
+	s.out.WriteString("return last\n")
+	return s.out.Bytes(), nil
+}
+
+func (t *transpiler) statement(stmt ast.Statement) error {
+	out := &t.scope.out
+
+	switch stmt := stmt.(type) {
+	case *ast.ExpressionStatement:
+		value, err := t.expression(stmt.Expression)
+		if err != nil {
+			return err
+		}
+		// the program's value is that of the last expression statement run
+		if t.scope.parent == nil {
+			fmt.Fprintf(out, "last = %s\n", value)
+		} else {
+			fmt.Fprintf(out, "_ = %s\n", value)
+		}
+
+	case *ast.LetStatement:
+		if stmt.Pattern != nil {
+			value, err := t.expression(stmt.Value)
+			if err != nil {
+				return err
+			}
+			// the value may be a variable the pattern binds
+			return t.destructure(stmt.Pattern, t.temp("%s", value), stmt.Const)
+		}
+
+		name := stmt.Name.Value
+		if t.isConstant(name) {
+			return redeclaredConstant(stmt.Name)
+		}
+		if stmt.Const {
+			t.declareConstant(name)
+		}
+
+		// a function may refer to the binding it is assigned to
+		_, isFunction := stmt.Value.(*ast.FunctionLiteral)
+		if isFunction {
+			t.define(name)
+		}
+		value, err := t.expression(stmt.Value)
+		if err != nil {
+			return err
+		}
+		if !isFunction {
+			t.define(name)
+		}
+		fmt.Fprintf(out, "%s = %s\n", t.variable(name), value)
+
+	case *ast.ReturnStatement:
+		if t.scope.parent == nil {
+			return fmt.Errorf("return outside of a function")
+		}
+		value, err := t.expression(stmt.ReturnValue)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "return %s\n", value)
+
+	case *ast.WhileStatement:
+		out.WriteString("for {\n")
+		if err := t.loopCondition(stmt.Condition); err != nil {
+			return err
+		}
+		if err := t.statements(stmt.Body); err != nil {
+			return err
+		}
+		out.WriteString("}\n")
+
+	case *ast.ForStatement:
+		if stmt.Init != nil {
+			if err := t.statement(stmt.Init); err != nil {
+				return err
+			}
+		}
+
+		// the update runs before every iteration but the first, so that
+		// continue, which goes to the next iteration, runs it too
+		if stmt.Update == nil {
+			out.WriteString("for {\n")
+		} else {
+			first := t.newTemp()
+			fmt.Fprintf(out, "for %s := true; ; %s = false {\n", first, first)
+			fmt.Fprintf(out, "if !%s {\n", first)
+			if err := t.statement(stmt.Update); err != nil {
+				return err
+			}
+			out.WriteString("}\n")
+		}
+		if stmt.Condition != nil {
+			if err := t.loopCondition(stmt.Condition); err != nil {
+				return err
+			}
+		}
+		if err := t.statements(stmt.Body); err != nil {
+			return err
+		}
+		out.WriteString("}\n")
+
+	case *ast.ForInStatement:
+		iterable, err := t.expression(stmt.Iterable)
+		if err != nil {
+			return err
+		}
+		it := t.temp("iterate(%s, %s)", position(stmt.Token), iterable)
+		key, value, ok := t.newTemp(), t.newTemp(), t.newTemp()
+
+		// continue goes to the next iteration like in the VM
+		out.WriteString("for {\n")
+		fmt.Fprintf(out, "%s, %s, %s := %s.next()\n", key, value, ok, it)
+		fmt.Fprintf(out, "if !%s {\nbreak\n}\n", ok)
+		for _, v := range stmt.Variables {
+			if t.isConstant(v.Value) {
+				return redeclaredConstant(v)
+			}
+			t.define(v.Value)
+		}
+		if len(stmt.Variables) == 2 {
+			fmt.Fprintf(out, "%s, %s = %s, %s\n", t.variable(stmt.Variables[0].Value), t.variable(stmt.Variables[1].Value), key, value)
+		} else {
+			name := t.variable(stmt.Variables[0].Value)
+			fmt.Fprintf(out, "if %s.keys {\n%s = %s\n} else {\n%s = %s\n}\n", it, name, key, name, value)
+		}
+		if err := t.statements(stmt.Body); err != nil {
+			return err
+		}
+		out.WriteString("}\n")
+
+	case *ast.BreakStatement:
+		out.WriteString("break\n")
+
+	case *ast.ContinueStatement:
+		out.WriteString("continue\n")
+
+	default:
+		return fmt.Errorf("cannot transpile %T", stmt)
+	}
+
+	return nil
+}
+
+// Binds name to a new Go variable, which shadows the binding of name in the
+// current function or the main program, and returns it.
+func (t *transpiler) bindNew(name string) string {
+	s := t.scope
+	t.vars++
+	v := fmt.Sprintf("v%d_%s", t.vars, name)
+	s.vars = append(s.vars, v)
+	s.names[name] = v
+	s.defined[name] = true
+	delete(s.consts, name)
+	return v
+}
+
+// Returns a function restoring the bindings of names in the current scope
+// as they are now, which ends the scope of the variables bound to them in
+// between by bindNew.
+func (t *transpiler) shadow(names []string) func() {
+	s := t.scope
+	type binding struct {
+		defined, constant bool
+		name              string
+		named             bool
+	}
+	saved := make(map[string]binding, len(names))
+	for _, name := range names {
+		b := binding{defined: s.defined[name], constant: s.consts[name]}
+		b.name, b.named = s.names[name]
+		saved[name] = b
+	}
+	return func() {
+		for name, b := range saved {
+			s.defined[name] = b.defined
+			s.consts[name] = b.constant
+			if b.named {
+				s.names[name] = b.name
+			} else {
+				delete(s.names, name)
+			}
+		}
+	}
+}
+
+// Generates the statements binding the names of the pattern to the parts
+// of value, checking it against the shape of the pattern.
+func (t *transpiler) destructure(pattern ast.Pattern, value string, constant bool) error {
+	out := &t.scope.out
+
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		name := pattern.Value
+		if t.isConstant(name) {
+			return redeclaredConstant(pattern)
+		}
+		if constant {
+			t.declareConstant(name)
+		}
+		t.define(name)
+		fmt.Fprintf(out, "%s = %s\n", t.variable(name), value)
+
+	case *ast.ArrayPattern:
+		min, max := arrayBounds(pattern)
+		fmt.Fprintf(out, "checkArray(%s, %s, %d, %d)\n", position(pattern.Token), value, min, max)
+
+		for i, e := range pattern.Elements {
+			element := t.temp("index(%s, %s, Int(%d))", position(pattern.Token), value, i)
+			if err := t.destructure(e, element, constant); err != nil {
+				return err
+			}
+		}
+		if pattern.Rest != nil {
+			rest := t.temp("rest(%s, %d)", value, len(pattern.Elements))
+			if err := t.destructure(pattern.Rest, rest, constant); err != nil {
+				return err
+			}
+		}
+
+	case *ast.HashPattern:
+		fmt.Fprintf(out, "checkHash(%s, %s)\n", position(pattern.Token), value)
+
+		for _, pair := range pattern.Pairs {
+			key := fmt.Sprintf("Str(%q)", pair.Key.Value)
+			var field string
+			if _, ok := pair.Value.(*ast.DefaultPattern); ok {
+				field = t.temp("index(%s, %s, %s)", position(pair.Key.Token), value, key)
+			} else {
+				field = t.temp("field(%s, %s, %s)", position(pair.Key.Token), value, key)
+			}
+			if err := t.destructure(pair.Value, field, constant); err != nil {
+				return err
+			}
+		}
+
+	case *ast.DefaultPattern:
+		fmt.Fprintf(out, "if %s == Null {\n", value)
+		def, err := t.expression(pattern.Default)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s = %s\n}\n", value, def)
+		return t.destructure(pattern.Target, value, constant)
+
+	default:
+		return fmt.Errorf("cannot transpile %T", pattern)
+	}
+	return nil
+}
+
+// Returns the number of elements an array may have for pattern, max is -1 if
+// it has a rest. Elements after the last one without a default may be
+// missing.
+func arrayBounds(pattern *ast.ArrayPattern) (min, max int) {
+	for i, e := range pattern.Elements {
+		if _, ok := e.(*ast.DefaultPattern); !ok {
+			min = i + 1
+		}
+	}
+	max = len(pattern.Elements)
+	if pattern.Rest != nil {
+		max = -1
+	}
+	return min, max
+}
+
+// Generates an arm of a match testing value, which jumps to the label fail
+// if the value does not match, and returns the Go expression of its body.
+// The names of its pattern are new variables that only its guard and body
+// see, so that neither the enclosing scope nor the other arms are affected
+// by its bindings.
+func (t *transpiler) matchArm(arm *ast.MatchArm, value, fail string) (string, error) {
+	defer t.shadow(ast.PatternNames(arm.Pattern))()
+
+	if err := t.match(arm.Pattern, value, fail); err != nil {
+		return "", err
+	}
+	if arm.Guard != nil {
+		guard, err := t.expression(arm.Guard)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&t.scope.out, "if !truthy(%s) {\ngoto %s\n}\n", guard, fail)
+	}
+	return t.expression(arm.Body)
+}
+
+// Generates the test of the pattern of a match arm against value, which
+// binds its names and jumps to the label fail if the value does not match.
+func (t *transpiler) match(pattern ast.Pattern, value, fail string) error {
+	out := &t.scope.out
+
+	switch pattern := pattern.(type) {
+	case *ast.WildcardPattern:
+
+	case *ast.Identifier:
+		fmt.Fprintf(out, "%s = %s\n", t.bindNew(pattern.Value), value)
+
+	case *ast.LiteralPattern:
+		literal, err := t.expression(pattern.Value)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "if %s != %s {\ngoto %s\n}\n", value, literal, fail)
+
+	case *ast.ArrayPattern:
+		min, max := arrayBounds(pattern)
+		fmt.Fprintf(out, "if !isArray(%s, %d, %d) {\ngoto %s\n}\n", value, min, max, fail)
+
+		for i, e := range pattern.Elements {
+			// Go rejects temporaries that are never used
+			if _, ok := e.(*ast.WildcardPattern); ok {
+				continue
+			}
+			element := t.temp("index(%s, %s, Int(%d))", position(pattern.Token), value, i)
+			if err := t.match(e, element, fail); err != nil {
+				return err
+			}
+		}
+		if pattern.Rest != nil {
+			rest := t.temp("rest(%s, %d)", value, len(pattern.Elements))
+			if err := t.match(pattern.Rest, rest, fail); err != nil {
+				return err
+			}
+		}
+
+	case *ast.HashPattern:
+		fmt.Fprintf(out, "if !isHash(%s) {\ngoto %s\n}\n", value, fail)
+
+		for _, pair := range pattern.Pairs {
+			key := fmt.Sprintf("Str(%q)", pair.Key.Value)
+			if _, ok := pair.Value.(*ast.DefaultPattern); !ok {
+				fmt.Fprintf(out, "if !hasKey(%s, %s) {\ngoto %s\n}\n", value, key, fail)
+			}
+			if _, ok := pair.Value.(*ast.WildcardPattern); ok {
+				continue
+			}
+			field := t.temp("index(%s, %s, %s)", position(pair.Key.Token), value, key)
+			if err := t.match(pair.Value, field, fail); err != nil {
+				return err
+			}
+		}
+
+	case *ast.DefaultPattern:
+		fmt.Fprintf(out, "if %s == Null {\n", value)
+		def, err := t.expression(pattern.Default)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "%s = %s\n}\n", value, def)
+		return t.match(pattern.Target, value, fail)
+
+	default:
+		return fmt.Errorf("cannot transpile %T", pattern)
+	}
+	return nil
+}
+
+// Reports whether the arm may not match, jumping to the label after it.
+func refutable(arm *ast.MatchArm) bool {
+	switch arm.Pattern.(type) {
+	case *ast.Identifier, *ast.WildcardPattern:
+		return arm.Guard != nil
+	}
+	return true
+}
+
+// Generates the statements that leave the loop once condition is false.
+func (t *transpiler) loopCondition(condition ast.Expression) error {
+	value, err := t.expression(condition)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(&t.scope.out, "if !truthy(%s) {\nbreak\n}\n", value)
+	return nil
+}
+
+func (t *transpiler) statements(b *ast.BlockStatement) error {
+	for _, stmt := range b.Statements {
+		if err := t.statement(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t *transpiler) define(name string) {
+	if s := t.scope; s.parent != nil {
+		s.defined[name] = true
+		if s.captured {
+			t.vars++
+			s.names[name] = fmt.Sprintf("v%d_%s", t.vars, name)
+			s.vars = append(s.vars, s.names[name])
+		}
+		return
+	}
+	if !t.globals[name] {
+		t.globals[name] = true
+		t.globalNames = append(t.globalNames, name)
+	}
+}
+
+// Generates the statements of the block, assigning its value to the
+// variable dst: the value of its last expression statement, or null if it
+// does not end with one.
+func (t *transpiler) block(b *ast.BlockStatement, dst string) error {
+	for i, stmt := range b.Statements {
+		if es, ok := stmt.(*ast.ExpressionStatement); ok && i == len(b.Statements)-1 {
+			value, err := t.expression(es.Expression)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(&t.scope.out, "%s = %s\n", dst, value)
+			return nil
+		}
+		if err := t.statement(stmt); err != nil {
+			return err
+		}
+	}
+
+	if n := len(b.Statements); n > 0 {
+		if _, ok := b.Statements[n-1].(*ast.ReturnStatement); ok {
+			return nil
+		}
+	}
+	fmt.Fprintf(&t.scope.out, "%s = Null\n", dst)
+	return nil
+}
+
+// Generates the statements that evaluate e and returns a Go expression for
+// its value. Everything but literals is evaluated into a temporary, so that
+// operands are evaluated in source order even when later ones need
+// statements of their own.
+func (t *transpiler) expression(e ast.Expression) (string, error) {
+	switch e := e.(type) {
+	case *ast.IntegerLiteral:
+		return fmt.Sprintf("Int(%d)", e.Value), nil
+
+	case *ast.StringLiteral:
+		return fmt.Sprintf("Str(%q)", e.Value), nil
+
+	case *ast.Boolean:
+		return fmt.Sprintf("Bool(%t)", e.Value), nil
+
+	case *ast.Identifier:
+		if err := t.resolve(e.Value); err != nil {
+			return "", err
+		}
+		return t.temp(t.variable(e.Value)), nil
+
+	case *ast.PrefixExpression:
+		right, err := t.expression(e.Right)
+		if err != nil {
+			return "", err
+		}
+		switch e.Operator {
+		case "!":
+			return t.temp("not(%s)", right), nil
+		case "-":
+			return t.temp("negate(%s, %s)", position(e.Token), right), nil
+		default:
+			return "", fmt.Errorf("unknown operator %s", e.Operator)
+		}
+
+	case *ast.InfixExpression:
+		left, err := t.expression(e.Left)
+		if err != nil {
+			return "", err
+		}
+		right, err := t.expression(e.Right)
+		if err != nil {
+			return "", err
+		}
+		fn, ok := infixFunctions[e.Operator]
+		if !ok {
+			return "", fmt.Errorf("unknown operator %s", e.Operator)
+		}
+		return t.temp("%s(%s, %s, %s)", fn, position(e.Token), left, right), nil
+
+	case *ast.AssignExpression:
+		switch target := e.Target.(type) {
+		case *ast.Identifier:
+			return t.assignVariable(e, target)
+		case *ast.IndexExpression:
+			return t.assignIndex(e, target)
+		default:
+			return "", fmt.Errorf("cannot assign to %s", e.Target)
+		}
+
+	case *ast.IfExpression:
+		condition, err := t.expression(e.Condition)
+		if err != nil {
+			return "", err
+		}
+
+		result := t.newTemp()
+		out := &t.scope.out
+		fmt.Fprintf(out, "var %s Value\n", result)
+		fmt.Fprintf(out, "if truthy(%s) {\n", condition)
+		if err := t.block(e.Consequence, result); err != nil {
+			return "", err
+		}
+		out.WriteString("} else {\n")
+		if e.Alternative == nil {
+			fmt.Fprintf(out, "%s = Null\n", result)
+		} else if err := t.block(e.Alternative, result); err != nil {
+			return "", err
+		}
+		out.WriteString("}\n")
+		return result, nil
+
+	case *ast.MatchExpression:
+		out := &t.scope.out
+		value, err := t.expression(e.Value)
+		if err != nil {
+			return "", err
+		}
+		// the arms must not see the bindings of earlier arms in the value,
+		// which is unused if they are all wildcards
+		value = t.temp("%s", value)
+		fmt.Fprintf(out, "_ = %s\n", value)
+
+		// each arm is a block jumping to the label after it when the value
+		// does not match, so that its temporaries are out of scope there
+		result := t.newTemp()
+		end := "L" + t.newTemp()
+		fmt.Fprintf(out, "var %s Value = Null\n", result)
+		for _, arm := range e.Arms {
+			next := "L" + t.newTemp()
+			out.WriteString("{\n")
+			body, err := t.matchArm(arm, value, next)
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(out, "%s = %s\ngoto %s\n}\n", result, body, end)
+			if refutable(arm) {
+				fmt.Fprintf(out, "%s:\n", next)
+			}
+		}
+		if len(e.Arms) > 0 {
+			fmt.Fprintf(out, "%s:\n", end)
+		}
+		return result, nil
+
+	case *ast.FunctionLiteral:
+		fn, err := t.function(e)
+		if err != nil {
+			return "", err
+		}
+		// functions nested in others capture the variables of the call
+		// evaluating them, the others are hoisted to a package level
+		// variable so that they are the same value every time, like a
+		// constant of the VM
+		if t.scope.captured {
+			return t.temp("%s", fn), nil
+		}
+		name := fmt.Sprintf("fn%d", len(t.functions))
+		t.functions = append(t.functions, fn)
+		return name, nil
+
+	case *ast.ArrayLiteral:
+		elements, err := t.expressions(e.Elements)
+		if err != nil {
+			return "", err
+		}
+		return t.temp("array(%s)", strings.Join(elements, ", ")), nil
+
+	case *ast.HashLiteral:
+		var keysAndValues []string
+		for _, pair := range e.Pairs {
+			kv, err := t.expressions([]ast.Expression{pair.Key, pair.Value})
+			if err != nil {
+				return "", err
+			}
+			keysAndValues = append(keysAndValues, kv...)
+		}
+		args := append([]string{position(e.Token)}, keysAndValues...)
+		return t.temp("hash(%s)", strings.Join(args, ", ")), nil
+
+	case *ast.IndexExpression:
+		operands, err := t.expressions([]ast.Expression{e.Left, e.Index})
+		if err != nil {
+			return "", err
+		}
+		return t.temp("index(%s, %s, %s)", position(e.Token), operands[0], operands[1]), nil
+
+	case *ast.RangeExpression:
+		bounds := []ast.Expression{e.Start, e.End}
+		if e.Step != nil {
+			bounds = append(bounds, e.Step)
+		}
+		operands, err := t.expressions(bounds)
+		if err != nil {
+			return "", err
+		}
+		if e.Step == nil {
+			operands = append(operands, "Int(1)")
+		}
+		return t.temp("rangeOf(%s, %s, %t)", position(e.Token), strings.Join(operands, ", "), e.Inclusive), nil
+
+	case *ast.CallExpression:
+		callee, err := t.expression(e.Function)
+		if err != nil {
+			return "", err
+		}
+		args, err := t.arguments(e)
+		if err != nil {
+			return "", err
+		}
+		if t.scope.tailCalls[e] {
+			return t.temp("tailCall(%s, %s%s)", position(e.Token), callee, args), nil
+		}
+		return t.temp("call(%s, %s%s)", position(e.Token), callee, args), nil
+
+	default:
+		return "", fmt.Errorf("cannot transpile %T", e)
+	}
+}
+
+// Generates the arguments of a call, which follow the callee. Spread
+// arguments and the runs of others between them are passed as arrays to
+// spread.
+func (t *transpiler) arguments(e *ast.CallExpression) (string, error) {
+	var arrays, run []string
+	spreads := false
+	for _, a := range e.Arguments {
+		s, ok := a.(*ast.SpreadExpression)
+		if !ok {
+			arg, err := t.expression(a)
+			if err != nil {
+				return "", err
+			}
+			run = append(run, arg)
+			continue
+		}
+
+		if len(run) > 0 {
+			arrays = append(arrays, t.temp("array(%s)", strings.Join(run, ", ")))
+			run = nil
+		}
+		value, err := t.expression(s.Value)
+		if err != nil {
+			return "", err
+		}
+		arrays = append(arrays, value)
+		spreads = true
+	}
+
+	if !spreads {
+		return joinArguments(run), nil
+	}
+	if len(run) > 0 {
+		arrays = append(arrays, t.temp("array(%s)", strings.Join(run, ", ")))
+	}
+	return fmt.Sprintf(", spread(%s%s)...", position(e.Token), joinArguments(arrays)), nil
+}
+
+// Joins arguments following others.
+func joinArguments(args []string) string {
+	out := ""
+	for _, a := range args {
+		out += ", " + a
+	}
+	return out
+}
+
+// Generates an assignment to a variable and returns the assigned value.
+func (t *transpiler) assignVariable(e *ast.AssignExpression, target *ast.Identifier) (string, error) {
+	if err := t.resolve(target.Value); err != nil {
+		return "", err
+	}
+	if t.constant(target.Value) {
+		return "", assignedConstant(e)
+	}
+
+	// the current value is read before the operand is evaluated
+	variable := t.variable(target.Value)
+	var current string
+	if e.Operator != "" {
+		current = t.temp(variable)
+	}
+	value, err := t.expression(e.Value)
+	if err != nil {
+		return "", err
+	}
+	if e.Operator != "" {
+		if value, err = t.compoundOperator(e, current, value); err != nil {
+			return "", err
+		}
+	}
+
+	fmt.Fprintf(&t.scope.out, "%s = %s\n", variable, value)
+	return value, nil
+}
+
+// Generates an assignment to an element of an array or a hash and returns
+// the assigned value. The indexed object and the index are evaluated once.
+func (t *transpiler) assignIndex(e *ast.AssignExpression, target *ast.IndexExpression) (string, error) {
+	operands, err := t.expressions([]ast.Expression{target.Left, target.Index})
+	if err != nil {
+		return "", err
+	}
+	left, index := operands[0], operands[1]
+
+	var current string
+	if e.Operator != "" {
+		current = t.temp("index(%s, %s, %s)", position(e.Token), left, index)
+	}
+	value, err := t.expression(e.Value)
+	if err != nil {
+		return "", err
+	}
+	if e.Operator != "" {
+		if value, err = t.compoundOperator(e, current, value); err != nil {
+			return "", err
+		}
+	}
+
+	fmt.Fprintf(&t.scope.out, "setIndex(%s, %s, %s, %s)\n", position(e.Token), left, index, value)
+	return value, nil
+}
+
+// Generates the operation of a compound assignment and returns its result.
+func (t *transpiler) compoundOperator(e *ast.AssignExpression, current, operand string) (string, error) {
+	fn, ok := infixFunctions[e.Operator]
+	if !ok {
+		return "", fmt.Errorf("unknown operator %s", e.Operator)
+	}
+	return t.temp("%s(%s, %s, %s)", fn, position(e.Token), current, operand), nil
+}
+
+// Generates the expressions in order and returns their values.
+func (t *transpiler) expressions(es []ast.Expression) ([]string, error) {
+	values := make([]string, len(es))
+	for i, e := range es {
+		value, err := t.expression(e)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = value
+	}
+	return values, nil
+}
+
+var infixFunctions = map[string]string{
+	"+":  "add",
+	"-":  "sub",
+	"*":  "mul",
+	"/":  "div",
+	"%":  "mod",
+	"**": "pow",
+	"==": "eq",
+	"!=": "ne",
+	">":  "gt",
+	"<":  "lt",
+}
+
+// Generates the Go value of the function literal.
+func (t *transpiler) function(e *ast.FunctionLiteral) (string, error) {
+	s := &scope{
+		parent:  t.scope,
+		locals:  make(map[string]bool),
+		defined: make(map[string]bool),
+		consts:  make(map[string]bool),
+
+		tailCalls: ast.TailCalls(e),
+		captured:  e.NestsFunctions(),
+		names:     make(map[string]string),
+	}
+	t.scope = s
+	defer func() { t.scope = s.parent }()
+
+	names := e.Parameters
+	if e.Rest != nil {
+		names = append(names[:len(names):len(names)], e.Rest)
+	}
+	var params []string
+	for i, p := range names {
+		// the last of repeated parameters wins
+		if s.locals[p.Value] {
+			fmt.Fprintf(&s.out, "%s = args[%d]\n", t.variable(p.Value), i)
+			continue
+		}
+		s.locals[p.Value] = true
+		t.define(p.Value)
+		params = append(params, t.variable(p.Value))
+		fmt.Fprintf(&s.out, "%s := args[%d]\n", t.variable(p.Value), i)
+	}
+	// the parameters are declared by assigning the arguments
+	s.vars = nil
+	header := s.out.String()
+	s.out.Reset()
+
+	// the locals of captured functions are collected as they are generated
+	var locals []string
+	if !s.captured {
+		for _, d := range e.Defaults {
+			declareLocals(s, d, &locals)
+		}
+		declareLocals(s, e.Body, &locals)
+		for i, name := range locals {
+			locals[i] = mangle(name)
+		}
+	}
+
+	// missing arguments are null, which the defaults replace in order
+	for i, p := range e.Parameters {
+		d := e.Default(i)
+		if d == nil {
+			continue
+		}
+		fmt.Fprintf(&s.out, "if args[%d] == Null {\n", i)
+		value, err := t.expression(d)
+		if err != nil {
+			return "", err
+		}
+		if shadowed(e, i) {
+			fmt.Fprintf(&s.out, "_ = %s\n}\n", value)
+		} else {
+			fmt.Fprintf(&s.out, "%s = %s\n}\n", t.variable(p.Value), value)
+		}
+	}
+
+	result := t.newTemp()
+	fmt.Fprintf(&s.out, "var %s Value\n", result)
+	if err := t.block(e.Body, result); err != nil {
+		return "", err
+	}
+	fmt.Fprintf(&s.out, "return %s\n", result)
+
+	var body strings.Builder
+	body.WriteString(header)
+	locals = append(locals, s.vars...)
+	for _, name := range locals {
+		fmt.Fprintf(&body, "var %s Value\n", name)
+	}
+	for _, name := range append(params, locals...) {
+		fmt.Fprintf(&body, "_ = %s\n", name)
+	}
+	body.Write(s.out.Bytes())
+
+	// the fields describing defaults and rest parameters are left out
+	// when unused
+	var fields strings.Builder
+	fmt.Fprintf(&fields, "Name: %s,\nArity: %d,\n", strconv.Quote(e.Name), len(e.Parameters))
+	if required, _ := e.Arity(); required < len(e.Parameters) {
+		fmt.Fprintf(&fields, "Defaults: %d,\n", len(e.Parameters)-required)
+	}
+	if e.Rest != nil {
+		fields.WriteString("Variadic: true,\n")
+	}
+	if s.parent.captured {
+		fields.WriteString("Closure: true,\n")
+	}
+
+	return fmt.Sprintf("&Func{\n%sFn: func(args []Value) Value {\n%s},\n}", fields.String(), body.String()), nil
+}
+
+// Reports whether the i-th parameter of fn is repeated later, which makes
+// its value unreachable.
+func shadowed(fn *ast.FunctionLiteral, i int) bool {
+	for _, p := range fn.Parameters[i+1:] {
+		if p.Value == fn.Parameters[i].Value {
+			return true
+		}
+	}
+	return fn.Rest != nil && fn.Rest.Value == fn.Parameters[i].Value
+}
+
+// Collects the lets of a function body, which become variables declared at
+// the top of the Go function.
+func declareLocals(s *scope, node ast.Node, locals *[]string) {
+	switch node := node.(type) {
+	case *ast.BlockStatement:
+		if node == nil {
+			return
+		}
+		for _, stmt := range node.Statements {
+			declareLocals(s, stmt, locals)
+		}
+	case *ast.LetStatement:
+		if node.Pattern != nil {
+			declarePattern(s, node.Pattern, locals)
+		} else {
+			declareLocal(s, node.Name.Value, locals)
+		}
+		declareLocals(s, node.Value, locals)
+	case *ast.ReturnStatement:
+		declareLocals(s, node.ReturnValue, locals)
+	case *ast.WhileStatement:
+		declareLocals(s, node.Condition, locals)
+		declareLocals(s, node.Body, locals)
+	case *ast.ForStatement:
+		declareLocals(s, node.Init, locals)
+		declareLocals(s, node.Condition, locals)
+		declareLocals(s, node.Update, locals)
+		declareLocals(s, node.Body, locals)
+	case *ast.ForInStatement:
+		for _, v := range node.Variables {
+			declareLocal(s, v.Value, locals)
+		}
+		declareLocals(s, node.Iterable, locals)
+		declareLocals(s, node.Body, locals)
+	case *ast.ExpressionStatement:
+		declareLocals(s, node.Expression, locals)
+	case *ast.PrefixExpression:
+		declareLocals(s, node.Right, locals)
+	case *ast.InfixExpression:
+		declareLocals(s, node.Left, locals)
+		declareLocals(s, node.Right, locals)
+	case *ast.AssignExpression:
+		declareLocals(s, node.Target, locals)
+		declareLocals(s, node.Value, locals)
+	case *ast.IfExpression:
+		declareLocals(s, node.Condition, locals)
+		declareLocals(s, node.Consequence, locals)
+		declareLocals(s, node.Alternative, locals)
+	case *ast.MatchExpression:
+		declareLocals(s, node.Value, locals)
+		for _, arm := range node.Arms {
+			declarePattern(s, arm.Pattern, locals)
+			declareLocals(s, arm.Guard, locals)
+			declareLocals(s, arm.Body, locals)
+		}
+	case *ast.CallExpression:
+		declareLocals(s, node.Function, locals)
+		for _, a := range node.Arguments {
+			declareLocals(s, a, locals)
+		}
+	case *ast.SpreadExpression:
+		declareLocals(s, node.Value, locals)
+	case *ast.ArrayLiteral:
+		for _, el := range node.Elements {
+			declareLocals(s, el, locals)
+		}
+	case *ast.HashLiteral:
+		for _, pair := range node.Pairs {
+			declareLocals(s, pair.Key, locals)
+			declareLocals(s, pair.Value, locals)
+		}
+	case *ast.IndexExpression:
+		declareLocals(s, node.Left, locals)
+		declareLocals(s, node.Index, locals)
+	case *ast.RangeExpression:
+		declareLocals(s, node.Start, locals)
+		declareLocals(s, node.End, locals)
+		declareLocals(s, node.Step, locals)
+	}
+}
+
+// Collects the names bound by a pattern and the lets of its default
+// values.
+func declarePattern(s *scope, pattern ast.Pattern, locals *[]string) {
+	switch pattern := pattern.(type) {
+	case *ast.Identifier:
+		declareLocal(s, pattern.Value, locals)
+	case *ast.ArrayPattern:
+		for _, e := range pattern.Elements {
+			declarePattern(s, e, locals)
+		}
+		if pattern.Rest != nil {
+			declareLocal(s, pattern.Rest.Value, locals)
+		}
+	case *ast.HashPattern:
+		for _, pair := range pattern.Pairs {
+			declarePattern(s, pair.Value, locals)
+		}
+	case *ast.DefaultPattern:
+		declarePattern(s, pattern.Target, locals)
+		declareLocals(s, pattern.Default, locals)
+	}
+}
+
+func declareLocal(s *scope, name string, locals *[]string) {
+	if !s.locals[name] {
+		s.locals[name] = true
+		*locals = append(*locals, name)
+	}
+}
+
+// Checks that name refers to a local of the current function or of an
+// enclosing one, or to a global, with the same rules as the compiler.
+func (t *transpiler) resolve(name string) error {
+	for s := t.scope; s != nil; s = s.parent {
+		if s.defined[name] {
+			return nil
+		}
+	}
+	if t.globals[name] {
+		return nil
+	}
+	return fmt.Errorf("undefined variable %s", name)
+}
+
+// Returns the Go variable of the binding name resolves to.
+func (t *transpiler) variable(name string) string {
+	for s := t.scope; s != nil; s = s.parent {
+		if !s.defined[name] {
+			continue
+		}
+		if v, ok := s.names[name]; ok {
+			return v
+		}
+		break
+	}
+	return mangle(name)
+}
+
+// Reports whether the binding name resolves to is declared with const.
+func (t *transpiler) constant(name string) bool {
+	for s := t.scope; s != nil; s = s.parent {
+		if s.defined[name] {
+			return s.consts[name]
+		}
+	}
+	return t.consts[name]
+}
+
+// Reports whether name is declared with const in the current function or,
+// at the top level, in the main program.
+func (t *transpiler) isConstant(name string) bool {
+	if s := t.scope; s.parent != nil || s.defined[name] {
+		return s.consts[name]
+	}
+	return t.consts[name]
+}
+
+func (t *transpiler) declareConstant(name string) {
+	if t.scope.parent != nil {
+		t.scope.consts[name] = true
+	} else {
+		t.consts[name] = true
+	}
+}
+
+// Returns the error for redeclaring a constant in its own scope, positioned
+// at the redeclared name.
+func redeclaredConstant(name *ast.Identifier) error {
+	return fmt.Errorf("%d:%d: cannot redeclare constant %s", name.Token.Line, name.Token.Column, name.Value)
+}
+
+// Returns the error for assigning to a constant, positioned at the
+// assignment operator.
+func assignedConstant(e *ast.AssignExpression) error {
+	return fmt.Errorf("%d:%d: cannot assign to constant %s", e.Token.Line, e.Token.Column, e.Target)
+}
+
+func (t *transpiler) newTemp() string {
+	t.scope.temps++
+	return fmt.Sprintf("t%d", t.scope.temps)
+}
+
+// Assigns the Go expression to a new temporary and returns its name.
+func (t *transpiler) temp(format string, args ...interface{}) string {
+	name := t.newTemp()
+	fmt.Fprintf(&t.scope.out, "%s := %s\n", name, fmt.Sprintf(format, args...))
+	return name
+}
+
+// Monkey identifiers may clash with Go keywords and the runtime.
+func mangle(name string) string {
+	return "m_" + name
+}
+
+func position(tok token.Token) string {
+	return fmt.Sprintf("%d, %d", tok.Line, tok.Column)
+}
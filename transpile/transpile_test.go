@@ -0,0 +1,260 @@
+package transpile
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"interpreter/ast"
+	"interpreter/compiler"
+	"interpreter/lexer"
+	"interpreter/parser"
+	"interpreter/vm"
+)
+
+var programs = []string{
+	"1 + 2 * 3",
+	"-7 % 3 - 2 ** 3 ** 2",
+	"let a = 5; let b = a * 2; a < b",
+	"true == false",
+	"1 == true",
+	"!(if (false) { 5 })",
+	"if (1 > 2) { 10 }",
+	"if (1 < 2) { 10 } else { 20 }",
+	"let a = 1; a + if (true) { let a = 10; a }",
+	"let fib = fn(n) { if (n < 2) { return n; } fib(n - 1) + fib(n - 2) }; fib(20)",
+	"let max = fn(a, b) { if (a > b) { return a; } b }; max(3, 7) + max(9, 2)",
+	"let f = fn(a) { let b = a * 2; let c = if (b > 4) { let d = b + 1; d } else { b }; c }; f(1) + f(5)",
+	"let noReturn = fn() { }; noReturn()",
+	"let f = fn() { 1 }; f == f",
+	"let identity = fn(x) { x }; identity(identity)(42)",
+	"let loop = fn(n, acc) { if (n == 0) { return acc; } loop(n - 1, acc + n) }; loop(500, 0)",
+	"let loop = fn(n, acc) { if (n == 0) { acc } else { loop(n - 1, acc + n) } }; loop(100000, 0)",
+	"9223372036854775807 + 1",
+	"let greet = fn(name) { \"hello, \" + name }; greet(\"\\\"monkey\\\"\")",
+	"\"a\" == \"a\" == (\"a\" != \"b\")",
+	"let a = 0; let b = 0; a = b = 7; a + b",
+	"1; let y = 7;",
+	"let i = 0; let sum = 0; while (i < 5) { sum = sum + i; i = i + 1; } sum",
+	"let sum = 0; for (let i = 0; i < 10; i = i + 1) { if (i % 2 == 0) { continue; } if (i > 7) { break; } sum = sum + i; } sum",
+	"let f = fn(n) { let total = 0; for (;;) { if (n == 0) { return total; } total = total + n; n = n - 1; } }; f(100)",
+	"let n = 0; for (let i = 0; i < 3; i = i + 1) { let j = 0; while (true) { if (j == i) { break; } n = n + 1; j = j + 1; } } n",
+	"[1, \"two\", [true], {}]",
+	"{\"b\": 1, 2: [3], true: 4, \"b\": 5}",
+	"[1, 2, 3][2] + {\"a\": 1}[\"a\"]",
+	"[[1]][1]",
+	"let r = 0..=10 step 2; r",
+	"let sum = 0; for (i, x in [10, 20, 30]) { sum = sum + i * x; } sum",
+	"let s = \"\"; for (k in {\"b\": 1, \"a\": 2}) { s = s + k; } for (k, v in {\"x\": \"1\"}) { s = s + k + v; } s",
+	"let s = \"\"; for (c in \"héllo\") { s = c + s; } s",
+	"let sum = 0; for (i in 10..0 step -3) { if (i == 7) { continue; } sum = sum + i; } sum",
+	"let f = fn(n) { let s = 0; for (i in 0..=n) { if (i > 5) { break; } s = s + i; } s }; f(10)",
+	"let n = 0; for (i in 9223372036854775806..=9223372036854775807) { n = n + 1; } n",
+	"let n = 0; for (x in [1, 2]) { n += x; }",
+	"let a = 10; a += 5; a -= 3; a *= 2; a /= 4; a %= 4; a",
+	"let f = fn(a) { let b = 2; a += b *= 3; a += (a = 5); a + b }; f(1)",
+	"let xs = [1, [2]]; xs[0] = 10; xs[1][0] *= 3; let h = {}; h[\"k\"] = 1; h[\"k\"] += 1; h[true] = xs; h",
+	"let calls = 0; let i = fn() { calls += 1; 0 }; let a = [5]; a[i()] += 1; calls * 10 + a[0]",
+	"let a = [1, {}]; a[1][\"self\"] = a; a[0] = a; [a, a]",
+	"const limit = 3; let f = fn() { const limit = 2; let n = limit; n += 1; n * limit }; const a = [limit]; a[0] += 1; f() + a[0]",
+	"let [a, b, ...rest] = [1, 2, 3, 4]; let {name, age: years = 0} = {\"name\": \"m\"}; [a, b, rest, name, years]",
+	"let [a = 1, [b, c] = [2, 3]] = [if (false) { 0 }]; let [b, a] = [a, b]; [a, b, c]",
+	"let f = fn(p) { let {x, y = x * 2, z: [w] = [x]} = p; [x, y, w] }; [f({\"x\": 1}), f({\"x\": 2, \"y\": 5, \"z\": [9]})]",
+	"let f = fn(x) { match (x) { 0 => \"zero\", -1 => \"minus\", true => \"yes\", [a, _] => a, [a, ...r] => r, {type: \"user\", name} => name, {id = 7} => id, n if n > 10 => \"big\", _ => \"other\" } }; [f(0), f(-1), f(true), f([1, 2]), f([1, 2, 3]), f({\"type\": \"user\", \"name\": \"m\"}), f({}), f(11), f(3)]",
+	"let x = match (5) { 1 => 1 }; let y = match ([x]) { _ => 2 }; let z = match (0) { }; [x, y, z]",
+	"let sum = fn(xs, acc) { match (xs) { [] => acc, [x, ...rest] => sum(rest, acc + x) } }; sum([1, 2, 3, 4, 5], 0)",
+	"let n = 0; for (i in 0..20) { n += match ([i % 3, {\"k\": i}]) { [0, {j}] => 100, [1, {k}] if k > 10 => k, [_, {k: v}] => 1 }; if (n > 200) { break; } } n",
+	"let f = fn(x, y = x * 2, ...rest) { [x, y, rest] }; [f(1), f(1, 5), f(1, if (false) { 5 }), f(1, 2, 3, 4)]",
+	"let f = fn(a, b = match (a) { [h, ...t] => h, _ => 0 }) { b }; [f([7]), f(1), f(1, 2)]",
+	"let g = fn(x = 1, x = 2) { x }; let h = fn(...xs) { xs }; [g(), g(5), h(), h(...[1], 2, ...[], ...[3, 4])]",
+	"let f = fn(acc, ...xs) { match (xs) { [] => acc, [x, ...rest] => f(acc + x, ...rest) } }; f(0, ...[1, 2, 3, 4, 5])",
+	"let x = 5; let n = 100; const c = 1; [match (3) { x => x }, x, match ([1, 2]) { [n, 3] => 0, _ => n }, match (2) { [c] => c, c => (c = 3) } + c]",
+	"let f = fn(n) { let g = match ([1, 2]) { [n, 3] => 0, [_, n] => fn() { n } }; [g(), n, match (n) { n if n > 1 => n, _ => 0 } + n] }; f(100)",
+	"let g = match (3) { x => fn() { x } }; let x = 4; let fs = [0, 0]; for (i in 0..2) { fs[i] = match (i) { y => fn() { y } } } [g(), x, fs[0](), fs[1]()]",
+	"let double = x => x * 2; let add = (a, b = 1) => a + b; let all = (...xs) => xs; [double(4), add(1), add(1, 2), all(1, 2), (() => 0)()]",
+	"let map = fn(xs, f) { let out = [0, 0, 0]; for (i, x in xs) { out[i] = f(x); } out }; let sum = fn(xs) { let s = 0; for (x in xs) { s += x; } s }; [1, 2, 3] |> map(x => x * x) |> sum",
+	"let newAdder = fn(a) { fn(b) { a + b } }; let addTwo = newAdder(2); [addTwo(3), newAdder(10)(1)]",
+	"let counter = fn() { let count = 0; fn() { count += 1 } }; let next = counter(); next(); next(); [next(), counter()()]",
+	"let counter = fn(start) { let count = fn(n) { if (n == 0) { start } else { count(n - 1) + 1 } }; count }; counter(10)(3) * 100 + counter(0)(5)",
+	"let f = fn() { let g = fn() { 0 }; for (i in 0..3) { g = fn() { i }; } g() }; f()",
+	"let f = fn() { let a = 1; let g = fn() { a }; let a = 2; let set = fn(v) { a = v }; set(5); [g(), a] }; f()",
+	"let f = fn(a, b = a + 1, ...rest) { let [c] = rest; fn(d) { fn() { [a, b, c, d] } } }; [f(1, 2, 3)(4)(), f(5, if (false) { 0 }, 6)(7)()]",
+	"let make = x => y => x + y; let f = fn() { const k = 2; fn(x) { x * k } }; [make(1)(2), f()(21)]",
+	"let f = fn() { let g = fn() { 1 }; g }; f()",
+
+	// runtime errors
+	"1 / 0",
+	"let a = 1;\nlet b = a * 2;\n  -b + !b",
+	"-true",
+	"\"a\" - \"b\"",
+	"\"a\" > \"b\"",
+	"1 < true",
+	"2 ** -1",
+	"1()",
+	"fn(a) { a }()",
+	"let f = fn() {\n  1 % 0\n};\nf()",
+	"let f = fn() { 1 + f() };\nf()",
+	"let g = fn(a, b) { a };\nlet f = fn(n) { g(n) };\nf(1)",
+	"let f = fn() { 1() };\nf()",
+	"let i = 0;\nwhile (i < 3) { i = i + true; }",
+	"[1][\"a\"]",
+	"{}[[]]",
+	"{[]: 1}",
+	"0..\"a\"",
+	"0..10 step 0",
+	"for (x in 1) { }",
+	"let a = [1];\na[1] = 2",
+	"let s = \"a\"; s[0] = \"b\"",
+	"let a = [1]; a[-1] += 2",
+	"let h = {}; h[[]] = 1",
+	"let [a, b] = [1];",
+	"let [a] = [1, 2];",
+	"let [a, b = 1, ...c] = [];",
+	"let [a] = 5;",
+	"let {a} = [1];",
+	"let f = fn(h) { let {a, b: [c]} = h; c };\nf({\"a\": 1})",
+	"match ([1]) {\n  [n] if n / 0 => n\n}",
+	"let f = fn(a, b = 1) { a };\nf(1, 2, 3)",
+	"let f = fn(a, ...b) { a };\nf()",
+	"fn(a) { a }(...1)",
+	"let f = fn(...xs) { xs };\nf(...0..3)",
+	"let f = fn() { fn() { 1 } };\nf() + 1",
+}
+
+// Builds the transpiled programs with the local Go toolchain and checks that
+// their output matches the VM.
+func TestDifferential(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping differential test in short mode")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go toolchain not found")
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module transpiled\n\ngo 1.18\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, input := range programs {
+		src, err := Transpile(parse(t, input), "test.mk")
+		if err != nil {
+			t.Fatalf("transpile error for %q: %s", input, err)
+		}
+
+		pkg := filepath.Join(dir, fmt.Sprintf("p%d", i))
+		if err := os.Mkdir(pkg, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(pkg, "main.go"), src, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a single build of all programs is much faster than one per program
+	bin := filepath.Join(dir, "bin")
+	build := exec.Command(goTool, "build", "-o", bin+string(filepath.Separator), "./...")
+	build.Dir = dir
+	build.Env = append(os.Environ(), "GOFLAGS=-mod=mod", "GOWORK=off")
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("go build failed: %s\n%s", err, out)
+	}
+
+	for i, input := range programs {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command(filepath.Join(bin, fmt.Sprintf("p%d", i)))
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err := cmd.Run()
+
+		var actual string
+		if err != nil {
+			actual = "error: " + strings.TrimSpace(stderr.String())
+		} else {
+			actual = strings.TrimSpace(stdout.String())
+		}
+
+		if expected := interpret(t, input); actual != expected {
+			t.Errorf("wrong output for %q.\nexpected=%q\ngot=%q", input, expected, actual)
+		}
+	}
+}
+
+// Returns what "monkey run" prints for the program.
+func interpret(t *testing.T, input string) string {
+	comp := compiler.New()
+	if err := comp.Compile(parse(t, input)); err != nil {
+		t.Fatalf("compiler error for %q: %s", input, err)
+	}
+
+	machine := vm.New(comp.Bytecode())
+	if err := machine.Run(); err != nil {
+		return fmt.Sprintf("error: test.mk:%s", err)
+	}
+	return machine.LastPoppedStackElem().Inspect()
+}
+
+func TestGofmt(t *testing.T) {
+	for _, input := range programs {
+		src, err := Transpile(parse(t, input), "test.mk")
+		if err != nil {
+			t.Fatalf("transpile error for %q: %s", input, err)
+		}
+
+		formatted, err := format.Source(src)
+		if err != nil {
+			t.Fatalf("invalid Go source for %q: %s", input, err)
+		}
+		if !bytes.Equal(src, formatted) {
+			t.Errorf("source for %q is not gofmt-clean", input)
+		}
+	}
+}
+
+func TestTranspileErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x", "undefined variable x"},
+		{"let x = x;", "undefined variable x"},
+		{"return 1;", "return outside of a function"},
+		{"fn() { a; let a = 1; }", "undefined variable a"},
+		{"const x = 1; x = 2", "1:16: cannot assign to constant x"},
+		{"const x = 1; let x = 2;", "1:18: cannot redeclare constant x"},
+		{"const x = 1; for (x in []) {}", "1:19: cannot redeclare constant x"},
+		{"const x = 1; fn() { x += 2 };", "1:23: cannot assign to constant x"},
+		{"fn() { const x = 1; let x = 2; };", "1:25: cannot redeclare constant x"},
+		{"fn() { const x = 1; x = 2 };", "1:23: cannot assign to constant x"},
+		{"const [x, {y}] = [1, {}]; let [y] = [2];", "1:32: cannot redeclare constant y"},
+		{"fn() { const [x] = [1]; x += 1 };", "1:27: cannot assign to constant x"},
+		{"fn() { const x = 1; fn() { x = 2 } };", "1:30: cannot assign to constant x"},
+	}
+
+	for _, tt := range tests {
+		_, err := Transpile(parse(t, tt.input), "test.mk")
+		if err == nil {
+			t.Fatalf("expected transpile error for %q", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("expected error %q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("parser errors for %q: %v", input, p.Errors())
+	}
+	return program
+}
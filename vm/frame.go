@@ -0,0 +1,25 @@
+package vm
+
+import (
+	"interpreter/code"
+	"interpreter/object"
+)
+
+// Frame is the activation of a compiled function.
+type Frame struct {
+	fn          *object.CompiledFunction
+	ip          int // instruction being executed
+	basePointer int // stack index of the first local
+
+	// environment of the call if the function is captured, otherwise the
+	// one the function was defined in
+	env *object.Environment
+}
+
+func NewFrame(fn *object.CompiledFunction, basePointer int) *Frame {
+	return &Frame{fn: fn, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.fn.Instructions
+}
@@ -0,0 +1,163 @@
+package vm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unsafe"
+
+	"interpreter/object"
+)
+
+// Limits bound the resources of a run. Zero values mean no limit.
+type Limits struct {
+	MaxSteps  int64 // instructions executed
+	MaxDepth  int   // nested function calls
+	MaxMemory int64 // bytes of objects allocated by the VM
+}
+
+// How often the context is checked, in instructions.
+const contextCheckInterval = 1024
+
+// Sizes of what the VM allocates, for the memory limit.
+var (
+	integerSize = int64(unsafe.Sizeof(object.Integer{}))
+	frameSize   = int64(unsafe.Sizeof(Frame{}))
+	stringSize  = int64(unsafe.Sizeof(object.String{}))
+	closureSize = int64(unsafe.Sizeof(object.Closure{}))
+
+	arraySize    = int64(unsafe.Sizeof(object.Array{}))
+	hashSize     = int64(unsafe.Sizeof(object.Hash{}))
+	pairSize     = int64(unsafe.Sizeof(object.HashPair{}))
+	rangeSize    = int64(unsafe.Sizeof(object.Range{}))
+	iteratorSize = int64(unsafe.Sizeof(object.Iterator{}))
+
+	environmentSize = int64(unsafe.Sizeof(object.Environment{}))
+	objectSize      = int64(unsafe.Sizeof(object.Object(nil)))
+)
+
+// StepLimitError reports that a run executed more than Limits.MaxSteps
+// instructions.
+type StepLimitError struct {
+	Limit int64
+}
+
+func (e *StepLimitError) Error() string {
+	return fmt.Sprintf("step limit of %d exceeded", e.Limit)
+}
+
+// DepthLimitError reports that a run nested more than Limits.MaxDepth
+// calls.
+type DepthLimitError struct {
+	Limit int
+}
+
+func (e *DepthLimitError) Error() string {
+	return fmt.Sprintf("call depth limit of %d exceeded", e.Limit)
+}
+
+// MemoryLimitError reports that a run allocated more than Limits.MaxMemory
+// bytes.
+type MemoryLimitError struct {
+	Limit int64
+}
+
+func (e *MemoryLimitError) Error() string {
+	return fmt.Sprintf("memory limit of %d bytes exceeded", e.Limit)
+}
+
+// CanceledError reports that the context of a run was canceled or its
+// deadline passed. It unwraps to the error of the context.
+type CanceledError struct {
+	Err error
+}
+
+func (e *CanceledError) Error() string {
+	return fmt.Sprintf("execution stopped: %s", e.Err)
+}
+
+func (e *CanceledError) Unwrap() error {
+	return e.Err
+}
+
+// StackFrame is a function being executed when a run failed.
+type StackFrame struct {
+	Function string // empty for the main program
+	Line     int
+	Column   int
+}
+
+func (f StackFrame) String() string {
+	name := f.Function
+	if name == "" {
+		name = "<main>"
+	}
+	return fmt.Sprintf("%s at %d:%d", name, f.Line, f.Column)
+}
+
+// StackTrace formats the stack of the error, innermost call first.
+func (e *Error) StackTrace() string {
+	var out strings.Builder
+	for _, frame := range e.Stack {
+		fmt.Fprintf(&out, "\t%s\n", frame)
+	}
+	return out.String()
+}
+
+// SetLimits bounds the resources of the next runs.
+func (vm *VM) SetLimits(limits Limits) {
+	vm.limits = limits
+}
+
+// Counts an executed instruction and checks the step limit and, from time
+// to time, the context.
+func (vm *VM) step(ctx context.Context) error {
+	vm.steps++
+	if vm.limits.MaxSteps > 0 && vm.steps > vm.limits.MaxSteps {
+		return &StepLimitError{Limit: vm.limits.MaxSteps}
+	}
+	if vm.steps%contextCheckInterval == 0 {
+		if err := ctx.Err(); err != nil {
+			return &CanceledError{Err: err}
+		}
+	}
+	return nil
+}
+
+func (vm *VM) allocate(size int64) error {
+	vm.allocated += size
+	if vm.limits.MaxMemory > 0 && vm.allocated > vm.limits.MaxMemory {
+		return &MemoryLimitError{Limit: vm.limits.MaxMemory}
+	}
+	return nil
+}
+
+func (vm *VM) newInteger(value int64) (*object.Integer, error) {
+	if err := vm.allocate(integerSize); err != nil {
+		return nil, err
+	}
+	return &object.Integer{Value: value}, nil
+}
+
+// Strings are charged for their header and their bytes.
+func (vm *VM) newString(value string) (*object.String, error) {
+	if err := vm.allocate(stringSize + int64(len(value))); err != nil {
+		return nil, err
+	}
+	return &object.String{Value: value}, nil
+}
+
+// Returns the functions being executed, innermost first.
+func (vm *VM) stackTrace() []StackFrame {
+	stack := make([]StackFrame, 0, vm.framesIndex)
+	for i := vm.framesIndex - 1; i >= 0; i-- {
+		frame := vm.frames[i]
+		location, _ := frame.fn.SourceMap.Lookup(frame.ip)
+		stack = append(stack, StackFrame{
+			Function: frame.fn.Name,
+			Line:     location.Line,
+			Column:   location.Column,
+		})
+	}
+	return stack
+}
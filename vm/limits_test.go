@@ -0,0 +1,155 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"interpreter/compiler"
+)
+
+const deepRecursion = `
+let down = fn(n) {
+  if (n == 0) { return 0; }
+  down(n - 1) + 1
+};
+let run = fn() { let n = down(100); n };
+run()`
+
+// runs for minutes
+const endless = `
+let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } };
+fib(50)`
+
+func TestLimits(t *testing.T) {
+	tests := []struct {
+		name     string
+		input    string
+		limits   Limits
+		check    func(err error) bool
+		expected string
+	}{
+		{
+			"steps",
+			"let f = fn(x) { x + 1 }; f(1) + f(2) + f(3)",
+			Limits{MaxSteps: 10},
+			func(err error) bool { var e *StepLimitError; return errors.As(err, &e) && e.Limit == 10 },
+			"1:35: step limit of 10 exceeded",
+		},
+		{
+			"steps of a loop",
+			"let i = 0;\nwhile (true) { i = i + 1; }",
+			Limits{MaxSteps: 100},
+			func(err error) bool { var e *StepLimitError; return errors.As(err, &e) && e.Limit == 100 },
+			"2:1: step limit of 100 exceeded",
+		},
+		{
+			"depth",
+			deepRecursion,
+			Limits{MaxDepth: 50},
+			func(err error) bool { var e *DepthLimitError; return errors.As(err, &e) && e.Limit == 50 },
+			"4:7: call depth limit of 50 exceeded",
+		},
+		{
+			"memory",
+			"let sum = fn(n) { if (n == 0) { return 0; } n + sum(n - 1) }; sum(200)",
+			Limits{MaxMemory: 1000},
+			func(err error) bool { var e *MemoryLimitError; return errors.As(err, &e) && e.Limit == 1000 },
+			"1:52: memory limit of 1000 bytes exceeded",
+		},
+		{
+			"memory of arrays",
+			"let xs = [];\nfor (i in 0..1000) { xs = [xs, i]; }",
+			Limits{MaxMemory: 1000},
+			func(err error) bool { var e *MemoryLimitError; return errors.As(err, &e) && e.Limit == 1000 },
+			"2:1: memory limit of 1000 bytes exceeded",
+		},
+	}
+
+	for _, tt := range tests {
+		vm := New(compile(t, tt.input))
+		vm.SetLimits(tt.limits)
+
+		err := vm.Run()
+		if !tt.check(err) {
+			t.Errorf("%s: wrong error type: %T (%v)", tt.name, errors.Unwrap(err), err)
+			continue
+		}
+		var vmErr *Error
+		if !errors.As(err, &vmErr) {
+			t.Fatalf("%s: expected *Error, got=%T", tt.name, err)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%s: expected error %q, got=%q", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestLimitsAllowEnough(t *testing.T) {
+	vm := New(compile(t, deepRecursion))
+	vm.SetLimits(Limits{MaxSteps: 10000, MaxDepth: 102, MaxMemory: 1 << 20})
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	testExpectedObject(t, 100, vm.LastPoppedStackElem())
+}
+
+func TestStackTrace(t *testing.T) {
+	vm := New(compile(t, deepRecursion))
+	vm.SetLimits(Limits{MaxDepth: 3})
+
+	err := vm.Run()
+	var vmErr *Error
+	if !errors.As(err, &vmErr) {
+		t.Fatalf("expected *Error, got=%T (%v)", err, err)
+	}
+
+	expected := "\tdown at 4:7\n" +
+		"\tdown at 4:7\n" +
+		"\trun at 6:30\n" +
+		"\t<main> at 7:4\n"
+	if trace := vmErr.StackTrace(); trace != expected {
+		t.Errorf("wrong stack trace.\nexpected=%q\ngot=%q", expected, trace)
+	}
+}
+
+func TestRunContext(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	vm := New(compile(t, "1 + 2"))
+	err := vm.RunContext(canceled)
+	var canceledErr *CanceledError
+	if !errors.As(err, &canceledErr) || !errors.Is(err, context.Canceled) {
+		t.Errorf("expected a CanceledError for context.Canceled, got=%v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	vm = New(compile(t, endless))
+	start := time.Now()
+	err = vm.RunContext(ctx)
+	if !errors.As(err, &canceledErr) || !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected a CanceledError for context.DeadlineExceeded, got=%v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("run took %s to notice the deadline", elapsed)
+	}
+
+	var vmErr *Error
+	if !errors.As(err, &vmErr) || len(vmErr.Stack) < 2 || vmErr.Stack[len(vmErr.Stack)-1].Function != "" {
+		t.Errorf("expected the stack down to the main program, got=%+v", vmErr)
+	}
+}
+
+func compile(t *testing.T, input string) *compiler.Bytecode {
+	t.Helper()
+
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	return comp.Bytecode()
+}
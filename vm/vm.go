@@ -0,0 +1,1113 @@
+package vm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"interpreter/code"
+	"interpreter/compiler"
+	"interpreter/object"
+)
+
+const StackSize = 2048
+const GlobalsSize = 65536
+const MaxFrames = 1024
+
+var (
+	True  = &object.Boolean{Value: true}
+	False = &object.Boolean{Value: false}
+	Null  = &object.Null{}
+)
+
+type VM struct {
+	constants []object.Object
+
+	stack []object.Object
+	sp    int // stack[sp-1] is the top of the stack
+
+	globals  []object.Object
+	builtins []*object.Builtin
+
+	// names of the globals declared with const by index, which only
+	// OpInitGlobal may set
+	constGlobals []string
+
+	frames      []*Frame
+	framesIndex int
+
+	limits    Limits
+	steps     int64
+	allocated int64
+
+	ctx context.Context // of the current run, given to builtins
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		SourceMap:    bytecode.SourceMap,
+	}
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = NewFrame(mainFn, 0)
+
+	return &VM{
+		constants: bytecode.Constants,
+
+		stack: make([]object.Object, StackSize),
+		sp:    0,
+
+		globals:      make([]object.Object, GlobalsSize),
+		constGlobals: compiler.ConstNames(bytecode.Symbols),
+
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+// NewWithGlobalsStore returns a VM that shares the globals of a previous
+// run, e.g. in the REPL.
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
+// NewWithBuiltins returns a VM with the globals of a previous run and the
+// builtins the program was compiled against, by index.
+func NewWithBuiltins(bytecode *compiler.Bytecode, globals []object.Object, builtins []*object.Builtin) *VM {
+	vm := NewWithGlobalsStore(bytecode, globals)
+	vm.builtins = builtins
+	return vm
+}
+
+// LastPoppedStackElem returns the result of the last expression statement.
+// After running a whole program, it is the value of the program, see
+// ast.Program.EndsWithExpression.
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.stack[vm.sp]
+}
+
+// Error is a runtime error, located at the source of the instruction that
+// failed if the bytecode has a source map.
+type Error struct {
+	Line   int
+	Column int
+	Err    error
+	Stack  []StackFrame // innermost call first
+}
+
+func (e *Error) Error() string {
+	if e.Line == 0 {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Err)
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) {
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+func (vm *VM) Run() error {
+	return vm.RunContext(context.Background())
+}
+
+// RunContext runs the program until it ends, fails, exceeds its limits or
+// ctx is done.
+func (vm *VM) RunContext(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return &Error{Err: &CanceledError{Err: err}}
+	}
+
+	vm.ctx = ctx
+	defer func() { vm.ctx = nil }()
+	if err := vm.run(ctx); err != nil {
+		stack := vm.stackTrace()
+		return &Error{Line: stack[0].Line, Column: stack[0].Column, Err: err, Stack: stack}
+	}
+	return nil
+}
+
+func (vm *VM) run(ctx context.Context) error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+		if err := vm.step(ctx); err != nil {
+			return err
+		}
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if err := vm.push(vm.constants[constIndex]); err != nil {
+				return err
+			}
+
+		case code.OpPop:
+			vm.pop()
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod, code.OpPow:
+			if err := vm.executeBinaryOperation(op); err != nil {
+				return err
+			}
+
+		case code.OpTrue:
+			if err := vm.push(True); err != nil {
+				return err
+			}
+
+		case code.OpFalse:
+			if err := vm.push(False); err != nil {
+				return err
+			}
+
+		case code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
+			if err := vm.executeComparison(op); err != nil {
+				return err
+			}
+
+		case code.OpBang:
+			if err := vm.executeBangOperator(); err != nil {
+				return err
+			}
+
+		case code.OpMinus:
+			if err := vm.executeMinusOperator(); err != nil {
+				return err
+			}
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if name := constantName(vm.constGlobals, int(globalIndex)); name != "" {
+				return fmt.Errorf("cannot assign to constant %s", name)
+			}
+			vm.globals[globalIndex] = vm.pop()
+
+		case code.OpInitGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			vm.globals[globalIndex] = vm.pop()
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if err := vm.push(vm.globals[globalIndex]); err != nil {
+				return err
+			}
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			frame := vm.currentFrame()
+			if err := vm.push(vm.stack[frame.basePointer+int(localIndex)]); err != nil {
+				return err
+			}
+
+		case code.OpSetEnv:
+			depth := code.ReadUint8(ins[ip+1:])
+			index := code.ReadUint8(ins[ip+2:])
+			vm.currentFrame().ip += 2
+
+			env := vm.currentFrame().env.At(int(depth))
+			if name := constantName(env.Consts, int(index)); name != "" {
+				return fmt.Errorf("cannot assign to constant %s", name)
+			}
+			env.Store[index] = vm.pop()
+
+		case code.OpInitEnv:
+			depth := code.ReadUint8(ins[ip+1:])
+			index := code.ReadUint8(ins[ip+2:])
+			vm.currentFrame().ip += 2
+
+			vm.currentFrame().env.At(int(depth)).Store[index] = vm.pop()
+
+		case code.OpGetEnv:
+			depth := code.ReadUint8(ins[ip+1:])
+			index := code.ReadUint8(ins[ip+2:])
+			vm.currentFrame().ip += 2
+
+			if err := vm.push(vm.currentFrame().env.At(int(depth)).Store[index]); err != nil {
+				return err
+			}
+
+		case code.OpClosure:
+			constIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if err := vm.allocate(closureSize); err != nil {
+				return err
+			}
+			closure := &object.Closure{
+				Fn:  vm.constants[constIndex].(*object.CompiledFunction),
+				Env: vm.currentFrame().env,
+			}
+			if err := vm.push(closure); err != nil {
+				return err
+			}
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+
+			if int(builtinIndex) >= len(vm.builtins) {
+				return fmt.Errorf("undefined builtin %d", builtinIndex)
+			}
+			if err := vm.push(vm.builtins[builtinIndex]); err != nil {
+				return err
+			}
+
+		case code.OpJump:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			condition := vm.pop()
+			if !isTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpNull:
+			if err := vm.push(Null); err != nil {
+				return err
+			}
+
+		case code.OpCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.executeCall(int(numArgs)); err != nil {
+				return err
+			}
+
+		case code.OpTailCall:
+			numArgs := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+
+			if err := vm.executeTailCall(int(numArgs)); err != nil {
+				return err
+			}
+
+		case code.OpCallSpread:
+			numArrays := code.ReadUint8(ins[ip+1:])
+			tail := code.ReadUint8(ins[ip+2:]) == 1
+			vm.currentFrame().ip += 2
+
+			numArgs, err := vm.spread(int(numArrays))
+			if err != nil {
+				return err
+			}
+			if tail {
+				err = vm.executeTailCall(numArgs)
+			} else {
+				err = vm.executeCall(numArgs)
+			}
+			if err != nil {
+				return err
+			}
+
+		case code.OpReturnValue:
+			if err := vm.returnValue(vm.pop()); err != nil {
+				return err
+			}
+
+		case code.OpReturn:
+			if err := vm.returnValue(Null); err != nil {
+				return err
+			}
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			array, err := vm.buildArray(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp = vm.sp - numElements
+			if err := vm.push(array); err != nil {
+				return err
+			}
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			hash, err := vm.buildHash(vm.sp-numElements, vm.sp)
+			if err != nil {
+				return err
+			}
+			vm.sp = vm.sp - numElements
+			if err := vm.push(hash); err != nil {
+				return err
+			}
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			if err := vm.executeIndexExpression(left, index); err != nil {
+				return err
+			}
+
+		case code.OpSetIndex:
+			value := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			if err := vm.executeSetIndex(left, index, value); err != nil {
+				return err
+			}
+
+		case code.OpDupPair:
+			if err := vm.push(vm.stack[vm.sp-2]); err != nil {
+				return err
+			}
+			if err := vm.push(vm.stack[vm.sp-2]); err != nil {
+				return err
+			}
+
+		case code.OpRange:
+			inclusive := code.ReadUint8(ins[ip+1:]) == 1
+			vm.currentFrame().ip += 1
+
+			step := vm.pop()
+			end := vm.pop()
+			start := vm.pop()
+			if err := vm.executeRange(start, end, step, inclusive); err != nil {
+				return err
+			}
+
+		case code.OpIterator:
+			iterable := vm.pop()
+			it, ok := object.NewIterator(iterable)
+			if !ok {
+				return fmt.Errorf("cannot iterate over %s", iterable.Type())
+			}
+			if err := vm.allocate(iteratorSize); err != nil {
+				return err
+			}
+			if err := vm.push(it); err != nil {
+				return err
+			}
+
+		case code.OpCheckArray:
+			required := int(code.ReadUint16(ins[ip+1:]))
+			count := int(code.ReadUint16(ins[ip+3:]))
+			rest := code.ReadUint8(ins[ip+5:]) == 1
+			vm.currentFrame().ip += 5
+
+			if err := checkArray(vm.stack[vm.sp-1], required, count, rest); err != nil {
+				return err
+			}
+
+		case code.OpCheckHash:
+			if value := vm.stack[vm.sp-1]; value.Type() != object.HASH_OBJ {
+				return fmt.Errorf("cannot destructure %s as a hash", value.Type())
+			}
+
+		case code.OpElement:
+			i := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			var element object.Object = Null
+			if elements := vm.stack[vm.sp-1].(*object.Array).Elements; i < len(elements) {
+				element = elements[i]
+			}
+			if err := vm.push(element); err != nil {
+				return err
+			}
+
+		case code.OpRest:
+			i := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			elements := vm.stack[vm.sp-1].(*object.Array).Elements[i:]
+			if err := vm.allocate(arraySize + int64(len(elements))*objectSize); err != nil {
+				return err
+			}
+			rest := &object.Array{Elements: append([]object.Object(nil), elements...)}
+			if err := vm.push(rest); err != nil {
+				return err
+			}
+
+		case code.OpField:
+			key := vm.constants[code.ReadUint16(ins[ip+1:])].(*object.String)
+			required := code.ReadUint8(ins[ip+3:]) == 1
+			vm.currentFrame().ip += 3
+
+			value, ok := vm.stack[vm.sp-1].(*object.Hash).Get(key)
+			if !ok {
+				if required {
+					return fmt.Errorf("missing key %q in destructured hash", key.Value)
+				}
+				value = Null
+			}
+			if err := vm.push(value); err != nil {
+				return err
+			}
+
+		case code.OpJumpNotNull:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+
+			if vm.stack[vm.sp-1] != Null {
+				vm.currentFrame().ip = pos - 1
+			} else {
+				vm.pop()
+			}
+
+		case code.OpDup:
+			if err := vm.push(vm.stack[vm.sp-1]); err != nil {
+				return err
+			}
+
+		case code.OpMatchArray:
+			required := int(code.ReadUint16(ins[ip+1:]))
+			count := int(code.ReadUint16(ins[ip+3:]))
+			rest := code.ReadUint8(ins[ip+5:]) == 1
+			vm.currentFrame().ip += 5
+
+			array, ok := vm.stack[vm.sp-1].(*object.Array)
+			matched := ok && fits(len(array.Elements), required, count, rest)
+			if err := vm.push(nativeBoolToBooleanObject(matched)); err != nil {
+				return err
+			}
+
+		case code.OpMatchHash:
+			matched := vm.stack[vm.sp-1].Type() == object.HASH_OBJ
+			if err := vm.push(nativeBoolToBooleanObject(matched)); err != nil {
+				return err
+			}
+
+		case code.OpHasKey:
+			key := vm.constants[code.ReadUint16(ins[ip+1:])].(*object.String)
+			vm.currentFrame().ip += 2
+
+			_, ok := vm.stack[vm.sp-1].(*object.Hash).Get(key)
+			if err := vm.push(nativeBoolToBooleanObject(ok)); err != nil {
+				return err
+			}
+
+		case code.OpIterNext:
+			pos := int(code.ReadUint16(ins[ip+1:]))
+			numValues := code.ReadUint8(ins[ip+3:])
+			vm.currentFrame().ip += 3
+
+			if err := vm.executeIterNext(pos, int(numValues)); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("unknown opcode %d", op)
+		}
+	}
+
+	return nil
+}
+
+func (vm *VM) executeCall(numArgs int) error {
+	switch callee := vm.stack[vm.sp-1-numArgs].(type) {
+	case *object.CompiledFunction:
+		return vm.callFunction(callee, nil, numArgs)
+	case *object.Closure:
+		return vm.callFunction(callee.Fn, callee.Env, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return fmt.Errorf("calling non-function: %s", callee.Type())
+	}
+}
+
+// Replaces the arrays on top of the stack with their elements, which are
+// the arguments of a call, and returns their number.
+func (vm *VM) spread(numArrays int) (int, error) {
+	arrays := make([]*object.Array, numArrays)
+	for i := range arrays {
+		value := vm.stack[vm.sp-numArrays+i]
+		array, ok := value.(*object.Array)
+		if !ok {
+			return 0, fmt.Errorf("cannot spread %s as arguments", value.Type())
+		}
+		arrays[i] = array
+	}
+	vm.sp -= numArrays
+
+	numArgs := 0
+	for _, array := range arrays {
+		for _, el := range array.Elements {
+			if err := vm.push(el); err != nil {
+				return 0, err
+			}
+		}
+		numArgs += len(array.Elements)
+	}
+	return numArgs, nil
+}
+
+// Fits the arguments on top of the stack to the parameters of fn: missing
+// arguments with a default are null and the extra ones are collected into
+// an array for the rest parameter. Returns the number of arguments then.
+func (vm *VM) arguments(fn *object.CompiledFunction, numArgs int) (int, error) {
+	required := fn.NumParameters - fn.NumDefaults
+	if numArgs < required || numArgs > fn.NumParameters && !fn.Variadic {
+		return 0, arityError(fn, numArgs)
+	}
+
+	for ; numArgs < fn.NumParameters; numArgs++ {
+		if err := vm.push(Null); err != nil {
+			return 0, err
+		}
+	}
+	if !fn.Variadic {
+		return numArgs, nil
+	}
+
+	start := vm.sp - (numArgs - fn.NumParameters)
+	rest, err := vm.buildArray(start, vm.sp)
+	if err != nil {
+		return 0, err
+	}
+	vm.sp = start
+	if err := vm.push(rest); err != nil {
+		return 0, err
+	}
+	return fn.NumParameters + 1, nil
+}
+
+// Names the function and the number of arguments it takes.
+func arityError(fn *object.CompiledFunction, numArgs int) error {
+	name := fn.Name
+	if name == "" {
+		name = "anonymous function"
+	}
+
+	required := fn.NumParameters - fn.NumDefaults
+	var want string
+	switch {
+	case fn.Variadic:
+		want = fmt.Sprintf("want at least %d", required)
+	case fn.NumDefaults > 0:
+		want = fmt.Sprintf("want %d to %d", required, fn.NumParameters)
+	default:
+		want = fmt.Sprintf("want=%d", required)
+	}
+	return fmt.Errorf("wrong number of arguments to %s: %s, got=%d", name, want, numArgs)
+}
+
+func (vm *VM) callFunction(fn *object.CompiledFunction, env *object.Environment, numArgs int) error {
+	numArgs, err := vm.arguments(fn, numArgs)
+	if err != nil {
+		return err
+	}
+	if vm.framesIndex >= MaxFrames {
+		return fmt.Errorf("stack overflow")
+	}
+	if vm.limits.MaxDepth > 0 && vm.framesIndex > vm.limits.MaxDepth {
+		return &DepthLimitError{Limit: vm.limits.MaxDepth}
+	}
+	if err := vm.allocate(frameSize); err != nil {
+		return err
+	}
+
+	frame := NewFrame(fn, vm.sp-numArgs)
+	if err := vm.enter(frame, env); err != nil {
+		return err
+	}
+	vm.pushFrame(frame)
+	return nil
+}
+
+// Makes the current call execute the callee instead, so that calls in tail
+// position run in constant space. Other callees are called normally and
+// their result returned.
+func (vm *VM) executeTailCall(numArgs int) error {
+	var fn *object.CompiledFunction
+	var env *object.Environment
+	switch callee := vm.stack[vm.sp-1-numArgs].(type) {
+	case *object.CompiledFunction:
+		fn = callee
+	case *object.Closure:
+		fn, env = callee.Fn, callee.Env
+	default:
+		if err := vm.executeCall(numArgs); err != nil {
+			return err
+		}
+		return vm.returnValue(vm.pop())
+	}
+	numArgs, err := vm.arguments(fn, numArgs)
+	if err != nil {
+		return err
+	}
+
+	// the callee and its arguments replace those of the current call
+	frame := vm.currentFrame()
+	start := frame.basePointer - 1
+	copy(vm.stack[start:], vm.stack[vm.sp-1-numArgs:vm.sp])
+	vm.sp = frame.basePointer + numArgs
+
+	// errors are still reported at the call
+	next := Frame{fn: fn, ip: -1, basePointer: frame.basePointer}
+	if err := vm.enter(&next, env); err != nil {
+		return err
+	}
+	*frame = next
+	return nil
+}
+
+// Sets up the locals of the frame, whose arguments are on top of the
+// stack.
+func (vm *VM) enter(frame *Frame, env *object.Environment) error {
+	fn := frame.fn
+	if frame.basePointer+fn.NumLocals >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+
+	frame.env = env
+	if fn.Captured {
+		if err := vm.allocate(environmentSize + int64(fn.NumLocals)*objectSize); err != nil {
+			return err
+		}
+		frame.env = object.NewEnclosedEnvironment(env, fn.NumLocals)
+		frame.env.Consts = fn.ConstLocals
+		copy(frame.env.Store, vm.stack[frame.basePointer:vm.sp])
+	}
+
+	// clear the locals left over from previous calls
+	for i := vm.sp; i < frame.basePointer+fn.NumLocals; i++ {
+		vm.stack[i] = nil
+	}
+	vm.sp = frame.basePointer + fn.NumLocals
+
+	return nil
+}
+
+func (vm *VM) returnValue(value object.Object) error {
+	frame := vm.popFrame()
+	vm.sp = frame.basePointer - 1
+	return vm.push(value)
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	// builtins may keep their arguments, which must not change with the stack
+	args := make([]object.Object, numArgs)
+	copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+
+	var result object.Object
+	var err error
+	if builtin.ContextFn != nil {
+		result, err = builtin.ContextFn(vm.ctx, args...)
+	} else {
+		result, err = builtin.Fn(args...)
+	}
+	if err != nil {
+		if ctxErr := vm.ctx.Err(); ctxErr != nil && errors.Is(err, ctxErr) {
+			// stopped waiting because the run was canceled
+			return &CanceledError{Err: ctxErr}
+		}
+		return err
+	}
+	if result == nil {
+		result = Null
+	}
+
+	vm.sp = vm.sp - numArgs - 1
+	return vm.push(result)
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) (object.Object, error) {
+	n := endIndex - startIndex
+	if err := vm.allocate(arraySize + int64(n)*objectSize); err != nil {
+		return nil, err
+	}
+
+	elements := make([]object.Object, n)
+	copy(elements, vm.stack[startIndex:endIndex])
+	return &object.Array{Elements: elements}, nil
+}
+
+func (vm *VM) buildHash(startIndex, endIndex int) (object.Object, error) {
+	n := (endIndex - startIndex) / 2
+	if err := vm.allocate(hashSize + int64(n)*pairSize); err != nil {
+		return nil, err
+	}
+
+	hash := object.NewHash(n)
+	for i := startIndex; i < endIndex; i += 2 {
+		key, ok := vm.stack[i].(object.Hashable)
+		if !ok {
+			return nil, fmt.Errorf("unusable as hash key: %s", vm.stack[i].Type())
+		}
+		hash.Set(key, vm.stack[i+1])
+	}
+	return hash, nil
+}
+
+// Indexing past the end of an array or with a missing key gives null.
+func (vm *VM) executeIndexExpression(left, index object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		elements := left.(*object.Array).Elements
+		i := index.(*object.Integer).Value
+		if i < 0 || i >= int64(len(elements)) {
+			return vm.push(Null)
+		}
+		return vm.push(elements[i])
+
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		value, ok := left.(*object.Hash).Get(key)
+		if !ok {
+			return vm.push(Null)
+		}
+		return vm.push(value)
+
+	default:
+		return fmt.Errorf("index operator not supported: %s[%s]", left.Type(), index.Type())
+	}
+}
+
+// Sets an element of an array, which must exist, or the value of a key of
+// a hash, and pushes the value.
+func (vm *VM) executeSetIndex(left, index, value object.Object) error {
+	switch {
+	case left.Type() == object.ARRAY_OBJ && index.Type() == object.INTEGER_OBJ:
+		elements := left.(*object.Array).Elements
+		i := index.(*object.Integer).Value
+		if i < 0 || i >= int64(len(elements)) {
+			return fmt.Errorf("index %d out of range for array of length %d", i, len(elements))
+		}
+		elements[i] = value
+
+	case left.Type() == object.HASH_OBJ:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return fmt.Errorf("unusable as hash key: %s", index.Type())
+		}
+		hash := left.(*object.Hash)
+		if _, ok := hash.Get(key); !ok {
+			if err := vm.allocate(pairSize); err != nil {
+				return err
+			}
+		}
+		hash.Set(key, value)
+
+	default:
+		return fmt.Errorf("index assignment not supported: %s[%s]", left.Type(), index.Type())
+	}
+
+	return vm.push(value)
+}
+
+// Checks that value is an array with enough elements for the required ones
+// of a pattern with count elements, and no more unless it has a rest.
+func checkArray(value object.Object, required, count int, rest bool) error {
+	array, ok := value.(*object.Array)
+	if !ok {
+		return fmt.Errorf("cannot destructure %s as an array", value.Type())
+	}
+
+	n := len(array.Elements)
+	switch {
+	case fits(n, required, count, rest):
+		return nil
+	case rest:
+		return fmt.Errorf("cannot destructure array of length %d into at least %d elements", n, required)
+	default:
+		if required == count {
+			return fmt.Errorf("cannot destructure array of length %d into %d elements", n, count)
+		}
+		return fmt.Errorf("cannot destructure array of length %d into %d to %d elements", n, required, count)
+	}
+}
+
+// Reports whether an array of n elements has enough elements for the
+// required ones of a pattern with count elements, and no more unless it has
+// a rest.
+func fits(n, required, count int, rest bool) bool {
+	return n >= required && (rest || n <= count)
+}
+
+func (vm *VM) executeRange(start, end, step object.Object, inclusive bool) error {
+	for _, bound := range []object.Object{start, end, step} {
+		if bound.Type() != object.INTEGER_OBJ {
+			return fmt.Errorf("range bounds must be integers, got %s", bound.Type())
+		}
+	}
+	r := &object.Range{
+		Start:     start.(*object.Integer).Value,
+		End:       end.(*object.Integer).Value,
+		Step:      step.(*object.Integer).Value,
+		Inclusive: inclusive,
+	}
+	if r.Step == 0 {
+		return fmt.Errorf("range step must not be zero")
+	}
+
+	if err := vm.allocate(rangeSize); err != nil {
+		return err
+	}
+	return vm.push(r)
+}
+
+// Pushes the values of the next iteration of the iterator on top of the
+// stack, or jumps to pos once it is exhausted.
+func (vm *VM) executeIterNext(pos int, numValues int) error {
+	it := vm.stack[vm.sp-1].(*object.Iterator)
+	key, value, ok := it.Next()
+	if !ok {
+		vm.currentFrame().ip = pos - 1
+		return nil
+	}
+
+	// the key and the element are charged like new integers and strings
+	if err := vm.allocate(integerSize + stringSize); err != nil {
+		return err
+	}
+	if numValues == 2 {
+		if err := vm.push(key); err != nil {
+			return err
+		}
+		return vm.push(value)
+	}
+	if it.Keys() {
+		return vm.push(key)
+	}
+	return vm.push(value)
+}
+
+func (vm *VM) executeBinaryOperation(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
+		return vm.executeBinaryIntegerOperation(op, left, right)
+	}
+	if op == code.OpAdd && left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+		str, err := vm.newString(left.(*object.String).Value + right.(*object.String).Value)
+		if err != nil {
+			return err
+		}
+		return vm.push(str)
+	}
+
+	return fmt.Errorf("unsupported types for binary operation: %s %s", left.Type(), right.Type())
+}
+
+func (vm *VM) executeBinaryIntegerOperation(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.Integer).Value
+	rightValue := right.(*object.Integer).Value
+
+	var result int64
+
+	switch op {
+	case code.OpAdd:
+		result = leftValue + rightValue
+	case code.OpSub:
+		result = leftValue - rightValue
+	case code.OpMul:
+		result = leftValue * rightValue
+	case code.OpDiv:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = leftValue / rightValue
+	case code.OpMod:
+		if rightValue == 0 {
+			return fmt.Errorf("division by zero")
+		}
+		result = leftValue % rightValue
+	case code.OpPow:
+		if rightValue < 0 {
+			return fmt.Errorf("negative exponent: %d", rightValue)
+		}
+		result = power(leftValue, rightValue)
+	default:
+		return fmt.Errorf("unknown integer operator: %d", op)
+	}
+
+	integer, err := vm.newInteger(result)
+	if err != nil {
+		return err
+	}
+	return vm.push(integer)
+}
+
+// Computes base ** exp by squaring, wrapping around on overflow like the
+// other integer operators.
+func power(base, exp int64) int64 {
+	result := int64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return result
+}
+
+func (vm *VM) executeComparison(op code.Opcode) error {
+	right := vm.pop()
+	left := vm.pop()
+
+	if left.Type() == object.INTEGER_OBJ && right.Type() == object.INTEGER_OBJ {
+		return vm.executeIntegerComparison(op, left, right)
+	}
+	if left.Type() == object.STRING_OBJ && right.Type() == object.STRING_OBJ {
+		return vm.executeStringComparison(op, left, right)
+	}
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(right == left))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(right != left))
+	default:
+		return fmt.Errorf("unknown operator: %s (%s %s)", comparisonOperators[op], left.Type(), right.Type())
+	}
+}
+
+// The source operators of the comparisons, for error messages.
+var comparisonOperators = map[code.Opcode]string{
+	code.OpEqual:       "==",
+	code.OpNotEqual:    "!=",
+	code.OpGreaterThan: ">",
+	code.OpLessThan:    "<",
+}
+
+func (vm *VM) executeIntegerComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.Integer).Value
+	rightValue := right.(*object.Integer).Value
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
+	case code.OpGreaterThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue > rightValue))
+	case code.OpLessThan:
+		return vm.push(nativeBoolToBooleanObject(leftValue < rightValue))
+	default:
+		return fmt.Errorf("unknown operator: %s", comparisonOperators[op])
+	}
+}
+
+func (vm *VM) executeStringComparison(op code.Opcode, left, right object.Object) error {
+	leftValue := left.(*object.String).Value
+	rightValue := right.(*object.String).Value
+
+	switch op {
+	case code.OpEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue == rightValue))
+	case code.OpNotEqual:
+		return vm.push(nativeBoolToBooleanObject(leftValue != rightValue))
+	default:
+		return fmt.Errorf("unknown operator: %s (%s %s)", comparisonOperators[op], left.Type(), right.Type())
+	}
+}
+
+func (vm *VM) executeBangOperator() error {
+	operand := vm.pop()
+
+	return vm.push(nativeBoolToBooleanObject(!isTruthy(operand)))
+}
+
+// Returns the name of the constant with index i, see compiler.ConstNames, or
+// "" if the slot is not a constant's.
+func constantName(names []string, i int) string {
+	if i < len(names) {
+		return names[i]
+	}
+	return ""
+}
+
+func isTruthy(obj object.Object) bool {
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		return obj.Value
+	case *object.Null:
+		return false
+	default:
+		return true
+	}
+}
+
+func (vm *VM) executeMinusOperator() error {
+	operand := vm.pop()
+
+	if operand.Type() != object.INTEGER_OBJ {
+		return fmt.Errorf("unsupported type for negation: %s", operand.Type())
+	}
+
+	value := operand.(*object.Integer).Value
+	integer, err := vm.newInteger(-value)
+	if err != nil {
+		return err
+	}
+	return vm.push(integer)
+}
+
+func (vm *VM) push(o object.Object) error {
+	if vm.sp >= StackSize {
+		return fmt.Errorf("stack overflow")
+	}
+
+	vm.stack[vm.sp] = o
+	vm.sp++
+
+	return nil
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func nativeBoolToBooleanObject(input bool) *object.Boolean {
+	if input {
+		return True
+	}
+	return False
+}
@@ -0,0 +1,753 @@
+package vm
+
+import (
+	"fmt"
+	"testing"
+
+	"interpreter/ast"
+	"interpreter/code"
+	"interpreter/compiler"
+	"interpreter/lexer"
+	"interpreter/object"
+	"interpreter/parser"
+)
+
+type vmTestCase struct {
+	input    string
+	expected interface{}
+}
+
+func TestIntegerArithmetic(t *testing.T) {
+	tests := []vmTestCase{
+		{"1", 1},
+		{"2", 2},
+		{"1 + 2", 3},
+		{"1 - 2", -1},
+		{"1 * 2", 2},
+		{"4 / 2", 2},
+		{"50 / 2 * 2 + 10 - 5", 55},
+		{"5 * 2 + 10", 20},
+		{"5 + 2 * 10", 25},
+		{"7 % 3", 1},
+		{"-7 % 3", -1},
+		{"2 ** 10", 1024},
+		{"2 ** 3 ** 2", 512},
+		{"-2 ** 2", -4},
+		{"5 ** 0", 1},
+		{"-5", -5},
+		{"-10", -10},
+		{"-50 + 100 + -50", 0},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBooleanExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{"true", true},
+		{"false", false},
+		{"1 < 2", true},
+		{"1 > 2", false},
+		{"1 < 1", false},
+		{"1 > 1", false},
+		{"1 == 1", true},
+		{"1 != 1", false},
+		{"1 == 2", false},
+		{"1 != 2", true},
+		{"true == true", true},
+		{"false == false", true},
+		{"true == false", false},
+		{"true != false", true},
+		{"1 == true", false},
+		{"!true", false},
+		{"!false", true},
+		{"!5", false},
+		{"!!true", true},
+		{"!!5", true},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestStringExpressions(t *testing.T) {
+	tests := []vmTestCase{
+		{`"monkey"`, "monkey"},
+		{`"mon" + "key"`, "monkey"},
+		{`"mon" + "key" + "banana"`, "monkeybanana"},
+		{`"a\tb"`, "a\tb"},
+		{`"monkey" == "mon" + "key"`, true},
+		{`"monkey" != "banana"`, true},
+		{`"1" == 1`, false},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestGlobalLetStatements(t *testing.T) {
+	tests := []vmTestCase{
+		{"let one = 1; one", 1},
+		{"let one = 1; let two = 2; one + two", 3},
+		{"let one = 1; let two = one + one; one + two", 3},
+		{"const one = 1; let two = one + one; one + two", 3},
+		{"let x = 1; const x = x + 1; x", 2},
+		{"const x = 1; let f = fn() { let x = 2; x += 1; x }; f() + x", 4},
+		{"let x = 5;", Null},
+		{"1; let y = 7;", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestConditionals(t *testing.T) {
+	tests := []vmTestCase{
+		{"if (true) { 10 }", 10},
+		{"if (true) { 10 } else { 20 }", 10},
+		{"if (false) { 10 } else { 20 } ", 20},
+		{"if (1) { 10 }", 10},
+		{"if (1 < 2) { 10 }", 10},
+		{"if (1 > 2) { 10 } else { 20 }", 20},
+		{"if (1 > 2) { 10 }", Null},
+		{"if (false) { 10 }", Null},
+		{"if ((if (false) { 10 })) { 10 } else { 20 }", 20},
+		{"!(if (false) { 5; })", true},
+		{"if (true) { let a = 1; }", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCallingFunctions(t *testing.T) {
+	tests := []vmTestCase{
+		{"let fivePlusTen = fn() { 5 + 10; }; fivePlusTen();", 15},
+		{"let one = fn() { 1; }; let two = fn() { 2; }; one() + two()", 3},
+		{"let a = fn() { 1 }; let b = fn() { a() + 1 }; let c = fn() { b() + 1 }; c();", 3},
+		{"let earlyExit = fn() { return 99; 100; }; earlyExit();", 99},
+		{"let noReturn = fn() { }; noReturn();", Null},
+		{"let identity = fn(a) { a; }; identity(4);", 4},
+		{"let sum = fn(a, b) { let c = a + b; c; }; sum(1, 2) + sum(3, 4);", 10},
+		{"let globalNum = 10; let sum = fn(a, b) { let c = a + b; c + globalNum; }; sum(1, 2);", 13},
+		{"let returnsOne = fn() { 1; }; let returnsOneReturner = fn() { returnsOne; }; returnsOneReturner()();", 1},
+		{"let max = fn(a, b) { if (a > b) { return a; } b }; max(3, 7) + max(9, 2)", 16},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestClosures(t *testing.T) {
+	tests := []vmTestCase{
+		// adders made by a higher-order function
+		{"let newAdder = fn(a) { fn(b) { a + b } }; let addTwo = newAdder(2); addTwo(3)", 5},
+		{"let newAdder = fn(a) { fn(b) { a + b } }; newAdder(1)(1) + newAdder(10)(1)", 13},
+		{`
+		let compose = fn(f, g) { fn(x) { g(f(x)) } };
+		let inc = fn(x) { x + 1 };
+		let double = fn(x) { x * 2 };
+		compose(inc, double)(5) + compose(double, inc)(5)`, 23},
+
+		// every call gets its own environment
+		{`
+		let counter = fn(start) {
+			let count = fn(n) { if (n == 0) { start } else { count(n - 1) + 1 } };
+			count
+		};
+		let fromTen = counter(10);
+		let fromZero = counter(0);
+		fromTen(3) * 100 + fromZero(5)`, 1305},
+
+		// several levels of nesting
+		{"let f = fn(a) { fn(b) { fn(c) { a + b + c } } }; f(1)(2)(3)", 6},
+		{"let f = fn(a) { let b = a * 2; fn() { fn() { a + b } } }; f(4)()()", 12},
+
+		// inner bindings shadow outer ones without changing them
+		{"let f = fn(x) { let g = fn() { let x = 2; x }; g() * 10 + x }; f(1)", 21},
+		{"let f = fn(x) { let g = fn(x) { x }; g(5) + x }; f(1)", 6},
+		{"let x = 1; let f = fn() { let g = fn() { x }; let x = 5; g() }; f()", 1},
+		{"let f = fn() { let a = 1; let g = fn() { a }; let a = 2; g() + a }; f()", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestAssignments(t *testing.T) {
+	tests := []vmTestCase{
+		{"let a = 1; a = a + 1; a", 2},
+		{"let a = 0; let b = 0; a = b = 7; a + b", 14},
+		{"let a = 1; (a = 5) * 2", 10},
+		{"let x = 1; let f = fn() { x = x + 10 }; f(); f(); x", 21},
+		{"let f = fn(n) { n = n * 2; n + 1 }; f(4)", 9},
+
+		// captured bindings are shared with the closures
+		{"let counter = fn() { let count = 0; fn() { count = count + 1 } }; let next = counter(); next(); next(); next()", 3},
+		{"let f = fn() { let a = 1; let set = fn(v) { a = v }; set(5); a }; f()", 5},
+		{"let f = fn() { let a = 1; let g = fn() { a = 2 }; let a = 3; g(); a }; f()", 3},
+
+		// compound assignments
+		{"let a = 10; a += 5; a -= 3; a *= 2; a /= 4; a %= 4; a", 2},
+		{`let s = "a"; s += "b"`, "ab"},
+		{"let a = 1; let b = 2; a += b *= 3; a + b", 13},
+		{"let counter = fn() { let count = 0; fn() { count += 1 } }; let next = counter(); next(); next()", 2},
+		{"let f = fn(n) { let i = 0; while (i < n) { i += 1; } i }; f(5)", 5},
+
+		// index assignments
+		{"let a = [1, 2, 3]; a[1] = 20; a[0] + a[1] + a[2]", 24},
+		{"let a = [1, 2, 3]; a[2] *= 3", 9},
+		{"let a = [[0]]; a[0][0] += 5; a[0][0]", 5},
+		{`let h = {}; h["k"] = 1; h["k"] += 2; h["k"]`, 3},
+		{`let h = {"a": 1, "b": 2}; h["a"] = 3; let s = ""; for (k, v in h) { s += k; } s`, "ab"},
+		{"let calls = 0; let i = fn() { calls += 1; 0 }; let a = [5]; a[i()] += 1; calls * 10 + a[0]", 16},
+		{"let f = fn(xs) { xs[0] = 7 }; let a = [0]; f(a); a[0]", 7},
+	}
+
+	runVmTests(t, tests)
+
+	// an array or hash containing itself is not formatted forever
+	input := `let a = [1, {}]; a[1]["self"] = a; a[0] = a; [a, a]`
+	comp := compiler.New()
+	if err := comp.Compile(parse(input)); err != nil {
+		t.Fatalf("compiler error: %s", err)
+	}
+	vm := New(comp.Bytecode())
+	if err := vm.Run(); err != nil {
+		t.Fatalf("vm error: %s", err)
+	}
+	expected := "[[[...], {self: [...]}], [[...], {self: [...]}]]"
+	if actual := vm.LastPoppedStackElem().Inspect(); actual != expected {
+		t.Errorf("wrong inspection. expected=%q, got=%q", expected, actual)
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let i = 0; let sum = 0; while (i < 5) { sum = sum + i; i = i + 1; } sum", 10},
+		{"let x = 5; while (false) { x = 0; } x", 5},
+		{`
+		let sum = 0;
+		for (let i = 0; i < 10; i = i + 1) {
+			if (i % 2 == 0) { continue; }
+			if (i > 7) { break; }
+			sum = sum + i;
+		}
+		sum`, 16},
+		{`
+		let n = 0;
+		for (let i = 0; i < 3; i = i + 1) {
+			for (let j = 0; j < 10; j = j + 1) {
+				if (j == i) { break; }
+				n = n + 1;
+			}
+		}
+		n`, 3},
+		{"let f = fn(n) { let total = 0; while (true) { if (n == 0) { return total; } total = total + n; n = n - 1; } }; f(4)", 10},
+		{"let f = fn() { let i = 0; for (;;) { i = i + 1; if (i == 3) { break; } } i }; f()", 3},
+		{"let f = fn() { for (let i = 0; i < 3; i = i + 1) { } }; f()", Null},
+		{"let i = 0; while (i < 3) { i = i + 1; if (true) { continue; } i = 100; } i", 3},
+
+		// every call of a closure made in a loop sees the same binding
+		{"let f = fn() { let x = 0; let get = fn() { x }; while (x < 4) { x = x + 1; } get() }; f()", 4},
+
+		// a program ending in a loop has no value
+		{"let i = 0; while (i < 3) { i += 1; }", Null},
+		{"let n = 0; for (let i = 0; i < 3; i += 1) { n += i; }", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestCollections(t *testing.T) {
+	tests := []vmTestCase{
+		{"[]", []int{}},
+		{"[1, 2 * 2, 3 + 3]", []int{1, 4, 6}},
+		{"[1, 2, 3][1]", 2},
+		{"let i = 0; [[1, 1, 1]][i][i + 2]", 1},
+		{"[1, 2, 3][3]", Null},
+		{"[1, 2, 3][-1]", Null},
+		{`{"one": 1, "two": 2}["two"]`, 2},
+		{"{1: 1, true: 2}[true]", 2},
+		{"{1: 1}[0]", Null},
+		{"{}[1]", Null},
+		{`let h = {"a": 1, "a": 2}; h["a"]`, 2},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestForInLoops(t *testing.T) {
+	tests := []vmTestCase{
+		{"let sum = 0; for (x in [1, 2, 3]) { sum = sum + x; } sum", 6},
+		{"let sum = 0; for (i, x in [10, 20, 30]) { sum = sum + i * x; } sum", 80},
+		{`let s = ""; for (k in {"b": 1, "a": 2, "c": 3}) { s = s + k; } s`, "bac"},
+		{`let s = ""; for (k, v in {"x": "1", "y": "2"}) { s = s + k + v; } s`, "x1y2"},
+		{`let s = ""; for (c in "héllo") { s = c + s; } s`, "olléh"},
+		{`let n = 0; for (i, c in "日本語") { n = i; } n`, 2},
+		{"let sum = 0; for (i in 0..10) { sum = sum + i; } sum", 45},
+		{"let sum = 0; for (i in 0..=10) { sum = sum + i; } sum", 55},
+		{"let sum = 0; for (i in 0..10 step 3) { sum = sum + i; } sum", 18},
+		{"let sum = 0; for (i in 10..0 step -2) { sum = sum + i; } sum", 30},
+		{"let sum = 0; for (i in 10..=0 step -5) { sum = sum + i; } sum", 15},
+		{"let n = 0; for (i in 5..0) { n = n + 1; } n", 0},
+		{"let n = 0; for (i in 9223372036854775806..=9223372036854775807) { n = n + 1; } n", 2},
+		{`
+		let sum = 0;
+		for (i in 0..100) {
+			if (i % 2 == 0) { continue; }
+			if (i > 7) { break; }
+			sum = sum + i;
+		}
+		sum`, 16},
+		{`
+		let n = 0;
+		for (i in 0..3) {
+			for (j in 0..10) {
+				if (j == i) { break; }
+				n = n + 1;
+			}
+		}
+		n`, 3},
+		{"let f = fn(xs) { for (x in xs) { if (x > 1) { return x; } } 0 }; f([1, 5, 2])", 5},
+		{"let f = fn(xs) { let n = 0; for (x in xs) { n = n + x; } n }; f(0..=4)", 10},
+		{"let f = fn() { let g = fn() { 0 }; for (i in 0..3) { g = fn() { i }; } g() }; f()", 2},
+
+		// the iterator does not become the value of the program
+		{"let n = 0; for (x in [1, 2]) { n += x; }", Null},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestTailCalls(t *testing.T) {
+	// deeper than MaxFrames, so only tail calls can run them
+	tests := []vmTestCase{
+		{"let loop = fn(n) { if (n == 0) { 0 } else { loop(n - 1) } }; loop(100000)", 0},
+		{"let loop = fn(n, acc) { if (n == 0) { return acc; } loop(n - 1, acc + 1) }; loop(100000, 0)", 100000},
+		{"let loop = fn(n) { if (n > 0) { return loop(n - 1); } n }; loop(5000)", 0},
+		{`
+		let isEven = fn(n, even) { if (n == 0) { even } else { isEven(n - 1, !even) } };
+		isEven(10001, true)`, false},
+		{`
+		let count = fn(n) {
+			let step = fn(i, acc) { if (i == n) { acc } else { step(i + 1, acc + i) } };
+			step(0, 0)
+		};
+		count(3000)`, 4498500},
+		{"let loop = fn(n) { if (n == 0) { nothing() } else { loop(n - 1) } }; loop(3000)", Null},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		symbolTable := compiler.NewSymbolTable()
+		symbolTable.DefineBuiltin(0, "nothing")
+		comp := compiler.NewWithState(symbolTable, []object.Object{})
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		nothing := &object.Builtin{Name: "nothing", Fn: func(args ...object.Object) (object.Object, error) {
+			return nil, nil
+		}}
+		vm := NewWithBuiltins(comp.Bytecode(), make([]object.Object, GlobalsSize), []*object.Builtin{nothing})
+		vm.SetLimits(Limits{MaxDepth: 10})
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error for %q: %s", tt.input, err)
+		}
+		testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+	}
+}
+
+func TestRecursiveFibonacci(t *testing.T) {
+	tests := []vmTestCase{
+		{`
+		let fibonacci = fn(x) {
+			if (x < 2) { return x; }
+			fibonacci(x - 1) + fibonacci(x - 2);
+		};
+		fibonacci(15);`, 610},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestBuiltinFunctions(t *testing.T) {
+	double := &object.Builtin{Name: "double", Fn: func(args ...object.Object) (object.Object, error) {
+		if len(args) != 1 {
+			return nil, fmt.Errorf("wrong number of arguments to double: want=1, got=%d", len(args))
+		}
+		return &object.Integer{Value: 2 * args[0].(*object.Integer).Value}, nil
+	}}
+	nothing := &object.Builtin{Name: "nothing", Fn: func(args ...object.Object) (object.Object, error) {
+		return nil, nil
+	}}
+	builtins := []*object.Builtin{double, nothing}
+
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"double(21)", 42},
+		{"let f = fn(x) { double(x) + 1 }; f(double(2))", 9},
+		{"nothing()", Null},
+		{"nothing", nothing},
+		{"1 +\n  double(1, 2)", "2:9: wrong number of arguments to double: want=1, got=2"},
+	}
+
+	for _, tt := range tests {
+		symbolTable := compiler.NewSymbolTable()
+		for i, b := range builtins {
+			symbolTable.DefineBuiltin(i, b.Name)
+		}
+
+		comp := compiler.NewWithState(symbolTable, []object.Object{})
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := NewWithBuiltins(comp.Bytecode(), make([]object.Object, GlobalsSize), builtins)
+		err := vm.Run()
+
+		switch expected := tt.expected.(type) {
+		case string:
+			if err == nil || err.Error() != expected {
+				t.Errorf("expected error %q, got=%v", expected, err)
+			}
+		case *object.Builtin:
+			if err != nil || vm.LastPoppedStackElem() != expected {
+				t.Errorf("expected %s, got=%v (%v)", expected.Inspect(), vm.LastPoppedStackElem(), err)
+			}
+		default:
+			if err != nil {
+				t.Fatalf("vm error: %s", err)
+			}
+			testExpectedObject(t, tt.expected, vm.LastPoppedStackElem())
+		}
+	}
+}
+
+func TestDestructuring(t *testing.T) {
+	tests := []vmTestCase{
+		{"let [a, b] = [1, 2]; a * 10 + b", 12},
+		{"let [a, b, ...rest] = [1, 2, 3, 4]; rest", []int{3, 4}},
+		{"let [...all] = []; all", []int{}},
+		{"let [a, ...rest] = [1]; rest", []int{}},
+		{"let [[a, b], [c]] = [[1, 2], [3]]; a + b + c", 6},
+		{"let [a, b = a * 2] = [5]; b", 10},
+		{"let [a = 1, b = 2] = [if (false) { 0 }, 3]; a * 10 + b", 13},
+		{"let [a = 1, b] = [7, 8]; a + b", 15},
+		{`let {name, age: years} = {"name": "monkey", "age": 3, "extra": true}; name + "!"`, "monkey!"},
+		{`let {age: years} = {"age": 3}; years`, 3},
+		{`let {name = "anon", n = 1} = {"n": 2}; name`, "anon"},
+		{`let {point: [x, y], tag: {id}} = {"point": [1, 2], "tag": {"id": 3}}; x + y + id`, 6},
+		{`let [{a}, {a: b}] = [{"a": 1}, {"a": 2}]; a + b`, 3},
+		{"let [a, b] = [1, 2]; let [b, a] = [a, b]; a * 10 + b", 21},
+		{"let f = fn(pair) { let [a, b] = pair; a - b }; f([5, 3])", 2},
+		{"let f = fn(h) { let {x, y = 10} = h; x * y }; f({\"x\": 4})", 40},
+		{"let f = fn() { let [a, b] = [1, 2]; fn() { a + b } }; f()()", 3},
+		{"const [a, b] = [1, 2]; let f = fn() { let a = 5; a + b }; f()", 7},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestMatch(t *testing.T) {
+	tests := []vmTestCase{
+		{`match (1) { 0 => "zero", 1 => "one", _ => "many" }`, "one"},
+		{`match (-1) { -1 => "minus", _ => "other" }`, "minus"},
+		{`match ("b") { "a" => 1, "b" => 2 }`, 2},
+		{"match (true) { 1 => 1, true => 2 }", 2},
+		{"match (5) { 0 => 1 }", Null},
+		{"match (5) { n => n * 2 }", 10},
+		{"match ([1, 2]) { [a] => a, [a, b] => a + b }", 3},
+		{"match ([1, 2, 3]) { [a, b] => 0, [a, ...rest] => rest }", []int{2, 3}},
+		{"match ([1, [2, 3]]) { [1, [x, 4]] => 0, [1, [x, 3]] => x }", 2},
+		{"match ([7]) { [a, b = 10] => a + b }", 17},
+		{`match ({"type": "user", "name": "monkey"}) { {type: "admin"} => 0, {type: "user", name} => name }`, "monkey"},
+		{`match ({"id": 1}) { {name} => name, {id, name = "anon"} => name }`, "anon"},
+		{`match ([1]) { {a} => 0, _ => 1 }`, 1},
+		{"match (12) { n if n > 10 => \"big\", n => \"small\" }", "big"},
+		{"match (2) { n if n > 10 => \"big\", n => \"small\" }", "small"},
+		{"match ([5, 6]) { [a, b] if a > b => a, [a, b] => b }", 6},
+		{"let f = fn(x) { match (x) { [h, ...t] => h + f(t), _ => 0 } }; f([1, 2, 3])", 6},
+		{"let f = fn(n, acc) { match (n) { 0 => acc, _ => f(n - 1, acc + n) } }; f(100000, 0)", 5000050000},
+		{"let i = 0; let n = 0; while (i < 100) { n += match ([i, {\"k\": i}]) { [0, {k: 1}] => 1, [_, {j}] => 1, [x, {k}] if x == k => 2 }; i += 1; } n", 200},
+		{"let x = 1; match (x) { [y] => y, y => x + y }", 2},
+		{"let x = 5; match (3) { x => x } + x", 8},
+		{"let n = 100; match ([1, 2]) { [n, 3] => 0, _ => n }", 100},
+		{"let n = 100; match ([1, 2]) { [n, 2] if n > 1 => 0, [_, n] => n }", 2},
+		{"const x = 5; match (3) { x => x } + x", 8},
+		{"const x = 1; match (2) { [x] => x, x => (x = 3) } + x", 4},
+		{"let f = fn(n) { let g = match ([1, 2]) { [n, 3] => 0, [_, n] => fn() { n } }; [g(), n] }; f(100)", []int{2, 100}},
+		{"let f = fn(n) { match (n) { n if n > 1 => n, _ => 0 } + n }; f(5)", 10},
+		{"let g = match (3) { x => fn() { x } }; let x = 4; g()", 3},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestFunctionParameters(t *testing.T) {
+	tests := []vmTestCase{
+		{"let f = fn(x, y = 10) { x + y }; f(1)", 11},
+		{"let f = fn(x, y = 10) { x + y }; f(1, 2)", 3},
+		{"let f = fn(x, y = 10) { x + y }; f(1, if (false) { 2 })", 11},
+		{"let f = fn(x, y = x * 2) { y }; f(4)", 8},
+		{"let n = 0; let f = fn(x = n) { x }; n = 5; f()", 5},
+		{"let f = fn(x, ...rest) { rest }; f(1, 2, 3)", []int{2, 3}},
+		{"let f = fn(x, ...rest) { rest }; f(1)", []int{}},
+		{"let f = fn(x = 1, ...rest) { [x, rest] }; f()[0]", 1},
+		{"let f = fn(a, b, c) { a * 100 + b * 10 + c }; f(...[1, 2, 3])", 123},
+		{"let f = fn(a, b, c) { a * 100 + b * 10 + c }; let xs = [2]; f(1, ...xs, 3)", 123},
+		{"let f = fn(...xs) { xs }; f(...[1], ...[], 2, ...[3, 4])", []int{1, 2, 3, 4}},
+		{"let f = fn(x, y = 1) { fn() { x + y } }; f(1)()", 2},
+		{"let f = fn(g = fn() { 1 }) { g() }; f()", 1},
+		{"let f = fn(x = 1) { let g = fn(y = x) { y }; g() }; f(7)", 7},
+		{"let sum = fn(acc, ...xs) { match (xs) { [] => acc, [x, ...rest] => sum(acc + x, ...rest) } }; sum(0, 1, 2, 3)", 6},
+		{"let count = fn(n, acc = 0) { if (n == 0) { acc } else { count(...[n - 1, acc + 1]) } }; count(100000)", 100000},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestArrowFunctionsAndPipelines(t *testing.T) {
+	tests := []vmTestCase{
+		{"let double = x => x * 2; double(21)", 42},
+		{"let add = (a, b = 1) => a + b; [add(1), add(1, 2)]", []int{2, 3}},
+		{"let f = () => 7; f()", 7},
+		{"let f = (...xs) => xs; f(1, 2)", []int{1, 2}},
+		{"let make = x => y => x + y; make(1)(2)", 3},
+		{"let f = (x) => { let y = x; y + 1 }; f(2)", 3},
+		{"let f = () => {}; f()", Null},
+		{"let f = x => ({\"a\": x}); f(1)[\"a\"]", 1},
+		{"let inc = x => x + 1; 1 |> inc |> inc", 3},
+		{"let sub = fn(a, b) { a - b }; 10 |> sub(3)", 7},
+		{"let map = fn(xs, f) { let out = [0, 0]; for (i, x in xs) { out[i] = f(x); } out }; [1, 2] |> map(x => x * 10)", []int{10, 20}},
+		{"let sum = fn(xs) { let s = 0; for (x in xs) { s += x; } s }; 1..4 |> sum() == 6", true},
+		{"match (2) { n if n |> (x => x > 1) => 1, _ => 0 }", 1},
+	}
+
+	runVmTests(t, tests)
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"1 / 0", "1:3: division by zero"},
+		{"1 % 0", "1:3: division by zero"},
+		{"2 ** -1", "1:3: negative exponent: -1"},
+		{"-true", "1:1: unsupported type for negation: BOOLEAN"},
+		{"true + 1", "1:6: unsupported types for binary operation: BOOLEAN INTEGER"},
+		{"let a = 1;\nlet b = a * 2;\n  -b + !b", "3:6: unsupported types for binary operation: INTEGER BOOLEAN"},
+		{`"a" - "b"`, "1:5: unsupported types for binary operation: STRING STRING"},
+		{`"a" > "b"`, "1:5: unknown operator: > (STRING STRING)"},
+		{"1 < true", "1:3: unknown operator: < (INTEGER BOOLEAN)"},
+		{"1()", "1:2: calling non-function: INTEGER"},
+		{"fn(a) { a }()", "1:12: wrong number of arguments to anonymous function: want=1, got=0"},
+		{"let f = fn() { let g = fn(a) { a }; g() };\nf()", "1:38: wrong number of arguments to g: want=1, got=0"},
+		{"let f = fn(a, b = 1) { a }; f()", "1:30: wrong number of arguments to f: want 1 to 2, got=0"},
+		{"let f = fn(a, b = 1) { a }; f(1, 2, 3)", "1:30: wrong number of arguments to f: want 1 to 2, got=3"},
+		{"let f = fn(a, ...rest) { a }; f(...[])", "1:32: wrong number of arguments to f: want at least 1, got=0"},
+		{"let f = fn(a) { a }; f(...1)", "1:23: cannot spread INTEGER as arguments"},
+		{"let f = fn(a) { a }; let g = fn(x) { f(x, ...[x]) }; g(1)", "1:39: wrong number of arguments to f: want=1, got=2"},
+		{"let f = fn() { 1() };\nf()", "1:17: calling non-function: INTEGER"},
+		{"let f = fn() {\n  1 / 0\n};\nf()", "2:5: division by zero"},
+		{"let f = fn() { 1 + f() };\nf()", "1:21: stack overflow"},
+		{"1[0]", "1:2: index operator not supported: INTEGER[INTEGER]"},
+		{`[1]["a"]`, "1:4: index operator not supported: ARRAY[STRING]"},
+		{"{}[[]]", "1:3: unusable as hash key: ARRAY"},
+		{"{fn() { 1 }: 1}", "1:1: unusable as hash key: COMPILED_FUNCTION"},
+		{`0.."a"`, "1:2: range bounds must be integers, got STRING"},
+		{"0..10 step 0", "1:2: range step must not be zero"},
+		{"for (x in 1) { }", "1:1: cannot iterate over INTEGER"},
+		{"let a = [1];\na[1] = 2", "2:6: index 1 out of range for array of length 1"},
+		{"let a = [1]; a[-1] += 2", "1:20: unsupported types for binary operation: NULL INTEGER"},
+		{`let s = "a"; s[0] = "b"`, "1:19: index assignment not supported: STRING[INTEGER]"},
+		{"let h = {}; h[[]] = 1", "1:19: unusable as hash key: ARRAY"},
+		{"let a = true; a -= 1", "1:17: unsupported types for binary operation: BOOLEAN INTEGER"},
+		{"let [a, b] = [1];", "1:5: cannot destructure array of length 1 into 2 elements"},
+		{"let [a] = [1, 2];", "1:5: cannot destructure array of length 2 into 1 elements"},
+		{"let [a, b = 1] = [];", "1:5: cannot destructure array of length 0 into 1 to 2 elements"},
+		{"let [a, b, ...c] = [1];", "1:5: cannot destructure array of length 1 into at least 2 elements"},
+		{"let [a] = 5;", "1:5: cannot destructure INTEGER as an array"},
+		{"let {a} = [1];", "1:5: cannot destructure ARRAY as a hash"},
+		{`let {a, b: [c]} = {"a": 1};`, `1:9: missing key "b" in destructured hash`},
+		{"let f = fn(p) { let [x, y] = p; x };\nf([1, [2]][1])", "1:21: cannot destructure array of length 1 into 2 elements"},
+	}
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		err := vm.Run()
+		if err == nil {
+			t.Fatalf("expected VM error for %q", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("expected error %q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+// The declaration of a global or captured constant is the only store to its
+// slot the VM allows, even for bytecode the compiler did not check.
+func TestConstantStores(t *testing.T) {
+	runVmTests(t, []vmTestCase{
+		{"let n = 0; while (n < 3) { const k = n; n = k + 1; } n", 3},
+		{"let f = fn() { let gs = [0, 0]; for (i in 0..2) { const k = i; gs[i] = fn() { k }; } gs[0]() }; f()", 1},
+	})
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"const x = 1;\nx", "1:1: cannot assign to constant x"},
+		{"let a = 1;\nconst [b, c] = [2, 3];", "2:7: cannot assign to constant b"},
+		{"let f = fn() {\n  const k = 1;\n  fn() { k }\n};\nf()", "2:3: cannot assign to constant k"},
+	}
+
+	for _, tt := range tests {
+		comp := compiler.New()
+		if err := comp.Compile(parse(tt.input)); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		// turn the declarations into assignments
+		bytecode := comp.Bytecode()
+		replaceOpcode(bytecode.Instructions, code.OpInitGlobal, code.OpSetGlobal)
+		for _, constant := range bytecode.Constants {
+			if fn, ok := constant.(*object.CompiledFunction); ok {
+				replaceOpcode(fn.Instructions, code.OpInitEnv, code.OpSetEnv)
+			}
+		}
+
+		err := New(bytecode).Run()
+		if err == nil {
+			t.Fatalf("expected VM error for %q", tt.input)
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("expected error %q, got=%q", tt.expected, err)
+		}
+	}
+}
+
+func replaceOpcode(ins code.Instructions, from, to code.Opcode) {
+	for i := 0; i < len(ins); {
+		def, err := code.Lookup(ins[i])
+		if err != nil {
+			panic(err)
+		}
+		if code.Opcode(ins[i]) == from {
+			ins[i] = byte(to)
+		}
+		_, read := code.ReadOperands(def, ins[i+1:])
+		i += 1 + read
+	}
+}
+
+func runVmTests(t *testing.T, tests []vmTestCase) {
+	t.Helper()
+
+	for _, tt := range tests {
+		program := parse(tt.input)
+
+		comp := compiler.New()
+		if err := comp.Compile(program); err != nil {
+			t.Fatalf("compiler error: %s", err)
+		}
+
+		vm := New(comp.Bytecode())
+		if err := vm.Run(); err != nil {
+			t.Fatalf("vm error: %s", err)
+		}
+
+		stackElem := vm.LastPoppedStackElem()
+
+		testExpectedObject(t, tt.expected, stackElem)
+	}
+}
+
+func testExpectedObject(t *testing.T, expected interface{}, actual object.Object) {
+	t.Helper()
+
+	switch expected := expected.(type) {
+	case int:
+		if err := testIntegerObject(int64(expected), actual); err != nil {
+			t.Errorf("testIntegerObject failed: %s", err)
+		}
+	case bool:
+		if err := testBooleanObject(expected, actual); err != nil {
+			t.Errorf("testBooleanObject failed: %s", err)
+		}
+	case string:
+		if err := testStringObject(expected, actual); err != nil {
+			t.Errorf("testStringObject failed: %s", err)
+		}
+	case []int:
+		array, ok := actual.(*object.Array)
+		if !ok {
+			t.Errorf("object is not Array: %T (%+v)", actual, actual)
+			return
+		}
+		if len(array.Elements) != len(expected) {
+			t.Errorf("wrong number of elements. want=%d, got=%d", len(expected), len(array.Elements))
+			return
+		}
+		for i, el := range expected {
+			if err := testIntegerObject(int64(el), array.Elements[i]); err != nil {
+				t.Errorf("testIntegerObject failed: %s", err)
+			}
+		}
+	case *object.Null:
+		if actual != Null {
+			t.Errorf("object is not Null: %T (%+v)", actual, actual)
+		}
+	}
+}
+
+func parse(input string) *ast.Program {
+	l := lexer.New(input)
+	p := parser.New(l)
+	return p.ParseProgram()
+}
+
+func testIntegerObject(expected int64, actual object.Object) error {
+	result, ok := actual.(*object.Integer)
+	if !ok {
+		return fmt.Errorf("object is not Integer. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. expected=%d, got=%d", expected, result.Value)
+	}
+
+	return nil
+}
+
+func testBooleanObject(expected bool, actual object.Object) error {
+	result, ok := actual.(*object.Boolean)
+	if !ok {
+		return fmt.Errorf("object is not Boolean. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. expected=%t, got=%t", expected, result.Value)
+	}
+
+	return nil
+}
+
+func testStringObject(expected string, actual object.Object) error {
+	result, ok := actual.(*object.String)
+	if !ok {
+		return fmt.Errorf("object is not String. got=%T (%+v)", actual, actual)
+	}
+
+	if result.Value != expected {
+		return fmt.Errorf("object has wrong value. expected=%q, got=%q", expected, result.Value)
+	}
+
+	return nil
+}